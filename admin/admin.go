@@ -0,0 +1,148 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package admin
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/peer"
+	"github.com/bitmark-inc/bitmarkd/reservoir"
+	"github.com/bitmark-inc/logger"
+)
+
+// Admin
+// -----
+
+type Admin struct {
+	sync.Mutex // one reload or rescan at a time
+
+	log     *logger.L
+	actions *Actions
+}
+
+// arguments for calls that take none
+type EmptyArguments struct{}
+
+// Admin rescan
+// ------------
+
+type RescanReply struct {
+	PendingBefore  int `json:"pendingBefore"`
+	VerifiedBefore int `json:"verifiedBefore"`
+	Pending        int `json:"pending"`
+	Verified       int `json:"verified"`
+}
+
+// drop reservoir transactions invalidated by confirmed blocks
+func (admin *Admin) Rescan(arguments *EmptyArguments, reply *RescanReply) error {
+	admin.Lock()
+	defer admin.Unlock()
+
+	admin.log.Info("Admin.Rescan")
+
+	reply.PendingBefore, reply.VerifiedBefore = reservoir.ReadCounters()
+
+	block.RescanReservoir()
+
+	reply.Pending, reply.Verified = reservoir.ReadCounters()
+
+	admin.log.Infof("rescan: pending: %d → %d  verified: %d → %d", reply.PendingBefore, reply.Pending, reply.VerifiedBefore, reply.Verified)
+	return nil
+}
+
+// Admin peers
+// -----------
+
+type PeerArguments struct {
+	PublicKey string `json:"publicKey"` // hex
+	Seconds   int    `json:"seconds"`   // ban duration
+}
+
+type PeerReply struct {
+	PublicKey string `json:"publicKey"`
+}
+
+// drop the outgoing connection to a peer
+func (admin *Admin) Disconnect(arguments *PeerArguments, reply *PeerReply) error {
+	admin.log.Infof("Admin.Disconnect: %+v", arguments)
+
+	publicKey, err := hex.DecodeString(arguments.PublicKey)
+	if nil != err {
+		return fault.ErrInvalidPublicKey
+	}
+	err = peer.Disconnect(publicKey)
+	if nil != err {
+		return err
+	}
+
+	reply.PublicKey = arguments.PublicKey
+	return nil
+}
+
+// drop the connection to a peer and do not reconnect for a time
+func (admin *Admin) Ban(arguments *PeerArguments, reply *PeerReply) error {
+	admin.log.Infof("Admin.Ban: %+v", arguments)
+
+	publicKey, err := hex.DecodeString(arguments.PublicKey)
+	if nil != err {
+		return fault.ErrInvalidPublicKey
+	}
+	err = peer.Ban(publicKey, time.Duration(arguments.Seconds)*time.Second)
+	if nil != err {
+		return err
+	}
+
+	reply.PublicKey = arguments.PublicKey
+	return nil
+}
+
+// Admin reload
+// ------------
+
+// sections are compared with the configuration bitmarkd started with,
+// not the previous reload, so an unapplied change is reported each
+// time until bitmarkd is restarted
+type ReloadReply struct {
+	RestartRequired []string `json:"restartRequired"` // sections that differ from startup
+}
+
+// re-read the configuration file and apply what can be changed while
+// running
+func (admin *Admin) Reload(arguments *EmptyArguments, reply *ReloadReply) error {
+	admin.Lock()
+	defer admin.Unlock()
+
+	admin.log.Info("Admin.Reload")
+
+	restart, err := admin.actions.Reload()
+	if nil != err {
+		admin.log.Errorf("reload error: %s", err)
+		return err
+	}
+
+	reply.RestartRequired = restart
+	return nil
+}
+
+// Admin shutdown
+// --------------
+
+type ShutdownReply struct {
+	Stopping bool `json:"stopping"`
+}
+
+// begin a graceful shutdown, the reply is sent before services stop
+func (admin *Admin) Shutdown(arguments *EmptyArguments, reply *ShutdownReply) error {
+	admin.log.Warn("Admin.Shutdown")
+
+	admin.actions.Shutdown()
+
+	reply.Stopping = true
+	return nil
+}
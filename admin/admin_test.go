@@ -0,0 +1,124 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package admin
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/logger"
+)
+
+// test directory and socket
+const (
+	testingDirName = "testing"
+	testingSocket  = testingDirName + "/admin.sock"
+)
+
+func setup(t *testing.T) {
+	os.RemoveAll(testingDirName)
+	os.Mkdir(testingDirName, 0700)
+
+	logging := logger.Configuration{
+		Directory: testingDirName,
+		File:      "testing.log",
+		Size:      1048576,
+		Count:     10,
+		Console:   false,
+		Levels: map[string]string{
+			logger.DefaultTag: "critical",
+		},
+	}
+	if err := logger.Initialise(logging); nil != err {
+		t.Fatalf("logger initialise error: %s", err)
+	}
+}
+
+func teardown(t *testing.T) {
+	logger.Finalise()
+	os.RemoveAll(testingDirName)
+}
+
+func TestAdminSocket(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	// a stale socket is replaced
+	stale, err := net.Listen("unix", testingSocket)
+	if nil != err {
+		t.Fatalf("listen error: %s", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	reloads := 0
+	stopped := false
+	actions := &Actions{
+		Reload: func() ([]string, error) {
+			reloads += 1
+			return []string{"peering"}, nil
+		},
+		Shutdown: func() {
+			stopped = true
+		},
+	}
+	err = Initialise(&Configuration{Socket: testingSocket}, actions)
+	if nil != err {
+		t.Fatalf("initialise error: %s", err)
+	}
+	defer Finalise()
+
+	info, err := os.Stat(testingSocket)
+	if nil != err {
+		t.Fatalf("stat error: %s", err)
+	}
+	if socketPermission != info.Mode().Perm() {
+		t.Errorf("permission: %o  expected: %o", info.Mode().Perm(), socketPermission)
+	}
+
+	// a running socket is not replaced
+	if err := removeStaleSocket(testingSocket); fault.ErrAdminSocketInUse != err {
+		t.Errorf("in use error: %v  expected: %v", err, fault.ErrAdminSocketInUse)
+	}
+
+	var reloadReply ReloadReply
+	if err := Call(testingSocket, "Admin.Reload", &EmptyArguments{}, &reloadReply); nil != err {
+		t.Fatalf("reload error: %s", err)
+	}
+	if 1 != reloads || 1 != len(reloadReply.RestartRequired) || "peering" != reloadReply.RestartRequired[0] {
+		t.Errorf("reloads: %d  reply: %+v", reloads, reloadReply)
+	}
+
+	var peerReply PeerReply
+	err = Call(testingSocket, "Admin.Disconnect", &PeerArguments{PublicKey: "xyz"}, &peerReply)
+	if nil == err || fault.ErrInvalidPublicKey.Error() != err.Error() {
+		t.Errorf("disconnect error: %v  expected: %v", err, fault.ErrInvalidPublicKey)
+	}
+
+	var shutdownReply ShutdownReply
+	if err := Call(testingSocket, "Admin.Shutdown", &EmptyArguments{}, &shutdownReply); nil != err {
+		t.Fatalf("shutdown error: %s", err)
+	}
+	if !stopped || !shutdownReply.Stopping {
+		t.Errorf("stopped: %v  reply: %+v", stopped, shutdownReply)
+	}
+}
+
+func TestAdminNotSocket(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	f, err := os.Create(testingSocket)
+	if nil != err {
+		t.Fatalf("create error: %s", err)
+	}
+	f.Close()
+
+	if err := removeStaleSocket(testingSocket); fault.ErrInvalidAdminSocket != err {
+		t.Errorf("error: %v  expected: %v", err, fault.ErrInvalidAdminSocket)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// local administration RPC
+//
+// served as JSON-RPC on a Unix domain socket, access is controlled by
+// the file permissions of the socket which only allow the user
+// running bitmarkd
+//
+// offers reservoir rescan, peer disconnect and ban, configuration
+// reload and shutdown
+//
+// log levels cannot be changed: the logger fixes the level of each
+// channel when it is created, so a change to the logging section is
+// listed by reload as needing a restart
+package admin
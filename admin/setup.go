@@ -0,0 +1,175 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package admin
+
+import (
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/logger"
+)
+
+// a block of configuration data
+// this is read from the configuration file
+type Configuration struct {
+	Socket string `gluamapper:"socket" json:"socket"` // blank to disable
+}
+
+// only the owner can connect
+const socketPermission = 0600
+
+// operations that need the main program
+type Actions struct {
+	Reload   func() ([]string, error) // re-read configuration, return the sections that need a restart
+	Shutdown func()                   // begin a graceful shutdown
+}
+
+// globals for background proccess
+type adminData struct {
+	sync.RWMutex // to allow locking
+
+	log *logger.L // logger
+
+	socket   string
+	listener net.Listener
+
+	// set once during initialise
+	initialised bool
+}
+
+// global data
+var globalData adminData
+
+// initialise the admin socket
+func Initialise(configuration *Configuration, actions *Actions) error {
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	// no need to start if already started
+	if globalData.initialised {
+		return fault.ErrAlreadyInitialised
+	}
+
+	log := logger.New("admin")
+	globalData.log = log
+
+	if "" == configuration.Socket {
+		log.Info("disabled")
+		return nil
+	}
+
+	log.Info("starting…")
+
+	if nil == actions || nil == actions.Reload || nil == actions.Shutdown {
+		return fault.ErrMissingParameters
+	}
+
+	err := removeStaleSocket(configuration.Socket)
+	if nil != err {
+		log.Errorf("socket: %q  error: %s", configuration.Socket, err)
+		return err
+	}
+
+	listener, err := net.Listen("unix", configuration.Socket)
+	if nil != err {
+		log.Errorf("listen: %q  error: %s", configuration.Socket, err)
+		return err
+	}
+	err = os.Chmod(configuration.Socket, socketPermission)
+	if nil != err {
+		listener.Close()
+		log.Errorf("chmod: %q  error: %s", configuration.Socket, err)
+		return err
+	}
+
+	server := rpc.NewServer()
+	server.Register(&Admin{
+		log:     log,
+		actions: actions,
+	})
+
+	globalData.socket = configuration.Socket
+	globalData.listener = listener
+
+	// all data initialised
+	globalData.initialised = true
+
+	log.Infof("listening on: %q", configuration.Socket)
+	go serve(log, listener, server)
+
+	return nil
+}
+
+// finialise - stop accepting connections and remove the socket
+func Finalise() error {
+
+	if !globalData.initialised {
+		return fault.ErrNotInitialised
+	}
+
+	globalData.log.Info("shutting down…")
+	globalData.log.Flush()
+
+	globalData.listener.Close()
+	os.Remove(globalData.socket)
+
+	// finally...
+	globalData.initialised = false
+
+	globalData.log.Info("finished")
+	globalData.log.Flush()
+
+	return nil
+}
+
+// accept connections until the listener is closed
+func serve(log *logger.L, listener net.Listener, server *rpc.Server) {
+	for {
+		conn, err := listener.Accept()
+		if nil != err {
+			log.Debugf("accept: %s", err)
+			return
+		}
+		log.Info("connection")
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// a socket file left by a previous run is removed, but not one that
+// is still accepting connections
+func removeStaleSocket(socket string) error {
+	info, err := os.Lstat(socket)
+	if os.IsNotExist(err) {
+		return nil
+	} else if nil != err {
+		return err
+	}
+	if 0 == info.Mode()&os.ModeSocket {
+		return fault.ErrInvalidAdminSocket
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if nil == err {
+		conn.Close()
+		return fault.ErrAdminSocketInUse
+	}
+	return os.Remove(socket)
+}
+
+// make a single call on the admin socket
+func Call(socket string, method string, arguments interface{}, reply interface{}) error {
+	client, err := jsonrpc.Dial("unix", socket)
+	if nil != err {
+		return err
+	}
+	defer client.Close()
+
+	return client.Call(method, arguments, reply)
+}
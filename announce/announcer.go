@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"time"
 
+	"github.com/bitmark-inc/bitmarkd/avl"
 	"github.com/bitmark-inc/bitmarkd/messagebus"
 	"github.com/bitmark-inc/logger"
 )
@@ -93,6 +94,8 @@ func (ann *announcer) process() {
 
 	expireRPC()
 	expirePeer(log)
+	expireReputation()
+	expireQuality()
 
 	if globalData.treeChanged {
 		determineConnections(log)
@@ -120,6 +123,7 @@ func determineConnections(log *logger.L) {
 	peer := n1.Value().(*peerEntry)
 	log.Infof("N1: peer: %s", peer)
 	messagebus.Bus.Connector.Send("N1", peer.publicKey, peer.listeners)
+	chosen := []*peerEntry{peer}
 
 	// N2
 	node := n1.Next()
@@ -142,6 +146,7 @@ func determineConnections(log *logger.L) {
 		peer := node.Value().(*peerEntry)
 		log.Infof("N3: peer: %s", peer)
 		messagebus.Bus.Connector.Send("N3", peer.publicKey, peer.listeners)
+		chosen = append(chosen, peer)
 	}
 
 	// determine X25, X50 and X75 the cross ¼,½ and ¾ positions (mod tree size)
@@ -172,27 +177,61 @@ func determineConnections(log *logger.L) {
 	log.Infof("X25: this: %x", globalData.publicKey)
 	if nil != x25 {
 		if x25 != n1 && x25 != n3 {
-			peer := x25.Value().(*peerEntry)
+			peer := crossPeer(x25, chosen)
 			log.Infof("X25: peer: %s", peer)
 			messagebus.Bus.Connector.Send("X25", peer.publicKey, peer.listeners)
+			chosen = append(chosen, peer)
 		}
 	}
 	if nil != x50 {
 		if x50 != n1 && x50 != n3 && x50 != x25 {
-			peer := x50.Value().(*peerEntry)
+			peer := crossPeer(x50, chosen)
 			log.Infof("X50: peer: %s", peer)
 			messagebus.Bus.Connector.Send("X50", peer.publicKey, peer.listeners)
+			chosen = append(chosen, peer)
 		}
 	}
 	if nil != x75 {
 		if x75 != n1 && x75 != n3 && x75 != x25 && x75 != x50 {
-			peer := x75.Value().(*peerEntry)
+			peer := crossPeer(x75, chosen)
 			log.Infof("X75: peer: %s", peer)
 			messagebus.Bus.Connector.Send("X75", peer.publicKey, peer.listeners)
+			chosen = append(chosen, peer)
 		}
 	}
 }
 
+// the peer for a ring cross connection
+//
+// a poor quality peer or one sharing an address range with an already
+// chosen peer is replaced by a quality weighted choice from another
+// range, if there is one
+func crossPeer(node *avl.Node, chosen []*peerEntry) *peerEntry {
+	peer := node.Value().(*peerEntry)
+
+	exclude := make([][]byte, 0, len(chosen))
+	avoid := make([]string, 0, len(chosen))
+	shared := false
+	network := addressRange(peer.listeners)
+	for _, c := range chosen {
+		exclude = append(exclude, c.publicKey)
+		r := addressRange(c.listeners)
+		avoid = append(avoid, r)
+		if r == network {
+			shared = true
+		}
+	}
+
+	if !shared && weightOf(peer.publicKey, bestHeight()) >= poorWeight {
+		return peer
+	}
+	selected := selectPeers(1, exclude, avoid)
+	if 0 == len(selected) {
+		return peer
+	}
+	return selected[0]
+}
+
 func expirePeer(log *logger.L) {
 	now := time.Now()
 	nextNode := globalData.peerTree.First()
@@ -213,6 +252,7 @@ scan_nodes:
 		if peer.timestamp.Add(announceExpiry).Before(now) {
 			log.Info("expired")
 			globalData.peerTree.Delete(key)
+			delete(globalData.quality, string(peer.publicKey))
 			globalData.treeChanged = true
 		}
 
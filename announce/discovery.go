@@ -0,0 +1,436 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package announce
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/messagebus"
+	"github.com/bitmark-inc/bitmarkd/util"
+	"github.com/bitmark-inc/logger"
+)
+
+// timing of the discovery sources
+const (
+	discoveryPoll     = 30 * time.Second // check for sources that are due
+	discoveryInterval = announceInterval // refresh DNS, static and HTTP sources
+	fileWatchInterval = discoveryPoll    // check a watched file for changes
+	httpTimeout       = 30 * time.Second // fetch of a bootstrap URL
+)
+
+// limit on the size of a bootstrap document
+const maximumBootstrapSize = 1 << 20
+
+// Discovery is a source of peers to bootstrap the peer tree
+//
+// Discover returns the peers found, which may be none if nothing
+// changed since the previous call
+type Discovery interface {
+	Name() string
+	Interval() time.Duration
+	Discover() ([]DiscoveredPeer, error)
+}
+
+// DiscoveredPeer is a peer public key and its packed listeners
+type DiscoveredPeer struct {
+	PublicKey []byte
+	Listeners []byte
+}
+
+// BootstrapPeer is a peer in the configuration file or a bootstrap
+// JSON document, the public key is hex and each listener is IP:port
+type BootstrapPeer struct {
+	PublicKey string   `gluamapper:"public_key" json:"public_key"`
+	Listeners []string `gluamapper:"listeners" json:"listeners"`
+}
+
+// DiscoveryConfiguration - peer sources in addition to the nodes domain
+//
+// URLs and files contain a JSON array of bootstrap peers
+type DiscoveryConfiguration struct {
+	Static []BootstrapPeer `gluamapper:"static" json:"static"`
+	URLs   []string        `gluamapper:"urls" json:"urls"`
+	Files  []string        `gluamapper:"files" json:"files"`
+}
+
+// create the discovery sources from the nodes domain and configuration
+func newDiscoveries(nodesDomain string, configuration *DiscoveryConfiguration) ([]Discovery, error) {
+
+	sources := []Discovery{}
+	if "" != nodesDomain {
+		sources = append(sources, NewDNSDiscovery(nodesDomain))
+	}
+	if nil == configuration {
+		return sources, nil
+	}
+
+	if 0 != len(configuration.Static) {
+		static, err := NewStaticDiscovery(configuration.Static)
+		if nil != err {
+			return nil, err
+		}
+		sources = append(sources, static)
+	}
+	for _, url := range configuration.URLs {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, fault.ErrInvalidItem
+		}
+		sources = append(sources, NewHTTPDiscovery(url))
+	}
+	for _, fileName := range configuration.Files {
+		sources = append(sources, NewFileDiscovery(fileName))
+	}
+	return sources, nil
+}
+
+// decode bootstrap peers into public keys and packed listeners
+func bootstrapPeers(peers []BootstrapPeer) ([]DiscoveredPeer, error) {
+
+	result := make([]DiscoveredPeer, 0, len(peers))
+	for _, p := range peers {
+		publicKey, err := hex.DecodeString(p.PublicKey)
+		if nil != err || publicKeyLength != len(p.PublicKey) {
+			return nil, fault.ErrInvalidPublicKey
+		}
+
+		listeners := []byte{}
+		for _, l := range p.Listeners {
+			c, err := util.NewConnection(l)
+			if nil != err {
+				return nil, err
+			}
+			listeners = append(listeners, c.Pack()...)
+		}
+		if v4, v6 := util.PackedConnection(listeners).Unpack46(); nil == v4 && nil == v6 {
+			return nil, fault.ErrInvalidIPAddress
+		}
+
+		result = append(result, DiscoveredPeer{
+			PublicKey: publicKey,
+			Listeners: listeners,
+		})
+	}
+	return result, nil
+}
+
+// DNS TXT records
+// ---------------
+
+type dnsDiscovery struct {
+	domain    string
+	lookupTXT func(string) ([]string, error)
+	log       *logger.L
+}
+
+// peers from the TXT records of a domain
+func NewDNSDiscovery(domain string) Discovery {
+	return &dnsDiscovery{
+		domain:    domain,
+		lookupTXT: net.LookupTXT,
+		log:       logger.New("discovery-dns"),
+	}
+}
+
+func (d *dnsDiscovery) Name() string {
+	return "dns:" + d.domain
+}
+
+func (d *dnsDiscovery) Interval() time.Duration {
+	return discoveryInterval
+}
+
+// invalid records are ignored
+func (d *dnsDiscovery) Discover() ([]DiscoveredPeer, error) {
+
+	texts, err := d.lookupTXT(d.domain)
+	if nil != err {
+		return nil, err
+	}
+
+	peers := []DiscoveredPeer{}
+
+	// process DNS entries
+	for i, t := range texts {
+		t = strings.TrimSpace(t)
+		tag, err := parseTag(t)
+		if nil != err {
+			d.log.Infof("ignore TXT[%d]: %q  error: %s", i, t, err)
+			continue
+		}
+		d.log.Infof("process TXT[%d]: %q", i, t)
+		d.log.Infof("result[%d]: IPv4: %q  IPv6: %q  rpc: %d  connect: %d", i, tag.ipv4, tag.ipv6, tag.rpcPort, tag.connectPort)
+		d.log.Infof("result[%d]: peer public key: %x", i, tag.publicKey)
+		d.log.Infof("result[%d]: rpc fingerprint: %x", i, tag.certificateFingerprint)
+
+		listeners := []byte{}
+
+		if nil != tag.ipv4 {
+			c1 := util.ConnectionFromIPandPort(tag.ipv4, tag.connectPort)
+			listeners = append(listeners, c1.Pack()...)
+		}
+		if nil != tag.ipv6 {
+			c2 := util.ConnectionFromIPandPort(tag.ipv6, tag.connectPort)
+			listeners = append(listeners, c2.Pack()...)
+		}
+
+		if nil == tag.ipv4 && nil == tag.ipv6 {
+			d.log.Debugf("result[%d]: ignoring invalid record", i)
+			continue
+		}
+		peers = append(peers, DiscoveredPeer{
+			PublicKey: tag.publicKey,
+			Listeners: listeners,
+		})
+	}
+	return peers, nil
+}
+
+// static list
+// -----------
+
+type staticDiscovery struct {
+	peers []DiscoveredPeer
+}
+
+// peers from a fixed list, validated when created
+func NewStaticDiscovery(peers []BootstrapPeer) (Discovery, error) {
+	discovered, err := bootstrapPeers(peers)
+	if nil != err {
+		return nil, err
+	}
+	return &staticDiscovery{
+		peers: discovered,
+	}, nil
+}
+
+func (s *staticDiscovery) Name() string {
+	return "static"
+}
+
+func (s *staticDiscovery) Interval() time.Duration {
+	return discoveryInterval
+}
+
+func (s *staticDiscovery) Discover() ([]DiscoveredPeer, error) {
+	return s.peers, nil
+}
+
+// HTTP(S) JSON document
+// ---------------------
+
+type httpDiscovery struct {
+	url    string
+	client *http.Client
+}
+
+// peers from a JSON array of bootstrap peers fetched by GET
+func NewHTTPDiscovery(url string) Discovery {
+	return &httpDiscovery{
+		url: url,
+		client: &http.Client{
+			Timeout: httpTimeout,
+		},
+	}
+}
+
+func (h *httpDiscovery) Name() string {
+	return h.url
+}
+
+func (h *httpDiscovery) Interval() time.Duration {
+	return discoveryInterval
+}
+
+func (h *httpDiscovery) Discover() ([]DiscoveredPeer, error) {
+
+	response, err := h.client.Get(h.url)
+	if nil != err {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if http.StatusOK != response.StatusCode {
+		return nil, fault.ErrInvalidPeerResponse
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, maximumBootstrapSize))
+	if nil != err {
+		return nil, err
+	}
+	return decodeBootstrap(data)
+}
+
+// watched file
+// ------------
+
+type fileDiscovery struct {
+	fileName string
+	modified time.Time
+	size     int64
+}
+
+// peers from a JSON array of bootstrap peers in a file, read again
+// whenever the file changes
+func NewFileDiscovery(fileName string) Discovery {
+	return &fileDiscovery{
+		fileName: fileName,
+	}
+}
+
+func (f *fileDiscovery) Name() string {
+	return "file:" + f.fileName
+}
+
+func (f *fileDiscovery) Interval() time.Duration {
+	return fileWatchInterval
+}
+
+// nothing is returned if the file is unchanged
+func (f *fileDiscovery) Discover() ([]DiscoveredPeer, error) {
+
+	info, err := os.Stat(f.fileName)
+	if nil != err {
+		return nil, err
+	}
+	if info.ModTime().Equal(f.modified) && info.Size() == f.size {
+		return nil, nil
+	}
+	if info.Size() > maximumBootstrapSize {
+		return nil, fault.ErrInvalidLength
+	}
+
+	data, err := ioutil.ReadFile(f.fileName)
+	if nil != err {
+		return nil, err
+	}
+	peers, err := decodeBootstrap(data)
+	if nil != err {
+		return nil, err
+	}
+
+	// only record the change once it has been read successfully
+	f.modified = info.ModTime()
+	f.size = info.Size()
+	return peers, nil
+}
+
+// decode a JSON array of bootstrap peers
+func decodeBootstrap(data []byte) ([]DiscoveredPeer, error) {
+	var peers []BootstrapPeer
+	err := json.Unmarshal(data, &peers)
+	if nil != err {
+		return nil, err
+	}
+	return bootstrapPeers(peers)
+}
+
+// background
+// ----------
+
+// a discovery source and when it is next due
+type discoverySource struct {
+	Discovery
+	next time.Time
+}
+
+type discoverer struct {
+	log     *logger.L
+	sources []*discoverySource
+}
+
+// initialise the discoverer
+func (d *discoverer) initialise(sources []Discovery) error {
+
+	log := logger.New("discoverer")
+	d.log = log
+
+	log.Info("initialising…")
+
+	d.sources = make([]*discoverySource, len(sources))
+	for i, s := range sources {
+		log.Infof("source[%d]: %s", i, s.Name())
+		d.sources[i] = &discoverySource{
+			Discovery: s,
+		}
+	}
+	return nil
+}
+
+// poll the sources that are due
+func (d *discoverer) Run(args interface{}, shutdown <-chan struct{}) {
+
+	log := d.log
+
+	log.Info("starting…")
+
+	// the initial discovery is here rather than in Initialise so
+	// that a slow source does not delay start up
+	d.pollAndReconnect(time.Now())
+
+	ticker := time.NewTicker(discoveryPoll)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-shutdown:
+			break loop
+
+		case <-ticker.C:
+			d.pollAndReconnect(time.Now())
+		}
+	}
+}
+
+// poll and have the connections checked if there are new peers
+func (d *discoverer) pollAndReconnect(now time.Time) {
+	if 0 != d.poll(now) {
+		messagebus.Bus.Announce.Send("reconnect")
+	}
+}
+
+// add the peers of all sources that are due to the peer tree
+//
+// the sources may wait on the network so are not called with the lock
+// held; it is only taken to add their peers
+//
+// returns the number of new or updated peers; do not hold lock
+func (d *discoverer) poll(now time.Time) int {
+
+	added := 0
+	for _, s := range d.sources {
+		if now.Before(s.next) {
+			continue
+		}
+		s.next = now.Add(s.Interval())
+
+		peers, err := s.Discover()
+		if nil != err {
+			d.log.Errorf("source: %s  error: %s", s.Name(), err)
+			continue
+		}
+
+		globalData.Lock()
+		for _, p := range peers {
+			if addPeer(p.PublicKey, p.Listeners, 0) {
+				added += 1
+			}
+		}
+		globalData.Unlock()
+
+		if 0 != len(peers) {
+			d.log.Infof("source: %s  peers: %d", s.Name(), len(peers))
+		}
+	}
+	return added
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package announce
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/util"
+)
+
+// a bootstrap JSON document for some test peers
+func bootstrapJSON(keys ...byte) string {
+	s := "["
+	for i, k := range keys {
+		if 0 != i {
+			s += ","
+		}
+		publicKey := hex.EncodeToString(bytes.Repeat([]byte{k}, 32))
+		s += fmt.Sprintf(`{"public_key":%q,"listeners":["192.0.2.%d:2136","[2001:db8::%d]:2136"]}`, publicKey, k, k)
+	}
+	return s + "]"
+}
+
+// check that exactly the given test peers are in the tree
+func checkPeers(t *testing.T, keys ...byte) {
+	if len(keys) != globalData.peerTree.Count() {
+		t.Errorf("peers: %d  expected: %d", globalData.peerTree.Count(), len(keys))
+	}
+	for _, k := range keys {
+		publicKey := bytes.Repeat([]byte{k}, 32)
+		if node, _ := globalData.peerTree.Search(pubkey(publicKey)); nil == node {
+			t.Errorf("missing peer: %x", publicKey)
+		}
+	}
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	fingerprint := hex.EncodeToString(bytes.Repeat([]byte{0xf0}, 32))
+	records := []string{
+		"bitmark=v3 a=127.0.0.1;[::1] c=2136 r=2130 f=" + fingerprint + " p=" + hex.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+		"bitmark=v3 a=192.0.2.2 c=2136 r=2130 f=" + fingerprint + " p=" + hex.EncodeToString(bytes.Repeat([]byte{2}, 32)),
+		"not a bitmark record",
+		"bitmark=v3 c=2136 r=2130 f=" + fingerprint + " p=" + hex.EncodeToString(bytes.Repeat([]byte{3}, 32)),
+	}
+
+	d := NewDNSDiscovery("nodes.example.com").(*dnsDiscovery)
+	d.lookupTXT = func(domain string) ([]string, error) {
+		if "nodes.example.com" != domain {
+			t.Errorf("lookup domain: %q", domain)
+		}
+		return records, nil
+	}
+
+	peers, err := d.Discover()
+	if nil != err {
+		t.Fatalf("discover error: %s", err)
+	}
+	if 2 != len(peers) {
+		t.Fatalf("peers: %d  expected: 2", len(peers))
+	}
+	v4, v6 := util.PackedConnection(peers[0].Listeners).Unpack46()
+	if nil == v4 || nil == v6 || "127.0.0.1:2136" != v4.String() || "[::1]:2136" != v6.String() {
+		t.Errorf("listeners: %v  %v", v4, v6)
+	}
+
+	d.lookupTXT = func(domain string) ([]string, error) {
+		return nil, errors.New("no such host")
+	}
+	if _, err := d.Discover(); nil == err {
+		t.Error("expected lookup error")
+	}
+}
+
+func TestStaticDiscovery(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	valid := []BootstrapPeer{
+		{
+			PublicKey: hex.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+			Listeners: []string{"192.0.2.1:2136"},
+		},
+	}
+	s, err := NewStaticDiscovery(valid)
+	if nil != err {
+		t.Fatalf("static error: %s", err)
+	}
+	peers, err := s.Discover()
+	if nil != err {
+		t.Fatalf("discover error: %s", err)
+	}
+	if 1 != len(peers) || !bytes.Equal(bytes.Repeat([]byte{1}, 32), peers[0].PublicKey) {
+		t.Errorf("peers: %v", peers)
+	}
+
+	invalid := []struct {
+		peer BootstrapPeer
+		err  error
+	}{
+		{BootstrapPeer{PublicKey: "0102", Listeners: []string{"192.0.2.1:2136"}}, fault.ErrInvalidPublicKey},
+		{BootstrapPeer{PublicKey: valid[0].PublicKey, Listeners: []string{}}, fault.ErrInvalidIPAddress},
+		{BootstrapPeer{PublicKey: valid[0].PublicKey, Listeners: []string{"192.0.2.1"}}, nil},
+	}
+	for i, item := range invalid {
+		_, err := NewStaticDiscovery([]BootstrapPeer{item.peer})
+		if nil == err {
+			t.Errorf("%d: expected error", i)
+		} else if nil != item.err && item.err != err {
+			t.Errorf("%d: error: %s  expected: %s", i, err, item.err)
+		}
+	}
+}
+
+func TestHTTPDiscovery(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	document := bootstrapJSON(1, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "/peers.json" != r.URL.Path {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, document)
+	}))
+	defer server.Close()
+
+	peers, err := NewHTTPDiscovery(server.URL + "/peers.json").Discover()
+	if nil != err {
+		t.Fatalf("discover error: %s", err)
+	}
+	if 2 != len(peers) {
+		t.Errorf("peers: %d  expected: 2", len(peers))
+	}
+
+	if _, err := NewHTTPDiscovery(server.URL + "/missing.json").Discover(); fault.ErrInvalidPeerResponse != err {
+		t.Errorf("missing document error: %v", err)
+	}
+
+	document = "{not json"
+	if _, err := NewHTTPDiscovery(server.URL + "/peers.json").Discover(); nil == err {
+		t.Error("expected decode error")
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	directory, err := ioutil.TempDir("", "discovery")
+	if nil != err {
+		t.Fatalf("temporary directory error: %s", err)
+	}
+	defer os.RemoveAll(directory)
+	fileName := filepath.Join(directory, "peers.json")
+
+	f := NewFileDiscovery(fileName)
+	if _, err := f.Discover(); nil == err {
+		t.Error("expected error for missing file")
+	}
+
+	if err := ioutil.WriteFile(fileName, []byte(bootstrapJSON(1)), 0600); nil != err {
+		t.Fatalf("write error: %s", err)
+	}
+	peers, err := f.Discover()
+	if nil != err {
+		t.Fatalf("discover error: %s", err)
+	}
+	if 1 != len(peers) {
+		t.Errorf("peers: %d  expected: 1", len(peers))
+	}
+
+	// unchanged file
+	peers, err = f.Discover()
+	if nil != err || 0 != len(peers) {
+		t.Errorf("unchanged: peers: %d  error: %v", len(peers), err)
+	}
+
+	// changed size and time
+	if err := ioutil.WriteFile(fileName, []byte(bootstrapJSON(1, 2, 3)), 0600); nil != err {
+		t.Fatalf("write error: %s", err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(fileName, later, later)
+	peers, err = f.Discover()
+	if nil != err {
+		t.Fatalf("discover error: %s", err)
+	}
+	if 3 != len(peers) {
+		t.Errorf("peers: %d  expected: 3", len(peers))
+	}
+}
+
+func TestDiscoverer(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	directory, err := ioutil.TempDir("", "discovery")
+	if nil != err {
+		t.Fatalf("temporary directory error: %s", err)
+	}
+	defer os.RemoveAll(directory)
+	fileName := filepath.Join(directory, "peers.json")
+	if err := ioutil.WriteFile(fileName, []byte(bootstrapJSON(3)), 0600); nil != err {
+		t.Fatalf("write error: %s", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, bootstrapJSON(2))
+	}))
+	defer server.Close()
+
+	configuration := &DiscoveryConfiguration{
+		Static: []BootstrapPeer{
+			{
+				PublicKey: hex.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+				Listeners: []string{"192.0.2.1:2136"},
+			},
+		},
+		URLs:  []string{server.URL},
+		Files: []string{fileName},
+	}
+	sources, err := newDiscoveries("", configuration)
+	if nil != err {
+		t.Fatalf("sources error: %s", err)
+	}
+	if 3 != len(sources) {
+		t.Fatalf("sources: %d  expected: 3", len(sources))
+	}
+
+	var d discoverer
+	d.initialise(sources)
+
+	now := time.Now()
+	if added := d.poll(now); 3 != added {
+		t.Errorf("added: %d  expected: 3", added)
+	}
+	checkPeers(t, 1, 2, 3)
+
+	// nothing is due yet
+	if added := d.poll(now.Add(time.Second)); 0 != added {
+		t.Errorf("added: %d  expected: 0", added)
+	}
+
+	// the watched file picks up a new peer
+	if err := ioutil.WriteFile(fileName, []byte(bootstrapJSON(3, 4)), 0600); nil != err {
+		t.Fatalf("write error: %s", err)
+	}
+	later := now.Add(time.Minute)
+	os.Chtimes(fileName, later, later)
+	d.poll(now.Add(fileWatchInterval))
+	checkPeers(t, 1, 2, 3, 4)
+
+	if _, err := newDiscoveries("", &DiscoveryConfiguration{URLs: []string{"ftp://example.com"}}); fault.ErrInvalidItem != err {
+		t.Errorf("invalid url error: %v", err)
+	}
+}
@@ -6,10 +6,8 @@ package announce
 
 import (
 	"bytes"
-	"crypto/rand"
 	"encoding/binary"
 	"fmt"
-	"math/big"
 	"time"
 
 	"github.com/bitmark-inc/bitmarkd/fault"
@@ -78,6 +76,11 @@ func addPeer(publicKey []byte, listeners []byte, timestamp uint64) bool {
 		return false
 	}
 
+	// ignore banned peers until the ban expires
+	if entry, ok := globalData.bannedKeys[string(publicKey)]; ok && time.Now().Before(entry.expires) {
+		return false
+	}
+
 	peer := &peerEntry{
 		publicKey: publicKey,
 		listeners: listeners,
@@ -128,46 +131,35 @@ func GetNext(publicKey []byte) ([]byte, []byte, time.Time, error) {
 	return peer.publicKey, peer.listeners, peer.timestamp, nil
 }
 
-// fetch the data for the random node in the ring not matching a given public key
+// fetch the data for a random node in the ring not matching a given public key
+//
+// the choice is weighted by peer quality and spread across address ranges
 func GetRandom(publicKey []byte) ([]byte, []byte, time.Time, error) {
 	globalData.Lock()
 	defer globalData.Unlock()
 
-retry_loop:
-	for tries := 1; tries <= 5; tries += 1 {
-		max := big.NewInt(int64(globalData.peerTree.Count()))
-		r, err := rand.Int(rand.Reader, max)
-		if nil != err {
-			continue retry_loop
-		}
-
-		n := int(r.Int64()) // 0 … max-1
-
-		node := globalData.peerTree.Get(n)
-		if nil == node {
-			node = globalData.peerTree.First()
-		}
-		if nil == node {
-			break retry_loop
-		}
-		peer := node.Value().(*peerEntry)
-		if bytes.Equal(peer.publicKey, globalData.publicKey) || bytes.Equal(peer.publicKey, publicKey) {
-			continue retry_loop
-		}
-		return peer.publicKey, peer.listeners, peer.timestamp, nil
+	selected := selectPeers(1, [][]byte{publicKey}, nil)
+	if 0 == len(selected) {
+		return nil, nil, time.Now(), fault.ErrInvalidPublicKey
 	}
-	return nil, nil, time.Now(), fault.ErrInvalidPublicKey
+	peer := selected[0]
+	return peer.publicKey, peer.listeners, peer.timestamp, nil
 }
 
 // send a peer registration request to a client channel
-func SendRegistration(client *zmqutil.Client, fn string) error {
+//
+// any extra parts are appended after the timestamp
+func SendRegistration(client *zmqutil.Client, fn string, extra ...[]byte) error {
 	chain := mode.ChainName()
 
 	// get a big endian timestamp
 	timestamp := make([]byte, 8)
 	binary.BigEndian.PutUint64(timestamp, uint64(time.Now().Unix()))
 
-	return client.Send(fn, chain, globalData.publicKey, globalData.listeners, timestamp)
+	if 0 == len(extra) {
+		return client.Send(fn, chain, globalData.publicKey, globalData.listeners, timestamp)
+	}
+	return client.Send(fn, chain, globalData.publicKey, globalData.listeners, timestamp, extra)
 }
 
 // public key comparison for AVL interface
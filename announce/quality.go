@@ -0,0 +1,370 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package announce
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/util"
+)
+
+// quality weighting
+const (
+	defaultLatency   = time.Second    // assumed for a peer never measured
+	latencyScale     = time.Second    // latency at which the weight halves
+	latencySmoothing = 4              // reciprocal of the weight of a new latency sample
+	maximumFailures  = 10             // consecutive failures beyond this do not reduce the weight further
+	heightLag        = 10             // blocks behind the best known height for a peer to be lagging
+	laggingWeight    = 0.5            // weight multiplier for a lagging peer
+	minimumWeight    = 0.01           // so that every peer is occasionally tried
+	poorWeight       = 0.1            // ring cross connections below this are replaced
+	ipv4RangePrefix  = 16             // IPv4 prefix length of an address range
+	ipv6RangePrefix  = 32             // IPv6 prefix length of an address range
+	qualityExpiry    = announceExpiry // statistics of peers no longer in the tree are dropped after this
+	randomPrecision  = 1 << 53        // mantissa bits of a random float
+)
+
+// connection statistics of a peer
+type qualityEntry struct {
+	attempts  uint64        // connections tried
+	successes uint64        // connections that registered
+	failures  uint64        // consecutive failures since the last success
+	latency   time.Duration // smoothed round trip time, zero if never measured
+	height    uint64        // block height last reported
+	lastGood  time.Time     // time of last successful response
+	updated   time.Time     // time of last change
+}
+
+// record a successful connection and registration with a peer
+func ConnectSucceeded(publicKey []byte, latency time.Duration) {
+	if 0 == len(publicKey) {
+		return
+	}
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	entry := qualityOf(publicKey)
+	entry.attempts += 1
+	entry.successes += 1
+	entry.failures = 0
+	entry.sample(latency)
+}
+
+// record a failed connection, registration or request to a peer
+func ConnectFailed(publicKey []byte) {
+	if 0 == len(publicKey) {
+		return
+	}
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	entry := qualityOf(publicKey)
+	entry.attempts += 1
+	entry.failures += 1
+	entry.updated = time.Now()
+}
+
+// record the block height reported by a peer and the time it took
+func HeightReceived(publicKey []byte, height uint64, latency time.Duration) {
+	if 0 == len(publicKey) {
+		return
+	}
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	entry := qualityOf(publicKey)
+	entry.height = height
+	entry.sample(latency)
+}
+
+// internal fetch or create of a quality entry, hold lock before calling
+func qualityOf(publicKey []byte) *qualityEntry {
+	key := string(publicKey)
+	entry, ok := globalData.quality[key]
+	if !ok {
+		entry = &qualityEntry{}
+		globalData.quality[key] = entry
+	}
+	return entry
+}
+
+// add a latency sample to the smoothed latency
+func (entry *qualityEntry) sample(latency time.Duration) {
+	now := time.Now()
+	if 0 == entry.latency {
+		entry.latency = latency
+	} else {
+		entry.latency += (latency - entry.latency) / latencySmoothing
+	}
+	entry.lastGood = now
+	entry.updated = now
+}
+
+// selection weight of a peer in the range minimumWeight … 1
+//
+// the product of the connection success rate, a latency factor and a
+// halving for each consecutive failure, reduced further if the peer
+// is lagging behind the best known height
+func (entry *qualityEntry) weight(bestHeight uint64) float64 {
+
+	// success rate, a peer never tried counts as half successful
+	w := float64(entry.successes+1) / float64(entry.attempts+2)
+
+	latency := entry.latency
+	if 0 == latency {
+		latency = defaultLatency
+	}
+	w /= 1 + float64(latency)/float64(latencyScale)
+
+	failures := entry.failures
+	if failures > maximumFailures {
+		failures = maximumFailures
+	}
+	w *= math.Pow(0.5, float64(failures))
+
+	if 0 != entry.height && entry.height+heightLag < bestHeight {
+		w *= laggingWeight
+	}
+
+	if w < minimumWeight {
+		return minimumWeight
+	}
+	return w
+}
+
+// internal weight of a public key, hold lock before calling
+func weightOf(publicKey []byte, bestHeight uint64) float64 {
+	entry, ok := globalData.quality[string(publicKey)]
+	if !ok {
+		entry = &qualityEntry{}
+	}
+	return entry.weight(bestHeight)
+}
+
+// internal highest height reported by any peer, hold lock before calling
+func bestHeight() uint64 {
+	best := uint64(0)
+	for _, entry := range globalData.quality {
+		if entry.height > best {
+			best = entry.height
+		}
+	}
+	return best
+}
+
+// QualityItem is the external form of peer statistics for backup and the RPC
+type QualityItem struct {
+	PublicKey string        `json:"publicKey"`
+	Attempts  uint64        `json:"attempts"`
+	Successes uint64        `json:"successes"`
+	Failures  uint64        `json:"failures"`
+	Latency   time.Duration `json:"latency"`
+	Height    uint64        `json:"height"`
+	LastGood  time.Time     `json:"lastGood"`
+	Updated   time.Time     `json:"updated"`
+}
+
+// the current selection weight and statistics of a peer
+//
+// statistics are nil if nothing has been recorded for the peer
+func Quality(publicKey []byte) (float64, *QualityItem) {
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	entry, ok := globalData.quality[string(publicKey)]
+	if !ok {
+		return weightOf(publicKey, 0), nil
+	}
+	return entry.weight(bestHeight()), qualityItem(publicKey, entry)
+}
+
+// convert a quality entry to its external form
+func qualityItem(publicKey []byte, entry *qualityEntry) *QualityItem {
+	return &QualityItem{
+		PublicKey: hex.EncodeToString(publicKey),
+		Attempts:  entry.attempts,
+		Successes: entry.successes,
+		Failures:  entry.failures,
+		Latency:   entry.latency,
+		Height:    entry.height,
+		LastGood:  entry.lastGood,
+		Updated:   entry.updated,
+	}
+}
+
+// internal list of all statistics, hold lock before calling
+func qualityList() []QualityItem {
+	items := make([]QualityItem, 0, len(globalData.quality))
+	for key, entry := range globalData.quality {
+		items = append(items, *qualityItem([]byte(key), entry))
+	}
+	return items
+}
+
+// internal restore of saved statistics, hold lock before calling
+func restoreQuality(item QualityItem) error {
+	publicKey, err := hex.DecodeString(item.PublicKey)
+	if nil != err {
+		return err
+	}
+	if time.Since(item.Updated) >= qualityExpiry {
+		return nil
+	}
+	globalData.quality[string(publicKey)] = &qualityEntry{
+		attempts:  item.Attempts,
+		successes: item.Successes,
+		failures:  item.Failures,
+		latency:   item.Latency,
+		height:    item.Height,
+		lastGood:  item.LastGood,
+		updated:   item.Updated,
+	}
+	return nil
+}
+
+// drop statistics of peers no longer in the tree, hold lock before calling
+func expireQuality() {
+	now := time.Now()
+	for key, entry := range globalData.quality {
+		if now.Sub(entry.updated) < qualityExpiry {
+			continue
+		}
+		if node, _ := globalData.peerTree.Search(pubkey(key)); nil == node {
+			delete(globalData.quality, key)
+		}
+	}
+}
+
+// the address range of a peer's preferred listener, used to spread
+// connections across networks
+func addressRange(listeners []byte) string {
+	v4, v6 := util.PackedConnection(listeners).Unpack46()
+	c := v4
+	if nil == c {
+		c = v6
+	}
+	if nil == c {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(c.String())
+	if nil != err {
+		return ""
+	}
+	ip := net.ParseIP(host)
+	if ip4 := ip.To4(); nil != ip4 {
+		return ip4.Mask(net.CIDRMask(ipv4RangePrefix, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(ipv6RangePrefix, 128)).String()
+}
+
+// a peer that can be selected and its weight within its address range
+type candidate struct {
+	peer        *peerEntry
+	network     string
+	weight      float64
+	unavailable bool
+}
+
+// internal selection of up to count peers, hold lock before calling
+//
+// peers are drawn at random weighted by quality, with the weight of
+// each address range shared by its peers, and a range is only drawn
+// from again once every other range has been used; this node, the
+// excluded keys and any peers in the avoided ranges are never chosen
+func selectPeers(count int, exclude [][]byte, avoid []string) []*peerEntry {
+
+	best := bestHeight()
+	avoided := make(map[string]struct{}, len(avoid))
+	for _, r := range avoid {
+		avoided[r] = struct{}{}
+	}
+
+	candidates := []*candidate{}
+	rangeSize := make(map[string]int)
+
+scan_nodes:
+	for node := globalData.peerTree.First(); nil != node; node = node.Next() {
+		peer := node.Value().(*peerEntry)
+		if bytes.Equal(peer.publicKey, globalData.publicKey) {
+			continue scan_nodes
+		}
+		for _, key := range exclude {
+			if bytes.Equal(peer.publicKey, key) {
+				continue scan_nodes
+			}
+		}
+		r := addressRange(peer.listeners)
+		if _, ok := avoided[r]; ok {
+			continue scan_nodes
+		}
+		candidates = append(candidates, &candidate{
+			peer:    peer,
+			network: r,
+			weight:  weightOf(peer.publicKey, best),
+		})
+		rangeSize[r] += 1
+	}
+	for _, c := range candidates {
+		c.weight /= float64(rangeSize[c.network])
+	}
+
+	selected := make([]*peerEntry, 0, count)
+	used := make(map[string]struct{})
+
+select_loop:
+	for len(selected) < count {
+		total := 0.0
+		for _, c := range candidates {
+			if _, ok := used[c.network]; !ok && !c.unavailable {
+				total += c.weight
+			}
+		}
+
+		// all ranges used so allow them again
+		if 0 == total {
+			if 0 == len(used) {
+				break select_loop
+			}
+			used = make(map[string]struct{})
+			continue select_loop
+		}
+
+		r := randomFloat() * total
+		var chosen *candidate
+	pick_loop:
+		for _, c := range candidates {
+			if _, ok := used[c.network]; ok || c.unavailable {
+				continue pick_loop
+			}
+			chosen = c
+			r -= c.weight
+			if r < 0 {
+				break pick_loop
+			}
+		}
+		chosen.unavailable = true
+		used[chosen.network] = struct{}{}
+		selected = append(selected, chosen.peer)
+	}
+	return selected
+}
+
+// uniform random number in the range 0 … 1
+func randomFloat() float64 {
+	n, err := rand.Int(rand.Reader, big.NewInt(randomPrecision))
+	if nil != err {
+		return 0
+	}
+	return float64(n.Int64()) / randomPrecision
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package announce
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQualityWeight(t *testing.T) {
+	fresh := &qualityEntry{}
+	good := &qualityEntry{
+		attempts:  10,
+		successes: 10,
+		latency:   100 * time.Millisecond,
+		height:    1000,
+	}
+	failing := &qualityEntry{
+		attempts:  10,
+		successes: 7,
+		failures:  3,
+		latency:   100 * time.Millisecond,
+	}
+	lagging := *good
+	lagging.height = 1000 - heightLag - 1
+
+	if w := fresh.weight(1000); w < 0.249 || w > 0.251 {
+		t.Errorf("fresh weight: %f  expected: 0.25", w)
+	}
+	if good.weight(1000) <= fresh.weight(1000) {
+		t.Errorf("good weight: %f  not above fresh: %f", good.weight(1000), fresh.weight(1000))
+	}
+	if failing.weight(1000) >= poorWeight {
+		t.Errorf("failing weight: %f  not below: %f", failing.weight(1000), poorWeight)
+	}
+	if w := lagging.weight(1000); w < good.weight(1000)*laggingWeight*0.999 || w > good.weight(1000)*laggingWeight*1.001 {
+		t.Errorf("lagging weight: %f  expected: %f", w, good.weight(1000)*laggingWeight)
+	}
+
+	hopeless := &qualityEntry{
+		attempts: 100,
+		failures: 100,
+		latency:  time.Minute,
+	}
+	if w := hopeless.weight(0); minimumWeight != w {
+		t.Errorf("hopeless weight: %f  expected: %f", w, minimumWeight)
+	}
+}
+
+func TestQualityRecord(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	publicKey, listeners := testPeer(1, "192.0.2.1")
+	addPeer(publicKey, listeners, 0)
+
+	if _, statistics := Quality(publicKey); nil != statistics {
+		t.Errorf("statistics before any record: %+v", statistics)
+	}
+
+	ConnectSucceeded(publicKey, 200*time.Millisecond)
+	HeightReceived(publicKey, 500, 100*time.Millisecond)
+	ConnectFailed(publicKey)
+	ConnectFailed(nil)
+
+	_, statistics := Quality(publicKey)
+	if nil == statistics {
+		t.Fatal("no statistics")
+	}
+	if 2 != statistics.Attempts || 1 != statistics.Successes || 1 != statistics.Failures {
+		t.Errorf("attempts: %d  successes: %d  failures: %d", statistics.Attempts, statistics.Successes, statistics.Failures)
+	}
+	if 500 != statistics.Height {
+		t.Errorf("height: %d  expected: 500", statistics.Height)
+	}
+	if 175*time.Millisecond != statistics.Latency {
+		t.Errorf("latency: %s  expected: 175ms", statistics.Latency)
+	}
+	if 1 != len(globalData.quality) {
+		t.Errorf("entries: %d  expected: 1", len(globalData.quality))
+	}
+
+	ConnectSucceeded(publicKey, 200*time.Millisecond)
+	if _, statistics := Quality(publicKey); 0 != statistics.Failures {
+		t.Errorf("failures after success: %d", statistics.Failures)
+	}
+
+	// a ban drops the statistics
+	Ban(publicKey, time.Hour, "test")
+	if _, statistics := Quality(publicKey); nil != statistics {
+		t.Errorf("statistics after ban: %+v", statistics)
+	}
+}
+
+func TestAddressRange(t *testing.T) {
+	items := []struct {
+		ip      string
+		network string
+	}{
+		{"192.0.2.1", "192.0.0.0"},
+		{"192.0.100.200", "192.0.0.0"},
+		{"198.51.100.1", "198.51.0.0"},
+		{"2001:db8:1::1", "2001:db8::"},
+		{"2001:db9::1", "2001:db9::"},
+	}
+	for i, item := range items {
+		_, listeners := testPeer(1, item.ip)
+		if network := addressRange(listeners); item.network != network {
+			t.Errorf("%d: %s  range: %q  expected: %q", i, item.ip, network, item.network)
+		}
+	}
+}
+
+func TestSelectPeersDiversity(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	for i, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "198.51.100.1"} {
+		publicKey, listeners := testPeer(byte(i+1), ip)
+		addPeer(publicKey, listeners, 0)
+	}
+
+	for i := 0; i < 50; i += 1 {
+		selected := selectPeers(2, nil, nil)
+		if 2 != len(selected) {
+			t.Fatalf("selected: %d  expected: 2", len(selected))
+		}
+		if addressRange(selected[0].listeners) == addressRange(selected[1].listeners) {
+			t.Fatalf("same range: %s  %s", selected[0], selected[1])
+		}
+	}
+
+	// ranges are reused once all have been chosen
+	if selected := selectPeers(5, nil, nil); 5 != len(selected) {
+		t.Errorf("selected: %d  expected: 5", len(selected))
+	}
+
+	// excluded keys and avoided ranges
+	excluded, _ := testPeer(5, "198.51.100.1")
+	for _, p := range selectPeers(5, [][]byte{excluded}, nil) {
+		if bytes.Equal(excluded, p.publicKey) {
+			t.Error("excluded peer selected")
+		}
+	}
+	if selected := selectPeers(5, nil, []string{"192.0.0.0"}); 1 != len(selected) {
+		t.Errorf("selected: %d  expected: 1", len(selected))
+	}
+}
+
+func TestSelectPeersWeighted(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	goodKey, goodListeners := testPeer(1, "192.0.2.1")
+	poorKey, poorListeners := testPeer(2, "198.51.100.1")
+	addPeer(goodKey, goodListeners, 0)
+	addPeer(poorKey, poorListeners, 0)
+
+	for i := 0; i < 10; i += 1 {
+		ConnectSucceeded(goodKey, 50*time.Millisecond)
+		ConnectFailed(poorKey)
+	}
+
+	good := 0
+	for i := 0; i < 200; i += 1 {
+		publicKey, _, _, err := GetRandom(nil)
+		if nil != err {
+			t.Fatalf("get random error: %s", err)
+		}
+		if bytes.Equal(goodKey, publicKey) {
+			good += 1
+		}
+	}
+	if good < 180 {
+		t.Errorf("good peer selected: %d of 200", good)
+	}
+
+	// the requesting peer is never returned
+	for i := 0; i < 20; i += 1 {
+		publicKey, _, _, err := GetRandom(goodKey)
+		if nil != err {
+			t.Fatalf("get random error: %s", err)
+		}
+		if !bytes.Equal(poorKey, publicKey) {
+			t.Fatalf("selected: %x  expected: %x", publicKey, poorKey)
+		}
+	}
+}
+
+func TestBackupRestoreQuality(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	directory, err := ioutil.TempDir("", "announce")
+	if nil != err {
+		t.Fatalf("temporary directory error: %s", err)
+	}
+	defer os.RemoveAll(directory)
+	peerFile := filepath.Join(directory, "peers.json")
+
+	for i, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		publicKey, listeners := testPeer(byte(i+1), ip)
+		addPeer(publicKey, listeners, 0)
+	}
+	publicKey, _ := testPeer(1, "192.0.2.1")
+	ConnectSucceeded(publicKey, 300*time.Millisecond)
+	HeightReceived(publicKey, 1234, 300*time.Millisecond)
+
+	err = backupPeers(peerFile)
+	if nil != err {
+		t.Fatalf("backup error: %s", err)
+	}
+
+	reset()
+	err = restorePeers(peerFile)
+	if nil != err {
+		t.Fatalf("restore error: %s", err)
+	}
+	_, statistics := Quality(publicKey)
+	if nil == statistics {
+		t.Fatal("statistics not restored")
+	}
+	if 1 != statistics.Successes || 1234 != statistics.Height || 300*time.Millisecond != statistics.Latency {
+		t.Errorf("restored: %+v", statistics)
+	}
+}
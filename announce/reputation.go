@@ -0,0 +1,320 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package announce
+
+import (
+	"encoding/hex"
+	"math"
+	"net"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+// kinds of peer misbehaviour
+type Misbehaviour int
+
+const (
+	InvalidBlock  Misbehaviour = iota // block failed validation
+	BadDigest     Misbehaviour = iota // block or header digest did not match
+	Timeout       Misbehaviour = iota // request did not complete
+	ProtocolError Misbehaviour = iota // malformed or unexpected response
+)
+
+// scoring limits
+const (
+	banScore         = 100.0          // score at which a peer is banned
+	scoreHalfLife    = time.Hour      // time for a score to decay by half
+	misbehaviourBan  = 24 * time.Hour // duration of an automatic ban
+	minimumScoreKept = 1.0            // scores below this are forgotten
+)
+
+// score added for each kind of misbehaviour
+var misbehaviourScore = map[Misbehaviour]float64{
+	InvalidBlock:  100,
+	BadDigest:     50,
+	Timeout:       5,
+	ProtocolError: 20,
+}
+
+// the current decaying score of a peer
+type reputationEntry struct {
+	score   float64
+	updated time.Time
+}
+
+// a ban on a public key and the addresses it was using
+type banEntry struct {
+	publicKey []byte
+	addresses []string
+	reason    string
+	expires   time.Time
+}
+
+// String returns the name of a kind of misbehaviour
+func (m Misbehaviour) String() string {
+	switch m {
+	case InvalidBlock:
+		return "invalid block"
+	case BadDigest:
+		return "bad digest"
+	case Timeout:
+		return "timeout"
+	case ProtocolError:
+		return "protocol error"
+	default:
+		return "unknown"
+	}
+}
+
+// record misbehaviour by a peer
+//
+// ip is the address the peer was reached at, not one it reported, and
+// is banned with the key; nil if it is not known
+//
+// returns true if this caused the peer to be banned
+func Misbehaved(publicKey []byte, ip net.IP, kind Misbehaviour) bool {
+	if 0 == len(publicKey) {
+		return false
+	}
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	now := time.Now()
+	key := string(publicKey)
+	if entry, ok := globalData.bannedKeys[key]; ok && now.Before(entry.expires) {
+		return false
+	}
+
+	entry, ok := globalData.reputation[key]
+	if !ok {
+		entry = &reputationEntry{}
+		globalData.reputation[key] = entry
+	}
+	entry.score = decayedScore(entry, now) + misbehaviourScore[kind]
+	entry.updated = now
+
+	globalData.log.Warnf("misbehaviour: %s  peer: %x  score: %.1f", kind, publicKey, entry.score)
+
+	if entry.score < banScore {
+		return false
+	}
+	ban(publicKey, ip, now.Add(misbehaviourBan), kind.String())
+	return true
+}
+
+// ban a peer for a period
+//
+// only the key is banned, the addresses it is seen at are added by
+// IsPeerBanned
+func Ban(publicKey []byte, duration time.Duration, reason string) error {
+	if 0 == len(publicKey) {
+		return fault.ErrInvalidPublicKey
+	}
+	if duration <= 0 {
+		return fault.ErrInvalidCount
+	}
+
+	globalData.Lock()
+	ban(publicKey, nil, time.Now().Add(duration), reason)
+	globalData.Unlock()
+
+	return nil
+}
+
+// internal ban, hold lock before calling
+//
+// the peer is removed from the tree so it is not selected for
+// connections or given to other nodes until the ban expires
+//
+// the listener addresses in the tree are reported by the peer and
+// could name any host, so only an observed ip is banned
+func ban(publicKey []byte, ip net.IP, expires time.Time, reason string) {
+
+	key := string(publicKey)
+	entry := &banEntry{
+		publicKey: append([]byte{}, publicKey...),
+		addresses: []string{},
+		reason:    reason,
+		expires:   expires,
+	}
+
+	if node, _ := globalData.peerTree.Search(pubkey(publicKey)); nil != node {
+		globalData.peerTree.Delete(pubkey(publicKey))
+		globalData.treeChanged = true
+	}
+
+	// a repeated ban keeps any previously observed addresses
+	if previous, ok := globalData.bannedKeys[key]; ok {
+		entry.addresses = previous.addresses
+	}
+
+	globalData.bannedKeys[key] = entry
+	for _, address := range entry.addresses {
+		globalData.bannedAddresses[address] = entry
+	}
+	banAddress(entry, ip)
+	delete(globalData.reputation, key)
+	delete(globalData.quality, key)
+
+	globalData.log.Warnf("ban: %x  addresses: %q  until: %s  reason: %s", publicKey, entry.addresses, expires.Format(timeFormat), reason)
+}
+
+// add an observed address to a ban, hold lock before calling
+func banAddress(entry *banEntry, ip net.IP) {
+	if nil == ip {
+		return
+	}
+	address := ip.String()
+	for _, a := range entry.addresses {
+		if a == address {
+			globalData.bannedAddresses[address] = entry
+			return
+		}
+	}
+	entry.addresses = append(entry.addresses, address)
+	globalData.bannedAddresses[address] = entry
+}
+
+// check for a current ban on a public key
+func IsBanned(publicKey []byte) bool {
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	entry, ok := globalData.bannedKeys[string(publicKey)]
+	return ok && time.Now().Before(entry.expires)
+}
+
+// check for a current ban on an IP address
+func IsAddressBanned(ip net.IP) bool {
+	if nil == ip {
+		return false
+	}
+
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	entry, ok := globalData.bannedAddresses[ip.String()]
+	return ok && time.Now().Before(entry.expires)
+}
+
+// check for a ban on either the key or the address it is seen at
+//
+// ip is the remote address of a connection or the address being
+// dialled; for a banned key it is added to the ban
+func IsPeerBanned(publicKey []byte, ip net.IP) bool {
+	if IsAddressBanned(ip) {
+		return true
+	}
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	entry, ok := globalData.bannedKeys[string(publicKey)]
+	if !ok || !time.Now().Before(entry.expires) {
+		return false
+	}
+	banAddress(entry, ip)
+	return true
+}
+
+// the current misbehaviour score of a peer
+func Score(publicKey []byte) float64 {
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	entry, ok := globalData.reputation[string(publicKey)]
+	if !ok {
+		return 0
+	}
+	return decayedScore(entry, time.Now())
+}
+
+// BanItem is the external form of a ban for backup and the RPC
+type BanItem struct {
+	PublicKey string    `json:"publicKey"`
+	Addresses []string  `json:"addresses"`
+	Reason    string    `json:"reason"`
+	Expires   time.Time `json:"expires"`
+}
+
+// list of all current bans
+func Bans() []BanItem {
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	return banList()
+}
+
+// internal list of bans, hold lock before calling
+func banList() []BanItem {
+	now := time.Now()
+	bans := make([]BanItem, 0, len(globalData.bannedKeys))
+	for _, entry := range globalData.bannedKeys {
+		if now.After(entry.expires) {
+			continue
+		}
+		bans = append(bans, BanItem{
+			PublicKey: hex.EncodeToString(entry.publicKey),
+			Addresses: entry.addresses,
+			Reason:    entry.reason,
+			Expires:   entry.expires,
+		})
+	}
+	return bans
+}
+
+// internal restore of a saved ban, hold lock before calling
+func restoreBan(item BanItem) error {
+	if !time.Now().Before(item.Expires) {
+		return nil
+	}
+	publicKey, err := hex.DecodeString(item.PublicKey)
+	if nil != err {
+		return err
+	}
+	entry := &banEntry{
+		publicKey: publicKey,
+		addresses: item.Addresses,
+		reason:    item.Reason,
+		expires:   item.Expires,
+	}
+	globalData.bannedKeys[string(publicKey)] = entry
+	for _, address := range entry.addresses {
+		globalData.bannedAddresses[address] = entry
+	}
+	return nil
+}
+
+// drop expired bans and forgotten scores, hold lock before calling
+func expireReputation() {
+	now := time.Now()
+	for key, entry := range globalData.bannedKeys {
+		if now.After(entry.expires) {
+			globalData.log.Infof("ban expired: %x", entry.publicKey)
+			delete(globalData.bannedKeys, key)
+		}
+	}
+	for address, entry := range globalData.bannedAddresses {
+		if now.After(entry.expires) {
+			delete(globalData.bannedAddresses, address)
+		}
+	}
+	for key, entry := range globalData.reputation {
+		if decayedScore(entry, now) < minimumScoreKept {
+			delete(globalData.reputation, key)
+		}
+	}
+}
+
+// exponential decay of a score since its last update
+func decayedScore(entry *reputationEntry, now time.Time) float64 {
+	if entry.updated.IsZero() {
+		return entry.score
+	}
+	halfLives := float64(now.Sub(entry.updated)) / float64(scoreHalfLife)
+	return entry.score * math.Pow(0.5, halfLives)
+}
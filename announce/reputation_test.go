@@ -0,0 +1,228 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package announce
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/avl"
+	"github.com/bitmark-inc/bitmarkd/util"
+	"github.com/bitmark-inc/logger"
+)
+
+// test log file
+const (
+	testingLogFile = "test.log"
+)
+
+// configure logging and an empty announcer for testing
+func setup(t *testing.T) {
+	os.Remove(testingLogFile)
+
+	logging := logger.Configuration{
+		Directory: ".",
+		File:      testingLogFile,
+		Size:      50000,
+		Count:     10,
+		Console:   false,
+		Levels: map[string]string{
+			logger.DefaultTag: "critical",
+		},
+	}
+
+	if err := logger.Initialise(logging); nil != err {
+		t.Fatalf("logger initialise error: %s", err)
+	}
+	reset()
+}
+
+// empty announcer data
+func reset() {
+	globalData.log = logger.New("testing")
+	globalData.peerTree = avl.New()
+	globalData.reputation = make(map[string]*reputationEntry)
+	globalData.bannedKeys = make(map[string]*banEntry)
+	globalData.bannedAddresses = make(map[string]*banEntry)
+	globalData.quality = make(map[string]*qualityEntry)
+}
+
+// post test cleanup
+func teardown(t *testing.T) {
+	logger.Finalise()
+	os.Remove(testingLogFile)
+}
+
+// a public key and listener for a test peer
+func testPeer(n byte, ip string) ([]byte, []byte) {
+	publicKey := bytes.Repeat([]byte{n}, 32)
+	c := util.ConnectionFromIPandPort(net.ParseIP(ip), 2136)
+	return publicKey, c.Pack()
+}
+
+func TestMisbehaviourBan(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	// the listener address is reported by the peer, only the address
+	// it was reached at is banned
+	publicKey, listeners := testPeer(1, "192.0.2.9")
+	addPeer(publicKey, listeners, 0)
+	observed := net.ParseIP("192.0.2.1")
+
+	for i := 0; i < 4; i += 1 {
+		if Misbehaved(publicKey, observed, ProtocolError) {
+			t.Fatalf("banned after: %d protocol errors", i+1)
+		}
+	}
+	if score := Score(publicKey); score < 79 || score > 80 {
+		t.Errorf("score: %f  expected: 80", score)
+	}
+
+	if !Misbehaved(publicKey, observed, BadDigest) {
+		t.Fatal("not banned above the ban score")
+	}
+	if !IsBanned(publicKey) {
+		t.Error("public key not banned")
+	}
+	if !IsAddressBanned(net.ParseIP("192.0.2.1")) {
+		t.Error("address not banned")
+	}
+	if IsAddressBanned(net.ParseIP("192.0.2.2")) || IsAddressBanned(net.ParseIP("192.0.2.9")) {
+		t.Error("other address banned")
+	}
+
+	// another key on the same address
+	otherKey, _ := testPeer(2, "192.0.2.1")
+	if !IsPeerBanned(otherKey, observed) {
+		t.Error("peer on banned address not banned")
+	}
+
+	// removed from the tree and cannot be re-added
+	if node, _ := globalData.peerTree.Search(pubkey(publicKey)); nil != node {
+		t.Error("banned peer still in tree")
+	}
+	if addPeer(publicKey, listeners, 0) {
+		t.Error("banned peer was added")
+	}
+
+	bans := Bans()
+	if 1 != len(bans) || BadDigest.String() != bans[0].Reason {
+		t.Errorf("bans: %+v", bans)
+	}
+}
+
+func TestBanExpiry(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	publicKey, listeners := testPeer(3, "192.0.2.3")
+	addPeer(publicKey, listeners, 0)
+
+	err := Ban(publicKey, time.Hour, "admin")
+	if nil != err {
+		t.Fatalf("ban error: %s", err)
+	}
+	if !IsBanned(publicKey) {
+		t.Fatal("not banned")
+	}
+
+	// force expiry
+	globalData.bannedKeys[string(publicKey)].expires = time.Now().Add(-time.Second)
+	if IsBanned(publicKey) || IsPeerBanned(publicKey, net.ParseIP("192.0.2.3")) {
+		t.Error("expired ban still active")
+	}
+
+	expireReputation()
+	if 0 != len(globalData.bannedKeys) || 0 != len(globalData.bannedAddresses) {
+		t.Errorf("expired bans not removed: %d keys  %d addresses", len(globalData.bannedKeys), len(globalData.bannedAddresses))
+	}
+	if !addPeer(publicKey, listeners, 0) {
+		t.Error("peer not added after ban expired")
+	}
+}
+
+func TestScoreDecay(t *testing.T) {
+	entry := &reputationEntry{
+		score:   80,
+		updated: time.Now().Add(-2 * scoreHalfLife),
+	}
+	if score := decayedScore(entry, time.Now()); score < 19.9 || score > 20.1 {
+		t.Errorf("decayed score: %f  expected: 20", score)
+	}
+}
+
+func TestBackupRestoreBans(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	directory, err := ioutil.TempDir("", "announce")
+	if nil != err {
+		t.Fatalf("temporary directory error: %s", err)
+	}
+	defer os.RemoveAll(directory)
+	peerFile := filepath.Join(directory, "peers.json")
+
+	publicKey, listeners := testPeer(4, "192.0.2.4")
+	otherKey, otherListeners := testPeer(5, "192.0.2.5")
+	addPeer(publicKey, listeners, 0)
+	addPeer(otherKey, otherListeners, 0)
+	Ban(publicKey, time.Hour, "admin")
+
+	// a banned key is seen at an address which is then also banned
+	if !IsPeerBanned(publicKey, net.ParseIP("192.0.2.4")) {
+		t.Error("banned key not reported")
+	}
+
+	err = backupPeers(peerFile)
+	if nil != err {
+		t.Fatalf("backup error: %s", err)
+	}
+
+	reset()
+	err = restorePeers(peerFile)
+	if nil != err {
+		t.Fatalf("restore error: %s", err)
+	}
+	if !IsBanned(publicKey) || !IsAddressBanned(net.ParseIP("192.0.2.4")) {
+		t.Error("ban not restored")
+	}
+	if node, _ := globalData.peerTree.Search(pubkey(otherKey)); nil == node {
+		t.Error("peer not restored")
+	}
+
+	// the older format is a plain list of peers
+	err = ioutil.WriteFile(peerFile, []byte(`["`+peerItemText(t, otherKey, otherListeners)+`"]`), 0600)
+	if nil != err {
+		t.Fatalf("write error: %s", err)
+	}
+	reset()
+	err = restorePeers(peerFile)
+	if nil != err {
+		t.Fatalf("restore legacy error: %s", err)
+	}
+	if node, _ := globalData.peerTree.Search(pubkey(otherKey)); nil == node {
+		t.Error("legacy peer not restored")
+	}
+}
+
+// text form of a peer item
+func peerItemText(t *testing.T, publicKey []byte, listeners []byte) string {
+	item := PeerItem{
+		PublicKey: publicKey,
+		Listeners: listeners,
+		Timestamp: uint64(time.Now().Unix()),
+	}
+	text, err := item.MarshalText()
+	if nil != err {
+		t.Fatalf("marshal error: %s", err)
+	}
+	return string(text)
+}
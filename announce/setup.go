@@ -6,8 +6,6 @@ package announce
 
 import (
 	"encoding/hex"
-	"net"
-	"strings"
 	"sync"
 	"time"
 
@@ -63,8 +61,17 @@ type announcerData struct {
 	rpcIndex map[fingerprintType]int // index to find rpc entry
 	rpcList  []*rpcEntry             // array of RPCs
 
-	// data for thread
-	ann announcer
+	// peer misbehaviour scores and bans
+	reputation      map[string]*reputationEntry // public key → score
+	bannedKeys      map[string]*banEntry        // public key → ban
+	bannedAddresses map[string]*banEntry        // IP address → ban
+
+	// peer connection statistics
+	quality map[string]*qualityEntry // public key → statistics
+
+	// data for threads
+	ann  announcer
+	disc discoverer
 
 	// for background
 	background *background.T
@@ -79,7 +86,8 @@ var globalData announcerData
 // initialise the announcement system
 // pass a fully qualified domain for root node list
 // or empty string for no root nodes
-func Initialise(nodesDomain, peerFile string) error {
+// and optionally further discovery sources
+func Initialise(nodesDomain, peerFile string, discovery *DiscoveryConfiguration) error {
 
 	globalData.Lock()
 	defer globalData.Unlock()
@@ -99,6 +107,11 @@ func Initialise(nodesDomain, peerFile string) error {
 	globalData.rpcIndex = make(map[fingerprintType]int, 1000)
 	globalData.rpcList = make([]*rpcEntry, 0, 1000)
 
+	globalData.reputation = make(map[string]*reputationEntry)
+	globalData.bannedKeys = make(map[string]*banEntry)
+	globalData.bannedAddresses = make(map[string]*banEntry)
+	globalData.quality = make(map[string]*qualityEntry)
+
 	globalData.peerSet = false
 	globalData.rpcsSet = false
 	globalData.peerFile = peerFile
@@ -108,46 +121,14 @@ func Initialise(nodesDomain, peerFile string) error {
 		globalData.log.Errorf("fail to restore peer data: %s", err.Error())
 	}
 
-	if "" != nodesDomain {
-		texts, err := net.LookupTXT(nodesDomain)
-		if nil != err {
-			return err
-		}
-
-		// process DNS entries
-		for i, t := range texts {
-			t = strings.TrimSpace(t)
-			tag, err := parseTag(t)
-			if nil != err {
-				globalData.log.Infof("ignore TXT[%d]: %q  error: %s", i, t, err)
-			} else {
-				globalData.log.Infof("process TXT[%d]: %q", i, t)
-				globalData.log.Infof("result[%d]: IPv4: %q  IPv6: %q  rpc: %d  connect: %d", i, tag.ipv4, tag.ipv6, tag.rpcPort, tag.connectPort)
-				globalData.log.Infof("result[%d]: peer public key: %x", i, tag.publicKey)
-				globalData.log.Infof("result[%d]: rpc fingerprint: %x", i, tag.certificateFingerprint)
-
-				listeners := []byte{}
-
-				if nil != tag.ipv4 {
-					c1 := util.ConnectionFromIPandPort(tag.ipv4, tag.connectPort)
-					listeners = append(listeners, c1.Pack()...)
-				}
-				if nil != tag.ipv6 {
-					c2 := util.ConnectionFromIPandPort(tag.ipv6, tag.connectPort)
-					listeners = append(listeners, c2.Pack()...)
-				}
-
-				if nil == tag.ipv4 && nil == tag.ipv6 {
-					globalData.log.Debugf("result[%d]: ignoring invalid record", i)
-				} else {
-					globalData.log.Infof("result[%d]: adding: %x", i, listeners)
-
-					// internal add, as lock is already held
-					addPeer(tag.publicKey, listeners, 0)
-				}
-			}
-		}
+	sources, err := newDiscoveries(nodesDomain, discovery)
+	if nil != err {
+		return err
 	}
+	if err := globalData.disc.initialise(sources); nil != err {
+		return err
+	}
+
 	if err := globalData.ann.initialise(); nil != err {
 		return err
 	}
@@ -160,6 +141,7 @@ func Initialise(nodesDomain, peerFile string) error {
 
 	processes := background.Processes{
 		&globalData.ann,
+		&globalData.disc,
 	}
 
 	globalData.background = background.Start(processes, globalData.log)
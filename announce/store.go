@@ -5,8 +5,10 @@
 package announce
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"io/ioutil"
 	"os"
 
 	"github.com/bitmark-inc/bitmarkd/fault"
@@ -53,9 +55,7 @@ func (item *PeerItem) UnmarshalText(data []byte) error {
 	n += publicKeyLength
 
 	listenerLength, listenerOffset := util.ClippedVarint64(b[n:], 1, 8192)
-
-	ll := listenerLength % 19
-	if 0 == listenerOffset || ll < 1 || ll > 2 {
+	if 0 == listenerOffset || len(b) < n+listenerOffset+listenerLength {
 		return fault.ErrInvalidIPAddress
 	}
 	listener := make([]byte, listenerLength)
@@ -63,6 +63,11 @@ func (item *PeerItem) UnmarshalText(data []byte) error {
 	copy(listener, b[n:n+listenerLength])
 	n += listenerLength
 
+	// packed connections are 7 (IPv4) or 19 (IPv6) bytes each
+	if v4, v6 := util.PackedConnection(listener).Unpack46(); nil == v4 && nil == v6 {
+		return fault.ErrInvalidIPAddress
+	}
+
 	timestamp, timestampLength := util.FromVarint64(b[n:])
 	if 0 == timestampLength {
 		return fault.ErrInvalidTimestamp
@@ -89,9 +94,23 @@ func NewPeerItem(peer *peerEntry) *PeerItem {
 // PeerList is a list of PeerItem
 type PeerList []PeerItem
 
-// backupPeers will backup all peers into a peer file
+// PeerFile is the contents of the peer file
+//
+// older versions wrote only the peer list as a JSON array
+type PeerFile struct {
+	Peers   PeerList      `json:"peers"`
+	Bans    []BanItem     `json:"bans"`
+	Quality []QualityItem `json:"quality"`
+}
+
+// backupPeers will backup all peers, current bans and peer statistics
+// into a peer file
 func backupPeers(peerFile string) error {
-	if globalData.peerTree.Count() <= 2 {
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	bans := banList()
+	if globalData.peerTree.Count() <= 2 && 0 == len(bans) {
 		globalData.log.Info("no need to backup. peer nodes are less than two")
 		return nil
 	}
@@ -109,10 +128,12 @@ func backupPeers(peerFile string) error {
 		node = node.Next()
 	}
 	// backup the last node
-	peer, ok := lastNode.Value().(*peerEntry)
-	if ok {
-		p := NewPeerItem(peer)
-		peers = append(peers, *p)
+	if nil != lastNode {
+		peer, ok := lastNode.Value().(*peerEntry)
+		if ok {
+			p := NewPeerItem(peer)
+			peers = append(peers, *p)
+		}
 	}
 
 	f, err := os.OpenFile(peerFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
@@ -122,27 +143,54 @@ func backupPeers(peerFile string) error {
 	defer f.Close()
 
 	enc := json.NewEncoder(f)
-	return enc.Encode(peers)
+	return enc.Encode(PeerFile{
+		Peers:   peers,
+		Bans:    bans,
+		Quality: qualityList(),
+	})
 }
 
-// restorePeers will backup peers from a peer file
+// restorePeers will restore peers, bans and peer statistics from a
+// peer file
+//
+// bans are restored first so that banned peers are not added
 func restorePeers(peerFile string) error {
-	var peers PeerList
-
-	f, err := os.OpenFile(peerFile, os.O_RDONLY, 0600)
+	data, err := ioutil.ReadFile(peerFile)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	d := json.NewDecoder(f)
-	err = d.Decode(&peers)
+	var file PeerFile
+	if '[' == firstNonSpace(data) {
+		err = json.Unmarshal(data, &file.Peers)
+	} else {
+		err = json.Unmarshal(data, &file)
+	}
 	if err != nil {
 		return err
 	}
 
-	for _, peer := range peers {
+	for _, item := range file.Bans {
+		if err := restoreBan(item); nil != err {
+			globalData.log.Errorf("ignore ban: %q  error: %s", item.PublicKey, err)
+		}
+	}
+	for _, item := range file.Quality {
+		if err := restoreQuality(item); nil != err {
+			globalData.log.Errorf("ignore quality: %q  error: %s", item.PublicKey, err)
+		}
+	}
+	for _, peer := range file.Peers {
 		addPeer(peer.PublicKey, peer.Listeners, peer.Timestamp)
 	}
 	return nil
 }
+
+// first non-whitespace byte of a buffer
+func firstNonSpace(data []byte) byte {
+	trimmed := bytes.TrimSpace(data)
+	if 0 == len(trimmed) {
+		return 0
+	}
+	return trimmed[0]
+}
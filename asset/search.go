@@ -0,0 +1,275 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package asset
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/storage"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+)
+
+// search index keys, all terminated by the 64 byte asset id:
+//
+//   'n' <lowercase name token> 0x00 <asset id>
+//   'f' <fingerprint> 0x00 <asset id>
+//   'm' <lowercase metadata key> 0x00 <lowercase metadata value> 0x00 <asset id>
+//
+// the value is the 8 byte block number key
+const (
+	searchName        = 'n'
+	searchFingerprint = 'f'
+	searchMetadata    = 'm'
+	searchSeparator   = 0x00
+)
+
+// index entries examined per search, a rare name token or metadata
+// value returns a short page and a next key rather than walking the
+// rest of its prefix
+const maximumSearchScan = 1000
+
+// criteria for a search, all non-blank fields must match
+//
+// name matches if every token in it is also a token of the asset
+// name, fingerprint is a prefix and the metadata value is only
+// checked if a key is given
+type SearchQuery struct {
+	Name          string
+	Fingerprint   string
+	MetadataKey   string
+	MetadataValue string
+}
+
+// returned from the Map callback to end the search early
+var errSearchDone = errors.New("search done")
+
+// add a newly confirmed asset to the search index
+//
+// called by block storage after the asset record is stored
+func IndexConfirmed(assetId transactionrecord.AssetIdentifier, asset *transactionrecord.AssetData, blockNumberKey []byte) {
+	for _, key := range searchKeys(assetId, asset) {
+		storage.Pool.AssetSearch.Put(key, blockNumberKey)
+	}
+}
+
+// remove an asset from the search index
+//
+// called by block storage when a block is deleted
+func DeleteIndex(assetId transactionrecord.AssetIdentifier, asset *transactionrecord.AssetData) {
+	for _, key := range searchKeys(assetId, asset) {
+		storage.Pool.AssetSearch.Delete(key)
+	}
+}
+
+// find confirmed assets matching a query
+//
+// start is the value returned as next by a previous search with the
+// same query or nil for the first page, next is nil when there are
+// no more results
+func Search(query *SearchQuery, start []byte, count int) ([]transactionrecord.AssetIdentifier, []byte, error) {
+
+	if count <= 0 {
+		return nil, nil, fault.ErrInvalidCount
+	}
+
+	prefix := searchPrefix(query)
+	if nil == prefix {
+		return nil, nil, fault.ErrMissingParameters
+	}
+
+	cursor := storage.Pool.AssetSearch.NewFetchCursor()
+	if nil == start {
+		cursor.Seek(prefix)
+	} else if bytes.HasPrefix(start, prefix) && len(start) >= len(prefix)+transactionrecord.AssetIdentifierLength {
+		cursor.Seek(start)
+	} else {
+		return nil, nil, fault.ErrInvalidCursor
+	}
+
+	tokens := nameTokens(query.Name)
+
+	results := make([]transactionrecord.AssetIdentifier, 0, count)
+	var next []byte
+	scanned := 0
+
+	err := cursor.Map(func(key []byte, value []byte) error {
+
+		// the start key was returned by the previous page
+		if nil != start && bytes.Equal(key, start) {
+			return nil
+		}
+		if !bytes.HasPrefix(key, prefix) {
+			next = nil
+			return errSearchDone
+		}
+
+		scanned += 1
+		next = key
+
+		var assetId transactionrecord.AssetIdentifier
+		copy(assetId[:], key[len(key)-transactionrecord.AssetIdentifierLength:])
+
+		if matches(assetId, query, tokens) {
+			results = append(results, assetId)
+		}
+		if len(results) >= count || scanned >= maximumSearchScan {
+			return errSearchDone
+		}
+		return nil
+	})
+	if errSearchDone != err && nil != err {
+		return nil, nil, err
+	}
+
+	// reached the end of the index
+	if nil == err {
+		next = nil
+	}
+
+	return results, next, nil
+}
+
+// the index prefix to scan for a query
+//
+// the most selective of the criteria is used and the remainder are
+// checked against the stored asset
+func searchPrefix(query *SearchQuery) []byte {
+
+	if "" != query.Fingerprint {
+		return append([]byte{searchFingerprint}, query.Fingerprint...)
+	}
+
+	if "" != query.MetadataKey {
+		prefix := append([]byte{searchMetadata}, strings.ToLower(query.MetadataKey)...)
+		prefix = append(prefix, searchSeparator)
+		if "" != query.MetadataValue {
+			prefix = append(prefix, strings.ToLower(query.MetadataValue)...)
+			prefix = append(prefix, searchSeparator)
+		}
+		return prefix
+	}
+
+	tokens := nameTokens(query.Name)
+	if 0 != len(tokens) {
+		prefix := append([]byte{searchName}, tokens[0]...)
+		return append(prefix, searchSeparator)
+	}
+
+	return nil
+}
+
+// check all the criteria against the stored asset
+func matches(assetId transactionrecord.AssetIdentifier, query *SearchQuery, tokens []string) bool {
+
+	_, packedAsset := storage.Pool.Assets.GetNB(assetId[:])
+	if nil == packedAsset {
+		return false
+	}
+	transaction, _, err := transactionrecord.Packed(packedAsset).Unpack(mode.IsTesting())
+	if nil != err {
+		return false
+	}
+	asset, ok := transaction.(*transactionrecord.AssetData)
+	if !ok {
+		return false
+	}
+
+	if !strings.HasPrefix(asset.Fingerprint, query.Fingerprint) {
+		return false
+	}
+
+	if 0 != len(tokens) {
+		names := make(map[string]struct{})
+		for _, t := range nameTokens(asset.Name) {
+			names[t] = struct{}{}
+		}
+		for _, t := range tokens {
+			if _, ok := names[t]; !ok {
+				return false
+			}
+		}
+	}
+
+	if "" != query.MetadataKey {
+		key := strings.ToLower(query.MetadataKey)
+		value := strings.ToLower(query.MetadataValue)
+		found := false
+		metadata := metadataPairs(asset.Metadata)
+	metadata_loop:
+		for i := 0; i+1 < len(metadata); i += 2 {
+			if key == metadata[i] && ("" == value || value == metadata[i+1]) {
+				found = true
+				break metadata_loop
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// all the index keys for an asset
+func searchKeys(assetId transactionrecord.AssetIdentifier, asset *transactionrecord.AssetData) [][]byte {
+
+	keys := make([][]byte, 0, 8)
+
+	add := func(tag byte, parts ...string) {
+		key := []byte{tag}
+		for _, p := range parts {
+			key = append(key, p...)
+			key = append(key, searchSeparator)
+		}
+		keys = append(keys, append(key, assetId[:]...))
+	}
+
+	for _, t := range nameTokens(asset.Name) {
+		add(searchName, t)
+	}
+
+	add(searchFingerprint, asset.Fingerprint)
+
+	metadata := metadataPairs(asset.Metadata)
+	for i := 0; i+1 < len(metadata); i += 2 {
+		add(searchMetadata, metadata[i], metadata[i+1])
+	}
+
+	return keys
+}
+
+// split a name into unique lowercase words
+func nameTokens(name string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	seen := make(map[string]struct{})
+	tokens := make([]string, 0, len(fields))
+token_loop:
+	for _, f := range fields {
+		if _, ok := seen[f]; ok {
+			continue token_loop
+		}
+		seen[f] = struct{}{}
+		tokens = append(tokens, f)
+	}
+	return tokens
+}
+
+// split metadata into lowercase key, value pairs
+//
+// the format was checked when the asset was packed
+func metadataPairs(metadata string) []string {
+	if "" == metadata {
+		return nil
+	}
+	return strings.Split(strings.ToLower(metadata), "\u0000")
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package asset
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/storage"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"github.com/bitmark-inc/logger"
+)
+
+// test database file
+const (
+	testingDirName   = "testing"
+	databaseFileName = testingDirName + "/test"
+)
+
+func searchSetup(t *testing.T) {
+	os.RemoveAll(testingDirName)
+	os.Mkdir(testingDirName, 0700)
+
+	logging := logger.Configuration{
+		Directory: testingDirName,
+		File:      "testing.log",
+		Size:      1048576,
+		Count:     10,
+		Console:   false,
+		Levels: map[string]string{
+			logger.DefaultTag: "critical",
+		},
+	}
+	if err := logger.Initialise(logging); nil != err {
+		t.Fatalf("logger initialise error: %s", err)
+	}
+
+	mustReindex, err := storage.Initialise(databaseFileName, storage.ReadWrite)
+	if nil != err {
+		t.Fatalf("storage initialise error: %s", err)
+	}
+	if mustReindex {
+		if err := storage.ReindexDone(); nil != err {
+			t.Fatalf("storage reindex done error: %s", err)
+		}
+	}
+}
+
+func searchTeardown(t *testing.T) {
+	storage.Finalise()
+	logger.Finalise()
+	os.RemoveAll(testingDirName)
+}
+
+// store a signed asset in the same way as block storage
+func storeAsset(t *testing.T, privateKey ed25519.PrivateKey, registrant *account.Account, name string, fingerprint string, metadata string, blockNumber uint64) transactionrecord.AssetIdentifier {
+
+	a := &transactionrecord.AssetData{
+		Name:        name,
+		Fingerprint: fingerprint,
+		Metadata:    metadata,
+		Registrant:  registrant,
+	}
+
+	unsigned, _ := a.Pack(registrant)
+	a.Signature = ed25519.Sign(privateKey, unsigned)
+	packed, err := a.Pack(registrant)
+	if nil != err {
+		t.Fatalf("pack error: %s", err)
+	}
+
+	blockNumberKey := make([]byte, 8)
+	binary.BigEndian.PutUint64(blockNumberKey, blockNumber)
+
+	assetId := a.AssetId()
+	storage.Pool.Assets.Put(assetId[:], blockNumberKey, packed)
+	IndexConfirmed(assetId, a, blockNumberKey)
+
+	return assetId
+}
+
+func TestSearch(t *testing.T) {
+	searchSetup(t)
+	defer searchTeardown(t)
+
+	seed := make([]byte, ed25519.SeedSize)
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	registrant := &account.Account{
+		AccountInterface: &account.ED25519Account{
+			Test:      false,
+			PublicKey: privateKey.Public().(ed25519.PublicKey),
+		},
+	}
+
+	sunset := storeAsset(t, privateKey, registrant, "Sunset over the Sea", "01aa-sunset", "type\x00Photo\x00artist\x00Alice", 2)
+	sunrise := storeAsset(t, privateKey, registrant, "sunrise, sea", "01ab-sunrise", "type\x00photo", 3)
+	song := storeAsset(t, privateKey, registrant, "Sea Song", "02aa-song", "type\x00audio\x00artist\x00alice", 4)
+
+	tests := []struct {
+		query    SearchQuery
+		expected []transactionrecord.AssetIdentifier
+	}{
+		{SearchQuery{Name: "SEA"}, []transactionrecord.AssetIdentifier{sunset, sunrise, song}},
+		{SearchQuery{Name: "sea sunset"}, []transactionrecord.AssetIdentifier{sunset}},
+		{SearchQuery{Name: "moon"}, []transactionrecord.AssetIdentifier{}},
+		{SearchQuery{Fingerprint: "01a"}, []transactionrecord.AssetIdentifier{sunset, sunrise}},
+		{SearchQuery{Fingerprint: "01", Name: "sunrise"}, []transactionrecord.AssetIdentifier{sunrise}},
+		{SearchQuery{MetadataKey: "Type", MetadataValue: "photo"}, []transactionrecord.AssetIdentifier{sunset, sunrise}},
+		{SearchQuery{MetadataKey: "artist"}, []transactionrecord.AssetIdentifier{sunset, song}},
+		{SearchQuery{MetadataKey: "artist", Name: "song"}, []transactionrecord.AssetIdentifier{song}},
+	}
+
+	for i, item := range tests {
+		result, next, err := Search(&item.query, nil, 10)
+		if nil != err {
+			t.Fatalf("%d: search error: %s", i, err)
+		}
+		if nil != next {
+			t.Errorf("%d: unexpected next: %x", i, next)
+		}
+		if !sameAssets(result, item.expected) {
+			t.Errorf("%d: %+v  result: %v  expected: %v", i, item.query, result, item.expected)
+		}
+	}
+
+	// paging
+	query := SearchQuery{Name: "sea"}
+	all := []transactionrecord.AssetIdentifier{}
+	var start []byte
+	for i := 0; i < 4; i += 1 {
+		result, next, err := Search(&query, start, 1)
+		if nil != err {
+			t.Fatalf("page: %d  search error: %s", i, err)
+		}
+		all = append(all, result...)
+		if nil == next {
+			break
+		}
+		start = next
+	}
+	if !sameAssets(all, []transactionrecord.AssetIdentifier{sunset, sunrise, song}) {
+		t.Errorf("paged result: %v", all)
+	}
+
+	// a token from another query cannot be used
+	_, next, _ := Search(&SearchQuery{Fingerprint: "01"}, nil, 1)
+	if _, _, err := Search(&query, next, 1); fault.ErrInvalidCursor != err {
+		t.Errorf("mismatched start error: %v  expected: %v", err, fault.ErrInvalidCursor)
+	}
+
+	if _, _, err := Search(&SearchQuery{}, nil, 1); fault.ErrMissingParameters != err {
+		t.Errorf("empty query error: %v  expected: %v", err, fault.ErrMissingParameters)
+	}
+
+	// roll back one asset
+	_, packed := storage.Pool.Assets.GetNB(sunset[:])
+	tx, _, err := transactionrecord.Packed(packed).Unpack(false)
+	if nil != err {
+		t.Fatalf("unpack error: %s", err)
+	}
+	DeleteIndex(sunset, tx.(*transactionrecord.AssetData))
+	storage.Pool.Assets.Delete(sunset[:])
+
+	result, _, err := Search(&SearchQuery{Name: "sea"}, nil, 10)
+	if nil != err {
+		t.Fatalf("search error: %s", err)
+	}
+	if !sameAssets(result, []transactionrecord.AssetIdentifier{sunrise, song}) {
+		t.Errorf("after delete: %v", result)
+	}
+
+	cursor := storage.Pool.AssetSearch.NewFetchCursor()
+	count := 0
+	cursor.Map(func(key []byte, value []byte) error {
+		count += 1
+		return nil
+	})
+	// sunrise: 2 names + fingerprint + 1 metadata, song: 2 + 1 + 2
+	if 9 != count {
+		t.Errorf("index entries: %d  expected: 9", count)
+	}
+}
+
+// compare ignoring order
+func sameAssets(a []transactionrecord.AssetIdentifier, b []transactionrecord.AssetIdentifier) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[transactionrecord.AssetIdentifier]int)
+	for _, id := range a {
+		set[id] += 1
+	}
+	for _, id := range b {
+		set[id] -= 1
+	}
+	for _, n := range set {
+		if 0 != n {
+			return false
+		}
+	}
+	return true
+}
@@ -9,7 +9,10 @@ import (
 
 	"github.com/bitmark-inc/bitmarkd/account"
 	"github.com/bitmark-inc/bitmarkd/asset"
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
 	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/blockring"
+	"github.com/bitmark-inc/bitmarkd/genesis"
 	"github.com/bitmark-inc/bitmarkd/mode"
 	"github.com/bitmark-inc/bitmarkd/ownership"
 	"github.com/bitmark-inc/bitmarkd/reservoir"
@@ -23,6 +26,15 @@ func DeleteDownToBlock(finalBlockNumber uint64) error {
 	globalData.Lock()
 	defer globalData.Unlock()
 
+	reservoir.Disable()
+	defer reservoir.Enable()
+
+	return deleteDownToBlock(finalBlockNumber)
+}
+
+// must hold lock and have the reservoir disabled to call this
+func deleteDownToBlock(finalBlockNumber uint64) error {
+
 	log := globalData.log
 
 	log.Infof("Delete down to block: %d", finalBlockNumber)
@@ -32,23 +44,38 @@ func DeleteDownToBlock(finalBlockNumber uint64) error {
 		return nil // block store is already empty
 	}
 
-	reservoir.Disable()
-	defer reservoir.Enable()
-
 	packedBlock := last.Value
 
+	// stored blocks are not checked against the current difficulty as
+	// it may have changed since they were stored; only the top digest
+	// may need computing, each lower one is the previous block digest
+	// of the block above it
+	top := binary.BigEndian.Uint64(last.Key)
+	var digest blockdigest.Digest
+	if d := blockring.DigestForBlock(top); nil != d {
+		digest = *d
+	} else {
+		d, err := storedDigest(top, packedBlock)
+		if nil != err {
+			log.Criticalf("failed to digest block: %d from storage  error: %s", top, err)
+			return err
+		}
+		digest = d
+	}
+
 outer_loop:
 	for {
-		header, digest, data, err := blockrecord.ExtractHeader(packedBlock)
+		header, err := unpackHeader(packedBlock)
 		if nil != err {
 			log.Criticalf("failed to unpack block: %d from storage  error: %s", binary.BigEndian.Uint64(last.Key), err)
 			return err
 		}
+		data := packedBlock[len(blockrecord.PackedHeader{}):]
 
 		// finished
 		if header.Number < finalBlockNumber {
 			log.Infof("finish: _NOT_ Deleting: %d", header.Number)
-			fillRingBuffer(log)
+			resetToBlock(log, header, digest, packedBlock)
 			return nil
 		}
 
@@ -76,6 +103,7 @@ outer_loop:
 
 			case *transactionrecord.AssetData:
 				assetId := tx.AssetId()
+				asset.DeleteIndex(assetId, tx)
 				storage.Pool.Assets.Delete(assetId[:])
 				asset.Delete(assetId)
 
@@ -93,6 +121,8 @@ outer_loop:
 				storage.Pool.Transactions.Delete(txId[:])
 				reservoir.DeleteByTxId(txId)
 				link := tr.GetLink()
+				storage.Pool.TxSuccessor.Delete(link[:])
+				storage.Pool.TxOrigin.Delete(txId[:])
 				linkOwner := ownership.OwnerOf(link)
 				if nil == linkOwner {
 					log.Criticalf("missing transaction record for: %v", link)
@@ -112,6 +142,8 @@ outer_loop:
 				key := txId[:]
 				storage.Pool.Transactions.Delete(key)
 				reservoir.DeleteByTxId(txId)
+				storage.Pool.TxSuccessor.Delete(tx.Link[:])
+				storage.Pool.TxOrigin.Delete(key)
 				linkOwner := ownership.OwnerOf(tx.Link)
 				if nil == linkOwner {
 					log.Criticalf("missing transaction record for: %v", tx.Link)
@@ -144,16 +176,88 @@ outer_loop:
 		}
 		// remove remaining block data
 		storage.Pool.BlockOwnerTxIndex.Delete(foundationTxId[:])
+		storage.Pool.BlockHeaderHash.Delete(digest[:])
+		storage.Pool.ChainWork.Delete(blockNumberKey)
 		storage.Pool.Blocks.Delete(blockNumberKey)
 
 		// fetch previous block number
 		binary.BigEndian.PutUint64(blockNumberKey, header.Number-1)
 		packedBlock = storage.Pool.Blocks.Get(blockNumberKey)
+		digest = header.PreviousBlock
 
 		if nil == packedBlock {
 			break outer_loop
 		}
 
 	}
+
+	// all blocks deleted
+	resetToGenesis(log)
 	return nil
 }
+
+// make a stored block the highest after deleting those above it
+//
+// the ring is refilled from the previous block links below the block
+// so no digest is recomputed and the blocks are not checked against
+// the current difficulty, which may have changed since they were stored
+//
+// must hold lock to call this
+func resetToBlock(log *logger.L, header *blockrecord.Header, digest blockdigest.Digest, packedBlock []byte) {
+
+	globalData.previousBlock = digest
+	globalData.previousVersion = header.Version
+	globalData.previousTimestamp = header.Timestamp
+	globalData.height = header.Number
+
+	type ringItem struct {
+		number uint64
+		digest blockdigest.Digest
+		packed []byte
+	}
+
+	items := make([]ringItem, 0, blockring.Size)
+	key := make([]byte, 8)
+	n := header.Number
+
+ring_loop:
+	for n > genesis.BlockNumber && len(items) < blockring.Size {
+		h, err := unpackHeader(packedBlock)
+		if nil != err {
+			log.Criticalf("failed to unpack block: %d from storage  error: %s", n, err)
+			break ring_loop
+		}
+		items = append(items, ringItem{
+			number: n,
+			digest: digest,
+			packed: packedBlock,
+		})
+
+		n -= 1
+		digest = h.PreviousBlock
+		binary.BigEndian.PutUint64(key, n)
+		packedBlock = storage.Pool.Blocks.Get(key)
+		if nil == packedBlock {
+			break ring_loop
+		}
+	}
+
+	blockring.Clear(log)
+	for i := len(items) - 1; i >= 0; i -= 1 {
+		blockring.Put(items[i].number, items[i].digest, items[i].packed)
+	}
+}
+
+// the state of an empty block store, as set by Initialise
+//
+// must hold lock to call this
+func resetToGenesis(log *logger.L) {
+	globalData.height = genesis.BlockNumber
+	globalData.previousBlock = genesis.LiveGenesisDigest
+	globalData.previousVersion = 1
+	globalData.previousTimestamp = 0
+	if mode.IsTesting() {
+		globalData.previousBlock = genesis.TestGenesisDigest
+	}
+	blockring.Clear(log)
+}
@@ -53,15 +53,152 @@ func DigestForBlock(number uint64) (blockdigest.Digest, error) {
 		}
 	}
 
-	// no cache, fetch block and compute digest
+	// no cache, fetch block and find its digest without checking it
+	// against the current difficulty
 	n := make([]byte, 8)
 	binary.BigEndian.PutUint64(n, number)
-	packed := storage.Pool.Blocks.Get(n) // ***** FIX THIS: possible optimisation is to store the block hashes in a separate index
+	packed := storage.Pool.Blocks.Get(n)
 	if nil == packed {
 		return blockdigest.Digest{}, fault.ErrBlockNotFound
 	}
 
-	_, digest, _, err := blockrecord.ExtractHeader(packed)
+	return storedDigest(number, packed)
+}
+
+// find the number of a stored block from its digest
+//
+// uses the header hash index so is only valid for blocks on the
+// current chain
+func NumberForDigest(digest blockdigest.Digest) (uint64, error) {
+
+	if mode.IsTesting() && genesis.TestGenesisDigest == digest {
+		return genesis.BlockNumber, nil
+	} else if !mode.IsTesting() && genesis.LiveGenesisDigest == digest {
+		return genesis.BlockNumber, nil
+	}
+
+	n := storage.Pool.BlockHeaderHash.Get(digest[:])
+	if 8 != len(n) {
+		return 0, fault.ErrBlockNotFound
+	}
+
+	return binary.BigEndian.Uint64(n), nil
+}
+
+// get the header and digest of a stored block
+//
+// the digest is taken from the cache or from the previous block field
+// of the following block where possible, as computing it is slow
+func HeaderForBlock(number uint64) (*blockrecord.Header, blockdigest.Digest, error) {
+	header, digest, _, err := DecodeBlock(number)
+	return header, digest, err
+}
+
+// get the header, digest and packed transactions of a stored block
+//
+// the header is not checked against the current difficulty so that
+// blocks from any part of the chain can be decoded
+func DecodeBlock(number uint64) (*blockrecord.Header, blockdigest.Digest, []byte, error) {
+
+	var packed []byte
+	if genesis.BlockNumber == number {
+		packed = genesis.LiveGenesisBlock
+		if mode.IsTesting() {
+			packed = genesis.TestGenesisBlock
+		}
+	} else {
+		n := make([]byte, 8)
+		binary.BigEndian.PutUint64(n, number)
+		packed = storage.Pool.Blocks.Get(n)
+	}
+	if nil == packed {
+		return nil, blockdigest.Digest{}, nil, fault.ErrBlockNotFound
+	}
+
+	header, err := unpackHeader(packed)
+	if nil != err {
+		return nil, blockdigest.Digest{}, nil, err
+	}
+	data := packed[len(blockrecord.PackedHeader{}):]
+
+	if genesis.BlockNumber == number {
+		if mode.IsTesting() {
+			return header, genesis.TestGenesisDigest, data, nil
+		}
+		return header, genesis.LiveGenesisDigest, data, nil
+	}
 
-	return digest, err
+	if d := blockring.DigestForBlock(number); nil != d {
+		return header, *d, data, nil
+	}
+
+	digest, err := storedDigest(number, packed)
+	if nil != err {
+		return nil, blockdigest.Digest{}, nil, err
+	}
+	return header, digest, data, nil
+}
+
+// digest of a stored block that is not in the cache
+//
+// the previous block field of the following block is used if the
+// header hash index confirms it, only the tip needs the slow digest
+func storedDigest(number uint64, packed []byte) (blockdigest.Digest, error) {
+	n := make([]byte, 8)
+	binary.BigEndian.PutUint64(n, number+1)
+	if next := storage.Pool.Blocks.Get(n); nil != next {
+		nextHeader, err := unpackHeader(next)
+		if nil == err {
+			d := nextHeader.PreviousBlock
+			indexed := storage.Pool.BlockHeaderHash.Get(d[:])
+			if 8 == len(indexed) && number == binary.BigEndian.Uint64(indexed) {
+				return d, nil
+			}
+		}
+	}
+
+	var packedHeader blockrecord.PackedHeader
+	if len(packed) < len(packedHeader) {
+		return blockdigest.Digest{}, fault.ErrInvalidBlockHeaderSize
+	}
+	copy(packedHeader[:], packed)
+	return packedHeader.Digest(), nil
+}
+
+// the packed header of a block without decoding it
+func PackedHeaderForBlock(number uint64) (blockrecord.PackedHeader, error) {
+
+	var packedHeader blockrecord.PackedHeader
+
+	var packed []byte
+	if genesis.BlockNumber == number {
+		packed = genesis.LiveGenesisBlock
+		if mode.IsTesting() {
+			packed = genesis.TestGenesisBlock
+		}
+	} else {
+		n := make([]byte, 8)
+		binary.BigEndian.PutUint64(n, number)
+		packed = storage.Pool.Blocks.Get(n)
+	}
+	if nil == packed {
+		return packedHeader, fault.ErrBlockNotFound
+	}
+	if len(packed) < len(packedHeader) {
+		return packedHeader, fault.ErrInvalidBlockHeaderSize
+	}
+
+	copy(packedHeader[:], packed)
+	return packedHeader, nil
+}
+
+// decode the header at the start of a packed block without any checks
+// against the current difficulty
+func unpackHeader(packed []byte) (*blockrecord.Header, error) {
+	var packedHeader blockrecord.PackedHeader
+	if len(packed) < len(packedHeader) {
+		return nil, fault.ErrInvalidBlockHeaderSize
+	}
+	copy(packedHeader[:], packed)
+	return packedHeader.Unpack()
 }
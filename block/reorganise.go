@@ -0,0 +1,91 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package block
+
+import (
+	"encoding/binary"
+
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/reservoir"
+	"github.com/bitmark-inc/bitmarkd/storage"
+)
+
+// replace the blocks after ancestor with the replacement blocks
+//
+// the lock is held from the delete until the last block is stored or
+// restored so no other block can be stored between the two chains
+//
+// returns the displaced blocks; if a replacement block is rejected
+// the displaced blocks are restored, invalid is set and the error of
+// the rejected block is returned
+func Reorganise(ancestor uint64, replacement [][]byte) (displaced [][]byte, invalid bool, err error) {
+	return reorganise(ancestor, replacement, blockrecord.ExtractHeader, extractRestored)
+}
+
+// the checks for replacement and restored blocks are parameters so
+// that tests need not compute digests
+func reorganise(ancestor uint64, replacement [][]byte, extractNew extractFunc, extractOld extractFunc) ([][]byte, bool, error) {
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	reservoir.Disable()
+	defer reservoir.Enable()
+
+	log := globalData.log
+
+	displaced := storedBlocks(ancestor+1, globalData.height)
+
+	err := deleteDownToBlock(ancestor + 1)
+	if nil != err {
+		return nil, false, err
+	}
+
+	for i, packed := range replacement {
+		err := storeLocked(packed, extractNew)
+		if nil == err {
+			continue
+		}
+		log.Errorf("reorganise: new chain failed after: %d blocks  error: %s", i, err)
+
+		// put back the original blocks
+		restoreErr := deleteDownToBlock(ancestor + 1)
+		if nil != restoreErr {
+			log.Criticalf("restore: delete down to block: %d  error: %s", ancestor+1, restoreErr)
+			return nil, true, err
+		}
+		for j, packed := range displaced {
+			restoreErr := storeLocked(packed, extractOld)
+			if nil != restoreErr {
+				log.Criticalf("restore: block: %d  error: %s", ancestor+1+uint64(j), restoreErr)
+				return nil, true, err
+			}
+		}
+		log.Warnf("restored: %d blocks from: %d", len(displaced), ancestor+1)
+		return nil, true, err
+	}
+
+	return displaced, false, nil
+}
+
+// copy of the packed blocks in a range
+//
+// must hold lock to call this
+func storedBlocks(first uint64, last uint64) [][]byte {
+	if last < first {
+		return nil
+	}
+	blocks := make([][]byte, 0, last-first+1)
+	key := make([]byte, 8)
+	for n := first; n <= last; n += 1 {
+		binary.BigEndian.PutUint64(key, n)
+		packed := storage.Pool.Blocks.Get(key)
+		if nil == packed {
+			break
+		}
+		blocks = append(blocks, append([]byte{}, packed...))
+	}
+	return blocks
+}
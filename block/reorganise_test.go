@@ -0,0 +1,236 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/blockring"
+	"github.com/bitmark-inc/bitmarkd/chain"
+	"github.com/bitmark-inc/bitmarkd/currency"
+	"github.com/bitmark-inc/bitmarkd/difficulty"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/storage"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"github.com/bitmark-inc/logger"
+)
+
+// test files
+const (
+	testingDirName = "testing"
+	testingLogFile = "test.log"
+)
+
+// start the block system on an empty testing chain
+func setup(t *testing.T) {
+	os.RemoveAll(testingDirName)
+	os.Mkdir(testingDirName, 0700)
+
+	logging := logger.Configuration{
+		Directory: testingDirName,
+		File:      testingLogFile,
+		Size:      50000,
+		Count:     10,
+		Levels: map[string]string{
+			logger.DefaultTag: "critical",
+		},
+	}
+	if err := logger.Initialise(logging); nil != err {
+		t.Fatalf("logger initialise error: %s", err)
+	}
+	if err := mode.Initialise(chain.Testing); nil != err {
+		t.Fatalf("mode initialise error: %s", err)
+	}
+	mustReindex, err := storage.Initialise(testingDirName+"/test", storage.ReadWrite)
+	if nil != err {
+		t.Fatalf("storage initialise error: %s", err)
+	}
+	if mustReindex {
+		if err := storage.ReindexDone(); nil != err {
+			t.Fatalf("storage reindex done error: %s", err)
+		}
+	}
+	if err := blockring.Initialise(); nil != err {
+		t.Fatalf("blockring initialise error: %s", err)
+	}
+	if err := Initialise(false); nil != err {
+		t.Fatalf("block initialise error: %s", err)
+	}
+}
+
+// post test cleanup
+func teardown(t *testing.T) {
+	Finalise()
+	blockring.Finalise()
+	storage.Finalise()
+	mode.Finalise()
+	logger.Finalise()
+	os.RemoveAll(testingDirName)
+}
+
+// checking real digests against a difficulty is too slow for a test,
+// so blocks are chained by a cheap digest of their header
+func testExtract(packedBlock []byte) (*blockrecord.Header, blockdigest.Digest, []byte, error) {
+	header, err := unpackHeader(packedBlock)
+	if nil != err {
+		return nil, blockdigest.Digest{}, nil, err
+	}
+	headerSize := len(blockrecord.PackedHeader{})
+	digest := blockdigest.Digest(merkle.NewDigest(packedBlock[:headerSize]))
+	return header, digest, packedBlock[headerSize:], nil
+}
+
+// build a chain of blocks after previous
+func makeBlocks(t *testing.T, first uint64, previous blockdigest.Digest, count int, nonce uint64) [][]byte {
+
+	seed := make([]byte, ed25519.SeedSize)
+	key := ed25519.NewKeyFromSeed(seed)
+	owner := &account.Account{
+		AccountInterface: &account.ED25519Account{
+			Test:      true,
+			PublicKey: key.Public().(ed25519.PublicKey),
+		},
+	}
+
+	blocks := make([][]byte, 0, count)
+	for i := 0; i < count; i += 1 {
+		foundation := &transactionrecord.BlockFoundation{
+			Version: transactionrecord.FoundationVersion,
+			Payments: currency.Map{
+				currency.Bitcoin:  "mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn",
+				currency.Litecoin: "mmCKZS7toE69QgXNs1JZcjW6LFj8LfUbz6",
+			},
+			Owner: owner,
+			Nonce: nonce + uint64(i),
+		}
+		unsigned, _ := foundation.Pack(owner)
+		foundation.Signature = ed25519.Sign(key, unsigned)
+		packed, err := foundation.Pack(owner)
+		if nil != err {
+			t.Fatalf("foundation pack error: %s", err)
+		}
+
+		// a block needs at least two transactions
+		asset := &transactionrecord.AssetData{
+			Name:        "asset",
+			Fingerprint: fmt.Sprintf("fingerprint-%d", nonce+uint64(i)),
+			Registrant:  owner,
+		}
+		unsigned, _ = asset.Pack(owner)
+		asset.Signature = ed25519.Sign(key, unsigned)
+		packedAsset, err := asset.Pack(owner)
+		if nil != err {
+			t.Fatalf("asset pack error: %s", err)
+		}
+
+		txIds := []merkle.Digest{merkle.NewDigest(packed), merkle.NewDigest(packedAsset)}
+		tree := merkle.FullMerkleTree(txIds)
+
+		header := blockrecord.Header{
+			Version:          blockrecord.Version,
+			TransactionCount: 2,
+			Number:           first + uint64(i),
+			PreviousBlock:    previous,
+			MerkleRoot:       tree[len(tree)-1],
+			Timestamp:        uint64(1000 + i),
+			Difficulty:       difficulty.New(),
+		}
+		packedHeader := header.Pack()
+		block := append(packedHeader[:], packed...)
+		block = append(block, packedAsset...)
+
+		_, digest, _, _ := testExtract(block)
+		previous = digest
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// store the blocks of a chain
+func storeBlocks(t *testing.T, blocks [][]byte) {
+	for _, packed := range blocks {
+		if err := storeBlock(packed, testExtract); nil != err {
+			t.Fatalf("store error: %s", err)
+		}
+	}
+}
+
+// check the stored chain and the current block match the blocks
+func checkChain(t *testing.T, first uint64, blocks [][]byte) {
+	last := first + uint64(len(blocks)) - 1
+	if h := GetHeight(); last != h {
+		t.Errorf("height: %d  expected: %d", h, last)
+	}
+	key := make([]byte, 8)
+	for i, expected := range blocks {
+		_, digest, _, _ := testExtract(expected)
+		n := first + uint64(i)
+		if d, err := DigestForBlock(n); nil != err || digest != d {
+			t.Errorf("block: %d  digest: %v  expected: %v  error: %v", n, d, digest, err)
+		}
+		binary.BigEndian.PutUint64(key, n)
+		if !bytes.Equal(expected, storage.Pool.Blocks.Get(key)) {
+			t.Errorf("block: %d  stored block does not match", n)
+		}
+		if n == last && digest != globalData.previousBlock {
+			t.Errorf("previous block: %v  expected: %v", globalData.previousBlock, digest)
+		}
+	}
+}
+
+func TestReorganiseSwitch(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	old := makeBlocks(t, 2, genesis.TestGenesisDigest, 3, 0)
+	storeBlocks(t, old)
+
+	_, ancestor, _, _ := testExtract(old[0])
+	replacement := makeBlocks(t, 3, ancestor, 3, 100)
+
+	displaced, invalid, err := reorganise(2, replacement, testExtract, testExtract)
+	if nil != err || invalid {
+		t.Fatalf("reorganise: invalid: %t  error: %v", invalid, err)
+	}
+
+	if 2 != len(displaced) || !bytes.Equal(old[1], displaced[0]) || !bytes.Equal(old[2], displaced[1]) {
+		t.Errorf("displaced blocks do not match the old chain")
+	}
+	checkChain(t, 2, append(old[:1:1], replacement...))
+}
+
+func TestReorganiseRestore(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	old := makeBlocks(t, 2, genesis.TestGenesisDigest, 3, 0)
+	storeBlocks(t, old)
+
+	// second replacement block does not follow the first
+	_, ancestor, _, _ := testExtract(old[0])
+	replacement := makeBlocks(t, 3, ancestor, 1, 100)
+	replacement = append(replacement, makeBlocks(t, 4, ancestor, 1, 200)...)
+
+	displaced, invalid, err := reorganise(2, replacement, testExtract, testExtract)
+	if fault.ErrPreviousBlockDigestDoesNotMatch != err || !invalid {
+		t.Fatalf("reorganise: invalid: %t  error: %v", invalid, err)
+	}
+	if 0 != len(displaced) {
+		t.Errorf("displaced: %d blocks after restore", len(displaced))
+	}
+	checkChain(t, 2, old)
+}
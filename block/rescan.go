@@ -0,0 +1,23 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package block
+
+import (
+	"github.com/bitmark-inc/bitmarkd/reservoir"
+)
+
+// drop reservoir transactions invalidated by confirmed blocks
+//
+// holds the block lock so that storing or deleting a block cannot
+// re-enable the reservoir part way through the rescan
+func RescanReservoir() {
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	reservoir.Disable()
+	defer reservoir.Enable()
+
+	reservoir.Rescan()
+}
@@ -9,8 +9,10 @@ import (
 
 	"github.com/bitmark-inc/bitmarkd/account"
 	"github.com/bitmark-inc/bitmarkd/asset"
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
 	"github.com/bitmark-inc/bitmarkd/blockrecord"
 	"github.com/bitmark-inc/bitmarkd/blockring"
+	"github.com/bitmark-inc/bitmarkd/checkpoint"
 	"github.com/bitmark-inc/bitmarkd/currency"
 	"github.com/bitmark-inc/bitmarkd/currency/litecoin"
 	"github.com/bitmark-inc/bitmarkd/fault"
@@ -25,6 +27,35 @@ import (
 
 // store an incoming block checking to make sure it is valid first
 func StoreIncoming(packedBlock []byte) error {
+	return storeBlock(packedBlock, blockrecord.ExtractHeader)
+}
+
+// decode a block that was deleted by a failed reorganisation
+//
+// its difficulty was checked against the current difficulty when it
+// was first stored, which may since have changed, so only its digest
+// is checked against the difficulty in its own header
+func extractRestored(packedBlock []byte) (*blockrecord.Header, blockdigest.Digest, []byte, error) {
+	header, err := unpackHeader(packedBlock)
+	if nil != err {
+		return nil, blockdigest.Digest{}, nil, err
+	}
+
+	var packedHeader blockrecord.PackedHeader
+	copy(packedHeader[:], packedBlock)
+	digest := packedHeader.Digest()
+	if digest.Cmp(header.Difficulty.BigInt()) > 0 {
+		return nil, blockdigest.Digest{}, nil, fault.ErrInvalidBlockHeaderDifficulty
+	}
+
+	return header, digest, packedBlock[len(packedHeader):], nil
+}
+
+// decode a packed block and check its header
+type extractFunc func([]byte) (*blockrecord.Header, blockdigest.Digest, []byte, error)
+
+// validate and store a block using extract to decode and check its header
+func storeBlock(packedBlock []byte, extract extractFunc) error {
 
 	globalData.Lock()
 	defer globalData.Unlock()
@@ -32,7 +63,13 @@ func StoreIncoming(packedBlock []byte) error {
 	reservoir.Disable()
 	defer reservoir.Enable()
 
-	header, digest, data, err := blockrecord.ExtractHeader(packedBlock)
+	return storeLocked(packedBlock, extract)
+}
+
+// must hold lock and have the reservoir disabled to call this
+func storeLocked(packedBlock []byte, extract extractFunc) error {
+
+	header, digest, data, err := extract(packedBlock)
 	if nil != err {
 		return err
 	}
@@ -43,6 +80,12 @@ func StoreIncoming(packedBlock []byte) error {
 		return fault.ErrPreviousBlockDigestDoesNotMatch
 	}
 
+	// blocks at a checkpoint must match it
+	err = checkpoint.Verify(header.Number, digest)
+	if nil != err {
+		return err
+	}
+
 	// check version
 	if header.Version < 1 {
 		return fault.ErrInvalidBlockHeaderVersion
@@ -274,10 +317,11 @@ func StoreIncoming(packedBlock []byte) error {
 			asset.Delete(assetId) // delete from pending cache
 			if !storage.Pool.Assets.Has(assetId[:]) {
 				storage.Pool.Assets.Put(assetId[:], blockNumberKey, item.packed)
+				asset.IndexConfirmed(assetId, tx, blockNumberKey)
 			}
 
 		case *transactionrecord.BitmarkIssue:
-			reservoir.DeleteByTxId(item.txId) // delete from pending cache
+			reservoir.ConfirmByTxId(item.txId) // delete from pending cache
 			if !storage.Pool.Transactions.Has(item.txId[:]) {
 				storage.Pool.Transactions.Put(item.txId[:], blockNumberKey, item.packed)
 				ownership.CreateAsset(item.txId, header.Number, tx.AssetId, tx.Owner)
@@ -285,7 +329,7 @@ func StoreIncoming(packedBlock []byte) error {
 
 		case *transactionrecord.BitmarkTransferUnratified, *transactionrecord.BitmarkTransferCountersigned:
 			tr := tx.(transactionrecord.BitmarkTransfer)
-			reservoir.DeleteByTxId(item.txId)
+			reservoir.ConfirmByTxId(item.txId)
 			link := tr.GetLink()
 
 			// when deleting a pending it is possible that the tx id
@@ -295,13 +339,15 @@ func StoreIncoming(packedBlock []byte) error {
 			reservoir.DeleteByLink(link)
 
 			storage.Pool.Transactions.Put(item.txId[:], blockNumberKey, item.packed)
+			storage.Pool.TxSuccessor.Put(link[:], item.txId[:])
+			storeOrigin(item.txId, link)
 			ownership.Transfer(link, item.txId, header.Number, item.linkOwner, tr.GetOwner())
 
 		case *transactionrecord.BlockFoundation:
 			logger.Panicf("should not occur: %+v", tx)
 
 		case *transactionrecord.BlockOwnerTransfer:
-			reservoir.DeleteByTxId(item.txId)
+			reservoir.ConfirmByTxId(item.txId)
 			link := tx.Link
 
 			// when deleting a pending it is possible that the tx id
@@ -319,6 +365,8 @@ func StoreIncoming(packedBlock []byte) error {
 			storage.Pool.Transactions.Put(item.txId[:], blockNumberKey, item.packed)
 			storage.Pool.BlockOwnerPayment.Put(item.previousBlockNumberKey, p)
 			storage.Pool.BlockOwnerTxIndex.Put(item.txId[:], blockNumberKey)
+			storage.Pool.TxSuccessor.Put(link[:], item.txId[:])
+			storeOrigin(item.txId, link)
 			ownership.Transfer(link, item.txId, header.Number, item.linkOwner, tx.Owner)
 
 		default:
@@ -335,6 +383,9 @@ func StoreIncoming(packedBlock []byte) error {
 	storage.Pool.Transactions.Put(foundationTxId[:], blockNumberKey, packedFoundation)
 	storage.Pool.BlockOwnerTxIndex.Put(foundationTxId[:], blockNumberKey)
 
+	// to find the block from its digest
+	storage.Pool.BlockHeaderHash.Put(digest[:], blockNumberKey)
+
 	ownership.CreateBlock(foundationTxId, header.Number, blockOwner)
 
 	expectedBlockNumber := globalData.height + 1
@@ -347,6 +398,11 @@ func StoreIncoming(packedBlock []byte) error {
 	globalData.previousTimestamp = header.Timestamp
 	globalData.height = header.Number
 
+	// cumulative work for fork choice
+	work := ChainWork(header.Number - 1)
+	work.Add(work, blockWork(header.Difficulty))
+	storage.Pool.ChainWork.Put(blockNumberKey, work.Bytes())
+
 	// return early if rebuilding
 	if globalData.rebuild {
 		globalData.log.Warnf("rebuilt block: %d", globalData.height)
@@ -363,3 +419,14 @@ func StoreIncoming(packedBlock []byte) error {
 
 	return nil
 }
+
+// record the first transaction of the chain that a transfer extends
+//
+// an issue or block foundation has no entry so is its own origin
+func storeOrigin(txId merkle.Digest, link merkle.Digest) {
+	origin := storage.Pool.TxOrigin.Get(link[:])
+	if nil == origin {
+		origin = link[:]
+	}
+	storage.Pool.TxOrigin.Put(txId[:], origin)
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package block
+
+import (
+	"encoding/binary"
+	"math/big"
+
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/difficulty"
+	"github.com/bitmark-inc/bitmarkd/storage"
+)
+
+// 2^256 the number of possible digests
+var digestSpace = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// the work represented by a single block: the expected number of
+// digests computed to find one not greater than the target, i.e.
+// 2^256 / (target + 1)
+func blockWork(d *difficulty.Difficulty) *big.Int {
+	target := new(big.Int).Add(d.BigInt(), big.NewInt(1))
+	return target.Div(digestSpace, target)
+}
+
+// cumulative work of the chain up to and including a block
+//
+// the genesis block and any block not in storage have zero work, so
+// the values are only comparable between chains with the same genesis
+func ChainWork(number uint64) *big.Int {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, number)
+
+	work := new(big.Int)
+	if b := storage.Pool.ChainWork.Get(key); nil != b {
+		work.SetBytes(b)
+	}
+	return work
+}
+
+// total work of the current chain
+func CurrentChainWork() *big.Int {
+	return ChainWork(GetHeight())
+}
+
+// total work of a sequence of headers, to compare a fork with the
+// blocks it would replace
+func HeadersWork(headers []*blockrecord.Header) *big.Int {
+	work := new(big.Int)
+	for _, header := range headers {
+		work.Add(work, blockWork(header.Difficulty))
+	}
+	return work
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package block
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/difficulty"
+)
+
+func TestBlockWork(t *testing.T) {
+
+	// difficulty one has a target just under 2^248
+	one := difficulty.New()
+	if w := blockWork(one); 0 != w.Cmp(big.NewInt(256)) {
+		t.Errorf("work for one: %s  expected: 256", w)
+	}
+
+	// a harder difficulty must represent more work
+	harder := difficulty.New()
+	harder.SetReciprocal(16)
+	if blockWork(harder).Cmp(blockWork(one)) <= 0 {
+		t.Errorf("harder: %s  not greater than: %s", blockWork(harder), blockWork(one))
+	}
+
+	headers := []*blockrecord.Header{
+		{Difficulty: one},
+		{Difficulty: one},
+		{Difficulty: harder},
+	}
+	expected := new(big.Int).Add(big.NewInt(2*256), blockWork(harder))
+	if w := HeadersWork(headers); 0 != w.Cmp(expected) {
+		t.Errorf("headers work: %s  expected: %s", w, expected)
+	}
+}
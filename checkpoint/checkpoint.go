@@ -0,0 +1,186 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package checkpoint
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/chain"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/logger"
+)
+
+// blocks below the current height and the spacing of recommended
+// checkpoints, deep enough that the block will not be reorganised
+const (
+	recommendedDepth    = 1000
+	recommendedInterval = 1000
+)
+
+// Configuration - a checkpoint from the configuration file, the digest
+// is the big endian hex as printed by the checkpoint command
+type Configuration struct {
+	Number uint64 `gluamapper:"number" json:"number"`
+	Digest string `gluamapper:"digest" json:"digest"`
+}
+
+// the genesis block of each chain is always a checkpoint
+var genesisDigests = map[string]blockdigest.Digest{
+	chain.Bitmark: genesis.LiveGenesisDigest,
+	chain.Testing: genesis.TestGenesisDigest,
+	chain.Local:   genesis.TestGenesisDigest,
+}
+
+// checkpoints built into each chain after its genesis block
+//
+// each entry is the source line printed by the checkpoint command of a
+// node synchronised to that chain, confirmed against a second node
+// before a release; none are listed yet as no digests of later blocks
+// have been recorded here
+var hardcoded = map[string][]Configuration{
+	chain.Bitmark: {},
+	chain.Testing: {},
+}
+
+var globalData struct {
+	sync.RWMutex
+	log         *logger.L
+	checkpoints map[uint64]blockdigest.Digest
+	numbers     []uint64 // ascending
+
+	// set once during initialise
+	initialised bool
+}
+
+// set up the checkpoints of a chain
+func Initialise(chainName string, configured []Configuration) error {
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	// no need to start if already started
+	if globalData.initialised {
+		return fault.ErrAlreadyInitialised
+	}
+
+	log := logger.New("checkpoint")
+	globalData.log = log
+	log.Info("starting…")
+
+	genesisDigest, ok := genesisDigests[chainName]
+	if !ok {
+		log.Criticalf("no checkpoints for chain: %q", chainName)
+		return fault.ErrInvalidChain
+	}
+
+	checkpoints := map[uint64]blockdigest.Digest{
+		genesis.BlockNumber: genesisDigest,
+	}
+	if err := addCheckpoints(log, checkpoints, hardcoded[chainName]); nil != err {
+		return err
+	}
+	if err := addCheckpoints(log, checkpoints, configured); nil != err {
+		return err
+	}
+
+	numbers := make([]uint64, 0, len(checkpoints))
+	for number, digest := range checkpoints {
+		numbers = append(numbers, number)
+		log.Infof("checkpoint: %d  digest: %v", number, digest)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	globalData.checkpoints = checkpoints
+	globalData.numbers = numbers
+
+	// all data initialised
+	globalData.initialised = true
+
+	return nil
+}
+
+// decode checkpoints into a map, failing on any that conflict
+func addCheckpoints(log *logger.L, checkpoints map[uint64]blockdigest.Digest, list []Configuration) error {
+	for i, c := range list {
+		if c.Number < genesis.BlockNumber || 2*blockdigest.Length != len(c.Digest) {
+			log.Errorf("checkpoint[%d]: %d  invalid digest: %q", i, c.Number, c.Digest)
+			return fault.ErrInvalidLength
+		}
+		var digest blockdigest.Digest
+		if _, err := fmt.Sscan(c.Digest, &digest); nil != err {
+			log.Errorf("checkpoint[%d]: %d  digest: %q  error: %s", i, c.Number, c.Digest, err)
+			return err
+		}
+		if d, ok := checkpoints[c.Number]; ok && d != digest {
+			log.Errorf("checkpoint[%d]: %d  digest: %v  conflicts with: %v", i, c.Number, digest, d)
+			return fault.ErrCheckpointMismatch
+		}
+		checkpoints[c.Number] = digest
+	}
+	return nil
+}
+
+// discard the checkpoints
+func Finalise() error {
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	if !globalData.initialised {
+		return fault.ErrNotInitialised
+	}
+
+	globalData.log.Info("shutting down…")
+	globalData.log.Flush()
+
+	globalData.checkpoints = nil
+	globalData.numbers = nil
+	globalData.initialised = false
+
+	globalData.log.Info("finished")
+	globalData.log.Flush()
+
+	return nil
+}
+
+// check that a block at a checkpoint has the checkpoint digest
+func Verify(number uint64, digest blockdigest.Digest) error {
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	if d, ok := globalData.checkpoints[number]; ok && d != digest {
+		globalData.log.Errorf("block: %d  digest: %v  expected checkpoint: %v", number, digest, d)
+		return fault.ErrCheckpointMismatch
+	}
+	return nil
+}
+
+// the highest checkpoint at or below a height, blocks up to this
+// number must never be replaced
+//
+// zero if there is no such checkpoint
+func Last(height uint64) uint64 {
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	i := sort.Search(len(globalData.numbers), func(i int) bool { return globalData.numbers[i] > height })
+	if 0 == i {
+		return 0
+	}
+	return globalData.numbers[i-1]
+}
+
+// the block number suggested as a new checkpoint for a height
+//
+// zero if the chain is not yet long enough
+func Recommended(height uint64) uint64 {
+	if height < recommendedDepth+recommendedInterval {
+		return 0
+	}
+	n := height - recommendedDepth
+	return n - n%recommendedInterval
+}
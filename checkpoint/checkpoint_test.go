@@ -0,0 +1,173 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package checkpoint
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/chain"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/logger"
+)
+
+// test log file
+const (
+	testingLogFile = "test.log"
+)
+
+// configure for testing
+func setup(t *testing.T) {
+	os.Remove(testingLogFile)
+
+	logging := logger.Configuration{
+		Directory: ".",
+		File:      testingLogFile,
+		Size:      50000,
+		Count:     10,
+		Console:   false,
+		Levels: map[string]string{
+			logger.DefaultTag: "critical",
+		},
+	}
+
+	// start logging
+	if err := logger.Initialise(logging); nil != err {
+		t.Fatalf("logger initialise error: %s", err)
+	}
+}
+
+// post test cleanup
+func teardown(t *testing.T) {
+	Finalise()
+	logger.Finalise()
+	os.Remove(testingLogFile)
+}
+
+func TestCheckpoints(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	digest := blockdigest.Digest{0x50, 0x00}
+	other := blockdigest.Digest{0x30, 0x00}
+
+	configured := []Configuration{
+		{Number: 5000, Digest: digest.String()},
+		{Number: 3000, Digest: other.String()},
+	}
+	err := Initialise(chain.Testing, configured)
+	if nil != err {
+		t.Fatalf("initialise error: %s", err)
+	}
+
+	if err := Verify(5000, digest); nil != err {
+		t.Errorf("verify error: %s", err)
+	}
+	if err := Verify(5000, other); fault.ErrCheckpointMismatch != err {
+		t.Errorf("mismatch error: %v  expected: %s", err, fault.ErrCheckpointMismatch)
+	}
+	if err := Verify(4999, other); nil != err {
+		t.Errorf("not a checkpoint error: %s", err)
+	}
+	if err := Verify(genesis.BlockNumber, genesis.LiveGenesisDigest); fault.ErrCheckpointMismatch != err {
+		t.Errorf("genesis error: %v  expected: %s", err, fault.ErrCheckpointMismatch)
+	}
+
+	last := []struct {
+		height uint64
+		number uint64
+	}{
+		{0, 0},
+		{1, 1},
+		{2999, 1},
+		{3000, 3000},
+		{4999, 3000},
+		{5000, 5000},
+		{900000, 5000},
+	}
+	for _, item := range last {
+		if n := Last(item.height); item.number != n {
+			t.Errorf("last: %d  checkpoint: %d  expected: %d", item.height, n, item.number)
+		}
+	}
+}
+
+func TestInvalidConfiguration(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	items := []struct {
+		chain      string
+		configured []Configuration
+		err        error
+	}{
+		{"nochain", nil, fault.ErrInvalidChain},
+		{chain.Bitmark, []Configuration{{Number: 10, Digest: "1234"}}, fault.ErrInvalidLength},
+		{chain.Bitmark, []Configuration{{Number: 0, Digest: genesis.LiveGenesisDigest.String()}}, fault.ErrInvalidLength},
+		{chain.Bitmark, []Configuration{{Number: 1, Digest: genesis.TestGenesisDigest.String()}}, fault.ErrCheckpointMismatch},
+	}
+	for i, item := range items {
+		err := Initialise(item.chain, item.configured)
+		if item.err != err {
+			t.Errorf("%d: error: %v  expected: %s", i, err, item.err)
+		}
+		if nil == err {
+			Finalise()
+		}
+	}
+
+	// the built in genesis checkpoint may be repeated
+	configured := []Configuration{{Number: 1, Digest: genesis.LiveGenesisDigest.String()}}
+	if err := Initialise(chain.Bitmark, configured); nil != err {
+		t.Errorf("genesis checkpoint error: %s", err)
+	}
+}
+
+func TestHardcoded(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	// as pasted from the checkpoint command
+	digest := blockdigest.Digest{0x20, 0x00}
+	hardcoded[chain.Local] = []Configuration{{Number: 2000, Digest: digest.String()}}
+	defer delete(hardcoded, chain.Local)
+
+	other := blockdigest.Digest{0x21, 0x00}
+	configured := []Configuration{{Number: 2000, Digest: other.String()}}
+	if err := Initialise(chain.Local, configured); fault.ErrCheckpointMismatch != err {
+		t.Errorf("conflict error: %v  expected: %s", err, fault.ErrCheckpointMismatch)
+	}
+
+	if err := Initialise(chain.Local, nil); nil != err {
+		t.Fatalf("initialise error: %s", err)
+	}
+	if err := Verify(2000, other); fault.ErrCheckpointMismatch != err {
+		t.Errorf("mismatch error: %v  expected: %s", err, fault.ErrCheckpointMismatch)
+	}
+	if n := Last(2500); 2000 != n {
+		t.Errorf("last: %d  expected: 2000", n)
+	}
+}
+
+func TestRecommended(t *testing.T) {
+	items := []struct {
+		height uint64
+		number uint64
+	}{
+		{1, 0},
+		{1999, 0},
+		{2000, 1000},
+		{2999, 1000},
+		{3000, 2000},
+		{123456, 122000},
+	}
+	for _, item := range items {
+		if n := Recommended(item.height); item.number != n {
+			t.Errorf("height: %d  recommended: %d  expected: %d", item.height, n, item.number)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// block number to digest checkpoints
+//
+// each chain has a list of hardcoded checkpoints that can be extended
+// from the configuration file; a block at a checkpoint number must
+// have the checkpoint digest and the chain is never reorganised below
+// the highest checkpoint it has reached
+package checkpoint
@@ -10,8 +10,9 @@ import (
 
 // common errors - keep in alphabetic order
 var (
-	ErrKeyLength        = fault.InvalidError("key length is invalid")
-	ErrNotFoundIdentity = fault.NotFoundError("identity name not found")
-	ErrInvalidNetwork   = fault.InvalidError("invalid network")
-	ErrNilKeyPair       = fault.ProcessError("internal error: nil key pair")
+	ErrKeyLength          = fault.InvalidError("key length is invalid")
+	ErrNotFoundIdentity   = fault.NotFoundError("identity name not found")
+	ErrInvalidNetwork     = fault.InvalidError("invalid network")
+	ErrNilKeyPair         = fault.ProcessError("internal error: nil key pair")
+	ErrProofDoesNotVerify = fault.InvalidError("proof does not verify")
 )
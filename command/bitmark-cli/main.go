@@ -266,6 +266,24 @@ func main() {
 			},
 			Action: runTransactionStatus,
 		},
+		{
+			Name:      "verify",
+			Usage:     "verify a transaction is in a block by its merkle proof",
+			ArgsUsage: "\n   (* = required)",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "txid, t",
+					Value: "",
+					Usage: "*transaction id to fetch the proof of `TXID`",
+				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Value: "",
+					Usage: " verify a saved Transaction.Proof reply from `FILE` instead",
+				},
+			},
+			Action: runVerify,
+		},
 		{
 			Name:      "account",
 			Usage:     "display account from a public key",
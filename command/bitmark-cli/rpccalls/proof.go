@@ -0,0 +1,39 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpccalls
+
+import (
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/rpc"
+)
+
+type TransactionProofData struct {
+	TxId string
+}
+
+func (client *Client) GetTransactionProof(proofConfig *TransactionProofData) (*rpc.TransactionProofReply, error) {
+
+	var txId merkle.Digest
+	err := txId.UnmarshalText([]byte(proofConfig.TxId))
+	if nil != err {
+		return nil, err
+	}
+
+	proofArgs := rpc.TransactionArguments{
+		TxId: txId,
+	}
+
+	client.printJson("Proof Request", proofArgs)
+
+	var reply rpc.TransactionProofReply
+	err = client.client.Call("Transaction.Proof", proofArgs, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	client.printJson("Proof Reply", reply)
+
+	return &reply, nil
+}
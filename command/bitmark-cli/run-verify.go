@@ -0,0 +1,114 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/urfave/cli"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/command/bitmark-cli/rpccalls"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/rpc"
+)
+
+type verifyReply struct {
+	TxId        merkle.Digest      `json:"txId"`
+	BlockNumber uint64             `json:"blockNumber"`
+	Digest      blockdigest.Digest `json:"digest"`
+	MerkleRoot  merkle.Digest      `json:"merkleRoot"`
+	Index       int                `json:"index"`
+	Verified    bool               `json:"verified"`
+}
+
+// verify a transaction is in a block using a merkle proof, either
+// fetched from bitmarkd or previously saved to a file
+func runVerify(c *cli.Context) error {
+
+	m := c.App.Metadata["config"].(*metadata)
+
+	fileName := c.String("file")
+
+	var proof *rpc.TransactionProofReply
+	if "" != fileName {
+		if m.verbose {
+			fmt.Fprintf(m.e, "file: %s\n", fileName)
+		}
+
+		data, err := ioutil.ReadFile(fileName)
+		if nil != err {
+			return err
+		}
+		proof = &rpc.TransactionProofReply{}
+		err = json.Unmarshal(data, proof)
+		if nil != err {
+			return err
+		}
+
+	} else {
+		txId, err := checkTransferTxId(c.String("txid"))
+		if nil != err {
+			return err
+		}
+
+		if m.verbose {
+			fmt.Fprintf(m.e, "txid: %s\n", txId)
+		}
+
+		client, err := rpccalls.NewClient(m.testnet, m.config.Connect, m.verbose, m.e)
+		if nil != err {
+			return err
+		}
+		defer client.Close()
+
+		proofConfig := &rpccalls.TransactionProofData{
+			TxId: txId,
+		}
+
+		proof, err = client.GetTransactionProof(proofConfig)
+		if nil != err {
+			return err
+		}
+	}
+
+	err := verifyProof(proof)
+	if nil != err {
+		return err
+	}
+
+	response := verifyReply{
+		TxId:        proof.TxId,
+		BlockNumber: proof.BlockNumber,
+		Digest:      proof.Digest,
+		MerkleRoot:  proof.Header.MerkleRoot,
+		Index:       proof.Index,
+		Verified:    true,
+	}
+	printJson(m.w, response)
+
+	return nil
+}
+
+// check the header matches its digest and the branch links the
+// transaction to the header's merkle root
+func verifyProof(proof *rpc.TransactionProofReply) error {
+
+	if nil == proof.Header || proof.Header.Number != proof.BlockNumber {
+		return ErrProofDoesNotVerify
+	}
+	if proof.Index < 0 || proof.Index >= int(proof.Header.TransactionCount) {
+		return ErrProofDoesNotVerify
+	}
+	if proof.Header.Pack().Digest() != proof.Digest {
+		return ErrProofDoesNotVerify
+	}
+	if !merkle.VerifyBranch(proof.TxId, proof.Index, proof.Branch, proof.Header.MerkleRoot) {
+		return ErrProofDoesNotVerify
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/bitmark-inc/bitmarkd/admin"
+	"github.com/bitmark-inc/bitmarkd/rpc"
+	"github.com/bitmark-inc/exitwithstatus"
+)
+
+// re-read the configuration file and apply the client limits
+//
+// returns the names of the other sections that differ from the
+// configuration read at startup, as bitmarkd is still running with
+// those; only the applied client limits are kept in current so a
+// section stays listed on every reload until a restart
+func reloadConfiguration(configurationFile string, current *Configuration) ([]string, error) {
+
+	options, err := getConfiguration(configurationFile)
+	if nil != err {
+		return nil, err
+	}
+
+	if err := rpc.ReloadLimits(&options.RPCLimits); nil != err {
+		return nil, err
+	}
+	current.RPCLimits = options.RPCLimits
+
+	return changedSections(current, options), nil
+}
+
+// compare the top level items of two configurations and return the
+// JSON names of those that differ
+func changedSections(a *Configuration, b *Configuration) []string {
+
+	changed := []string{}
+
+	va := reflect.ValueOf(a).Elem()
+	vb := reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i += 1 {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			name := strings.Split(va.Type().Field(i).Tag.Get("json"), ",")[0]
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// send a command to a running bitmarkd on its admin socket
+func adminCommand(arguments []string, options *Configuration) {
+
+	socket := options.Admin.Socket
+	if "" == socket {
+		exitwithstatus.Message("error: admin socket is not configured")
+	}
+
+	command := "help"
+	if len(arguments) > 0 {
+		command = arguments[0]
+		arguments = arguments[1:]
+	}
+
+	var reply interface{}
+	var err error
+
+	switch command {
+
+	case "rescan":
+		var r admin.RescanReply
+		err = admin.Call(socket, "Admin.Rescan", &admin.EmptyArguments{}, &r)
+		reply = r
+
+	case "disconnect":
+		if len(arguments) < 1 {
+			exitwithstatus.Message("missing public key argument")
+		}
+		var r admin.PeerReply
+		err = admin.Call(socket, "Admin.Disconnect", &admin.PeerArguments{PublicKey: arguments[0]}, &r)
+		reply = r
+
+	case "ban":
+		if len(arguments) < 2 {
+			exitwithstatus.Message("missing public key or seconds argument")
+		}
+		seconds, parseErr := strconv.Atoi(arguments[1])
+		if nil != parseErr {
+			exitwithstatus.Message("error in seconds: %s", parseErr)
+		}
+		var r admin.PeerReply
+		err = admin.Call(socket, "Admin.Ban", &admin.PeerArguments{PublicKey: arguments[0], Seconds: seconds}, &r)
+		reply = r
+
+	case "reload":
+		var r admin.ReloadReply
+		err = admin.Call(socket, "Admin.Reload", &admin.EmptyArguments{}, &r)
+		reply = r
+
+	case "shutdown":
+		var r admin.ShutdownReply
+		err = admin.Call(socket, "Admin.Shutdown", &admin.EmptyArguments{}, &r)
+		reply = r
+
+	default:
+		switch command {
+		case "help", "h", "?":
+		default:
+			fmt.Printf("error: no such admin command: %q\n", command)
+		}
+
+		fmt.Printf("admin commands:\n\n")
+		fmt.Printf("  rescan                              - drop reservoir transactions invalidated by confirmed blocks\n")
+		fmt.Printf("  disconnect PUBLIC-KEY               - drop the outgoing connection to a peer\n")
+		fmt.Printf("  ban PUBLIC-KEY SECONDS              - disconnect and do not reconnect to a peer for a time\n")
+		fmt.Printf("  reload                              - apply rpc_limits and list sections that differ from startup\n")
+		fmt.Printf("  shutdown                            - stop bitmarkd\n")
+		fmt.Printf("\n")
+
+		exitwithstatus.Exit(1)
+	}
+
+	if nil != err {
+		exitwithstatus.Message("error: %s", err)
+	}
+
+	s, err := json.MarshalIndent(reply, "", "  ")
+	if nil != err {
+		exitwithstatus.Message("error: %s", err)
+	}
+	fmt.Printf("%s\n", s)
+}
@@ -19,6 +19,7 @@ import (
 	"golang.org/x/crypto/sha3"
 
 	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/checkpoint"
 	"github.com/bitmark-inc/bitmarkd/fault"
 	"github.com/bitmark-inc/bitmarkd/mode"
 	"github.com/bitmark-inc/bitmarkd/util"
@@ -118,7 +119,7 @@ func processSetupCommand(arguments []string) bool {
 		fmt.Printf("generated private key: %q and public key: %q\n", privateKeyFilename, publicKeyFilename)
 		fmt.Printf("generated signing key: %q\n", signingKeyFilename)
 
-	case "dns-txt", "txt":
+	case "dns-txt", "txt", "admin":
 		return false // defer processing until configuration is read
 
 	case "start", "run":
@@ -127,7 +128,7 @@ func processSetupCommand(arguments []string) bool {
 		// case "block-times":
 		// 	return false // defer processing until database is loaded
 
-	case "block", "b", "save-blocks", "save", "load-blocks", "load", "delete-down", "dd":
+	case "block", "b", "save-blocks", "save", "load-blocks", "load", "delete-down", "dd", "checkpoint", "cp":
 		return false // defer processing until database is loaded
 
 	default:
@@ -162,6 +163,10 @@ func processSetupCommand(arguments []string) bool {
 		fmt.Printf("  dns-txt                    (txt)    - display the data to put in a dbs TXT record\n")
 		fmt.Printf("\n")
 
+		fmt.Printf("  admin COMMAND [ARGS...]             - send a command to the running node\n")
+		fmt.Printf("                                        use: \"admin help\" for a list of commands\n")
+		fmt.Printf("\n")
+
 		fmt.Printf("  start                      (run)    - just run the program, same as no arguments\n")
 		fmt.Printf("                                        for convienience when passing script arguments\n")
 		fmt.Printf("\n")
@@ -179,6 +184,10 @@ func processSetupCommand(arguments []string) bool {
 		fmt.Printf("  delete-down NUMBER         (dd)     - delete blocks in descending order\n")
 		fmt.Printf("\n")
 
+		fmt.Printf("  checkpoint                 (cp)     - display the recommended checkpoint\n")
+		fmt.Printf("                                        as an entry for the configuration file\n")
+		fmt.Printf("\n")
+
 		exitwithstatus.Exit(1)
 	}
 
@@ -201,6 +210,9 @@ func processConfigCommand(arguments []string, options *Configuration) bool {
 	case "dns-txt", "txt":
 		dnsTXT(options)
 
+	case "admin":
+		adminCommand(arguments, options)
+
 	default: // unknown commands fall through to data command
 		return false
 	}
@@ -326,6 +338,25 @@ func processDataCommand(log *logger.L, arguments []string, options *Configuratio
 		}
 		fmt.Printf("reduced height to: %d\n", block.GetHeight())
 
+	case "checkpoint", "cp":
+		height := block.GetHeight()
+		n := checkpoint.Recommended(height)
+		if 0 == n {
+			exitwithstatus.Message("error: height: %d is too low for a checkpoint", height)
+		}
+		digest, err := block.DigestForBlock(n)
+		if nil != err {
+			exitwithstatus.Message("block: %d  digest error: %s", n, err)
+		}
+		fmt.Printf("height:     %d\n", height)
+		fmt.Printf("checkpoint: %d\n", n)
+		fmt.Printf("digest:     %s\n", digest)
+		fmt.Printf("\n")
+		fmt.Printf("configuration file:\n")
+		fmt.Printf("  { number = %d, digest = \"%s\" },\n", n, digest)
+		fmt.Printf("checkpoint/checkpoint.go:\n")
+		fmt.Printf("  {Number: %d, Digest: \"%s\"},\n", n, digest)
+
 	default:
 		exitwithstatus.Message("error: no such command: %s", command)
 
@@ -11,7 +11,10 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/bitmark-inc/bitmarkd/admin"
+	"github.com/bitmark-inc/bitmarkd/announce"
 	"github.com/bitmark-inc/bitmarkd/chain"
+	"github.com/bitmark-inc/bitmarkd/checkpoint"
 	"github.com/bitmark-inc/bitmarkd/configuration"
 	"github.com/bitmark-inc/bitmarkd/payment"
 	"github.com/bitmark-inc/bitmarkd/peer"
@@ -46,6 +49,13 @@ const (
 
 	defaultRPCClients = 100          // maximum TCP connections
 	defaultBandwidth  = 25 * 1000000 // 25Mbps
+
+	defaultPeerRequestRate    = 100      // requests per second from each peer
+	defaultPeerRequestBurst   = 200      // requests
+	defaultPeerByteRate       = 4 << 20  // bytes per second to each peer
+	defaultPeerByteBurst      = 16 << 20 // bytes
+	defaultPeerBroadcastRate  = 1 << 20  // bytes per second of broadcasts to each peer
+	defaultPeerBroadcastBurst = 4 << 20  // bytes
 )
 
 // to hold log levels
@@ -73,13 +83,18 @@ type Configuration struct {
 	PeerFile      string `gluamapper:"peer_file" json:"peer_file"`
 	ReservoirFile string `gluamapper:"reservoir_file" json:"reservoir_file"`
 
+	Checkpoints []checkpoint.Configuration      `gluamapper:"checkpoints" json:"checkpoints"`
+	Discovery   announce.DiscoveryConfiguration `gluamapper:"discovery" json:"discovery"`
+
 	ClientRPC  rpc.RPCConfiguration   `gluamapper:"client_rpc" json:"client_rpc"`
 	HttpsRPC   rpc.HTTPSConfiguration `gluamapper:"https_rpc" json:"https_rpc"`
+	RPCLimits  rpc.LimitConfiguration `gluamapper:"rpc_limits" json:"rpc_limits"`
 	Peering    peer.Configuration     `gluamapper:"peering" json:"peering"`
 	Publishing publish.Configuration  `gluamapper:"publishing" json:"publishing"`
 	Proofing   proof.Configuration    `gluamapper:"proofing" json:"proofing"`
 	Payment    payment.Configuration  `gluamapper:"payment" json:"payment"`
 	Logging    logger.Configuration   `gluamapper:"logging" json:"logging"`
+	Admin      admin.Configuration    `gluamapper:"admin" json:"admin"`
 }
 
 // will read decode and verify the configuration
@@ -119,6 +134,14 @@ func getConfiguration(configurationFileName string) (*Configuration, error) {
 		Peering: peer.Configuration{
 			DynamicConnections: true,
 			PreferIPv6:         true,
+			Limits: peer.LimitConfiguration{
+				RequestRate:    defaultPeerRequestRate,
+				RequestBurst:   defaultPeerRequestBurst,
+				ByteRate:       defaultPeerByteRate,
+				ByteBurst:      defaultPeerByteBurst,
+				BroadcastRate:  defaultPeerBroadcastRate,
+				BroadcastBurst: defaultPeerBroadcastBurst,
+			},
 		},
 
 		Logging: logger.Configuration{
@@ -191,6 +214,8 @@ func getConfiguration(configurationFileName string) (*Configuration, error) {
 	// optional absolute paths i.e. blank or an absolute path
 	optionalAbsolute := []*string{
 		&options.PidFile,
+		&options.RPCLimits.KeyFile,
+		&options.Admin.Socket,
 	}
 	for _, f := range optionalAbsolute {
 		if "" != *f {
@@ -198,6 +223,11 @@ func getConfiguration(configurationFileName string) (*Configuration, error) {
 		}
 	}
 
+	// watched peer files are relative to the data directory
+	for i, f := range options.Discovery.Files {
+		options.Discovery.Files[i] = util.EnsureAbsolute(options.DataDirectory, f)
+	}
+
 	// fail if any of these are not simple file names i.e. must
 	// not contain path seperator, then add the correct directory
 	// prefix, file item is first and corresponding directory is
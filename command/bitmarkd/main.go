@@ -13,11 +13,13 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/bitmark-inc/bitmarkd/admin"
 	"github.com/bitmark-inc/bitmarkd/announce"
 	"github.com/bitmark-inc/bitmarkd/asset"
 	"github.com/bitmark-inc/bitmarkd/block"
 	"github.com/bitmark-inc/bitmarkd/blockring"
 	"github.com/bitmark-inc/bitmarkd/chain"
+	"github.com/bitmark-inc/bitmarkd/checkpoint"
 	"github.com/bitmark-inc/bitmarkd/mode"
 	"github.com/bitmark-inc/bitmarkd/payment"
 	"github.com/bitmark-inc/bitmarkd/peer"
@@ -134,6 +136,14 @@ func main() {
 	}
 	defer mode.Finalise()
 
+	// block checkpoints for the chain - before any blocks are stored
+	err = checkpoint.Initialise(masterConfiguration.Chain, masterConfiguration.Checkpoints)
+	if nil != err {
+		log.Criticalf("checkpoint initialise error: %s", err)
+		exitwithstatus.Message("checkpoint initialise error: %s", err)
+	}
+	defer checkpoint.Finalise()
+
 	// // if requested start profiling
 	// if "" != masterConfiguration.ProfileFile {
 	// 	f, err := os.Create(masterConfiguration.ProfileFile)
@@ -244,7 +254,7 @@ func main() {
 		// trying to fetch the TXT records for validation
 		nodesDomain = masterConfiguration.Nodes // just assume it is a domain name
 	}
-	err = announce.Initialise(nodesDomain, masterConfiguration.PeerFile)
+	err = announce.Initialise(nodesDomain, masterConfiguration.PeerFile, &masterConfiguration.Discovery)
 	if nil != err {
 		log.Criticalf("announce initialise error: %s", err)
 		exitwithstatus.Message("announce initialise error: %s", err)
@@ -283,7 +293,7 @@ func main() {
 	defer publish.Finalise()
 
 	// start up the rpc background processes
-	err = rpc.Initialise(&masterConfiguration.ClientRPC, &masterConfiguration.HttpsRPC, version)
+	err = rpc.Initialise(&masterConfiguration.ClientRPC, &masterConfiguration.HttpsRPC, &masterConfiguration.RPCLimits, version)
 	if nil != err {
 		log.Criticalf("rpc initialise error: %s", err)
 		exitwithstatus.Message("peer initialise error: %s", err)
@@ -298,6 +308,26 @@ func main() {
 	}
 	defer proof.Finalise()
 
+	// local administration, can request a shutdown
+	shutdown := make(chan struct{}, 1)
+	actions := &admin.Actions{
+		Reload: func() ([]string, error) {
+			return reloadConfiguration(configurationFile, masterConfiguration)
+		},
+		Shutdown: func() {
+			select {
+			case shutdown <- struct{}{}:
+			default:
+			}
+		},
+	}
+	err = admin.Initialise(&masterConfiguration.Admin, actions)
+	if nil != err {
+		log.Criticalf("admin initialise error: %s", err)
+		exitwithstatus.Message("admin initialise error: %s", err)
+	}
+	defer admin.Finalise()
+
 	// if memory logging enabled
 	if len(options["memory-stats"]) > 0 {
 		go memstats()
@@ -311,10 +341,19 @@ func main() {
 	// turn Signals into channel messages
 	ch := make(chan os.Signal)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-ch
-	log.Infof("received signal: %v", sig)
+	select {
+	case sig := <-ch:
+		log.Infof("received signal: %v", sig)
+		if 0 == len(options["quiet"]) {
+			fmt.Printf("\nreceived signal: %v\n", sig)
+		}
+	case <-shutdown:
+		log.Info("received admin shutdown")
+		if 0 == len(options["quiet"]) {
+			fmt.Printf("\nreceived admin shutdown\n")
+		}
+	}
 	if 0 == len(options["quiet"]) {
-		fmt.Printf("\nreceived signal: %v\n", sig)
 		fmt.Printf("\nshutting down…\n")
 	}
 
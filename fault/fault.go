@@ -18,11 +18,14 @@ type RecordError GenericError
 // common errors - keep in alphabetic order
 var (
 	ErrAddressIsNil                          = ProcessError("address is nil")
+	ErrAdminSocketInUse                      = ExistsError("admin socket in use")
 	ErrAlreadyInitialised                    = ExistsError("already initialised")
 	ErrAssetNotFound                         = NotFoundError("asset not found")
 	ErrAssetsAlreadyRegistered               = InvalidError("assets already registered")
 	ErrBitcoinAddressForWrongNetwork         = InvalidError("bitcoin address for wrong network")
 	ErrBitcoinAddressIsNotSupported          = InvalidError("bitcoin address is not supported")
+	ErrBlockDoesNotMatchHeader               = InvalidError("block does not match header")
+	ErrBlockFetchFailed                      = ProcessError("block fetch failed")
 	ErrBlockNotFound                         = NotFoundError("block not found")
 	ErrBlockVersionMustNotDecrease           = InvalidError("block version must not decrease")
 	ErrBufferCapacityLimit                   = LengthError("buffer capacity limit")
@@ -31,19 +34,27 @@ var (
 	ErrCannotDecodeSeed                      = RecordError("cannot decode seed")
 	ErrCertificateFileAlreadyExists          = ExistsError("certificate file already exists")
 	ErrCertificateFileNotFound               = NotFoundError("cerfificate file not found")
+	ErrCheckpointMismatch                    = InvalidError("checkpoint mismatch")
 	ErrChecksumMismatch                      = ProcessError("checksum mismatch")
 	ErrConnectingToSelfForbidden             = ProcessError("connecting to self forbidden")
 	ErrCurrencyIsNotSupportedByProofer       = InvalidError("currency is not supported by proofer")
 	ErrDoubleTransferAttempt                 = InvalidError("double transfer attempt")
 	ErrFingerprintTooLong                    = LengthError("fingerprint too long")
 	ErrFingerprintTooShort                   = LengthError("fingerprint too short")
+	ErrForkBelowCheckpoint                   = InvalidError("fork below checkpoint")
+	ErrIncompatiblePeerProtocol              = InvalidError("incompatible peer protocol")
 	ErrIncorrectChain                        = InvalidError("incorrect chain")
 	ErrInitialisationFailed                  = InvalidError("initialisation failed")
+	ErrInsufficientChainWork                 = InvalidError("insufficient chain work")
 	ErrInvalidBitcoinAddress                 = InvalidError("invalid bitcoin address")
+	ErrInvalidAPIKey                         = InvalidError("invalid api key")
+	ErrInvalidAdminSocket                    = InvalidError("invalid admin socket")
 	ErrInvalidBlockHeaderDifficulty          = InvalidError("invalid block header difficulty")
+	ErrInvalidBlockHeaderNumber              = InvalidError("invalid block header number")
 	ErrInvalidBlockHeaderSize                = InvalidError("invalid block header size")
 	ErrInvalidBlockHeaderTimestamp           = InvalidError("invalid block header timestamp")
 	ErrInvalidBlockHeaderVersion             = InvalidError("invalid block header version")
+	ErrInvalidBlockRange                     = InvalidError("invalid block range")
 	ErrInvalidBuffer                         = InvalidError("invalid buffer")
 	ErrInvalidChain                          = InvalidError("invalid chain")
 	ErrInvalidCount                          = InvalidError("invalid count")
@@ -82,6 +93,7 @@ var (
 	ErrMerkleRootDoesNotMatch                = InvalidError("Merkle Root Does Not Match")
 	ErrMetadataIsNotMap                      = InvalidError("metadata is not map")
 	ErrMetadataTooLong                       = LengthError("metadata too long")
+	ErrMissingAPIKey                         = NotFoundError("missing api key")
 	ErrMissingBlockOwner                     = LengthError("missing block owner")
 	ErrMissingParameters                     = LengthError("missing parameters")
 	ErrNameTooLong                           = LengthError("name too long")
@@ -102,18 +114,22 @@ var (
 	ErrOutOfPlaceBlockOwnerIssue             = InvalidError("out of place block owner issue")
 	ErrPayIdAlreadyUsed                      = InvalidError("payId already used")
 	ErrPaymentAddressTooLong                 = LengthError("payment address too long")
+	ErrPeerIsBanned                          = ProcessError("peer is banned")
 	ErrPreviousBlockDigestDoesNotMatch       = InvalidError("previous block digest does not match")
 	ErrRateLimiting                          = LengthError("rate limiting")
 	ErrReceiptTooLong                        = LengthError("receipt too long")
 	ErrSignatureTooLong                      = LengthError("signature too long")
 	ErrTooManyItemsToProcess                 = LengthError("too many items to process")
 	ErrTransactionCountOutOfRange            = LengthError("transaction count out of range")
+	ErrTransactionIndexOutOfRange            = LengthError("transaction index out of range")
 	ErrTransactionAlreadyExists              = ExistsError("transaction already exists")
 	ErrTransactionIsNotATransfer             = InvalidError("transaction is not a transfer")
 	ErrTransactionIsNotAnAsset               = InvalidError("transaction is not an asset")
 	ErrTransactionIsNotAnIssue               = InvalidError("transaction is not an issue")
 	ErrTransactionIsNotAnIssueOrATransfer    = InvalidError("transaction is not an issue or a transfer")
+	ErrTransactionIsNotConfirmed             = NotFoundError("transaction is not confirmed")
 	ErrTransactionLinksToSelf                = RecordError("transaction links to self")
+	ErrUnsupportedPeerCommand                = InvalidError("unsupported peer command")
 	ErrWrongNetworkForPrivateKey             = InvalidError("wrong network for private key")
 	ErrWrongNetworkForPublicKey              = InvalidError("wrong network for public key")
 )
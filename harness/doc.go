@@ -0,0 +1,30 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package harness runs a small network of bitmarkd nodes on the
+// local chain for integration tests.
+//
+// peer, publish, proof and rpc all keep their state in package
+// globals, so each node is a separate bitmarkd subprocess listening
+// on loopback ports with its own data directory.  A recorderd
+// subprocess is attached to a node on demand to mine blocks.
+//
+// Typical use:
+//
+//	network, err := harness.New(&harness.Options{Nodes: 4})
+//	...
+//	defer network.Stop()
+//	err = network.Start()
+//	...
+//	err = network.Mine(0, 2, time.Minute)
+//	height, err := network.WaitForConvergence(time.Minute)
+//
+// Partition splits the nodes into groups that only connect within
+// the group, so each side can extend its own fork; Heal rejoins them
+// and WaitForConvergence reports when every node has the same tip.
+//
+// The tests in this package only run with the integration build tag:
+//
+//	go test -tags integration ./harness
+package harness
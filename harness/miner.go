@@ -0,0 +1,165 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package harness
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+const (
+	minerDirectory             = "recorderd"
+	minerConfigurationFilename = "recorderd.conf"
+	minerPublicKeyFilename     = "recorderd.public"
+	minerPrivateKeyFilename    = "recorderd.private"
+	pollInterval               = time.Second
+)
+
+// a recorderd attached to the proof ports of one node
+type miner struct {
+	process *process
+}
+
+// create the miner directory and keys then start recorderd
+func startMiner(node *Node, program string, threads int) (*miner, error) {
+
+	directory := filepath.Join(node.directory, minerDirectory)
+	if err := os.MkdirAll(directory, 0700); nil != err {
+		return nil, err
+	}
+
+	keyFile := filepath.Join(directory, minerPublicKeyFilename)
+	if _, err := os.Stat(keyFile); os.IsNotExist(err) {
+		if _, err := makeKeyPair(directory, minerPublicKeyFilename, minerPrivateKeyFilename); nil != err {
+			return nil, err
+		}
+	}
+
+	configurationFile := filepath.Join(directory, minerConfigurationFilename)
+	f, err := os.OpenFile(configurationFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if nil != err {
+		return nil, err
+	}
+	err = recorderdTemplate.Execute(f, struct {
+		Directory string
+		Threads   int
+		PublicKey string
+		Blocks    string
+		Submit    string
+	}{
+		Directory: directory,
+		Threads:   threads,
+		PublicKey: node.proofPublicKey,
+		Blocks:    loopback(node.ports.Publish),
+		Submit:    loopback(node.ports.Submit),
+	})
+	f.Close()
+	if nil != err {
+		return nil, err
+	}
+
+	p, err := startProcess(program, configurationFile, "start", node.output)
+	if nil != err {
+		return nil, err
+	}
+	return &miner{process: p}, nil
+}
+
+func (m *miner) stop() {
+	m.process.stop()
+}
+
+// mine a number of blocks on one node
+//
+// a block is only created when there are verified transactions, so
+// each block is preceded by a free issue submitted to the same node
+func (network *Network) Mine(index int, blocks int, timeout time.Duration) error {
+	network.Lock()
+	defer network.Unlock()
+
+	if !network.running {
+		return ErrNetworkStopped
+	}
+
+	node := network.Node(index)
+	if nil == node {
+		return ErrNodeNotFound
+	}
+
+	m, ok := network.miners[index]
+	if !ok || m.process.exited() {
+		var err error
+		m, err = startMiner(node, network.recorderd, network.threads)
+		if nil != err {
+			return err
+		}
+		network.miners[index] = m
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	height, err := node.Height()
+	if nil != err {
+		return err
+	}
+
+	for i := 0; i < blocks; i += 1 {
+		if _, err := node.Issue(); nil != err {
+			return err
+		}
+
+		target := height + 1
+	wait_loop:
+		for {
+			if time.Now().After(deadline) {
+				return ErrNoBlockMined
+			}
+			time.Sleep(pollInterval)
+
+			h, err := node.Height()
+			if ErrNodeExited == err {
+				return err
+			}
+			if nil == err && h >= target {
+				height = h
+				break wait_loop
+			}
+		}
+	}
+	return nil
+}
+
+var recorderdTemplate = template.Must(template.New("recorderd").Parse(`-- generated by the bitmarkd test harness
+local M = {}
+` + readFileFunction + `
+M.data_directory = {{printf "%q" .Directory}}
+M.chain = "local"
+M.threads = {{.Threads}}
+
+M.peering = {
+    public_key = read_file("recorderd.public"),
+    private_key = read_file("recorderd.private"),
+    connect = {
+        {
+            public_key = "{{.PublicKey}}",
+            blocks = "{{.Blocks}}",
+            submit = "{{.Submit}}"
+        }
+    }
+}
+
+M.logging = {
+    size = 1048576,
+    count = 10,
+    console = false,
+    levels = {
+        DEFAULT = "info"
+    }
+}
+
+return M
+`))
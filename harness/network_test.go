@@ -0,0 +1,130 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// +build integration
+
+package harness
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	convergeTimeout = 3 * time.Minute
+	mineTimeout     = 5 * time.Minute
+)
+
+func startNetwork(t *testing.T, count int) *Network {
+	if testing.Short() {
+		t.Skip("integration test skipped in short mode")
+	}
+
+	options := &Options{
+		Nodes:     count,
+		Bitmarkd:  os.Getenv("BITMARKD"),
+		Recorderd: os.Getenv("RECORDERD"),
+	}
+	if testing.Verbose() {
+		options.Output = os.Stderr
+	}
+
+	network, err := New(options)
+	if nil != err {
+		t.Fatalf("new network error: %s", err)
+	}
+	if err := network.Start(); nil != err {
+		network.Stop()
+		t.Fatalf("start error: %s", err)
+	}
+	if _, err := network.WaitForConvergence(convergeTimeout); nil != err {
+		network.Stop()
+		t.Fatalf("initial convergence error: %s", err)
+	}
+	return network
+}
+
+func TestConvergence(t *testing.T) {
+	network := startNetwork(t, 4)
+	defer network.Stop()
+
+	start, err := network.Node(0).Height()
+	if nil != err {
+		t.Fatalf("height error: %s", err)
+	}
+
+	if err := network.Mine(0, 2, mineTimeout); nil != err {
+		t.Fatalf("mine error: %s", err)
+	}
+
+	height, err := network.WaitForConvergence(convergeTimeout)
+	if nil != err {
+		t.Fatalf("convergence error: %s", err)
+	}
+	if height < start+2 {
+		t.Errorf("height: %d  expected at least: %d", height, start+2)
+	}
+}
+
+func TestPartitionFork(t *testing.T) {
+	network := startNetwork(t, 4)
+	defer network.Stop()
+
+	if err := network.Partition([]int{0, 1}, []int{2, 3}); nil != err {
+		t.Fatalf("partition error: %s", err)
+	}
+	if _, err := network.WaitForConvergence(convergeTimeout, 0, 1); nil != err {
+		t.Fatalf("group 0 convergence error: %s", err)
+	}
+	if _, err := network.WaitForConvergence(convergeTimeout, 2, 3); nil != err {
+		t.Fatalf("group 1 convergence error: %s", err)
+	}
+
+	// the first group builds the longer fork
+	if err := network.Mine(0, 3, mineTimeout); nil != err {
+		t.Fatalf("mine group 0 error: %s", err)
+	}
+	if err := network.Mine(2, 1, mineTimeout); nil != err {
+		t.Fatalf("mine group 1 error: %s", err)
+	}
+
+	longHeight, err := network.WaitForConvergence(convergeTimeout, 0, 1)
+	if nil != err {
+		t.Fatalf("group 0 convergence error: %s", err)
+	}
+	longDigest, err := network.Node(0).Digest(longHeight)
+	if nil != err {
+		t.Fatalf("digest error: %s", err)
+	}
+
+	shortHeight, err := network.WaitForConvergence(convergeTimeout, 2, 3)
+	if nil != err {
+		t.Fatalf("group 1 convergence error: %s", err)
+	}
+	if shortHeight >= longHeight {
+		t.Fatalf("short fork height: %d  not below: %d", shortHeight, longHeight)
+	}
+
+	if err := network.Heal(); nil != err {
+		t.Fatalf("heal error: %s", err)
+	}
+	height, err := network.WaitForConvergence(convergeTimeout)
+	if nil != err {
+		t.Fatalf("convergence after heal error: %s", err)
+	}
+	if height != longHeight {
+		t.Errorf("height: %d  expected: %d", height, longHeight)
+	}
+
+	for i := 0; i < network.Count(); i += 1 {
+		digest, err := network.Node(i).Digest(longHeight)
+		if nil != err {
+			t.Fatalf("node[%d]: digest error: %s", i, err)
+		}
+		if digest != longDigest {
+			t.Errorf("node[%d]: digest: %v  expected: %v", i, digest, longDigest)
+		}
+	}
+}
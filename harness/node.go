@@ -0,0 +1,340 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package harness
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/keypair"
+	"github.com/bitmark-inc/bitmarkd/zmqutil"
+	"github.com/bitmark-inc/certgen"
+)
+
+// files in each node directory
+const (
+	configurationFilename   = "bitmarkd.conf"
+	peerPublicKeyFilename   = "peer.public"
+	peerPrivateKeyFilename  = "peer.private"
+	rpcCertificateFilename  = "rpc.crt"
+	rpcPrivateKeyFilename   = "rpc.key"
+	proofPublicKeyFilename  = "proof.public"
+	proofPrivateKeyFilename = "proof.private"
+	proofSigningKeyFilename = "proof.sign"
+)
+
+// loopback ports allocated to one node
+type ports struct {
+	RPC        int
+	Peer       int
+	Publish    int // proof: blocks to recorderd
+	Submit     int // proof: solutions from recorderd
+	PaymentSub int // payment discovery, nothing listens here
+	PaymentReq int
+}
+
+// one static connection in the configuration
+type peerConnection struct {
+	PublicKey string
+	Address   string
+}
+
+// one bitmarkd instance
+type Node struct {
+	index     int
+	directory string
+	program   string
+	output    io.Writer
+
+	peerPublicKey  string // hex
+	proofPublicKey string // hex
+	ports          ports
+	connect        []peerConnection
+
+	process *process
+}
+
+// create the directory, keys and certificate for a node
+func newNode(index int, directory string, program string, output io.Writer) (*Node, error) {
+
+	if err := os.MkdirAll(directory, 0700); nil != err {
+		return nil, err
+	}
+
+	node := &Node{
+		index:     index,
+		directory: directory,
+		program:   program,
+		output:    output,
+	}
+
+	var err error
+	node.peerPublicKey, err = makeKeyPair(directory, peerPublicKeyFilename, peerPrivateKeyFilename)
+	if nil != err {
+		return nil, err
+	}
+	node.proofPublicKey, err = makeKeyPair(directory, proofPublicKeyFilename, proofPrivateKeyFilename)
+	if nil != err {
+		return nil, err
+	}
+
+	seed, err := keypair.NewSeed(true)
+	if nil != err {
+		return nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(directory, proofSigningKeyFilename), []byte("SEED:"+seed+"\n"), 0600)
+	if nil != err {
+		return nil, err
+	}
+
+	org := fmt.Sprintf("bitmarkd harness node: %d", index)
+	cert, key, err := certgen.NewTLSCertPair(org, time.Now().Add(24*time.Hour), false, nil)
+	if nil != err {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(directory, rpcCertificateFilename), cert, 0666); nil != err {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(directory, rpcPrivateKeyFilename), key, 0600); nil != err {
+		return nil, err
+	}
+
+	for _, p := range []*int{
+		&node.ports.RPC,
+		&node.ports.Peer,
+		&node.ports.Publish,
+		&node.ports.Submit,
+		&node.ports.PaymentSub,
+		&node.ports.PaymentReq,
+	} {
+		*p, err = freePort()
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+// position of the node in the network
+func (node *Node) Index() int {
+	return node.index
+}
+
+// data directory of the node
+func (node *Node) Directory() string {
+	return node.directory
+}
+
+// client RPC address
+func (node *Node) RPCAddress() string {
+	return loopback(node.ports.RPC)
+}
+
+// peer listener address
+func (node *Node) PeerAddress() string {
+	return loopback(node.ports.Peer)
+}
+
+// hex peer public key
+func (node *Node) PublicKey() string {
+	return node.peerPublicKey
+}
+
+// true while the bitmarkd process is running
+func (node *Node) Running() bool {
+	return nil != node.process && !node.process.exited()
+}
+
+// write the configuration and start bitmarkd
+func (node *Node) start() error {
+	if node.Running() {
+		return nil
+	}
+
+	configurationFile := filepath.Join(node.directory, configurationFilename)
+	if err := node.writeConfiguration(configurationFile); nil != err {
+		return err
+	}
+
+	p, err := startProcess(node.program, configurationFile, "start", node.output)
+	if nil != err {
+		return err
+	}
+	node.process = p
+	return nil
+}
+
+// stop bitmarkd, the data directory is kept so it can be restarted
+func (node *Node) stop() {
+	if nil == node.process {
+		return
+	}
+	node.process.stop()
+	node.process = nil
+}
+
+// set the static connections used on the next start
+func (node *Node) setConnections(peers []*Node) {
+	node.connect = make([]peerConnection, 0, len(peers))
+	for _, peer := range peers {
+		if peer == node {
+			continue
+		}
+		node.connect = append(node.connect, peerConnection{
+			PublicKey: peer.peerPublicKey,
+			Address:   peer.PeerAddress(),
+		})
+	}
+}
+
+func (node *Node) writeConfiguration(filename string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+
+	return bitmarkdTemplate.Execute(f, struct {
+		Directory string
+		Ports     ports
+		Connect   []peerConnection
+	}{
+		Directory: node.directory,
+		Ports:     node.ports,
+		Connect:   node.connect,
+	})
+}
+
+// create a curve key pair and return the hex public key
+func makeKeyPair(directory string, publicFilename string, privateFilename string) (string, error) {
+	publicFilename = filepath.Join(directory, publicFilename)
+	err := zmqutil.MakeKeyPair(publicFilename, filepath.Join(directory, privateFilename))
+	if nil != err {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(publicFilename)
+	if nil != err {
+		return "", err
+	}
+	publicKey, err := zmqutil.ReadPublicKey(string(data))
+	if nil != err {
+		return "", err
+	}
+	return hex.EncodeToString(publicKey), nil
+}
+
+// find an unused loopback port
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func loopback(port int) string {
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+// shared by bitmarkd and recorderd configurations
+const readFileFunction = `
+function read_file(name)
+    local f, err = io.open(M.data_directory .. "/" .. name, "r")
+    if f == nil then
+        return nil
+    end
+    local r = f:read("*a")
+    f:close()
+    return r
+end
+`
+
+// local chain, loopback only, no DNS and no dynamic peers
+var bitmarkdTemplate = template.Must(template.New("bitmarkd").Parse(`-- generated by the bitmarkd test harness
+local M = {}
+` + readFileFunction + `
+M.data_directory = {{printf "%q" .Directory}}
+M.chain = "local"
+M.nodes = "none"
+
+M.client_rpc = {
+    maximum_connections = 50,
+    bandwidth = 25000000,
+    listen = {
+        "127.0.0.1:{{.Ports.RPC}}"
+    },
+    certificate = read_file("rpc.crt"),
+    private_key = read_file("rpc.key")
+}
+
+M.peering = {
+    dynamic_connections = false,
+    prefer_ipv6 = false,
+    minimum_connections = {{len .Connect}},
+    listen = {
+        "127.0.0.1:{{.Ports.Peer}}"
+    },
+    public_key = read_file("peer.public"),
+    private_key = read_file("peer.private"),
+    connect = {
+{{- range .Connect}}
+        {
+            public_key = "{{.PublicKey}}",
+            address = "{{.Address}}"
+        },
+{{- end}}
+    }
+}
+
+M.proofing = {
+    public_key = read_file("proof.public"),
+    private_key = read_file("proof.private"),
+    signing_key = read_file("proof.sign"),
+    payment_address = {
+        bitcoin = "msxN7C7cRNgbgyUzt3EcvrpmWXc59sZVN4",
+        litecoin = "mjPkDNakVA4w4hJZ6WF7p8yKUV2merhyCM"
+    },
+    publish = {
+        "127.0.0.1:{{.Ports.Publish}}"
+    },
+    submit = {
+        "127.0.0.1:{{.Ports.Submit}}"
+    }
+}
+
+M.payment = {
+    use_discovery = true,
+    discovery = {
+        sub_endpoint = "127.0.0.1:{{.Ports.PaymentSub}}",
+        req_endpoint = "127.0.0.1:{{.Ports.PaymentReq}}"
+    },
+    bitcoin = {
+        url = "http://127.0.0.1:8332/rest"
+    },
+    litecoin = {
+        url = "http://127.0.0.1:9332/rest"
+    }
+}
+
+M.logging = {
+    size = 1048576,
+    count = 10,
+    console = false,
+    levels = {
+        DEFAULT = "info"
+    }
+}
+
+return M
+`))
@@ -0,0 +1,150 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package harness
+
+import (
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/mode"
+)
+
+// split the network so that nodes only connect within their group
+//
+// every node must be in exactly one group and each group needs at
+// least two nodes; all nodes are restarted with the new connections
+func (network *Network) Partition(groups ...[]int) error {
+	network.Lock()
+	defer network.Unlock()
+
+	if err := network.checkGroups(groups); nil != err {
+		return err
+	}
+	return network.restart(groups)
+}
+
+// reconnect all nodes after a partition
+func (network *Network) Heal() error {
+	network.Lock()
+	defer network.Unlock()
+
+	return network.restart([][]int{network.all()})
+}
+
+// wait until the listed nodes (all nodes if none are listed) are in
+// normal mode with the same height and tip digest
+// returns the common height
+func (network *Network) WaitForConvergence(timeout time.Duration, indexes ...int) (uint64, error) {
+	if 0 == len(indexes) {
+		indexes = network.all()
+	}
+	nodes := make([]*Node, 0, len(indexes))
+	for _, i := range indexes {
+		node := network.Node(i)
+		if nil == node {
+			return 0, ErrNodeNotFound
+		}
+		nodes = append(nodes, node)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if height, ok, err := converged(nodes); nil != err {
+			return 0, err
+		} else if ok {
+			return height, nil
+		}
+		if time.Now().After(deadline) {
+			return 0, ErrNotConverged
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// check whether all nodes agree on the tip
+// RPC errors are treated as not yet converged, only an exited node
+// is an error
+func converged(nodes []*Node) (uint64, bool, error) {
+	height := uint64(0)
+	digest := blockdigest.Digest{}
+
+	for i, node := range nodes {
+		info, err := node.Info()
+		if ErrNodeExited == err {
+			return 0, false, err
+		}
+		if nil != err || mode.Normal.String() != info.Mode {
+			return 0, false, nil
+		}
+		d, err := node.Digest(info.Blocks)
+		if nil != err {
+			return 0, false, nil
+		}
+		if 0 == i {
+			height = info.Blocks
+			digest = d
+		} else if height != info.Blocks || digest != d {
+			return 0, false, nil
+		}
+	}
+	return height, true, nil
+}
+
+// every node must appear exactly once and groups need two or more
+func (network *Network) checkGroups(groups [][]int) error {
+	seen := make(map[int]bool)
+	for _, group := range groups {
+		if len(group) < minimumNodes {
+			return ErrPartitionTooSmall
+		}
+		for _, i := range group {
+			if nil == network.Node(i) {
+				return ErrNodeNotFound
+			}
+			if seen[i] {
+				return fault.ErrInvalidItem
+			}
+			seen[i] = true
+		}
+	}
+	if len(seen) != len(network.nodes) {
+		return fault.ErrInvalidCount
+	}
+	return nil
+}
+
+// set the static connections of each node to the rest of its group
+func (network *Network) connect(groups [][]int) {
+	for _, group := range groups {
+		peers := make([]*Node, len(group))
+		for j, i := range group {
+			peers[j] = network.nodes[i]
+		}
+		for _, node := range peers {
+			node.setConnections(peers)
+		}
+	}
+}
+
+// stop all nodes, change their connections and start them again
+// hold lock before calling
+func (network *Network) restart(groups [][]int) error {
+	if !network.running {
+		network.connect(groups)
+		return nil
+	}
+
+	for _, node := range network.nodes {
+		node.stop()
+	}
+	network.connect(groups)
+	for _, node := range network.nodes {
+		if err := node.start(); nil != err {
+			return err
+		}
+	}
+	return nil
+}
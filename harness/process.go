@@ -0,0 +1,70 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package harness
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const (
+	stopTimeout = 30 * time.Second // wait this long after interrupt before killing
+)
+
+// a running subprocess
+type process struct {
+	cmd  *exec.Cmd
+	done chan struct{} // closed when the process exits
+	err  error         // exit status, valid after done is closed
+}
+
+// run a program with its configuration file and command
+func startProcess(program string, configurationFile string, command string, output io.Writer) (*process, error) {
+
+	cmd := exec.Command(program, "--config-file="+configurationFile, command)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err := cmd.Start(); nil != err {
+		return nil, err
+	}
+
+	p := &process{
+		cmd:  cmd,
+		done: make(chan struct{}),
+	}
+	go func() {
+		p.err = cmd.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// true if the process has terminated
+func (p *process) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// interrupt the process and wait for it to finish
+// kill it if it does not stop in time
+func (p *process) stop() {
+	if p.exited() {
+		return
+	}
+	p.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-p.done:
+	case <-time.After(stopTimeout):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package harness
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc/jsonrpc"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/command/bitmark-cli/rpccalls"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/keypair"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/rpc"
+)
+
+const (
+	rpcTimeout = 10 * time.Second
+)
+
+// make one RPC call on a fresh connection
+func (node *Node) call(method string, arguments interface{}, reply interface{}) error {
+	if !node.Running() {
+		return ErrNodeExited
+	}
+
+	dialer := &net.Dialer{
+		Timeout: rpcTimeout,
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true,
+	}
+	conn, err := tls.DialWithDialer(dialer, "tcp", node.RPCAddress(), tlsConfig)
+	if nil != err {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(rpcTimeout))
+
+	client := jsonrpc.NewClient(conn)
+	defer client.Close()
+
+	return client.Call(method, arguments, reply)
+}
+
+// node status as reported by Node.Info
+func (node *Node) Info() (*rpc.InfoReply, error) {
+	var reply rpc.InfoReply
+	if err := node.call("Node.Info", &rpc.InfoArguments{}, &reply); nil != err {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// current block height
+func (node *Node) Height() (uint64, error) {
+	info, err := node.Info()
+	if nil != err {
+		return 0, err
+	}
+	return info.Blocks, nil
+}
+
+// digest of the block at a given height
+func (node *Node) Digest(height uint64) (blockdigest.Digest, error) {
+	arguments := rpc.BlocksRangeArguments{
+		Start:       height,
+		Count:       1,
+		HeadersOnly: true,
+	}
+	var reply rpc.BlocksRangeReply
+	if err := node.call("Blocks.Range", &arguments, &reply); nil != err {
+		return blockdigest.Digest{}, err
+	}
+	if 1 != len(reply.Blocks) {
+		return blockdigest.Digest{}, fault.ErrBlockNotFound
+	}
+	return reply.Blocks[0].Digest, nil
+}
+
+// register a new asset and make a free issue of it
+// returns the issue transaction id
+func (node *Node) Issue() (merkle.Digest, error) {
+	if !node.Running() {
+		return merkle.Digest{}, ErrNodeExited
+	}
+
+	_, registrant, err := keypair.MakeRawKeyPair(true)
+	if nil != err {
+		return merkle.Digest{}, err
+	}
+
+	fingerprint := make([]byte, 32)
+	if _, err := rand.Read(fingerprint); nil != err {
+		return merkle.Digest{}, err
+	}
+
+	client, err := rpccalls.NewClient(true, node.RPCAddress(), false, ioutil.Discard)
+	if nil != err {
+		return merkle.Digest{}, err
+	}
+	defer client.Close()
+
+	asset, err := client.MakeAsset(&rpccalls.AssetData{
+		Name:        fmt.Sprintf("harness node %d", node.index),
+		Metadata:    "",
+		Quantity:    1,
+		Registrant:  registrant,
+		Fingerprint: "01" + hex.EncodeToString(fingerprint),
+	})
+	if nil != err {
+		return merkle.Digest{}, err
+	}
+
+	issue, err := client.Issue(&rpccalls.IssueData{
+		Issuer:    registrant,
+		AssetId:   asset.AssetId,
+		Quantity:  1,
+		FreeIssue: true,
+	})
+	if nil != err {
+		return merkle.Digest{}, err
+	}
+	return issue.IssueIds[0], nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package harness
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+const (
+	bitmarkdPackage  = "github.com/bitmark-inc/bitmarkd/command/bitmarkd"
+	recorderdPackage = "github.com/bitmark-inc/bitmarkd/command/recorderd"
+	minimumNodes     = 2 // a node with no static connections cannot start
+	defaultThreads   = 1
+)
+
+var (
+	ErrNetworkRunning    = fault.ProcessError("network is already running")
+	ErrNetworkStopped    = fault.ProcessError("network is not running")
+	ErrNoBlockMined      = fault.ProcessError("no block was mined")
+	ErrNodeExited        = fault.ProcessError("node exited")
+	ErrNodeNotFound      = fault.NotFoundError("node not found")
+	ErrNotConverged      = fault.ProcessError("nodes did not converge")
+	ErrPartitionTooSmall = fault.ProcessError("partition group must have at least two nodes")
+)
+
+// options for creating a network
+type Options struct {
+	Nodes     int       // number of bitmarkd instances (minimum 2)
+	Directory string    // parent of the node directories, temporary if blank
+	Bitmarkd  string    // bitmarkd binary, built from this tree if blank
+	Recorderd string    // recorderd binary, built from this tree if blank
+	Threads   int       // hashing threads for each recorderd
+	Output    io.Writer // receives output from all subprocesses, discarded if nil
+}
+
+// a set of bitmarkd nodes on the local chain
+type Network struct {
+	sync.Mutex
+
+	directory string
+	temporary bool // remove directory on Stop
+
+	bitmarkd  string
+	recorderd string
+	threads   int
+	output    io.Writer
+
+	nodes   []*Node
+	miners  map[int]*miner
+	running bool
+}
+
+// create the directories, identities and configuration for a network
+// the nodes are not started until Start is called
+func New(options *Options) (*Network, error) {
+
+	if options.Nodes < minimumNodes {
+		return nil, fault.ErrInvalidCount
+	}
+
+	network := &Network{
+		directory: options.Directory,
+		bitmarkd:  options.Bitmarkd,
+		recorderd: options.Recorderd,
+		threads:   options.Threads,
+		output:    options.Output,
+		miners:    make(map[int]*miner),
+	}
+	if network.threads <= 0 {
+		network.threads = defaultThreads
+	}
+	if nil == network.output {
+		network.output = ioutil.Discard
+	}
+
+	if "" == network.directory {
+		dir, err := ioutil.TempDir("", "bitmarkd-harness-")
+		if nil != err {
+			return nil, err
+		}
+		network.directory = dir
+		network.temporary = true
+	} else if err := os.MkdirAll(network.directory, 0700); nil != err {
+		return nil, err
+	}
+
+	err := network.build()
+	if nil != err {
+		network.cleanup()
+		return nil, err
+	}
+
+	for i := 0; i < options.Nodes; i += 1 {
+		node, err := newNode(i, filepath.Join(network.directory, fmt.Sprintf("node-%d", i)), network.bitmarkd, network.output)
+		if nil != err {
+			network.cleanup()
+			return nil, err
+		}
+		network.nodes = append(network.nodes, node)
+	}
+
+	// initially every node connects to all the others
+	network.connect([][]int{network.all()})
+
+	return network, nil
+}
+
+// start all nodes
+func (network *Network) Start() error {
+	network.Lock()
+	defer network.Unlock()
+
+	if network.running {
+		return ErrNetworkRunning
+	}
+
+	for _, node := range network.nodes {
+		if err := node.start(); nil != err {
+			network.stop()
+			return err
+		}
+	}
+	network.running = true
+	return nil
+}
+
+// stop all miners and nodes and remove a temporary directory
+func (network *Network) Stop() {
+	network.Lock()
+	defer network.Unlock()
+
+	network.stop()
+	network.cleanup()
+}
+
+// return the number of nodes
+func (network *Network) Count() int {
+	return len(network.nodes)
+}
+
+// return a node by index
+func (network *Network) Node(i int) *Node {
+	if i < 0 || i >= len(network.nodes) {
+		return nil
+	}
+	return network.nodes[i]
+}
+
+// stop all subprocesses
+// hold lock before calling
+func (network *Network) stop() {
+	for i, m := range network.miners {
+		m.stop()
+		delete(network.miners, i)
+	}
+	for _, node := range network.nodes {
+		node.stop()
+	}
+	network.running = false
+}
+
+// remove the directory if it was created by New
+func (network *Network) cleanup() {
+	if network.temporary {
+		os.RemoveAll(network.directory)
+		network.temporary = false
+	}
+}
+
+// build any binaries that were not supplied
+func (network *Network) build() error {
+	binaries := []struct {
+		path *string
+		pkg  string
+	}{
+		{&network.bitmarkd, bitmarkdPackage},
+		{&network.recorderd, recorderdPackage},
+	}
+	for _, b := range binaries {
+		if "" != *b.path {
+			continue
+		}
+		out := filepath.Join(network.directory, filepath.Base(b.pkg))
+		cmd := exec.Command("go", "build", "-o", out, b.pkg)
+		cmd.Stdout = network.output
+		cmd.Stderr = network.output
+		if err := cmd.Run(); nil != err {
+			return err
+		}
+		*b.path = out
+	}
+	return nil
+}
+
+// indexes of all nodes
+func (network *Network) all() []int {
+	all := make([]int, len(network.nodes))
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
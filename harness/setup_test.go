@@ -0,0 +1,143 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/configuration"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/peer"
+)
+
+// nodes without keys or processes, enough for configuration and
+// partition checks
+func fakeNetwork(t *testing.T, count int) (*Network, string) {
+	dir, err := ioutil.TempDir("", "harness-test-")
+	if nil != err {
+		t.Fatalf("temporary directory error: %s", err)
+	}
+
+	network := &Network{
+		directory: dir,
+		miners:    make(map[int]*miner),
+	}
+	for i := 0; i < count; i += 1 {
+		network.nodes = append(network.nodes, &Node{
+			index:         i,
+			directory:     dir,
+			peerPublicKey: string(rune('a'+i)) + "0",
+			ports: ports{
+				RPC:  3000 + i,
+				Peer: 4000 + i,
+			},
+		})
+	}
+	network.connect([][]int{network.all()})
+	return network, dir
+}
+
+func TestConfiguration(t *testing.T) {
+	network, dir := fakeNetwork(t, 3)
+	defer os.RemoveAll(dir)
+
+	node := network.Node(1)
+	filename := filepath.Join(dir, configurationFilename)
+	if err := node.writeConfiguration(filename); nil != err {
+		t.Fatalf("write configuration error: %s", err)
+	}
+
+	options := struct {
+		DataDirectory string             `gluamapper:"data_directory"`
+		Chain         string             `gluamapper:"chain"`
+		Nodes         string             `gluamapper:"nodes"`
+		Peering       peer.Configuration `gluamapper:"peering"`
+	}{}
+	if err := configuration.ParseConfigurationFile(filename, &options); nil != err {
+		t.Fatalf("parse configuration error: %s", err)
+	}
+
+	if dir != options.DataDirectory {
+		t.Errorf("data directory: %q  expected: %q", options.DataDirectory, dir)
+	}
+	if "local" != options.Chain || "none" != options.Nodes {
+		t.Errorf("chain: %q  nodes: %q", options.Chain, options.Nodes)
+	}
+
+	p := options.Peering
+	if p.DynamicConnections || p.PreferIPv6 {
+		t.Errorf("dynamic: %t  ipv6: %t", p.DynamicConnections, p.PreferIPv6)
+	}
+	if 2 != p.MinimumConnections {
+		t.Errorf("minimum connections: %d  expected: 2", p.MinimumConnections)
+	}
+	if 1 != len(p.Listen) || node.PeerAddress() != p.Listen[0] {
+		t.Errorf("listen: %v", p.Listen)
+	}
+
+	expected := []peer.Connection{
+		{PublicKey: "a0", Address: "127.0.0.1:4000"},
+		{PublicKey: "c0", Address: "127.0.0.1:4002"},
+	}
+	if len(expected) != len(p.Connect) {
+		t.Fatalf("connect: %v  expected: %v", p.Connect, expected)
+	}
+	for i, c := range expected {
+		if c != p.Connect[i] {
+			t.Errorf("connect[%d]: %v  expected: %v", i, p.Connect[i], c)
+		}
+	}
+}
+
+func TestPartitionGroups(t *testing.T) {
+	network, dir := fakeNetwork(t, 4)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		groups [][]int
+		err    error
+	}{
+		{[][]int{{0, 1}, {2, 3}}, nil},
+		{[][]int{{0, 1, 2, 3}}, nil},
+		{[][]int{{0, 1, 2}, {3}}, ErrPartitionTooSmall},
+		{[][]int{{0, 1}, {2, 4}}, ErrNodeNotFound},
+		{[][]int{{0, 1}, {1, 2, 3}}, fault.ErrInvalidItem},
+		{[][]int{{0, 1}, {2, 2}}, fault.ErrInvalidItem},
+		{[][]int{{0, 1, 2}}, fault.ErrInvalidCount},
+	}
+
+	for i, item := range tests {
+		err := network.Partition(item.groups...)
+		if item.err != err {
+			t.Errorf("%d: groups: %v  error: %v  expected: %v", i, item.groups, err, item.err)
+		}
+	}
+
+	// not running, so only the connections change
+	if err := network.Partition([]int{0, 1}, []int{2, 3}); nil != err {
+		t.Fatalf("partition error: %s", err)
+	}
+	for i, node := range network.nodes {
+		if 1 != len(node.connect) {
+			t.Fatalf("node[%d]: connect: %v", i, node.connect)
+		}
+		peer := i ^ 1
+		if network.nodes[peer].peerPublicKey != node.connect[0].PublicKey {
+			t.Errorf("node[%d]: connect: %v  expected node: %d", i, node.connect, peer)
+		}
+	}
+
+	if err := network.Heal(); nil != err {
+		t.Fatalf("heal error: %s", err)
+	}
+	for i, node := range network.nodes {
+		if 3 != len(node.connect) {
+			t.Errorf("node[%d]: connect: %v", i, node.connect)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package lightclient
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/chain"
+	"github.com/bitmark-inc/bitmarkd/difficulty"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+)
+
+// limits for synchronising
+const (
+	syncBatch         = 100  // headers per request, the limit of Blocks.Range
+	maximumReorganise = 1000 // deepest fork that can replace stored headers
+)
+
+// the argon2 digest of a header, replaced by tests as it is slow
+var headerDigest = blockrecord.PackedHeader.Digest
+
+// 2^256 the number of possible digests
+var digestSpace = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// the target of the minimum difficulty, no header may have a higher one
+var maximumTarget = difficulty.New().BigInt()
+
+// percentage by which a difficulty may differ from that of the previous
+// header, as the full node allows around its current difficulty
+const difficultyTolerance = 10
+
+// Chain is the verified header chain of one network
+type Chain struct {
+	sync.RWMutex
+	syncing       sync.Mutex // only one Sync at a time
+	store         Store
+	genesis       *blockrecord.Header
+	genesisDigest blockdigest.Digest
+	tip           *blockrecord.Header
+	tipDigest     blockdigest.Digest
+}
+
+// create a header chain for a network from the headers in a store
+//
+// the stored headers were verified as they were added, so only the
+// digest of the last one is computed
+func New(chainName string, store Store) (*Chain, error) {
+
+	packedGenesis := genesis.TestGenesisBlock
+	genesisDigest := genesis.TestGenesisDigest
+	switch chainName {
+	case chain.Bitmark:
+		packedGenesis = genesis.LiveGenesisBlock
+		genesisDigest = genesis.LiveGenesisDigest
+	case chain.Testing, chain.Local:
+	default:
+		return nil, fault.ErrInvalidChain
+	}
+
+	packed := blockrecord.PackedHeader{}
+	copy(packed[:], packedGenesis)
+	genesisHeader, err := packed.Unpack()
+	if nil != err {
+		return nil, err
+	}
+
+	c := &Chain{
+		store:         store,
+		genesis:       genesisHeader,
+		genesisDigest: genesisDigest,
+		tip:           genesisHeader,
+		tipDigest:     genesisDigest,
+	}
+
+	if n := store.Len(); 0 != n {
+		packed, err := store.Get(n - 1)
+		if nil != err {
+			return nil, err
+		}
+		header, err := packed.Unpack()
+		if nil != err {
+			return nil, err
+		}
+		if genesis.BlockNumber+n != header.Number {
+			return nil, fault.ErrInvalidBlockHeaderNumber
+		}
+		c.tip = header
+		c.tipDigest = headerDigest(packed)
+	}
+	return c, nil
+}
+
+// number of the last verified header
+func (c *Chain) Height() uint64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.tip.Number
+}
+
+// digest of the last verified header
+func (c *Chain) Digest() blockdigest.Digest {
+	c.RLock()
+	defer c.RUnlock()
+	return c.tipDigest
+}
+
+// a verified header
+func (c *Chain) Header(number uint64) (*blockrecord.Header, error) {
+	if genesis.BlockNumber == number {
+		return c.genesis, nil
+	}
+	packed, err := c.packedHeader(number)
+	if nil != err {
+		return nil, err
+	}
+	return packed.Unpack()
+}
+
+// a stored header by block number
+func (c *Chain) packedHeader(number uint64) (blockrecord.PackedHeader, error) {
+	if number < blockrecord.MinimumBlockNumber {
+		return blockrecord.PackedHeader{}, fault.ErrBlockNotFound
+	}
+	return c.store.Get(number - blockrecord.MinimumBlockNumber)
+}
+
+// fetch and verify headers until level with a source
+//
+// if the source is on a different fork it replaces the stored headers
+// above the common block only if it has more work
+func (c *Chain) Sync(source Source) error {
+
+	c.syncing.Lock()
+	defer c.syncing.Unlock()
+
+	remoteHeight, err := source.Height()
+	if nil != err {
+		return err
+	}
+
+sync_loop:
+	for {
+		c.RLock()
+		previous, previousDigest := c.tip, c.tipDigest
+		c.RUnlock()
+
+		if previous.Number >= remoteHeight {
+			break sync_loop
+		}
+
+		count := remoteHeight - previous.Number
+		if count > syncBatch {
+			count = syncBatch
+		}
+		headers, err := source.Headers(previous.Number+1, int(count))
+		if nil != err {
+			return err
+		}
+		if 0 == len(headers) {
+			return fault.ErrBlockNotFound
+		}
+
+		first, err := headers[0].Unpack()
+		if nil != err {
+			return err
+		}
+		if first.PreviousBlock != previousDigest {
+			err := c.reorganise(source, remoteHeight)
+			if nil != err {
+				return err
+			}
+			continue sync_loop
+		}
+
+		unpacked, digest, err := verifyHeaders(previous, previousDigest, headers)
+		if nil != err {
+			return err
+		}
+
+		c.Lock()
+		err = c.appendHeaders(headers, unpacked[len(unpacked)-1], digest)
+		c.Unlock()
+		if nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// replace the stored headers above the common block with the fork of
+// a source if that fork has more work
+func (c *Chain) reorganise(source Source, remoteHeight uint64) error {
+
+	ancestor, err := c.commonAncestor(source)
+	if nil != err {
+		return err
+	}
+
+	previous, err := c.Header(ancestor)
+	if nil != err {
+		return err
+	}
+	next, err := c.Header(ancestor + 1)
+	if nil != err {
+		return err
+	}
+	previousDigest := next.PreviousBlock
+
+	localWork := new(big.Int)
+	for n := ancestor + 1; n <= c.Height(); n += 1 {
+		header, err := c.Header(n)
+		if nil != err {
+			return err
+		}
+		localWork.Add(localWork, headerWork(header))
+	}
+
+	// only fetch enough of the fork to show it has more work
+	branch := []blockrecord.PackedHeader{}
+	work := new(big.Int)
+	for n := ancestor + 1; n <= remoteHeight && work.Cmp(localWork) <= 0; {
+		count := remoteHeight - n + 1
+		if count > syncBatch {
+			count = syncBatch
+		}
+		headers, err := source.Headers(n, int(count))
+		if nil != err {
+			return err
+		}
+		if 0 == len(headers) {
+			return fault.ErrBlockNotFound
+		}
+		unpacked, digest, err := verifyHeaders(previous, previousDigest, headers)
+		if nil != err {
+			return err
+		}
+		for _, header := range unpacked {
+			work.Add(work, headerWork(header))
+		}
+		branch = append(branch, headers...)
+		previous, previousDigest = unpacked[len(unpacked)-1], digest
+		n += uint64(len(headers))
+	}
+
+	if work.Cmp(localWork) <= 0 {
+		return fault.ErrInvalidChain
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	err = c.store.Truncate(ancestor - genesis.BlockNumber)
+	if nil != err {
+		return err
+	}
+	return c.appendHeaders(branch, previous, previousDigest)
+}
+
+// find the highest block a source has in common with the stored
+// headers by comparing them from the top down
+func (c *Chain) commonAncestor(source Source) (uint64, error) {
+
+	height := c.Height()
+	for top := height; top >= blockrecord.MinimumBlockNumber && height-top < maximumReorganise; {
+		start := uint64(blockrecord.MinimumBlockNumber)
+		if top >= start+syncBatch {
+			start = top - syncBatch + 1
+		}
+		headers, err := source.Headers(start, int(top-start+1))
+		if nil != err {
+			return 0, err
+		}
+		for i := len(headers) - 1; i >= 0; i -= 1 {
+			stored, err := c.packedHeader(start + uint64(i))
+			if nil != err {
+				return 0, err
+			}
+			if stored == headers[i] {
+				return start + uint64(i), nil
+			}
+		}
+		if blockrecord.MinimumBlockNumber == start {
+			return genesis.BlockNumber, nil
+		}
+		top = start - 1
+	}
+	return 0, fault.ErrInvalidChain
+}
+
+// add verified headers to the store and make the last one the tip
+//
+// hold the write lock when calling this
+func (c *Chain) appendHeaders(headers []blockrecord.PackedHeader, tip *blockrecord.Header, tipDigest blockdigest.Digest) error {
+	for _, packed := range headers {
+		err := c.store.Append(packed)
+		if nil != err {
+			return err
+		}
+	}
+	c.tip = tip
+	c.tipDigest = tipDigest
+	return nil
+}
+
+// check a run of headers follows on from a header of known digest
+//
+// returns the unpacked headers and the digest of the last one
+func verifyHeaders(previous *blockrecord.Header, previousDigest blockdigest.Digest, headers []blockrecord.PackedHeader) ([]*blockrecord.Header, blockdigest.Digest, error) {
+
+	unpacked := make([]*blockrecord.Header, len(headers))
+	for i, packed := range headers {
+		header, err := packed.Unpack()
+		if nil != err {
+			return nil, blockdigest.Digest{}, err
+		}
+		if previous.Number+1 != header.Number {
+			return nil, blockdigest.Digest{}, fault.ErrInvalidBlockHeaderNumber
+		}
+		if previousDigest != header.PreviousBlock {
+			return nil, blockdigest.Digest{}, fault.ErrPreviousBlockDigestDoesNotMatch
+		}
+		if header.Version > blockrecord.Version {
+			return nil, blockdigest.Digest{}, fault.ErrInvalidBlockHeaderVersion
+		}
+		if header.Version < previous.Version {
+			return nil, blockdigest.Digest{}, fault.ErrBlockVersionMustNotDecrease
+		}
+		if !timestampValid(previous, header) {
+			return nil, blockdigest.Digest{}, fault.ErrInvalidBlockHeaderTimestamp
+		}
+		if !difficultyValid(previous, header) {
+			return nil, blockdigest.Digest{}, fault.ErrInvalidBlockHeaderDifficulty
+		}
+
+		digest := headerDigest(packed)
+		if digest.Cmp(header.Difficulty.BigInt()) > 0 {
+			return nil, blockdigest.Digest{}, fault.ErrInvalidBlockHeaderDifficulty
+		}
+
+		unpacked[i] = header
+		previous, previousDigest = header, digest
+	}
+	return unpacked, previousDigest, nil
+}
+
+// timestamps may only go back by a small amount, which is larger for
+// version 1 blocks, in the same way as the block store allows
+func timestampValid(previous *blockrecord.Header, header *blockrecord.Header) bool {
+	if header.Timestamp >= previous.Timestamp {
+		return true
+	}
+	d := previous.Timestamp - header.Timestamp
+	switch header.Version {
+	case 1:
+		return d <= 240*60 // seconds
+	case 2:
+		return d <= 10*60 // seconds
+	default:
+		return false
+	}
+}
+
+// a difficulty must be at least the minimum and within the tolerance
+// of the previous header, so a source cannot make a run of headers
+// easier than the chain they follow
+func difficultyValid(previous *blockrecord.Header, header *blockrecord.Header) bool {
+	target := header.Difficulty.BigInt()
+	if target.Cmp(maximumTarget) > 0 {
+		return false
+	}
+
+	previousTarget := previous.Difficulty.BigInt()
+	tolerance := new(big.Int).Quo(previousTarget, big.NewInt(difficultyTolerance))
+	low := new(big.Int).Sub(previousTarget, tolerance)
+	high := new(big.Int).Add(previousTarget, tolerance)
+	return target.Cmp(low) >= 0 && target.Cmp(high) <= 0
+}
+
+// the expected number of digests computed to find one not greater
+// than the target of a header
+func headerWork(header *blockrecord.Header) *big.Int {
+	target := new(big.Int).Add(header.Difficulty.BigInt(), big.NewInt(1))
+	return target.Div(digestSpace, target)
+}
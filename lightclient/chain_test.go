@@ -0,0 +1,399 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package lightclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/chain"
+	"github.com/bitmark-inc/bitmarkd/difficulty"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+)
+
+// the argon2 digest is too slow for tests, so use sha3 with the high
+// bytes cleared to satisfy any test difficulty
+func init() {
+	headerDigest = func(packed blockrecord.PackedHeader) blockdigest.Digest {
+		d := blockdigest.Digest(sha3.Sum256(packed[:]))
+		for i := 24; i < len(d); i += 1 {
+			d[i] = 0
+		}
+		return d
+	}
+}
+
+// a source holding headers from block 2
+type fakeSource struct {
+	headers []blockrecord.PackedHeader
+	proofs  map[merkle.Digest]*Proof
+}
+
+func (s *fakeSource) Height() (uint64, error) {
+	return genesis.BlockNumber + uint64(len(s.headers)), nil
+}
+
+func (s *fakeSource) Headers(start uint64, count int) ([]blockrecord.PackedHeader, error) {
+	i := start - blockrecord.MinimumBlockNumber
+	if i >= uint64(len(s.headers)) {
+		return []blockrecord.PackedHeader{}, nil
+	}
+	j := i + uint64(count)
+	if j > uint64(len(s.headers)) {
+		j = uint64(len(s.headers))
+	}
+	return append([]blockrecord.PackedHeader{}, s.headers[i:j]...), nil
+}
+
+func (s *fakeSource) Proof(txId merkle.Digest) (*Proof, error) {
+	proof, ok := s.proofs[txId]
+	if !ok {
+		return nil, fault.ErrTransactionIsNotConfirmed
+	}
+	return proof, nil
+}
+
+// extend a list of headers from block 2 of the test chain
+//
+// seed makes forks differ and reciprocal sets their difficulty
+func extend(headers []blockrecord.PackedHeader, count int, seed byte, reciprocal float64) []blockrecord.PackedHeader {
+
+	headers = append([]blockrecord.PackedHeader{}, headers...)
+
+	previousDigest := genesis.TestGenesisDigest
+	if 0 != len(headers) {
+		previousDigest = headerDigest(headers[len(headers)-1])
+	}
+	start := time.Now().Add(-24 * time.Hour).Unix()
+
+	for i := 0; i < count; i += 1 {
+		number := blockrecord.MinimumBlockNumber + uint64(len(headers))
+		d := difficulty.New()
+		d.SetReciprocal(reciprocal)
+		header := blockrecord.Header{
+			Version:          blockrecord.Version,
+			TransactionCount: blockrecord.MinimumTransactions,
+			Number:           number,
+			PreviousBlock:    previousDigest,
+			MerkleRoot:       merkle.NewDigest([]byte{seed, byte(number), byte(number >> 8)}),
+			Timestamp:        uint64(start) + number*60,
+			Difficulty:       d,
+		}
+		packed := header.Pack()
+		headers = append(headers, packed)
+		previousDigest = headerDigest(packed)
+	}
+	return headers
+}
+
+func TestSync(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "lightclient")
+	if nil != err {
+		t.Fatalf("temp dir error: %s", err)
+	}
+	defer os.RemoveAll(directory)
+	fileName := filepath.Join(directory, "headers.dat")
+
+	store, err := OpenFileStore(fileName)
+	if nil != err {
+		t.Fatalf("open store error: %s", err)
+	}
+	c, err := New(chain.Testing, store)
+	if nil != err {
+		t.Fatalf("new error: %s", err)
+	}
+	if genesis.BlockNumber != c.Height() || genesis.TestGenesisDigest != c.Digest() {
+		t.Errorf("initial height: %d  digest: %v", c.Height(), c.Digest())
+	}
+
+	source := &fakeSource{
+		headers: extend(nil, 250, 0, 1),
+	}
+	err = c.Sync(source)
+	if nil != err {
+		t.Fatalf("sync error: %s", err)
+	}
+	if 251 != c.Height() {
+		t.Errorf("height: %d  expected: 251", c.Height())
+	}
+	expected := headerDigest(source.headers[len(source.headers)-1])
+	if expected != c.Digest() {
+		t.Errorf("digest: %v  expected: %v", c.Digest(), expected)
+	}
+
+	// nothing more to fetch
+	err = c.Sync(source)
+	if nil != err {
+		t.Fatalf("second sync error: %s", err)
+	}
+
+	// the reopened store resumes at the same header
+	store.Close()
+	store, err = OpenFileStore(fileName)
+	if nil != err {
+		t.Fatalf("reopen store error: %s", err)
+	}
+	defer store.Close()
+	c, err = New(chain.Testing, store)
+	if nil != err {
+		t.Fatalf("reopen error: %s", err)
+	}
+	if 251 != c.Height() || expected != c.Digest() {
+		t.Errorf("reopened height: %d  digest: %v", c.Height(), c.Digest())
+	}
+	header, err := c.Header(100)
+	if nil != err {
+		t.Fatalf("header error: %s", err)
+	}
+	if 100 != header.Number {
+		t.Errorf("header number: %d  expected: 100", header.Number)
+	}
+
+	// the live chain does not follow the test genesis block
+	c, err = New(chain.Bitmark, NewMemoryStore())
+	if nil != err {
+		t.Fatalf("new error: %s", err)
+	}
+	if err := c.Sync(source); fault.ErrInvalidChain != err {
+		t.Errorf("wrong chain error: %v  expected: %s", err, fault.ErrInvalidChain)
+	}
+}
+
+func TestSyncInvalid(t *testing.T) {
+
+	valid := extend(nil, 10, 0, 1)
+
+	// each alteration is made to block 6 and the following headers
+	// relinked so only the alteration is invalid
+	alter := func(f func(header *blockrecord.Header)) []blockrecord.PackedHeader {
+		headers := append([]blockrecord.PackedHeader{}, valid...)
+		previousDigest := headerDigest(headers[3])
+		for i := 4; i < len(headers); i += 1 {
+			header, err := headers[i].Unpack()
+			if nil != err {
+				t.Fatalf("unpack error: %s", err)
+			}
+			header.PreviousBlock = previousDigest
+			if 4 == i {
+				f(header)
+			}
+			headers[i] = header.Pack()
+			previousDigest = headerDigest(headers[i])
+		}
+		return headers
+	}
+
+	items := []struct {
+		headers []blockrecord.PackedHeader
+		err     error
+	}{
+		{
+			headers: alter(func(header *blockrecord.Header) { header.PreviousBlock[0] ^= 1 }),
+			err:     fault.ErrPreviousBlockDigestDoesNotMatch,
+		},
+		{
+			headers: alter(func(header *blockrecord.Header) { header.Number += 1 }),
+			err:     fault.ErrInvalidBlockHeaderNumber,
+		},
+		{
+			headers: alter(func(header *blockrecord.Header) { header.Version = 1 }),
+			err:     fault.ErrBlockVersionMustNotDecrease,
+		},
+		{
+			headers: alter(func(header *blockrecord.Header) { header.Version = blockrecord.Version + 1 }),
+			err:     fault.ErrInvalidBlockHeaderVersion,
+		},
+		{
+			headers: alter(func(header *blockrecord.Header) { header.Timestamp -= 3600 }),
+			err:     fault.ErrInvalidBlockHeaderTimestamp,
+		},
+		{
+			// beyond what the cleared bytes of the test digest satisfy
+			headers: alter(func(header *blockrecord.Header) { header.Difficulty.SetReciprocal(1e30) }),
+			err:     fault.ErrInvalidBlockHeaderDifficulty,
+		},
+		{
+			// more than the tolerance above the previous header
+			headers: alter(func(header *blockrecord.Header) { header.Difficulty.SetReciprocal(1.2) }),
+			err:     fault.ErrInvalidBlockHeaderDifficulty,
+		},
+	}
+
+	for i, item := range items {
+		c, err := New(chain.Testing, NewMemoryStore())
+		if nil != err {
+			t.Fatalf("%d: new error: %s", i, err)
+		}
+		err = c.Sync(&fakeSource{headers: item.headers})
+		if item.err != err {
+			t.Errorf("%d: error: %v  expected: %s", i, err, item.err)
+		}
+		if genesis.BlockNumber != c.Height() {
+			t.Errorf("%d: height: %d  expected: %d", i, c.Height(), genesis.BlockNumber)
+		}
+	}
+}
+
+func TestSyncMaximumTarget(t *testing.T) {
+
+	// raise the difficulty within the tolerance
+	headers := extend(nil, 1, 0, 1)
+	for r := 1.05; r < 2; r *= 1.05 {
+		headers = extend(headers, 1, 0, r)
+	}
+
+	c, err := New(chain.Testing, NewMemoryStore())
+	if nil != err {
+		t.Fatalf("new error: %s", err)
+	}
+	if err := c.Sync(&fakeSource{headers: headers}); nil != err {
+		t.Fatalf("sync error: %s", err)
+	}
+	height := c.Height()
+
+	// a header at the maximum target, which any digest is likely to meet
+	headers = extend(headers, 1, 0, difficulty.MinimumReciprocal)
+	if err := c.Sync(&fakeSource{headers: headers}); fault.ErrInvalidBlockHeaderDifficulty != err {
+		t.Errorf("maximum target error: %v  expected: %s", err, fault.ErrInvalidBlockHeaderDifficulty)
+	}
+	if height != c.Height() {
+		t.Errorf("height: %d  expected: %d", c.Height(), height)
+	}
+}
+
+func TestReorganise(t *testing.T) {
+
+	// difficulties stay within the tolerance of the common headers
+	common := extend(nil, 120, 0, 1)
+	local := extend(common, 30, 1, 1.1)
+
+	c, err := New(chain.Testing, NewMemoryStore())
+	if nil != err {
+		t.Fatalf("new error: %s", err)
+	}
+	err = c.Sync(&fakeSource{headers: local})
+	if nil != err {
+		t.Fatalf("sync error: %s", err)
+	}
+	digest := c.Digest()
+
+	// a longer fork with less work is refused
+	weaker := &fakeSource{headers: extend(common, 32, 2, 1)}
+	if err := c.Sync(weaker); fault.ErrInvalidChain != err {
+		t.Errorf("weaker fork error: %v  expected: %s", err, fault.ErrInvalidChain)
+	}
+	if 151 != c.Height() || digest != c.Digest() {
+		t.Errorf("after weaker fork height: %d  digest: %v", c.Height(), c.Digest())
+	}
+
+	// a fork with more work replaces the stored headers
+	stronger := &fakeSource{headers: extend(common, 40, 3, 1.1)}
+	err = c.Sync(stronger)
+	if nil != err {
+		t.Fatalf("stronger fork error: %s", err)
+	}
+	if 161 != c.Height() {
+		t.Errorf("height: %d  expected: 161", c.Height())
+	}
+	for _, n := range []uint64{121, 122, 161} {
+		header, err := c.Header(n)
+		if nil != err {
+			t.Fatalf("header: %d  error: %s", n, err)
+		}
+		if stronger.headers[n-blockrecord.MinimumBlockNumber] != header.Pack() {
+			t.Errorf("header: %d  not from the stronger fork", n)
+		}
+	}
+}
+
+func TestVerifyProof(t *testing.T) {
+
+	txIds := []merkle.Digest{
+		merkle.NewDigest([]byte("foundation")),
+		merkle.NewDigest([]byte("issue one")),
+		merkle.NewDigest([]byte("issue two")),
+	}
+	tree := merkle.FullMerkleTree(txIds)
+
+	headers := extend(nil, 4, 0, 1)
+	header, _ := headers[2].Unpack()
+	header.TransactionCount = uint16(len(txIds))
+	header.MerkleRoot = tree[len(tree)-1]
+	headers[2] = header.Pack()
+	headers = extend(headers[:3], 2, 0, 1)
+
+	c, err := New(chain.Testing, NewMemoryStore())
+	if nil != err {
+		t.Fatalf("new error: %s", err)
+	}
+	source := &fakeSource{
+		headers: headers,
+		proofs:  make(map[merkle.Digest]*Proof),
+	}
+	err = c.Sync(source)
+	if nil != err {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	branch, err := merkle.Branch(txIds, 2)
+	if nil != err {
+		t.Fatalf("branch error: %s", err)
+	}
+	proof := &Proof{
+		TxId:        txIds[2],
+		BlockNumber: header.Number,
+		Index:       2,
+		Branch:      branch,
+	}
+	// three headers at the minimum difficulty
+	work, err := c.VerifyProof(proof)
+	if nil != err {
+		t.Fatalf("verify error: %s", err)
+	}
+	if 0 != work.Cmp(ConfirmationWork(3)) {
+		t.Errorf("work: %s  expected: %s", work, ConfirmationWork(3))
+	}
+
+	source.proofs[proof.TxId] = proof
+	work, err = c.VerifyTransaction(source, proof.TxId)
+	if nil != err || 0 != work.Cmp(ConfirmationWork(3)) {
+		t.Errorf("verify transaction: %s  error: %v", work, err)
+	}
+	if _, err := c.VerifyTransaction(source, txIds[1]); fault.ErrTransactionIsNotConfirmed != err {
+		t.Errorf("unconfirmed error: %v  expected: %s", err, fault.ErrTransactionIsNotConfirmed)
+	}
+
+	// the duplicated last digest does not make a fourth transaction
+	proof = &Proof{
+		TxId:        txIds[2],
+		BlockNumber: header.Number,
+		Index:       3,
+		Branch:      branch,
+	}
+	if _, err := c.VerifyProof(proof); fault.ErrTransactionIndexOutOfRange != err {
+		t.Errorf("index error: %v  expected: %s", err, fault.ErrTransactionIndexOutOfRange)
+	}
+
+	proof.Index = 1
+	if _, err := c.VerifyProof(proof); fault.ErrMerkleRootDoesNotMatch != err {
+		t.Errorf("wrong index error: %v  expected: %s", err, fault.ErrMerkleRootDoesNotMatch)
+	}
+
+	proof.Index = 2
+	proof.BlockNumber = c.Height() + 1
+	if _, err := c.VerifyProof(proof); fault.ErrBlockNotFound != err {
+		t.Errorf("unsynced block error: %v  expected: %s", err, fault.ErrBlockNotFound)
+	}
+}
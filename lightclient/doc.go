@@ -0,0 +1,19 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// header-only verification of the block chain for clients that cannot
+// run a full node
+//
+// headers are fetched from a node, checked for their digest links,
+// proof of work and version rules, then kept in a store of fixed size
+// records; merkle inclusion proofs from a node can then be checked
+// against the verified headers
+//
+// usage:
+//   store, err := lightclient.OpenFileStore("headers.dat")
+//   c, err := lightclient.New(chain.Bitmark, store)
+//   err = c.Sync(lightclient.NewRPCSource(conn))
+//   work, err := c.VerifyProof(proof)
+//   confirmed := work.Cmp(lightclient.ConfirmationWork(6)) >= 0
+package lightclient
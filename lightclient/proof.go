@@ -0,0 +1,84 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package lightclient
+
+import (
+	"math/big"
+
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/difficulty"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+)
+
+// Proof is the merkle branch of a transaction in a block, the fields
+// of a Transaction.Proof reply that do not need to be trusted
+//
+// the header in the reply is ignored as the verified header of the
+// chain is used instead
+type Proof struct {
+	TxId        merkle.Digest   `json:"txId"`
+	BlockNumber uint64          `json:"blockNumber"`
+	Index       int             `json:"index"`
+	Branch      []merkle.Digest `json:"branch"`
+}
+
+// check a proof against the verified header of its block
+//
+// returns the work of the confirmations, the block itself and those
+// above it; a count of blocks is not returned as a run of easy headers
+// could give any count, so callers should compare the work with that
+// of the confirmations they require, see ConfirmationWork
+func (c *Chain) VerifyProof(proof *Proof) (*big.Int, error) {
+
+	height := c.Height()
+	if proof.BlockNumber > height {
+		return nil, fault.ErrBlockNotFound
+	}
+
+	header, err := c.Header(proof.BlockNumber)
+	if nil != err {
+		return nil, err
+	}
+
+	if proof.Index < 0 || proof.Index >= int(header.TransactionCount) {
+		return nil, fault.ErrTransactionIndexOutOfRange
+	}
+	if !merkle.VerifyBranch(proof.TxId, proof.Index, proof.Branch, header.MerkleRoot) {
+		return nil, fault.ErrMerkleRootDoesNotMatch
+	}
+
+	work := headerWork(header)
+	for n := proof.BlockNumber + 1; n <= height; n += 1 {
+		h, err := c.Header(n)
+		if nil != err {
+			return nil, err
+		}
+		work.Add(work, headerWork(h))
+	}
+	return work, nil
+}
+
+// fetch the proof of a transaction from a source and check it
+//
+// returns the work of the confirmations as VerifyProof
+func (c *Chain) VerifyTransaction(source Source, txId merkle.Digest) (*big.Int, error) {
+
+	proof, err := source.Proof(txId)
+	if nil != err {
+		return nil, err
+	}
+	if txId != proof.TxId {
+		return nil, fault.ErrMerkleRootDoesNotMatch
+	}
+	return c.VerifyProof(proof)
+}
+
+// the work of a number of confirmations at the minimum difficulty, to
+// compare with the work returned by VerifyProof
+func ConfirmationWork(confirmations uint64) *big.Int {
+	work := headerWork(&blockrecord.Header{Difficulty: difficulty.New()})
+	return work.Mul(work, new(big.Int).SetUint64(confirmations))
+}
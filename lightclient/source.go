@@ -0,0 +1,127 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package lightclient
+
+import (
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+)
+
+// Source is a node that supplies headers and proofs
+//
+// nothing it returns is trusted until verified by the chain
+type Source interface {
+	Height() (uint64, error)
+	Headers(start uint64, count int) ([]blockrecord.PackedHeader, error)
+	Proof(txId merkle.Digest) (*Proof, error)
+}
+
+// RPCSource is a node reached by its JSON-RPC interface
+type RPCSource struct {
+	client *rpc.Client
+}
+
+// the parts of the node's RPC arguments and replies that are used,
+// declared here so the node packages are not imported
+type infoReply struct {
+	Blocks uint64 `json:"blocks"`
+}
+
+type rangeArguments struct {
+	Start       uint64 `json:"start,string"`
+	Count       int    `json:"count"`
+	HeadersOnly bool   `json:"headersOnly"`
+}
+
+type rangeReply struct {
+	Blocks []struct {
+		Header *blockrecord.Header `json:"header"`
+	} `json:"blocks"`
+}
+
+type proofArguments struct {
+	TxId merkle.Digest `json:"txId"`
+}
+
+type authArguments struct {
+	Key string `json:"key"`
+}
+
+// use an established connection, e.g. from tls.Dial, to a node's
+// RPC port
+func NewRPCSource(conn io.ReadWriteCloser) *RPCSource {
+	return &RPCSource{
+		client: jsonrpc.NewClient(conn),
+	}
+}
+
+// close the connection
+func (s *RPCSource) Close() error {
+	return s.client.Close()
+}
+
+// send an API key for the rest of the connection, required by nodes
+// configured with require_key
+func (s *RPCSource) Authenticate(key string) error {
+	var reply struct{}
+	return s.client.Call("Auth.Key", authArguments{Key: key}, &reply)
+}
+
+// the block height of the node
+func (s *RPCSource) Height() (uint64, error) {
+	var reply infoReply
+	err := s.client.Call("Node.Info", struct{}{}, &reply)
+	if nil != err {
+		return 0, err
+	}
+	return reply.Blocks, nil
+}
+
+// consecutive headers from a block number
+//
+// the headers are repacked from their JSON form, so any alteration
+// changes their digests and fails verification
+func (s *RPCSource) Headers(start uint64, count int) ([]blockrecord.PackedHeader, error) {
+	arguments := rangeArguments{
+		Start:       start,
+		Count:       count,
+		HeadersOnly: true,
+	}
+	var reply rangeReply
+	err := s.client.Call("Blocks.Range", arguments, &reply)
+	if nil != err {
+		return nil, err
+	}
+
+	headers := make([]blockrecord.PackedHeader, 0, len(reply.Blocks))
+	for _, b := range reply.Blocks {
+		if nil == b.Header {
+			return nil, fault.ErrBlockNotFound
+		}
+		if nil == b.Header.Difficulty {
+			return nil, fault.ErrInvalidBlockHeaderDifficulty
+		}
+		headers = append(headers, b.Header.Pack())
+	}
+	return headers, nil
+}
+
+// the merkle proof of a confirmed transaction
+func (s *RPCSource) Proof(txId merkle.Digest) (*Proof, error) {
+	arguments := proofArguments{
+		TxId: txId,
+	}
+	var reply Proof
+	err := s.client.Call("Transaction.Proof", arguments, &reply)
+	if nil != err {
+		return nil, err
+	}
+	return &reply, nil
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package lightclient
+
+import (
+	"os"
+	"sync"
+
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+// Store holds verified packed headers in block number order
+//
+// the genesis header is not stored, so index 0 is the header of
+// blockrecord.MinimumBlockNumber
+type Store interface {
+	Len() uint64
+	Get(index uint64) (blockrecord.PackedHeader, error)
+	Append(header blockrecord.PackedHeader) error
+	Truncate(length uint64) error
+	Close() error
+}
+
+// size of one stored header
+const headerSize = uint64(len(blockrecord.PackedHeader{}))
+
+// MemoryStore keeps the headers in memory
+type MemoryStore struct {
+	sync.RWMutex
+	headers []blockrecord.PackedHeader
+}
+
+// create an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		headers: []blockrecord.PackedHeader{},
+	}
+}
+
+// number of stored headers
+func (s *MemoryStore) Len() uint64 {
+	s.RLock()
+	defer s.RUnlock()
+	return uint64(len(s.headers))
+}
+
+// header at an index
+func (s *MemoryStore) Get(index uint64) (blockrecord.PackedHeader, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if index >= uint64(len(s.headers)) {
+		return blockrecord.PackedHeader{}, fault.ErrBlockNotFound
+	}
+	return s.headers[index], nil
+}
+
+// add a header after the last one
+func (s *MemoryStore) Append(header blockrecord.PackedHeader) error {
+	s.Lock()
+	defer s.Unlock()
+	s.headers = append(s.headers, header)
+	return nil
+}
+
+// discard headers from an index onwards
+func (s *MemoryStore) Truncate(length uint64) error {
+	s.Lock()
+	defer s.Unlock()
+	if length < uint64(len(s.headers)) {
+		s.headers = s.headers[:length]
+	}
+	return nil
+}
+
+// nothing to release
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// FileStore keeps the headers in a file of fixed size records
+type FileStore struct {
+	sync.RWMutex
+	file   *os.File
+	length uint64
+}
+
+// open or create a header file
+//
+// a partly written final record, e.g. from an interrupted append, is
+// discarded
+func OpenFileStore(fileName string) (*FileStore, error) {
+
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0600)
+	if nil != err {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if nil != err {
+		file.Close()
+		return nil, err
+	}
+
+	s := &FileStore{
+		file:   file,
+		length: uint64(info.Size()) / headerSize,
+	}
+	if uint64(info.Size()) != s.length*headerSize {
+		err := file.Truncate(int64(s.length * headerSize))
+		if nil != err {
+			file.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// number of stored headers
+func (s *FileStore) Len() uint64 {
+	s.RLock()
+	defer s.RUnlock()
+	return s.length
+}
+
+// header at an index
+func (s *FileStore) Get(index uint64) (blockrecord.PackedHeader, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	header := blockrecord.PackedHeader{}
+	if index >= s.length {
+		return header, fault.ErrBlockNotFound
+	}
+	_, err := s.file.ReadAt(header[:], int64(index*headerSize))
+	return header, err
+}
+
+// add a header after the last one
+func (s *FileStore) Append(header blockrecord.PackedHeader) error {
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.file.WriteAt(header[:], int64(s.length*headerSize))
+	if nil != err {
+		return err
+	}
+	s.length += 1
+	return nil
+}
+
+// discard headers from an index onwards
+func (s *FileStore) Truncate(length uint64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if length >= s.length {
+		return nil
+	}
+	err := s.file.Truncate(int64(length * headerSize))
+	if nil != err {
+		return err
+	}
+	s.length = length
+	return nil
+}
+
+// flush and close the file
+func (s *FileStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.file.Sync()
+	if nil != err {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package merkle
+
+import (
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+// compute the merkle branch proving that one transaction id is part
+// of the tree built by FullMerkleTree
+//
+// the branch is the sibling at each level from the transaction up to,
+// but not including, the root; the last digest of an odd level is its
+// own sibling
+func Branch(txIds []Digest, index int) ([]Digest, error) {
+
+	if index < 0 || index >= len(txIds) {
+		return nil, fault.ErrTransactionIndexOutOfRange
+	}
+
+	branch := []Digest{}
+	level := txIds
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index // compensate for odd number
+		}
+		branch = append(branch, level[sibling])
+
+		next := make([]Digest, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			j := i + 1
+			if j == len(level) {
+				j = i // compensate for odd number
+			}
+			next[i/2] = NewDigest(append(level[i][:], level[j][:]...))
+		}
+		level = next
+		index /= 2
+	}
+	return branch, nil
+}
+
+// check that a merkle branch links a transaction id at a given index
+// to the merkle root
+//
+// the caller must also check the index is less than the transaction
+// count of the block, as the duplicated last digest of an odd level
+// would otherwise also verify at the index following it
+func VerifyBranch(txId Digest, index int, branch []Digest, root Digest) bool {
+
+	if index < 0 {
+		return false
+	}
+
+	digest := txId
+	for _, sibling := range branch {
+		if 0 == index&1 {
+			digest = NewDigest(append(digest[:], sibling[:]...))
+		} else {
+			digest = NewDigest(append(sibling[:], digest[:]...))
+		}
+		index >>= 1
+	}
+	return 0 == index && digest == root
+}
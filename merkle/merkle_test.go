@@ -2773,3 +2773,46 @@ var block328656MerkleTree = []string{
 	"9f4dbd3dff28f964af4f776c34ebd24df4bc2c2160570b73496afa57633bb815",
 	"2b44fc83c84e21817b0da633af7733a4872c2415a21bf9f6b4883a5751c3e020",
 }
+
+func TestBranch(t *testing.T) {
+
+	for count := 1; count <= 11; count += 1 {
+		ids := make([]merkle.Digest, count)
+		for i := range ids {
+			ids[i] = merkle.NewDigest([]byte{byte(count), byte(i)})
+		}
+		tree := merkle.FullMerkleTree(ids)
+		root := tree[len(tree)-1]
+
+		for i, txId := range ids {
+			branch, err := merkle.Branch(ids, i)
+			if nil != err {
+				t.Fatalf("%d/%d: branch error: %s", i, count, err)
+			}
+			if !merkle.VerifyBranch(txId, i, branch, root) {
+				t.Errorf("%d/%d: branch did not verify", i, count)
+			}
+
+			// wrong index, wrong transaction or altered branch
+			if i^1 < count && merkle.VerifyBranch(txId, i^1, branch, root) {
+				t.Errorf("%d/%d: verified at wrong index", i, count)
+			}
+			if merkle.VerifyBranch(merkle.NewDigest([]byte{0xff}), i, branch, root) {
+				t.Errorf("%d/%d: verified wrong transaction", i, count)
+			}
+			if merkle.VerifyBranch(txId, i+1<<uint(len(branch)), branch, root) {
+				t.Errorf("%d/%d: verified index beyond tree", i, count)
+			}
+			if 0 != len(branch) {
+				branch[0][0] ^= 1
+				if merkle.VerifyBranch(txId, i, branch, root) {
+					t.Errorf("%d/%d: verified altered branch", i, count)
+				}
+			}
+		}
+	}
+
+	if _, err := merkle.Branch([]merkle.Digest{{}}, 1); nil == err {
+		t.Error("branch for missing index")
+	}
+}
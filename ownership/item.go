@@ -47,3 +47,16 @@ func (item OwnedItem) MarshalText() ([]byte, error) {
 	}
 	return s, nil
 }
+
+// convert text to item
+func (item *OwnedItem) UnmarshalText(s []byte) error {
+	switch string(s) {
+	case "Asset":
+		*item = OwnedAsset
+	case "Block":
+		*item = OwnedBlock
+	default:
+		return fault.ErrInvalidItem
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ package ownership
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 
 	"github.com/bitmark-inc/bitmarkd/account"
 	"github.com/bitmark-inc/bitmarkd/merkle"
@@ -25,6 +26,29 @@ type Ownership struct {
 	BlockNumber *uint64                            `json:"blockNumber,omitempty"`
 }
 
+// criteria to restrict a list of bitmarks, nil or zero fields match
+// every record
+//
+// block ranges are inclusive and a zero upper bound is unlimited, the
+// transfer block of a record that was never transferred is zero
+type Filter struct {
+	AssetId           *transactionrecord.AssetIdentifier
+	Item              *OwnedItem
+	IssueBlockFrom    uint64
+	IssueBlockTo      uint64
+	TransferBlockFrom uint64
+	TransferBlockTo   uint64
+}
+
+// ownership records examined per filtered list, an account holding
+// many bitmarks of other assets then gets a short page and a next
+// index instead of a scan of all its records
+const maximumFilterScan = 1000
+
+// returned from the Map callback once the page is full, the scan limit
+// is reached or the keys move past this owner
+var errListDone = errors.New("list done")
+
 // fetch a list of bitmarks for an owner
 func ListBitmarksFor(owner *account.Account, start uint64, count int) ([]Ownership, error) {
 
@@ -55,28 +79,113 @@ loop:
 			break loop
 		}
 
-		record := Ownership{
-			N: binary.BigEndian.Uint64(item.Key[split:]),
+		records = append(records, decode(item.Key[split:], item.Value))
+	}
+
+	return records, nil
+}
+
+// fetch a list of the bitmarks for an owner that match a filter
+//
+// at most count matching records are returned, but fewer records,
+// possibly none, are returned if the scan limit is reached first; next
+// is the record number following the last one examined and is zero
+// only if nothing remained to be examined
+func ListFilteredBitmarksFor(owner *account.Account, start uint64, count int, filter *Filter) ([]Ownership, uint64, error) {
+
+	startBytes := make([]byte, uint64ByteSize)
+	binary.BigEndian.PutUint64(startBytes, start)
+
+	ownerBytes := owner.Bytes()
+	prefix := append(ownerBytes, startBytes...)
+
+	cursor := storage.Pool.Ownership.NewFetchCursor().Seek(prefix)
+
+	records := make([]Ownership, 0, count)
+	next := uint64(0)
+	scanned := 0
+
+	err := cursor.Map(func(key []byte, value []byte) error {
+		n := len(key)
+		split := n - uint64ByteSize
+		if split <= 0 {
+			logger.Panicf("split cannot be <= 0: %d", split)
+		}
+		if !bytes.Equal(ownerBytes, key[:split]) {
+			return errListDone
+		}
+
+		scanned += 1
+		next = binary.BigEndian.Uint64(key[split:]) + 1
+
+		if filter.matches(value) {
+			records = append(records, decode(key[split:], value))
 		}
+		if len(records) >= count || scanned >= maximumFilterScan {
+			return errListDone
+		}
+		return nil
+	})
+	if errListDone != err && nil != err {
+		return nil, 0, err
+	}
+
+	return records, next, nil
+}
+
+// check the fields of a packed ownership value against a filter
+func (filter *Filter) matches(value []byte) bool {
+	if nil == filter {
+		return true
+	}
 
-		merkle.DigestFromBytes(&record.TxId, item.Value[TxIdStart:TxIdFinish])
-		merkle.DigestFromBytes(&record.IssueTxId, item.Value[IssueTxIdStart:IssueTxIdFinish])
-
-		switch itemType := OwnedItem(item.Value[FlagByteStart]); itemType {
-		case OwnedAsset:
-			a := &transactionrecord.AssetIdentifier{}
-			transactionrecord.AssetIdentifierFromBytes(a, item.Value[AssetIdentifierStart:AssetIdentifierFinish])
-			record.AssetId = a
-			record.Item = itemType
-		case OwnedBlock:
-			b := binary.BigEndian.Uint64(item.Value[OwnedBlockNumberStart:OwnedBlockNumberFinish])
-			record.BlockNumber = &b
-			record.Item = itemType
-		default:
-			logger.Panicf("unsupported item type: %d", item)
+	itemType := OwnedItem(value[FlagByteStart])
+	if nil != filter.Item && *filter.Item != itemType {
+		return false
+	}
+
+	if nil != filter.AssetId {
+		if OwnedAsset != itemType || !bytes.Equal(filter.AssetId[:], value[AssetIdentifierStart:AssetIdentifierFinish]) {
+			return false
 		}
-		records = append(records, record)
 	}
 
-	return records, nil
+	issueBlock := binary.BigEndian.Uint64(value[IssueBlockNumberStart:IssueBlockNumberFinish])
+	if !inRange(issueBlock, filter.IssueBlockFrom, filter.IssueBlockTo) {
+		return false
+	}
+
+	transferBlock := binary.BigEndian.Uint64(value[TransferBlockNumberStart:TransferBlockNumberFinish])
+	return inRange(transferBlock, filter.TransferBlockFrom, filter.TransferBlockTo)
+}
+
+// inclusive range check, zero upper bound is unlimited
+func inRange(n uint64, from uint64, to uint64) bool {
+	return n >= from && (0 == to || n <= to)
+}
+
+// convert a stored record to its external form
+func decode(countBytes []byte, value []byte) Ownership {
+
+	record := Ownership{
+		N: binary.BigEndian.Uint64(countBytes),
+	}
+
+	merkle.DigestFromBytes(&record.TxId, value[TxIdStart:TxIdFinish])
+	merkle.DigestFromBytes(&record.IssueTxId, value[IssueTxIdStart:IssueTxIdFinish])
+
+	switch itemType := OwnedItem(value[FlagByteStart]); itemType {
+	case OwnedAsset:
+		a := &transactionrecord.AssetIdentifier{}
+		transactionrecord.AssetIdentifierFromBytes(a, value[AssetIdentifierStart:AssetIdentifierFinish])
+		record.AssetId = a
+		record.Item = itemType
+	case OwnedBlock:
+		b := binary.BigEndian.Uint64(value[OwnedBlockNumberStart:OwnedBlockNumberFinish])
+		record.BlockNumber = &b
+		record.Item = itemType
+	default:
+		logger.Panicf("unsupported item type: %d", itemType)
+	}
+	return record
 }
@@ -0,0 +1,170 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package ownership
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/storage"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"github.com/bitmark-inc/logger"
+)
+
+// test database
+const (
+	testingDirName   = "testing"
+	databaseFileName = testingDirName + "/test"
+)
+
+func storageSetup(t *testing.T) {
+	os.RemoveAll(testingDirName)
+	os.Mkdir(testingDirName, 0700)
+
+	logging := logger.Configuration{
+		Directory: testingDirName,
+		File:      "testing.log",
+		Size:      1048576,
+		Count:     10,
+		Console:   false,
+		Levels: map[string]string{
+			logger.DefaultTag: "critical",
+		},
+	}
+	if err := logger.Initialise(logging); nil != err {
+		t.Fatalf("logger initialise error: %s", err)
+	}
+
+	mustReindex, err := storage.Initialise(databaseFileName, storage.ReadWrite)
+	if nil != err {
+		t.Fatalf("storage initialise error: %s", err)
+	}
+	if mustReindex {
+		if err := storage.ReindexDone(); nil != err {
+			t.Fatalf("storage reindex done error: %s", err)
+		}
+	}
+}
+
+func storageTeardown(t *testing.T) {
+	storage.Finalise()
+	logger.Finalise()
+	os.RemoveAll(testingDirName)
+}
+
+func TestListFiltered(t *testing.T) {
+	storageSetup(t)
+	defer storageTeardown(t)
+
+	owner := makeAccount(1)
+	other := makeAccount(2)
+
+	assetA := transactionrecord.AssetIdentifier{0xaa}
+	assetB := transactionrecord.AssetIdentifier{0xbb}
+
+	// record numbers for owner:
+	//   0: asset A issued in 10
+	//   1: asset B issued in 11
+	//   2: block 12
+	//   3: asset A issued in 13, via other, transferred in 20
+	CreateAsset(txId(1), 10, assetA, owner)
+	CreateAsset(txId(2), 11, assetB, owner)
+	CreateBlock(txId(3), 12, owner)
+	CreateAsset(txId(4), 13, assetA, other)
+	Transfer(txId(4), txId(5), 20, other, owner)
+
+	asset := OwnedAsset
+	block := OwnedBlock
+
+	tests := []struct {
+		filter   Filter
+		expected []uint64
+	}{
+		{Filter{}, []uint64{0, 1, 2, 3}},
+		{Filter{AssetId: &assetA}, []uint64{0, 3}},
+		{Filter{Item: &block}, []uint64{2}},
+		{Filter{Item: &asset, AssetId: &assetB}, []uint64{1}},
+		{Filter{Item: &block, AssetId: &assetB}, []uint64{}},
+		{Filter{IssueBlockFrom: 11, IssueBlockTo: 12}, []uint64{1, 2}},
+		{Filter{IssueBlockFrom: 12}, []uint64{2, 3}},
+		{Filter{TransferBlockFrom: 1}, []uint64{3}},
+		{Filter{TransferBlockTo: 19}, []uint64{0, 1, 2}},
+	}
+
+	for i, item := range tests {
+		records, next, err := ListFilteredBitmarksFor(owner, 0, 10, &item.filter)
+		if nil != err {
+			t.Fatalf("%d: list error: %s", i, err)
+		}
+		if 4 != next {
+			t.Errorf("%d: next: %d  expected: 4", i, next)
+		}
+		if !sameNumbers(records, item.expected) {
+			t.Errorf("%d: %+v  result: %+v  expected: %v", i, item.filter, records, item.expected)
+		}
+	}
+
+	// paging one match at a time
+	all := []Ownership{}
+	start := uint64(0)
+	for i := 0; i < 5; i += 1 {
+		records, next, err := ListFilteredBitmarksFor(owner, start, 1, &Filter{AssetId: &assetA})
+		if nil != err {
+			t.Fatalf("page: %d  list error: %s", i, err)
+		}
+		all = append(all, records...)
+		if 0 == next {
+			break
+		}
+		start = next
+	}
+	if !sameNumbers(all, []uint64{0, 3}) {
+		t.Errorf("paged result: %+v", all)
+	}
+	if 2 != len(all) || nil == all[1].AssetId || assetA != *all[1].AssetId || txId(5) != all[1].TxId || txId(4) != all[1].IssueTxId {
+		t.Errorf("transferred record: %+v", all[1])
+	}
+
+	// the unfiltered list is unchanged
+	records, err := ListBitmarksFor(owner, 1, 2)
+	if nil != err {
+		t.Fatalf("list error: %s", err)
+	}
+	if !sameNumbers(records, []uint64{1, 2}) {
+		t.Errorf("unfiltered result: %+v", records)
+	}
+	if nil == records[1].BlockNumber || 12 != *records[1].BlockNumber {
+		t.Errorf("block record: %+v", records[1])
+	}
+}
+
+func makeAccount(n byte) *account.Account {
+	publicKey := make([]byte, 32)
+	publicKey[0] = n
+	return &account.Account{
+		AccountInterface: &account.ED25519Account{
+			Test:      true,
+			PublicKey: publicKey,
+		},
+	}
+}
+
+func txId(n byte) merkle.Digest {
+	return merkle.Digest{n}
+}
+
+func sameNumbers(records []Ownership, expected []uint64) bool {
+	if len(records) != len(expected) {
+		return false
+	}
+	for i, r := range records {
+		if expected[i] != r.N {
+			return false
+		}
+	}
+	return true
+}
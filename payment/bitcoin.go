@@ -180,6 +180,8 @@ process_blocks:
 		log.Infof("height: %d hash: %q number of txs: %d", block.Height, block.Hash, len(block.Tx))
 		log.Tracef("block: %#v", block)
 
+		setBlockHeight(currency.Bitcoin, block.Height)
+
 		if block.Confirmations <= requiredConfirmations {
 			if !state.forward {
 				hash = block.PreviousBlockHash
@@ -253,14 +255,18 @@ scan_vouts:
 	}
 
 	if !found {
+		countTransaction(currency.Bitcoin, false)
 		return
 	}
 
 	if len(amounts) == 0 {
 		log.Warnf("found pay id but no payments in tx id: %s", tx.TxId)
+		countTransaction(currency.Bitcoin, false)
 		return
 	}
 
+	countTransaction(currency.Bitcoin, true)
+
 	reservoir.SetTransferVerified(
 		payId,
 		&reservoir.PaymentDetail{
@@ -187,6 +187,8 @@ process_blocks:
 		log.Infof("height: %d hash: %q number of txs: %d", block.Height, block.Hash, len(block.Tx))
 		log.Tracef("block: %#v", block)
 
+		setBlockHeight(currency.Litecoin, block.Height)
+
 		if block.Confirmations <= requiredConfirmations {
 			if !state.forward {
 				hash = block.PreviousBlockHash
@@ -260,14 +262,18 @@ scan_vouts:
 	}
 
 	if !found {
+		countTransaction(currency.Litecoin, false)
 		return
 	}
 
 	if len(amounts) == 0 {
 		log.Warnf("found pay id but no payments in tx id: %s", tx.TxId)
+		countTransaction(currency.Litecoin, false)
 		return
 	}
 
+	countTransaction(currency.Litecoin, true)
+
 	reservoir.SetTransferVerified(
 		payID,
 		&reservoir.PaymentDetail{
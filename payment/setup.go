@@ -43,6 +43,8 @@ type globalDataType struct {
 	handlers   map[string]currencyHandler
 	background *background.T
 
+	useDiscovery bool
+
 	// set once during initialise
 	initialised bool
 }
@@ -61,6 +63,8 @@ func Initialise(configuration *Configuration) error {
 	globalData.log = logger.New("payment")
 	globalData.log.Info("starting…")
 
+	globalData.useDiscovery = configuration.UseDiscovery
+
 	// initialise the handler for each currency
 	globalData.handlers = make(map[string]currencyHandler)
 	for c := currency.First; c <= currency.Last; c++ {
@@ -0,0 +1,77 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package payment
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/counter"
+	"github.com/bitmark-inc/bitmarkd/currency"
+)
+
+// progress of a single currency handler
+//
+// all fields are updated atomically by the background processes
+type currencyStatus struct {
+	blockHeight  uint64          // last block scanned, zero if discovery
+	transactions counter.Counter // transactions inspected
+	payments     counter.Counter // payments passed to reservoir
+	lastUpdate   int64           // unix time of the last transaction or block
+}
+
+// one entry per currency indexed by currency.Index()
+var paymentStatus [currency.Count]currencyStatus
+
+// the status of one currency as returned by ReadStatus
+type CurrencyStatus struct {
+	Currency     currency.Currency `json:"currency"`
+	Discovery    bool              `json:"discovery"`
+	BlockHeight  uint64            `json:"blockHeight"`
+	Transactions uint64            `json:"transactions"`
+	Payments     uint64            `json:"payments"`
+	LastUpdate   time.Time         `json:"lastUpdate"`
+}
+
+// for API to get the current state of all payment handlers
+func ReadStatus() []CurrencyStatus {
+	globalData.RLock()
+	discovery := globalData.useDiscovery
+	globalData.RUnlock()
+
+	status := make([]CurrencyStatus, 0, currency.Count)
+	for c := currency.First; c <= currency.Last; c += 1 {
+		s := &paymentStatus[c.Index()]
+		item := CurrencyStatus{
+			Currency:     c,
+			Discovery:    discovery,
+			BlockHeight:  atomic.LoadUint64(&s.blockHeight),
+			Transactions: s.transactions.Uint64(),
+			Payments:     s.payments.Uint64(),
+		}
+		if t := atomic.LoadInt64(&s.lastUpdate); 0 != t {
+			item.LastUpdate = time.Unix(t, 0).UTC()
+		}
+		status = append(status, item)
+	}
+	return status
+}
+
+// record that a block was scanned
+func setBlockHeight(c currency.Currency, height uint64) {
+	s := &paymentStatus[c.Index()]
+	atomic.StoreUint64(&s.blockHeight, height)
+	atomic.StoreInt64(&s.lastUpdate, time.Now().Unix())
+}
+
+// record that a transaction was inspected and whether it was a payment
+func countTransaction(c currency.Currency, isPayment bool) {
+	s := &paymentStatus[c.Index()]
+	s.transactions.Increment()
+	if isPayment {
+		s.payments.Increment()
+	}
+	atomic.StoreInt64(&s.lastUpdate, time.Now().Unix())
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/messagebus"
+	"github.com/bitmark-inc/bitmarkd/peer/protocol"
+	"github.com/bitmark-inc/bitmarkd/reservoir"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+)
+
+// limits for partly reconstructed compact blocks
+const (
+	maximumPendingCompact = 8           // blocks waiting for transactions
+	compactExpiry         = time.Minute // time to wait for transactions
+)
+
+// a compact block waiting for its missing transactions
+type pendingCompact struct {
+	compact *protocol.Compact
+	txs     map[int][]byte // index → packed transaction
+	missing []int          // indexes not found in the reservoir
+	expires time.Time
+}
+
+// compact blocks received by the listener, only accessed from the
+// listener goroutine
+type compactBlocks struct {
+	pending map[compactKey]*pendingCompact
+}
+
+// pending compact blocks are keyed by the packed header
+type compactKey string
+
+// reconstruct a compact block from the reservoir
+//
+// a complete block is sent to the block store, otherwise it is held
+// and the indexes of the missing transactions are returned
+func (cb *compactBlocks) receive(data []byte) ([]int, error) {
+
+	compact, err := protocol.UnpackCompact(data)
+	if nil != err {
+		return nil, err
+	}
+
+	p := &pendingCompact{
+		compact: compact,
+		txs:     reconstruct(compact),
+		missing: []int{},
+		expires: time.Now().Add(compactExpiry),
+	}
+	for _, i := range compact.Order {
+		if _, ok := p.txs[i]; !ok {
+			p.missing = append(p.missing, i)
+		}
+	}
+
+	if 0 == len(p.missing) {
+		return nil, completeCompact(p)
+	}
+
+	cb.expire()
+	if nil == cb.pending {
+		cb.pending = make(map[compactKey]*pendingCompact)
+	}
+	if len(cb.pending) >= maximumPendingCompact {
+		return nil, fault.ErrTooManyItemsToProcess
+	}
+	cb.pending[compactKey(compact.Header[:])] = p
+
+	return p.missing, nil
+}
+
+// add the missing transactions to a held compact block and send it
+// to the block store
+//
+// the transactions are in the order of the returned indexes
+func (cb *compactBlocks) fill(header []byte, packedTxs []byte) error {
+
+	key := compactKey(header)
+	p, ok := cb.pending[key]
+	if !ok {
+		return fault.ErrBlockNotFound
+	}
+	delete(cb.pending, key)
+
+	txs, err := protocol.UnpackTransactions(packedTxs)
+	if nil != err {
+		return err
+	}
+	if len(txs) != len(p.missing) {
+		return fault.ErrTransactionCountOutOfRange
+	}
+	for i, index := range p.missing {
+		p.txs[index] = txs[i]
+	}
+
+	return completeCompact(p)
+}
+
+// drop held blocks that have waited too long
+func (cb *compactBlocks) expire() {
+	now := time.Now()
+	for key, p := range cb.pending {
+		if now.After(p.expires) {
+			delete(cb.pending, key)
+		}
+	}
+}
+
+// find the transactions of a compact block in the reservoir
+//
+// a short id matching more than one transaction is treated as missing
+func reconstruct(compact *protocol.Compact) map[int][]byte {
+
+	wanted := make(map[protocol.ShortId][]int, len(compact.ShortIds))
+	for _, i := range compact.Order {
+		s := compact.ShortIds[i]
+		wanted[s] = append(wanted[s], i)
+	}
+
+	found := make(map[protocol.ShortId]transactionrecord.Packed)
+	ambiguous := make(map[protocol.ShortId]struct{})
+	reservoir.ForEachTransaction(func(txId merkle.Digest, packed transactionrecord.Packed) {
+		s := protocol.NewShortId(txId)
+		if _, ok := wanted[s]; !ok {
+			return
+		}
+		if _, ok := found[s]; ok {
+			ambiguous[s] = struct{}{}
+			return
+		}
+		found[s] = packed
+	})
+
+	txs := make(map[int][]byte, len(found))
+	for s, packed := range found {
+		if _, ok := ambiguous[s]; ok {
+			continue
+		}
+		for _, i := range wanted[s] {
+			txs[i] = append([]byte{}, packed...)
+		}
+	}
+	return txs
+}
+
+// assemble the block and pass it to the block store, which validates
+// it in the same way as a full block
+func completeCompact(p *pendingCompact) error {
+	packed, err := p.compact.Block(p.txs)
+	if nil != err {
+		return err
+	}
+	messagebus.Bus.Blockstore.Send("remote", packed)
+	return nil
+}
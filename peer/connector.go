@@ -8,9 +8,12 @@ import (
 	"bytes"
 	"container/list"
 	"encoding/hex"
+	"math/big"
 	"time"
 
+	"github.com/bitmark-inc/bitmarkd/announce"
 	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/checkpoint"
 	"github.com/bitmark-inc/bitmarkd/fault"
 	"github.com/bitmark-inc/bitmarkd/genesis"
 	"github.com/bitmark-inc/bitmarkd/messagebus"
@@ -23,14 +26,19 @@ import (
 // various timeouts
 const (
 	cycleInterval         = 15 * time.Second // pause to limit bandwidth
+	fetchInterval         = 1 * time.Second  // shorter pause between sets of blocks
 	connectorTimeout      = 60 * time.Second // time out for connections
 	samplelingLimit       = 10               // number of cycles to be 1 block out of sync before resync
 	fetchBlocksPerCycle   = 200              // number of blocks to fetch in one set
 	forkProtection        = 60               // fail to fork if height difference is greater than this
-	minimumClients        = 3                // do not proceed unless this many clients are connected
+	defaultMinimumClients = 3                // do not proceed unless this many clients are connected
 	maximumDynamicClients = 10               // total number of dynamic clients
 )
 
+// blocks less than this distance below the local tip can still be
+// replaced by a reorganisation
+const ForkProtection = forkProtection
+
 // a state type for the thread
 type connectorState int
 
@@ -47,7 +55,8 @@ const (
 type connector struct {
 	log *logger.L
 
-	preferIPv6 bool
+	preferIPv6     bool
+	minimumClients int // connections required before synchronising
 
 	staticClients []*upstream.Upstream
 
@@ -58,17 +67,23 @@ type connector struct {
 	theClient        *upstream.Upstream // client used for fetching blocks
 	startBlockNumber uint64             // block number where local chain forks
 	height           uint64             // block number on best node
+	chainWork        *big.Int           // chain work of best node, nil if not reported
 	samples          int                // counter to detect missed block broadcast
 }
 
 // initialise the connector
-func (conn *connector) initialise(privateKey []byte, publicKey []byte, connect []Connection, dynamicEnabled bool, preferIPv6 bool) error {
+func (conn *connector) initialise(privateKey []byte, publicKey []byte, connect []Connection, dynamicEnabled bool, preferIPv6 bool, minimumConnections int, limits *LimitConfiguration) error {
 
 	log := logger.New("connector")
 	conn.log = log
 
 	conn.preferIPv6 = preferIPv6
 
+	conn.minimumClients = defaultMinimumClients
+	if minimumConnections > 0 {
+		conn.minimumClients = minimumConnections
+	}
+
 	log.Info("initialising…")
 
 	// allocate all sockets
@@ -104,7 +119,7 @@ func (conn *connector) initialise(privateKey []byte, publicKey []byte, connect [
 			goto fail
 		}
 
-		client, err := upstream.New(privateKey, publicKey, connectorTimeout)
+		client, err := upstream.New(privateKey, publicKey, connectorTimeout, limits.BroadcastRate, limits.BroadcastBurst)
 		if nil != err {
 			log.Errorf("client[%d]=%q  error: %s", i, address, err)
 			errX = err
@@ -125,7 +140,7 @@ func (conn *connector) initialise(privateKey []byte, publicKey []byte, connect [
 
 	// just create sockets for dynamic clients
 	for i := 0; i < maximumDynamicClients; i += 1 {
-		client, err := upstream.New(privateKey, publicKey, connectorTimeout)
+		client, err := upstream.New(privateKey, publicKey, connectorTimeout, limits.BroadcastRate, limits.BroadcastBurst)
 		if nil != err {
 			log.Errorf("client[%d]  error: %s", i, err)
 			errX = err
@@ -187,6 +202,8 @@ func (conn *connector) Run(args interface{}, shutdown <-chan struct{}) {
 
 	queue := messagebus.Bus.Connector.Chan()
 
+	interval := cycleInterval
+
 loop:
 	for {
 		// wait for shutdown
@@ -196,13 +213,25 @@ loop:
 		case <-shutdown:
 			break loop
 		case item := <-queue:
-			c, _ := util.PackedConnection(item.Parameters[1]).Unpack()
-			conn.log.Debugf("received control: %s  public key: %x  connect: %x %q", item.Command, item.Parameters[0], item.Parameters[1], c)
-			//connectToUpstream(conn.log, conn.clients, conn.dynamicStart, item.Command, item.Parameters[0], item.Parameters[1])
-			conn.connectUpstream(item.Command, item.Parameters[0], item.Parameters[1])
+			switch item.Command {
+			case "disconnect":
+				conn.log.Debugf("received control: %s  public key: %x", item.Command, item.Parameters[0])
+				conn.disconnectUpstream(item.Parameters[0])
+			default:
+				c, _ := util.PackedConnection(item.Parameters[1]).Unpack()
+				conn.log.Debugf("received control: %s  public key: %x  connect: %x %q", item.Command, item.Parameters[0], item.Parameters[1], c)
+				//connectToUpstream(conn.log, conn.clients, conn.dynamicStart, item.Command, item.Parameters[0], item.Parameters[1])
+				conn.connectUpstream(item.Command, item.Parameters[0], item.Parameters[1])
+			}
 
-		case <-time.After(cycleInterval):
+		case <-time.After(interval):
 			conn.process()
+
+			// keep fetching while still behind
+			interval = cycleInterval
+			if cStateFetchBlocks == conn.state {
+				interval = fetchInterval
+			}
 		}
 	}
 	log.Info("shutting down…")
@@ -214,7 +243,16 @@ loop:
 func (conn *connector) process() {
 	// run the machine until it pauses
 	for conn.runStateMachine() {
+		conn.publishState()
 	}
+	conn.publishState()
+}
+
+// make the current state visible to ConnectorState
+func (conn *connector) publishState() {
+	globalData.Lock()
+	globalData.connectorState = conn.state
+	globalData.Unlock()
 }
 
 // run state machine
@@ -242,10 +280,10 @@ func (conn *connector) runStateMachine() bool {
 
 		log.Infof("connections: %d", clientCount)
 		globalData.clientCount = clientCount
-		if clientCount >= minimumClients {
+		if clientCount >= conn.minimumClients {
 			conn.state += 1
 		} else {
-			log.Warnf("connections: %d below minimum client count: %d", clientCount, minimumClients)
+			log.Warnf("connections: %d below minimum client count: %d", clientCount, conn.minimumClients)
 			messagebus.Bus.Announce.Send("reconnect")
 		}
 		continueLooping = false
@@ -261,7 +299,7 @@ func (conn *connector) runStateMachine() bool {
 
 	case cStateForkDetect:
 		height := block.GetHeight()
-		if conn.height <= height {
+		if !conn.remoteIsBetter(height) {
 			conn.state = cStateRebuild
 		} else {
 			// first block number
@@ -269,9 +307,23 @@ func (conn *connector) runStateMachine() bool {
 			conn.state += 1 // assume success
 			log.Infof("block number: %d", height)
 
+			// a chain with more work may be shorter
+			top := height
+			if conn.height < top {
+				top = conn.height
+			}
+
+			// blocks up to the highest checkpoint are never replaced
+			lastCheckpoint := checkpoint.Last(height)
+
 			// check digests of descending blocks (to detect a fork)
 		check_digests:
-			for h := height; h > genesis.BlockNumber; h -= 1 {
+			for h := top; h > genesis.BlockNumber; h -= 1 {
+				if h < lastCheckpoint {
+					log.Errorf("fork below checkpoint: %d", lastCheckpoint)
+					conn.state = cStateHighestBlock
+					break check_digests
+				}
 				digest, err := block.DigestForBlock(h)
 				if nil != err {
 					log.Infof("block number: %d  local digest error: %s", h, err)
@@ -292,11 +344,13 @@ func (conn *connector) runStateMachine() bool {
 					conn.startBlockNumber = h + 1
 					log.Infof("fork from block number: %d", conn.startBlockNumber)
 
-					// remove old blocks
-					err := block.DeleteDownToBlock(conn.startBlockNumber)
-					if nil != err {
-						log.Errorf("delete down to block number: %d  error: %s", conn.startBlockNumber, err)
-						conn.state = cStateHighestBlock // retry
+					// replace any local blocks after the common ancestor
+					if h < height {
+						err := conn.reorganise(h)
+						if nil != err {
+							log.Errorf("reorganise from block number: %d  error: %s", h, err)
+							conn.state = cStateHighestBlock // retry
+						}
 					}
 					break check_digests
 				}
@@ -307,33 +361,19 @@ func (conn *connector) runStateMachine() bool {
 
 		continueLooping = false
 
-	fetch_blocks:
-		for n := 0; n < fetchBlocksPerCycle; n += 1 {
-
-			if conn.startBlockNumber > conn.height {
-				conn.state = cStateHighestBlock // just in case block height has changed
-				continueLooping = true
-				break fetch_blocks
+		if conn.startBlockNumber > conn.height {
+			conn.state = cStateHighestBlock // just in case block height has changed
+			continueLooping = true
+		} else {
+			err := conn.fetchHeadersFirst()
+			if fault.ErrInvalidPeerResponse == err || fault.ErrUnsupportedPeerCommand == err {
+				log.Warn("header range not supported, fetch sequentially")
+				err = conn.fetchSequential()
 			}
-
-			log.Infof("fetch block number: %d", conn.startBlockNumber)
-			packedBlock, err := conn.theClient.GetBlockData(conn.startBlockNumber)
 			if nil != err {
 				log.Errorf("fetch block number: %d  error: %s", conn.startBlockNumber, err)
 				conn.state = cStateHighestBlock // retry
-				break fetch_blocks
 			}
-			log.Debugf("store block number: %d", conn.startBlockNumber)
-			err = block.StoreIncoming(packedBlock)
-			if nil != err {
-				log.Errorf("store block number: %d  error: %s", conn.startBlockNumber, err)
-				conn.state = cStateHighestBlock // retry
-				break fetch_blocks
-			}
-
-			// next block
-			conn.startBlockNumber += 1
-
 		}
 
 	case cStateRebuild:
@@ -364,8 +404,8 @@ func (conn *connector) runStateMachine() bool {
 
 		continueLooping = false
 
-		if conn.height > height {
-			if conn.height-height >= 2 {
+		if conn.remoteIsBetter(height) {
+			if conn.height <= height || conn.height-height >= 2 {
 				conn.state = cStateForkDetect
 				continueLooping = true
 			} else {
@@ -380,22 +420,47 @@ func (conn *connector) runStateMachine() bool {
 	return continueLooping
 }
 
+// select the client with the most chain work, or the highest block
+// if no client reports chain work
 func getHeight(conn *connector) (height uint64, theClient *upstream.Upstream) {
 	theClient = nil
 	height = 0
+	var work *big.Int
 
 	conn.allClients(func(client *upstream.Upstream, e *list.Element) {
 		h := client.GetHeight()
-		if h > height {
-			height = h
-			theClient = client
+		w := client.GetChainWork()
+		switch {
+		case nil == w && nil == work && h > height:
+		case nil != w && nil == work:
+		case nil != w && w.Cmp(work) > 0:
+		case nil != w && 0 == w.Cmp(work) && h > height:
+		default:
+			return
 		}
+		height = h
+		work = w
+		theClient = client
 	})
 
+	conn.chainWork = work
 	globalData.blockHeight = height
 	return height, theClient
 }
 
+// true if the selected client's chain should be examined for blocks
+// to replace or extend the local chain
+//
+// the work reported by a client is only a hint as it cannot be
+// checked here, reorganise replaces local blocks only after the work
+// of the client's headers has been proved
+func (conn *connector) remoteIsBetter(height uint64) bool {
+	if nil == conn.chainWork {
+		return conn.height > height
+	}
+	return conn.chainWork.Cmp(block.ChainWork(height)) > 0
+}
+
 func (state connectorState) String() string {
 	switch state {
 	case cStateConnecting:
@@ -437,6 +502,11 @@ func (conn *connector) connectUpstream(priority string, serverPublicKey []byte,
 
 	log.Infof("connect: %s to: %x @ %s", priority, serverPublicKey, address)
 
+	if announce.IsPeerBanned(serverPublicKey, address.IP()) {
+		log.Infof("banned: %x", serverPublicKey)
+		return fault.ErrPeerIsBanned
+	}
+
 	// see if already connected to this node
 	alreadyConnected := false
 	conn.searchClients(func(client *upstream.Upstream, e *list.Element) bool {
@@ -469,3 +539,35 @@ func (conn *connector) connectUpstream(priority string, serverPublicKey []byte,
 
 	return err
 }
+
+// drop any connection to a node
+//
+// a dynamic client is moved to the front of the list so it is the
+// next to be reused
+func (conn *connector) disconnectUpstream(serverPublicKey []byte) {
+
+	log := conn.log
+
+	found := false
+	conn.searchClients(func(client *upstream.Upstream, e *list.Element) bool {
+		if !client.IsConnectedTo(serverPublicKey) {
+			return false
+		}
+		log.Infof("disconnect: %x", serverPublicKey)
+		err := client.Disconnect()
+		if nil != err {
+			log.Errorf("disconnect: %x  error: %s", serverPublicKey, err)
+		}
+		if nil != e {
+			conn.dynamicClients.MoveToFront(e)
+		}
+		found = true
+		return true
+	})
+
+	if found {
+		messagebus.Bus.Announce.Send("reconnect")
+	} else {
+		log.Debugf("disconnect: %x  not connected", serverPublicKey)
+	}
+}
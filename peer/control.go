@@ -0,0 +1,60 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/announce"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/messagebus"
+)
+
+// drop any outgoing connection to a node
+//
+// a dynamic connection may be re-established to the same node by the
+// next announce cycle unless it is also banned
+func Disconnect(publicKey []byte) error {
+	if err := checkPublicKey(publicKey); nil != err {
+		return err
+	}
+
+	messagebus.Bus.Connector.Send("disconnect", publicKey)
+	return nil
+}
+
+// prevent outgoing connections to a node for a period and drop any
+// current connection
+func Ban(publicKey []byte, duration time.Duration) error {
+	if err := checkPublicKey(publicKey); nil != err {
+		return err
+	}
+
+	// record first so that the reconnect following the disconnect
+	// cannot select this node
+	err := announce.Ban(publicKey, duration, "admin")
+	if nil != err {
+		return err
+	}
+
+	globalData.log.Infof("ban: %x  for: %s", publicKey, duration)
+
+	messagebus.Bus.Connector.Send("disconnect", publicKey)
+	return nil
+}
+
+// ensure running and the key is the right size
+func checkPublicKey(publicKey []byte) error {
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	if !globalData.initialised {
+		return fault.ErrNotInitialised
+	}
+	if len(publicKey) != len(globalData.publicKey) {
+		return fault.ErrInvalidPublicKey
+	}
+	return nil
+}
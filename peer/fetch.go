@@ -0,0 +1,473 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"container/list"
+
+	"github.com/bitmark-inc/bitmarkd/announce"
+	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/checkpoint"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/peer/upstream"
+	"github.com/bitmark-inc/logger"
+)
+
+// limits for parallel block fetching
+const (
+	fetchWindow         = 4    // requests queued to a single upstream
+	fetchBufferPerPeer  = 16   // out of order blocks held per upstream
+	maximumFetchRetries = 3    // attempts for a block before giving up
+	maximumPeerFailures = 3    // errors before an upstream is dropped from the set
+	fetchRangeBatch     = 50   // blocks in one range request
+	farBehind           = 1000 // blocks behind before range requests are used
+)
+
+// a source of packed blocks, satisfied by upstream.Upstream
+type blockSource interface {
+	GetBlockData(blockNumber uint64) ([]byte, error)
+}
+
+// a source that can also return several blocks in one request
+type blockRangeSource interface {
+	GetBlockRange(blockNumber uint64, count uint16) ([][]byte, error)
+}
+
+// blocks following the first failure of a batch that were not
+// attempted, these are requeued without counting against the source
+var errBatchAborted = fault.ProcessError("batch aborted")
+
+// headers-first synchronisation of the next set of blocks
+//
+// the headers are fetched from the highest client and checked, then
+// the bodies are fetched in parallel from all clients that have the
+// blocks
+func (conn *connector) fetchHeadersFirst() error {
+	log := conn.log
+
+	remaining := conn.height - conn.startBlockNumber + 1
+	count := uint16(fetchBlocksPerCycle)
+	if remaining < uint64(count) {
+		count = uint16(remaining)
+	}
+
+	headers, err := conn.theClient.GetBlockHeaders(conn.startBlockNumber, count)
+	if fault.ErrInvalidPeerResponse == err || fault.ErrUnsupportedPeerCommand == err {
+		return err // not supported, caller falls back
+	} else if nil != err {
+		conn.penaliseError(conn.theClient, err)
+		return err
+	}
+
+	previous, nextNumber := block.Get()
+	if nextNumber != conn.startBlockNumber {
+		return fault.ErrInvalidBlockHeaderNumber
+	}
+	err = validateHeaders(previous, conn.startBlockNumber, headers)
+	if nil != err {
+		conn.penaliseError(conn.theClient, err)
+		return err
+	}
+
+	last := conn.startBlockNumber + uint64(len(headers)) - 1
+	sources := conn.blockSources(last)
+
+	// fewer round trips when far behind
+	batch := 1
+	if conn.height-conn.startBlockNumber >= farBehind {
+		batch = fetchRangeBatch
+	}
+
+	log.Infof("fetch blocks: %d to: %d  from: %d clients  batch: %d", conn.startBlockNumber, last, len(sources), batch)
+
+	n, err := fetchBlocks(log, sources, conn.startBlockNumber, headers, batch, block.StoreIncoming, conn.sourcePenalty(sources))
+	conn.startBlockNumber += uint64(n)
+	return err
+}
+
+// misbehaviour scored for an error from a peer
+//
+// errors reported by the peer itself and a lost connection are not
+// scored, any other non-fault error is from the transport
+func misbehaviourOf(err error) (announce.Misbehaviour, bool) {
+	switch err {
+	case nil, fault.ErrNotConnected:
+		return 0, false
+
+	case fault.ErrBlockDoesNotMatchHeader,
+		fault.ErrCheckpointMismatch,
+		fault.ErrPreviousBlockDigestDoesNotMatch,
+		fault.ErrInvalidBlockHeaderDifficulty:
+		return announce.BadDigest, true
+
+	case fault.ErrInvalidPeerResponse,
+		fault.ErrInvalidBlockHeaderNumber,
+		fault.ErrBlockVersionMustNotDecrease:
+		return announce.ProtocolError, true
+	}
+
+	switch err.(type) {
+	case fault.GenericError, fault.ExistsError, fault.InvalidError, fault.LengthError,
+		fault.NotFoundError, fault.ProcessError, fault.RecordError:
+		return 0, false
+	}
+	return announce.Timeout, true
+}
+
+// score an error against a client
+func (conn *connector) penaliseError(client *upstream.Upstream, err error) {
+	if kind, ok := misbehaviourOf(err); ok {
+		conn.penalise(client, kind)
+	}
+}
+
+// score misbehaviour against a client and drop it if this caused a ban
+func (conn *connector) penalise(client *upstream.Upstream, kind announce.Misbehaviour) {
+	publicKey := client.ServerPublicKey()
+	if nil == publicKey {
+		return
+	}
+	if announce.Misbehaved(publicKey, client.RemoteIP(), kind) {
+		conn.disconnectUpstream(publicKey)
+	}
+}
+
+// penalty function for fetchBlocks on sources from blockSources
+func (conn *connector) sourcePenalty(sources []blockSource) func(int, announce.Misbehaviour) {
+	return func(source int, kind announce.Misbehaviour) {
+		if client, ok := sources[source].(*upstream.Upstream); ok {
+			conn.penalise(client, kind)
+		}
+	}
+}
+
+// the highest client and all others that have the last block
+func (conn *connector) blockSources(last uint64) []blockSource {
+	sources := []blockSource{conn.theClient}
+	conn.allClients(func(client *upstream.Upstream, e *list.Element) {
+		if client != conn.theClient && client.IsOK() && client.GetHeight() >= last {
+			sources = append(sources, client)
+		}
+	})
+	return sources
+}
+
+// the original method: fetch blocks one at a time from the highest
+// client, for peers that do not support the header range request
+func (conn *connector) fetchSequential() error {
+	log := conn.log
+
+	for n := 0; n < fetchBlocksPerCycle && conn.startBlockNumber <= conn.height; n += 1 {
+
+		log.Infof("fetch block number: %d", conn.startBlockNumber)
+		packedBlock, err := conn.theClient.GetBlockData(conn.startBlockNumber)
+		if nil != err {
+			conn.penaliseError(conn.theClient, err)
+			return err
+		}
+		log.Debugf("store block number: %d", conn.startBlockNumber)
+		err = block.StoreIncoming(packedBlock)
+		if nil != err {
+			conn.penalise(conn.theClient, announce.InvalidBlock)
+			return err
+		}
+
+		// next block
+		conn.startBlockNumber += 1
+	}
+	return nil
+}
+
+// check that headers are in sequence starting from the current block
+//
+// only the final header has its digest computed and checked against
+// its difficulty as this is as slow as the mining hash, the previous
+// digest links of the others are confirmed as each block is stored;
+// the link in each header is the digest its predecessor must have, so
+// checkpoints can be checked without computing digests
+func validateHeaders(previous blockdigest.Digest, start uint64, headers []blockrecord.PackedHeader) error {
+
+	if 0 == len(headers) {
+		return fault.ErrBlockNotFound
+	}
+
+	version := uint16(0)
+	for i, packed := range headers {
+		header, err := packed.Unpack()
+		if nil != err {
+			return err
+		}
+		if start+uint64(i) != header.Number {
+			return fault.ErrInvalidBlockHeaderNumber
+		}
+		if 0 == i && previous != header.PreviousBlock {
+			return fault.ErrPreviousBlockDigestDoesNotMatch
+		}
+		if header.Version < version {
+			return fault.ErrBlockVersionMustNotDecrease
+		}
+		version = header.Version
+
+		if 0 != i {
+			err := checkpoint.Verify(header.Number-1, header.PreviousBlock)
+			if nil != err {
+				return err
+			}
+		}
+
+		if len(headers)-1 == i {
+			digest := packed.Digest()
+			if digest.Cmp(header.Difficulty.BigInt()) > 0 {
+				return fault.ErrInvalidBlockHeaderDifficulty
+			}
+			err := checkpoint.Verify(header.Number, digest)
+			if nil != err {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// a run of consecutive blocks requested from a source
+type fetchRequest struct {
+	start uint64
+	count int
+}
+
+// one block or error from a source
+type fetchResult struct {
+	source int
+	number uint64
+	packed []byte
+	err    error
+	last   bool // final result of its request
+}
+
+// state of a source during fetchBlocks
+type fetchPeer struct {
+	requests chan fetchRequest
+	busy     int
+	failures int
+	dropped  bool
+}
+
+// fetch the blocks for the headers in parallel and store them in
+// order
+//
+// each source has a window of queued requests of up to batch blocks,
+// blocks arriving out of order are buffered and a failed block is
+// retried on any source; errors and blocks that fail to store are
+// scored against the source that supplied them through penalise
+//
+// returns the number of blocks stored
+func fetchBlocks(log *logger.L, sources []blockSource, start uint64, headers []blockrecord.PackedHeader, batch int, store func([]byte) error, penalise func(int, announce.Misbehaviour)) (int, error) {
+
+	if 0 == len(sources) {
+		return 0, fault.ErrNoConnectionsAvailable
+	}
+	if batch < 1 {
+		batch = 1
+	}
+
+	results := make(chan fetchResult, len(sources)*fetchWindow*batch)
+	peers := make([]*fetchPeer, len(sources))
+	for i, source := range sources {
+		p := &fetchPeer{
+			requests: make(chan fetchRequest, fetchWindow),
+		}
+		peers[i] = p
+		go fetchWorker(i, source, p.requests, results)
+	}
+
+	// block numbers still to be requested in ascending order
+	queue := make([]uint64, len(headers))
+	for i := range queue {
+		queue[i] = start + uint64(i)
+	}
+
+	buffer := make(map[uint64]fetchResult)
+	attempts := make(map[uint64]int)
+	bufferLimit := uint64(len(sources) * fetchBufferPerPeer)
+	if batchLimit := uint64(len(sources) * fetchWindow * batch); batchLimit > bufferLimit {
+		bufferLimit = batchLimit
+	}
+	next := start
+	last := start + uint64(len(headers)) - 1
+	stored := 0
+	outstanding := 0
+
+	var err error
+
+fetch_loop:
+	for next <= last {
+
+		// hand out requests that fit in the windows
+		for _, p := range peers {
+			for !p.dropped && p.busy < fetchWindow && len(queue) > 0 && queue[0] < next+bufferLimit {
+				n := 1
+				for n < batch && n < len(queue) && queue[n] == queue[0]+uint64(n) && queue[n] < next+bufferLimit {
+					n += 1
+				}
+				p.requests <- fetchRequest{start: queue[0], count: n}
+				queue = queue[n:]
+				p.busy += 1
+				outstanding += n
+			}
+		}
+
+		if 0 == outstanding {
+			err = fault.ErrNoConnectionsAvailable
+			break fetch_loop
+		}
+
+		r := <-results
+		p := peers[r.source]
+		if r.last {
+			p.busy -= 1
+		}
+		outstanding -= 1
+
+		if errBatchAborted == r.err {
+			queue = requeue(queue, r.number)
+			continue fetch_loop
+		}
+
+		if nil == r.err {
+			h := headers[r.number-start]
+			if len(r.packed) < len(h) || !bytes.Equal(h[:], r.packed[:len(h)]) {
+				r.err = fault.ErrBlockDoesNotMatchHeader
+			}
+		}
+
+		if nil != r.err {
+			log.Warnf("fetch block number: %d  from client: %d  error: %s", r.number, r.source, r.err)
+			if kind, ok := misbehaviourOf(r.err); ok {
+				penalise(r.source, kind)
+			}
+
+			attempts[r.number] += 1
+			if attempts[r.number] >= maximumFetchRetries {
+				err = fault.ErrBlockFetchFailed
+				break fetch_loop
+			}
+			queue = requeue(queue, r.number)
+
+			p.failures += 1
+			if p.failures >= maximumPeerFailures && !p.dropped {
+				p.dropped = true
+				close(p.requests)
+			}
+			continue fetch_loop
+		}
+
+		buffer[r.number] = r
+
+		// store all blocks now in sequence
+	store_loop:
+		for {
+			b, ok := buffer[next]
+			if !ok {
+				break store_loop
+			}
+			delete(buffer, next)
+
+			log.Debugf("store block number: %d", next)
+			err = store(b.packed)
+			if nil != err {
+				log.Errorf("store block number: %d  from client: %d  error: %s", next, b.source, err)
+				penalise(b.source, announce.InvalidBlock)
+				break fetch_loop
+			}
+			next += 1
+			stored += 1
+		}
+	}
+
+	// stop the workers, results has room for anything still in flight
+	for _, p := range peers {
+		if !p.dropped {
+			close(p.requests)
+		}
+	}
+
+	return stored, err
+}
+
+// insert a block number keeping the queue in ascending order
+func requeue(queue []uint64, number uint64) []uint64 {
+	i := 0
+	for i < len(queue) && queue[i] < number {
+		i += 1
+	}
+	queue = append(queue, 0)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = number
+	return queue
+}
+
+// fetch each requested run of blocks from one source
+//
+// there is one result for every block, after an error the rest of the
+// run is returned as aborted
+func fetchWorker(source int, s blockSource, requests <-chan fetchRequest, results chan<- fetchResult) {
+	for request := range requests {
+		number := request.start
+		end := request.start + uint64(request.count)
+
+	request_loop:
+		for number < end {
+			blocks, err := fetchRange(s, number, int(end-number))
+			if nil != err {
+				for n := number; n < end; n += 1 {
+					if n != number {
+						err = errBatchAborted
+					}
+					results <- fetchResult{
+						source: source,
+						number: n,
+						err:    err,
+						last:   n == end-1,
+					}
+				}
+				break request_loop
+			}
+			for _, packed := range blocks {
+				results <- fetchResult{
+					source: source,
+					number: number,
+					packed: packed,
+					last:   number == end-1,
+				}
+				number += 1
+			}
+		}
+	}
+}
+
+// fetch up to count blocks, using a range request if the source
+// supports one and falling back to a single block if not
+func fetchRange(s blockSource, number uint64, count int) ([][]byte, error) {
+	if rs, ok := s.(blockRangeSource); ok && count > 1 {
+		blocks, err := rs.GetBlockRange(number, uint16(count))
+		if fault.ErrUnsupportedPeerCommand != err {
+			if nil == err && 0 == len(blocks) {
+				err = fault.ErrBlockNotFound
+			}
+			if len(blocks) > count {
+				blocks = blocks[:count]
+			}
+			return blocks, err
+		}
+	}
+	packed, err := s.GetBlockData(number)
+	if nil != err {
+		return nil, err
+	}
+	return [][]byte{packed}, nil
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/announce"
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/difficulty"
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+// a set of headers with bodies, the digests are not real
+func makeBlocks(start uint64, count int) ([]blockrecord.PackedHeader, map[uint64][]byte) {
+	headers := make([]blockrecord.PackedHeader, count)
+	blocks := make(map[uint64][]byte)
+	for i := 0; i < count; i += 1 {
+		header := blockrecord.Header{
+			Version:          2,
+			TransactionCount: 2,
+			Number:           start + uint64(i),
+			Timestamp:        uint64(time.Now().Unix()),
+			Difficulty:       difficulty.New(),
+		}
+		header.PreviousBlock[0] = byte(i)
+		headers[i] = header.Pack()
+		blocks[header.Number] = append(headers[i][:], 0xaa, byte(i))
+	}
+	return headers, blocks
+}
+
+// serves blocks with optional failures and delays
+type fakeSource struct {
+	sync.Mutex
+	blocks  map[uint64][]byte
+	fail    map[uint64]int // number of times to fail a block
+	corrupt bool           // return a different header
+	delay   time.Duration
+	calls   int
+}
+
+func (s *fakeSource) GetBlockData(number uint64) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.calls += 1
+	time.Sleep(s.delay)
+	if s.fail[number] > 0 {
+		s.fail[number] -= 1
+		return nil, fault.ErrBlockNotFound
+	}
+	packed, ok := s.blocks[number]
+	if !ok {
+		return nil, fault.ErrBlockNotFound
+	}
+	if s.corrupt {
+		packed = append([]byte{}, packed...)
+		packed[0] ^= 0xff
+	}
+	return packed, nil
+}
+
+// also serves ranges of at most limit blocks
+type fakeRangeSource struct {
+	fakeSource
+	limit  int
+	ranges int
+}
+
+func (s *fakeRangeSource) GetBlockRange(number uint64, count uint16) ([][]byte, error) {
+	s.Lock()
+	s.ranges += 1
+	s.Unlock()
+
+	blocks := [][]byte{}
+	for i := 0; i < int(count) && i < s.limit; i += 1 {
+		packed, err := s.GetBlockData(number + uint64(i))
+		if nil != err {
+			if 0 == i {
+				return nil, err
+			}
+			break
+		}
+		blocks = append(blocks, packed)
+	}
+	return blocks, nil
+}
+
+// records the misbehaviour scored against each source
+type penalties map[int][]announce.Misbehaviour
+
+func (p penalties) penalise(source int, kind announce.Misbehaviour) {
+	p[source] = append(p[source], kind)
+}
+
+func TestFetchBlocksParallel(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	const start = 10
+	const count = 50
+	headers, blocks := makeBlocks(start, count)
+
+	sources := []blockSource{
+		&fakeSource{blocks: blocks, delay: 2 * time.Millisecond},
+		&fakeSource{blocks: blocks, fail: map[uint64]int{12: 1, 30: 1}},
+		&fakeSource{blocks: blocks, corrupt: true},
+	}
+
+	stored := []uint64{}
+	store := func(packed []byte) error {
+		var h blockrecord.PackedHeader
+		copy(h[:], packed)
+		header, err := h.Unpack()
+		if nil != err {
+			return err
+		}
+		stored = append(stored, header.Number)
+		return nil
+	}
+
+	scored := penalties{}
+	n, err := fetchBlocks(log, sources, start, headers, 1, store, scored.penalise)
+	if nil != err {
+		t.Fatalf("fetch error: %s", err)
+	}
+	if count != n {
+		t.Errorf("stored: %d  expected: %d", n, count)
+	}
+	for i, number := range stored {
+		if start+uint64(i) != number {
+			t.Fatalf("out of order at: %d  number: %d", i, number)
+		}
+	}
+
+	// all sources should have been used
+	for i, s := range sources {
+		if 0 == s.(*fakeSource).calls {
+			t.Errorf("source: %d not used", i)
+		}
+	}
+
+	// only the corrupt source misbehaved, not found is not scored
+	if 0 != len(scored[0]) || 0 != len(scored[1]) {
+		t.Errorf("unexpected penalties: %v", scored)
+	}
+	if 0 == len(scored[2]) {
+		t.Error("corrupt source was not penalised")
+	}
+	for _, kind := range scored[2] {
+		if announce.BadDigest != kind {
+			t.Errorf("corrupt source penalty: %s  expected: %s", kind, announce.BadDigest)
+		}
+	}
+}
+
+func TestFetchBlocksRange(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	const start = 100
+	const count = 200
+	const batch = 50
+	headers, blocks := makeBlocks(start, count)
+
+	// replies are truncated, one block fails once and one source
+	// only supports single blocks
+	ranged := &fakeRangeSource{
+		fakeSource: fakeSource{blocks: blocks, fail: map[uint64]int{120: 1}},
+		limit:      30,
+	}
+	sources := []blockSource{
+		ranged,
+		&fakeSource{blocks: blocks},
+	}
+
+	stored := 0
+	scored := penalties{}
+	n, err := fetchBlocks(log, sources, start, headers, batch, func([]byte) error { stored += 1; return nil }, scored.penalise)
+	if nil != err {
+		t.Fatalf("fetch error: %s", err)
+	}
+	if count != n || count != stored {
+		t.Errorf("stored: %d  expected: %d", n, count)
+	}
+	if 0 == ranged.ranges || ranged.ranges >= count/2 {
+		t.Errorf("range requests: %d", ranged.ranges)
+	}
+	if 0 != len(scored) {
+		t.Errorf("unexpected penalties: %v", scored)
+	}
+}
+
+func TestFetchBlocksFailure(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	const start = 2
+	headers, blocks := makeBlocks(start, 10)
+
+	// block 5 can never be fetched
+	sources := []blockSource{
+		&fakeSource{blocks: blocks, fail: map[uint64]int{5: 100}},
+		&fakeSource{blocks: blocks, fail: map[uint64]int{5: 100}},
+	}
+
+	scored := penalties{}
+	n, err := fetchBlocks(log, sources, start, headers, 1, func([]byte) error { return nil }, scored.penalise)
+	if fault.ErrBlockFetchFailed != err {
+		t.Errorf("error: %v  expected: %s", err, fault.ErrBlockFetchFailed)
+	}
+	if 3 != n {
+		t.Errorf("stored: %d  expected: 3", n)
+	}
+
+	// a store failure stops immediately
+	sources = []blockSource{&fakeSource{blocks: blocks}}
+	n, err = fetchBlocks(log, sources, start, headers, 1, func([]byte) error { return fault.ErrPreviousBlockDigestDoesNotMatch }, scored.penalise)
+	if fault.ErrPreviousBlockDigestDoesNotMatch != err || 0 != n {
+		t.Errorf("stored: %d  error: %v", n, err)
+	}
+	if 1 != len(scored[0]) || announce.InvalidBlock != scored[0][0] {
+		t.Errorf("store failure penalties: %v", scored)
+	}
+
+	// nothing to fetch from
+	_, err = fetchBlocks(log, nil, start, headers, 1, func([]byte) error { return nil }, scored.penalise)
+	if fault.ErrNoConnectionsAvailable != err {
+		t.Errorf("error: %v  expected: %s", err, fault.ErrNoConnectionsAvailable)
+	}
+}
+
+func TestValidateHeaders(t *testing.T) {
+
+	headers, _ := makeBlocks(2, 3)
+	first, _ := headers[0].Unpack()
+
+	// these fail before the final digest is computed
+	if err := validateHeaders(blockdigest.Digest{0xff}, 2, headers); fault.ErrPreviousBlockDigestDoesNotMatch != err {
+		t.Errorf("previous: error: %v", err)
+	}
+	if err := validateHeaders(first.PreviousBlock, 3, headers); fault.ErrInvalidBlockHeaderNumber != err {
+		t.Errorf("number: error: %v", err)
+	}
+
+	older, _ := headers[1].Unpack()
+	older.Version = 1
+	headers[1] = older.Pack()
+	if err := validateHeaders(first.PreviousBlock, 2, headers); fault.ErrBlockVersionMustNotDecrease != err {
+		t.Errorf("version: error: %v", err)
+	}
+
+	if err := validateHeaders(first.PreviousBlock, 2, nil); fault.ErrBlockNotFound != err {
+		t.Errorf("empty: error: %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/util"
+)
+
+// per-client limits on requests to the listener and on the broadcasts
+// pushed to each connected server
+//
+// byte rates are bytes per second, a zero rate disables that limit
+type LimitConfiguration struct {
+	RequestRate    float64 `gluamapper:"request_rate" json:"request_rate"`
+	RequestBurst   int     `gluamapper:"request_burst" json:"request_burst"`
+	ByteRate       float64 `gluamapper:"byte_rate" json:"byte_rate"`
+	ByteBurst      int     `gluamapper:"byte_burst" json:"byte_burst"`
+	BroadcastRate  float64 `gluamapper:"broadcast_rate" json:"broadcast_rate"`
+	BroadcastBurst int     `gluamapper:"broadcast_burst" json:"broadcast_burst"`
+}
+
+// Throttled is a client that has had requests refused
+type Throttled struct {
+	Address       string    `json:"address"`
+	Requests      uint64    `json:"requests"`
+	BytesSent     uint64    `json:"bytesSent"`
+	Rejected      uint64    `json:"rejected"`
+	LastThrottled time.Time `json:"lastThrottled"`
+}
+
+// the limiters of one client address
+type clientLimiter struct {
+	requests      *rate.Limiter // nil if not limited
+	bytes         *rate.Limiter // nil if not limited
+	requestCount  uint64
+	bytesSent     uint64
+	rejected      uint64
+	lastThrottled time.Time
+}
+
+// all the listener's per-client limiters
+type listenerLimits struct {
+	sync.Mutex
+
+	requestRate  rate.Limit
+	requestBurst int
+	byteRate     rate.Limit
+	byteBurst    int
+
+	clients *util.IdleMap // of *clientLimiter
+}
+
+// check that each enabled rate has a usable burst
+func (configuration *LimitConfiguration) validate() error {
+	if configuration.RequestRate < 0 || configuration.ByteRate < 0 || configuration.BroadcastRate < 0 {
+		return fault.ErrInvalidCount
+	}
+	if 0 != configuration.RequestRate && configuration.RequestBurst < 1 {
+		return fault.ErrInvalidCount
+	}
+	if 0 != configuration.ByteRate && configuration.ByteBurst < 1 {
+		return fault.ErrInvalidCount
+	}
+	if 0 != configuration.BroadcastRate && configuration.BroadcastBurst < 1 {
+		return fault.ErrInvalidCount
+	}
+	return nil
+}
+
+// create the listener limits from configuration
+//
+// nil if neither requests nor bytes are limited
+func newListenerLimits(configuration *LimitConfiguration) (*listenerLimits, error) {
+	if err := configuration.validate(); nil != err {
+		return nil, err
+	}
+	if 0 == configuration.RequestRate && 0 == configuration.ByteRate {
+		return nil, nil
+	}
+	return &listenerLimits{
+		requestRate:  rate.Limit(configuration.RequestRate),
+		requestBurst: configuration.RequestBurst,
+		byteRate:     rate.Limit(configuration.ByteRate),
+		byteBurst:    configuration.ByteBurst,
+		clients:      util.NewIdleMap(),
+	}, nil
+}
+
+// check if a client can make a request
+//
+// a client is refused if it exceeds its request rate or if the
+// bytes already sent to it have used up its byte allowance
+func (limits *listenerLimits) allow(address string) bool {
+	if nil == limits || "" == address {
+		return true
+	}
+
+	limits.Lock()
+	defer limits.Unlock()
+
+	now := time.Now()
+	c := limits.clients.Use(address, now, func() interface{} {
+		c := &clientLimiter{}
+		if 0 != limits.requestRate {
+			c.requests = rate.NewLimiter(limits.requestRate, limits.requestBurst)
+		}
+		if 0 != limits.byteRate {
+			c.bytes = rate.NewLimiter(limits.byteRate, limits.byteBurst)
+		}
+		return c
+	}).(*clientLimiter)
+	c.requestCount += 1
+
+	if nil != c.bytes && inDebt(c.bytes, now) {
+		c.throttle(now)
+		return false
+	}
+	if nil != c.requests && !c.requests.AllowN(now, 1) {
+		c.throttle(now)
+		return false
+	}
+	return true
+}
+
+// charge the bytes of a reply to a client
+//
+// a reply larger than the burst is charged as the burst
+func (limits *listenerLimits) sent(address string, count int) {
+	if nil == limits || "" == address {
+		return
+	}
+
+	limits.Lock()
+	defer limits.Unlock()
+
+	value, ok := limits.clients.Get(address)
+	if !ok {
+		return
+	}
+	c := value.(*clientLimiter)
+	c.bytesSent += uint64(count)
+	if nil != c.bytes {
+		charge(c.bytes, time.Now(), count)
+	}
+}
+
+// list the clients that have been throttled, most recent first
+func (limits *listenerLimits) throttled() []Throttled {
+	result := []Throttled{}
+	if nil == limits {
+		return result
+	}
+
+	limits.Lock()
+	defer limits.Unlock()
+
+	limits.clients.Range(func(address string, value interface{}) {
+		c := value.(*clientLimiter)
+		if 0 == c.rejected {
+			return
+		}
+		result = append(result, Throttled{
+			Address:       address,
+			Requests:      c.requestCount,
+			BytesSent:     c.bytesSent,
+			Rejected:      c.rejected,
+			LastThrottled: c.lastThrottled,
+		})
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastThrottled.After(result[j].LastThrottled)
+	})
+	return result
+}
+
+// record a refused request
+func (c *clientLimiter) throttle(now time.Time) {
+	c.rejected += 1
+	c.lastThrottled = now
+}
+
+// true if a byte limiter has no tokens left
+func inDebt(limiter *rate.Limiter, now time.Time) bool {
+	r := limiter.ReserveN(now, 1)
+	defer r.CancelAt(now)
+	return !r.OK() || r.DelayFrom(now) > 0
+}
+
+// take tokens from a byte limiter without waiting, so that a large
+// reply is paid for by refusing later requests
+func charge(limiter *rate.Limiter, now time.Time, count int) {
+	if count > limiter.Burst() {
+		count = limiter.Burst()
+	}
+	limiter.ReserveN(now, count)
+}
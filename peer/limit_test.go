@@ -0,0 +1,105 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"testing"
+)
+
+func TestListenerLimitsRequests(t *testing.T) {
+	limits, err := newListenerLimits(&LimitConfiguration{
+		RequestRate:  1,
+		RequestBurst: 3,
+	})
+	if nil != err {
+		t.Fatalf("limits error: %s", err)
+	}
+
+	for i := 0; i < 3; i += 1 {
+		if !limits.allow("192.0.2.1") {
+			t.Fatalf("request: %d  refused within burst", i)
+		}
+	}
+	if limits.allow("192.0.2.1") {
+		t.Error("request allowed above burst")
+	}
+
+	// other clients are not affected and some clients are not identified
+	if !limits.allow("192.0.2.2") {
+		t.Error("other client refused")
+	}
+	if !limits.allow("") {
+		t.Error("unidentified client refused")
+	}
+
+	throttled := limits.throttled()
+	if 1 != len(throttled) {
+		t.Fatalf("throttled: %d  expected: 1", len(throttled))
+	}
+	if "192.0.2.1" != throttled[0].Address || 4 != throttled[0].Requests || 1 != throttled[0].Rejected {
+		t.Errorf("throttled: %+v", throttled[0])
+	}
+}
+
+func TestListenerLimitsBytes(t *testing.T) {
+	limits, err := newListenerLimits(&LimitConfiguration{
+		ByteRate:  1,
+		ByteBurst: 1000,
+	})
+	if nil != err {
+		t.Fatalf("limits error: %s", err)
+	}
+
+	if !limits.allow("192.0.2.1") {
+		t.Fatal("first request refused")
+	}
+	limits.sent("192.0.2.1", 600)
+	if !limits.allow("192.0.2.1") {
+		t.Fatal("request refused with allowance remaining")
+	}
+
+	// a reply larger than the allowance is sent, then further
+	// requests are refused
+	limits.sent("192.0.2.1", 5000)
+	if limits.allow("192.0.2.1") {
+		t.Error("request allowed with no allowance remaining")
+	}
+
+	throttled := limits.throttled()
+	if 1 != len(throttled) || 5600 != throttled[0].BytesSent {
+		t.Errorf("throttled: %+v", throttled)
+	}
+}
+
+func TestListenerLimitsConfiguration(t *testing.T) {
+	limits, err := newListenerLimits(&LimitConfiguration{})
+	if nil != err {
+		t.Fatalf("limits error: %s", err)
+	}
+	if nil != limits {
+		t.Error("limits created with no rates")
+	}
+
+	// nil limits allow everything
+	if !limits.allow("192.0.2.1") {
+		t.Error("nil limits refused")
+	}
+	limits.sent("192.0.2.1", 1000)
+	if 0 != len(limits.throttled()) {
+		t.Error("nil limits throttled")
+	}
+
+	invalid := []LimitConfiguration{
+		{RequestRate: -1},
+		{RequestRate: 10},
+		{ByteRate: 10, ByteBurst: 0},
+		{BroadcastRate: 10},
+	}
+	for i, configuration := range invalid {
+		if _, err := newListenerLimits(&configuration); nil == err {
+			t.Errorf("%d: expected error for: %+v", i, configuration)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"net"
 
 	zmq "github.com/pebbe/zmq4"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/bitmark-inc/bitmarkd/block"
 	"github.com/bitmark-inc/bitmarkd/fault"
 	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/peer/protocol"
 	"github.com/bitmark-inc/bitmarkd/storage"
 	"github.com/bitmark-inc/bitmarkd/util"
 	"github.com/bitmark-inc/bitmarkd/zmqutil"
@@ -26,6 +28,12 @@ const (
 	listenerSignal            = "inproc://bitmark-listener-signal"
 	listenerIPv4MonitorSignal = "inproc://listener-ipv4-monitor-signal"
 	listenerIPv6MonitorSignal = "inproc://listener-ipv6-monitor-signal"
+
+	maximumHeaderRange = 500     // headers returned by one "HR" request
+	maximumBlockRange  = 100     // blocks returned by one "BR" request
+	maximumRangeBytes  = 4 << 20 // bytes of blocks returned by one "BR" request
+
+	peerAddressProperty = "Peer-Address" // message metadata holding the client's IP address
 )
 
 type listener struct {
@@ -39,18 +47,21 @@ type listener struct {
 	monitor4    *zmq.Socket // IPv4 socket monitor
 	monitor6    *zmq.Socket // IPv6 socket monitor
 	connections uint64      // total incoming connections
+	compact     compactBlocks
+	limits      *listenerLimits // nil if not limited
 }
 
 // type to hold server info
 type serverInfo struct {
-	Version string `json:"version"`
-	Chain   string `json:"chain"`
-	Normal  bool   `json:"normal"`
-	Height  uint64 `json:"height"`
+	Version  string                 `json:"version"`
+	Chain    string                 `json:"chain"`
+	Normal   bool                   `json:"normal"`
+	Height   uint64                 `json:"height"`
+	Protocol *protocol.Capabilities `json:"protocol"`
 }
 
 // initialise the listener
-func (lstn *listener) initialise(privateKey []byte, publicKey []byte, listen []string, version string) error {
+func (lstn *listener) initialise(privateKey []byte, publicKey []byte, listen []string, limits *LimitConfiguration, version string) error {
 
 	log := logger.New("listener")
 
@@ -67,6 +78,12 @@ func (lstn *listener) initialise(privateKey []byte, publicKey []byte, listen []s
 		return err
 	}
 
+	lstn.limits, err = newListenerLimits(limits)
+	if nil != err {
+		log.Errorf("limits error: %s", err)
+		return err
+	}
+
 	// signalling channel
 	lstn.push, lstn.pull, err = zmqutil.NewSignalPair(listenerSignal)
 	if nil != err {
@@ -160,11 +177,19 @@ func (lstn *listener) process(socket *zmq.Socket) {
 
 	log.Debug("process starting…")
 
-	data, err := socket.RecvMessageBytes(0)
+	data, metadata, err := socket.RecvMessageBytesWithMetadata(0, peerAddressProperty)
 	if nil != err {
 		log.Errorf("receive error: %s", err)
 		return
 	}
+	address := metadata[peerAddressProperty]
+
+	// the address is that of the connection, not one the client reported
+	if announce.IsAddressBanned(net.ParseIP(address)) {
+		log.Warnf("banned address: %s", address)
+		listenerSendError(socket, fault.ErrPeerIsBanned)
+		return
+	}
 
 	if len(data) < 2 {
 		listenerSendError(socket, fmt.Errorf("packet too short"))
@@ -183,16 +208,23 @@ func (lstn *listener) process(socket *zmq.Socket) {
 
 	log.Debugf("received message: %q: %x", fn, parameters)
 
+	if !lstn.limits.allow(address) {
+		log.Warnf("throttled: %s  request: %q", address, fn)
+		listenerSendError(socket, fault.ErrRateLimiting)
+		return
+	}
+
 	result := []byte{}
 
 	switch fn {
 
 	case "I": // server information
 		info := serverInfo{
-			Version: lstn.version,
-			Chain:   mode.ChainName(),
-			Normal:  mode.Is(mode.Normal),
-			Height:  block.GetHeight(),
+			Version:  lstn.version,
+			Chain:    mode.ChainName(),
+			Normal:   mode.Is(mode.Normal),
+			Height:   block.GetHeight(),
+			Protocol: protocol.Local(),
 		}
 		result, err = json.Marshal(info)
 		logger.PanicIfError("JSON encode error: %s", err)
@@ -202,6 +234,12 @@ func (lstn *listener) process(socket *zmq.Socket) {
 		result = make([]byte, 8)
 		binary.BigEndian.PutUint64(result, blockNumber)
 
+	case "W": // get block number and chain work
+		blockNumber := block.GetHeight()
+		result = make([]byte, 8)
+		binary.BigEndian.PutUint64(result, blockNumber)
+		result = append(result, block.ChainWork(blockNumber).Bytes()...)
+
 	case "B": // get packed block
 		if 1 != len(parameters) {
 			err = fault.ErrMissingParameters
@@ -229,7 +267,64 @@ func (lstn *listener) process(socket *zmq.Socket) {
 			err = fault.ErrBlockNotFound
 		}
 
-	case "R": // registration: chain, publicKey, listeners, timestamp
+	case "HR": // get packed headers: start block number, count
+		if 2 != len(parameters) {
+			err = fault.ErrMissingParameters
+		} else if 8 == len(parameters[0]) && 2 == len(parameters[1]) {
+			number := binary.BigEndian.Uint64(parameters[0])
+			count := int(binary.BigEndian.Uint16(parameters[1]))
+			if count > maximumHeaderRange {
+				count = maximumHeaderRange
+			}
+
+			// stop at the first missing block
+		header_loop:
+			for i := 0; i < count; i += 1 {
+				h, e := block.PackedHeaderForBlock(number + uint64(i))
+				if nil != e {
+					break header_loop
+				}
+				result = append(result, h[:]...)
+			}
+			if 0 == len(result) {
+				err = fault.ErrBlockNotFound
+			}
+		} else {
+			err = fault.ErrBlockNotFound
+		}
+
+	case "BR": // get packed blocks: start block number, count
+		if 2 != len(parameters) {
+			err = fault.ErrMissingParameters
+		} else if 8 == len(parameters[0]) && 2 == len(parameters[1]) {
+			result = blockRange(binary.BigEndian.Uint64(parameters[0]), int(binary.BigEndian.Uint16(parameters[1])))
+			if 0 == len(result) {
+				err = fault.ErrBlockNotFound
+			}
+		} else {
+			err = fault.ErrBlockNotFound
+		}
+
+	case "CB": // compact block: header, short ids and prefilled transactions
+		if 1 != len(parameters) {
+			err = fault.ErrMissingParameters
+		} else if !mode.Is(mode.Normal) {
+			err = fault.ErrNotAvailableDuringSynchronise
+		} else {
+			var missing []int
+			missing, err = lstn.compact.receive(parameters[0])
+			result = protocol.PackIndexes(missing)
+		}
+
+	case "BT": // missing transactions of a compact block: header, transactions
+		if 2 != len(parameters) {
+			err = fault.ErrMissingParameters
+		} else {
+			err = lstn.compact.fill(parameters[0], parameters[1])
+			result = []byte{'A'}
+		}
+
+	case "R": // registration: chain, publicKey, listeners, timestamp [, capabilities]
 		if len(parameters) < 4 {
 			listenerSendError(socket, fault.ErrMissingParameters)
 			return
@@ -240,6 +335,25 @@ func (lstn *listener) process(socket *zmq.Socket) {
 			return
 		}
 
+		// older nodes do not send capabilities and ignore the
+		// extra part in the reply
+		if len(parameters) >= 5 {
+			remote, err := protocol.Unpack(parameters[4])
+			if nil == err {
+				_, err = protocol.Negotiate(protocol.Local(), remote)
+			}
+			if nil != err {
+				log.Warnf("registration: %x  capabilities error: %s", parameters[1], err)
+				listenerSendError(socket, err)
+				return
+			}
+		}
+
+		if announce.IsPeerBanned(parameters[1], net.ParseIP(address)) {
+			listenerSendError(socket, fault.ErrPeerIsBanned)
+			return
+		}
+
 		timestamp := binary.BigEndian.Uint64(parameters[3])
 		announce.AddPeer(parameters[1], parameters[2], timestamp) // publicKey, listeners, timestamp
 		publicKey, listeners, ts, err := announce.GetRandom(parameters[1])
@@ -259,7 +373,9 @@ func (lstn *listener) process(socket *zmq.Socket) {
 		logger.PanicIfError("Listener", err)
 		_, err = socket.SendBytes(listeners, zmq.SNDMORE)
 		logger.PanicIfError("Listener", err)
-		_, err = socket.SendBytes(binTs[:], 0)
+		_, err = socket.SendBytes(binTs[:], zmq.SNDMORE)
+		logger.PanicIfError("Listener", err)
+		_, err = socket.SendBytes(protocol.Local().Pack(), 0)
 		logger.PanicIfError("Listener", err)
 
 		return
@@ -280,9 +396,39 @@ func (lstn *listener) process(socket *zmq.Socket) {
 	_, err = socket.SendBytes(result, 0)
 	logger.PanicIfError("Listener", err)
 
+	lstn.limits.sent(address, len(result))
+
 	log.Infof("sent: %q  result: %x", fn, result)
 }
 
+// contiguous packed blocks each preceded by its varint length
+//
+// stops at the first missing block or when the byte limit is reached,
+// but always includes the first block
+func blockRange(number uint64, count int) []byte {
+	if count > maximumBlockRange {
+		count = maximumBlockRange
+	}
+
+	result := []byte{}
+	key := make([]byte, 8)
+
+block_loop:
+	for i := 0; i < count; i += 1 {
+		binary.BigEndian.PutUint64(key, number+uint64(i))
+		packed := storage.Pool.Blocks.Get(key)
+		if nil == packed {
+			break block_loop
+		}
+		if 0 != i && len(result)+len(packed) > maximumRangeBytes {
+			break block_loop
+		}
+		result = append(result, util.ToVarint64(uint64(len(packed)))...)
+		result = append(result, packed...)
+	}
+	return result
+}
+
 // process the socket events
 func (lstn *listener) handleEvent(socket *zmq.Socket) {
 	ev, addr, v, err := socket.RecvEvent(0)
@@ -3,3 +3,42 @@
 // license that can be found in the LICENSE file.
 
 package peer
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bitmark-inc/logger"
+)
+
+// test log file
+const (
+	testingLogFile = "test.log"
+)
+
+// configure for testing
+func setup(t *testing.T) *logger.L {
+	os.Remove(testingLogFile)
+
+	logging := logger.Configuration{
+		Directory: ".",
+		File:      testingLogFile,
+		Size:      50000,
+		Count:     10,
+		Console:   false,
+		Levels: map[string]string{
+			logger.DefaultTag: "critical",
+		},
+	}
+
+	if err := logger.Initialise(logging); nil != err {
+		t.Fatalf("logger initialise error: %s", err)
+	}
+	return logger.New("testing")
+}
+
+// post test cleanup
+func teardown(t *testing.T) {
+	logger.Finalise()
+	os.Remove(testingLogFile)
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"github.com/bitmark-inc/bitmarkd/util"
+)
+
+// bytes of a transaction id sent in a compact block
+const ShortIdSize = 8
+
+// ShortId is the prefix of a transaction id
+type ShortId [ShortIdSize]byte
+
+// the short id of a transaction id
+func NewShortId(txId merkle.Digest) ShortId {
+	var s ShortId
+	copy(s[:], txId[:])
+	return s
+}
+
+// Compact is a block header and the short ids of its transactions
+//
+// records that are never held in a reservoir (base, foundation and
+// asset records) are sent in full as prefilled transactions
+type Compact struct {
+	Header    blockrecord.PackedHeader
+	Count     int                 // total transactions
+	Prefilled map[int][]byte      // index → packed transaction
+	ShortIds  map[int]ShortId     // index → short id
+	Order     []int               // indexes of short ids in ascending order
+	headerOf  *blockrecord.Header // unpacked header
+}
+
+// make the compact form of a packed block
+//
+// layout:
+//   header
+//   varint transaction count
+//   varint prefilled count, then for each: varint index, varint length, packed
+//   short ids of the remaining transactions in order
+func PackCompact(packedBlock []byte) ([]byte, error) {
+
+	packedHeader, txs, err := SplitBlock(packedBlock)
+	if nil != err {
+		return nil, err
+	}
+
+	prefilled := []byte{}
+	prefilledCount := 0
+	shortIds := []byte{}
+
+	for i, packed := range txs {
+		switch transactionrecord.Packed(packed).Type() {
+		case transactionrecord.BitmarkIssueTag,
+			transactionrecord.BitmarkTransferUnratifiedTag,
+			transactionrecord.BitmarkTransferCountersignedTag,
+			transactionrecord.BlockOwnerTransferTag:
+			s := NewShortId(merkle.NewDigest(packed))
+			shortIds = append(shortIds, s[:]...)
+
+		default:
+			prefilled = append(prefilled, util.ToVarint64(uint64(i))...)
+			prefilled = append(prefilled, util.ToVarint64(uint64(len(packed)))...)
+			prefilled = append(prefilled, packed...)
+			prefilledCount += 1
+		}
+	}
+
+	result := append([]byte{}, packedHeader[:]...)
+	result = append(result, util.ToVarint64(uint64(len(txs)))...)
+	result = append(result, util.ToVarint64(uint64(prefilledCount))...)
+	result = append(result, prefilled...)
+	result = append(result, shortIds...)
+	return result, nil
+}
+
+// separate a packed block into its header and transactions
+func SplitBlock(packedBlock []byte) (blockrecord.PackedHeader, [][]byte, error) {
+
+	var packedHeader blockrecord.PackedHeader
+	if len(packedBlock) < len(packedHeader) {
+		return packedHeader, nil, fault.ErrInvalidBlockHeaderSize
+	}
+	copy(packedHeader[:], packedBlock)
+	header, err := packedHeader.Unpack()
+	if nil != err {
+		return packedHeader, nil, err
+	}
+
+	txs := make([][]byte, header.TransactionCount)
+	data := transactionrecord.Packed(packedBlock[len(packedHeader):])
+	for i := range txs {
+		_, n, err := data.Unpack(mode.IsTesting())
+		if nil != err {
+			return packedHeader, nil, err
+		}
+		txs[i] = data[:n]
+		data = data[n:]
+	}
+	if 0 != len(data) {
+		return packedHeader, nil, fault.ErrTransactionCountOutOfRange
+	}
+	return packedHeader, txs, nil
+}
+
+// decode a compact block
+func UnpackCompact(data []byte) (*Compact, error) {
+
+	c := &Compact{
+		Prefilled: make(map[int][]byte),
+		ShortIds:  make(map[int]ShortId),
+	}
+
+	if len(data) < len(c.Header) {
+		return nil, fault.ErrInvalidBlockHeaderSize
+	}
+	copy(c.Header[:], data)
+	header, err := c.Header.Unpack()
+	if nil != err {
+		return nil, err
+	}
+	c.headerOf = header
+	data = data[len(c.Header):]
+
+	count, n := util.ClippedVarint64(data, 1, blockrecord.MaximumTransactions)
+	if 0 == n || count != int(header.TransactionCount) {
+		return nil, fault.ErrTransactionCountOutOfRange
+	}
+	c.Count = count
+	data = data[n:]
+
+	prefilledCount, n := util.FromVarint64(data)
+	if 0 == n || prefilledCount > uint64(count) {
+		return nil, fault.ErrTransactionCountOutOfRange
+	}
+	data = data[n:]
+
+	for i := 0; i < int(prefilledCount); i += 1 {
+		index, n := util.FromVarint64(data)
+		if 0 == n || index >= uint64(count) {
+			return nil, fault.ErrInvalidCount
+		}
+		data = data[n:]
+		length, n := util.FromVarint64(data)
+		if 0 == n || 0 == length || uint64(len(data)-n) < length {
+			return nil, fault.ErrInvalidLength
+		}
+		data = data[n:]
+		if _, ok := c.Prefilled[int(index)]; ok {
+			return nil, fault.ErrInvalidCount
+		}
+		c.Prefilled[int(index)] = data[:length]
+		data = data[length:]
+	}
+
+	if len(data) != (count-len(c.Prefilled))*ShortIdSize {
+		return nil, fault.ErrInvalidLength
+	}
+	for i := 0; i < count; i += 1 {
+		if _, ok := c.Prefilled[i]; ok {
+			continue
+		}
+		var s ShortId
+		copy(s[:], data)
+		data = data[ShortIdSize:]
+		c.ShortIds[i] = s
+		c.Order = append(c.Order, i)
+	}
+
+	return c, nil
+}
+
+// the block number of a compact block
+func (c *Compact) Number() uint64 {
+	return c.headerOf.Number
+}
+
+// join the header and transactions into a packed block
+//
+// txs must have one entry for each short id index
+func (c *Compact) Block(txs map[int][]byte) ([]byte, error) {
+	packed := append([]byte{}, c.Header[:]...)
+	for i := 0; i < c.Count; i += 1 {
+		tx, ok := c.Prefilled[i]
+		if !ok {
+			tx, ok = txs[i]
+		}
+		if !ok {
+			return nil, fault.ErrTransactionCountOutOfRange
+		}
+		packed = append(packed, tx...)
+	}
+	return packed, nil
+}
+
+// encode a list of transaction indexes
+func PackIndexes(indexes []int) []byte {
+	packed := []byte{}
+	for _, i := range indexes {
+		packed = append(packed, util.ToVarint64(uint64(i))...)
+	}
+	return packed
+}
+
+// decode a list of transaction indexes
+func UnpackIndexes(packed []byte) ([]int, error) {
+	indexes := []int{}
+	for 0 != len(packed) {
+		i, n := util.ClippedVarint64(packed, 0, blockrecord.MaximumTransactions-1)
+		if 0 == n {
+			return nil, fault.ErrInvalidCount
+		}
+		indexes = append(indexes, i)
+		packed = packed[n:]
+	}
+	return indexes, nil
+}
+
+// encode transactions each preceded by its varint length
+func PackTransactions(txs [][]byte) []byte {
+	packed := []byte{}
+	for _, tx := range txs {
+		packed = append(packed, util.ToVarint64(uint64(len(tx)))...)
+		packed = append(packed, tx...)
+	}
+	return packed
+}
+
+// decode transactions each preceded by its varint length
+func UnpackTransactions(packed []byte) ([][]byte, error) {
+	txs := [][]byte{}
+	for 0 != len(packed) {
+		length, n := util.FromVarint64(packed)
+		if 0 == n || 0 == length || uint64(len(packed)-n) < length {
+			return nil, fault.ErrInvalidLength
+		}
+		txs = append(txs, packed[n:n+int(length)])
+		packed = packed[n+int(length):]
+	}
+	return txs, nil
+}
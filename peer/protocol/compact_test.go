@@ -0,0 +1,157 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/difficulty"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+)
+
+// a block of the genesis foundation record followed by signed issues
+func makeBlock(t *testing.T, issues int) ([]byte, [][]byte) {
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if nil != err {
+		t.Fatalf("generate key error: %s", err)
+	}
+	owner := &account.Account{
+		AccountInterface: &account.ED25519Account{
+			PublicKey: publicKey,
+		},
+	}
+
+	headerSize := len(blockrecord.PackedHeader{})
+	txs := [][]byte{genesis.LiveGenesisBlock[headerSize:]}
+
+	for i := 0; i < issues; i += 1 {
+		r := &transactionrecord.BitmarkIssue{
+			Owner: owner,
+			Nonce: uint64(i) + 1,
+		}
+		r.AssetId[0] = 1
+		partial, err := r.Pack(owner)
+		if fault.ErrInvalidSignature != err {
+			t.Fatalf("pack error: %s", err)
+		}
+		r.Signature = ed25519.Sign(privateKey, partial)
+		packed, err := r.Pack(owner)
+		if nil != err {
+			t.Fatalf("pack error: %s", err)
+		}
+		txs = append(txs, packed)
+	}
+
+	header := blockrecord.Header{
+		Version:          blockrecord.Version,
+		TransactionCount: uint16(len(txs)),
+		Number:           2,
+		Timestamp:        uint64(time.Now().Unix()),
+		Difficulty:       difficulty.New(),
+	}
+	packedHeader := header.Pack()
+	packedBlock := append([]byte{}, packedHeader[:]...)
+	for _, tx := range txs {
+		packedBlock = append(packedBlock, tx...)
+	}
+	return packedBlock, txs
+}
+
+func TestCompactBlock(t *testing.T) {
+
+	packedBlock, txs := makeBlock(t, 5)
+
+	data, err := PackCompact(packedBlock)
+	if nil != err {
+		t.Fatalf("pack compact error: %s", err)
+	}
+	if len(data) >= len(packedBlock) {
+		t.Errorf("compact size: %d  block size: %d", len(data), len(packedBlock))
+	}
+
+	c, err := UnpackCompact(data)
+	if nil != err {
+		t.Fatalf("unpack compact error: %s", err)
+	}
+	if 2 != c.Number() || len(txs) != c.Count {
+		t.Errorf("number: %d  count: %d", c.Number(), c.Count)
+	}
+
+	// foundation is sent in full, issues as short ids
+	if 1 != len(c.Prefilled) || !bytes.Equal(txs[0], c.Prefilled[0]) {
+		t.Errorf("prefilled: %x", c.Prefilled)
+	}
+	if !reflect.DeepEqual([]int{1, 2, 3, 4, 5}, c.Order) {
+		t.Errorf("order: %v", c.Order)
+	}
+
+	found := make(map[int][]byte)
+	for _, i := range c.Order {
+		if NewShortId(merkle.NewDigest(txs[i])) != c.ShortIds[i] {
+			t.Errorf("short id: %d: %x", i, c.ShortIds[i])
+		}
+		found[i] = txs[i]
+	}
+
+	// a missing transaction cannot make a block
+	missing := make(map[int][]byte)
+	for i, tx := range found {
+		missing[i] = tx
+	}
+	delete(missing, 3)
+	if _, err := c.Block(missing); fault.ErrTransactionCountOutOfRange != err {
+		t.Errorf("incomplete block error: %v", err)
+	}
+
+	reconstructed, err := c.Block(found)
+	if nil != err {
+		t.Fatalf("block error: %s", err)
+	}
+	if !bytes.Equal(packedBlock, reconstructed) {
+		t.Error("reconstructed block does not match")
+	}
+
+	// truncated data
+	if _, err := UnpackCompact(data[:len(data)-1]); nil == err {
+		t.Error("truncated compact block unpacked")
+	}
+}
+
+func TestIndexesAndTransactions(t *testing.T) {
+
+	indexes := []int{0, 5, 300, blockrecord.MaximumTransactions - 1}
+	unpacked, err := UnpackIndexes(PackIndexes(indexes))
+	if nil != err {
+		t.Fatalf("unpack indexes error: %s", err)
+	}
+	if !reflect.DeepEqual(indexes, unpacked) {
+		t.Errorf("indexes: %v  expected: %v", unpacked, indexes)
+	}
+
+	txs := [][]byte{{1, 2, 3}, bytes.Repeat([]byte{4}, 200)}
+	unpackedTxs, err := UnpackTransactions(PackTransactions(txs))
+	if nil != err {
+		t.Fatalf("unpack transactions error: %s", err)
+	}
+	if !reflect.DeepEqual(txs, unpackedTxs) {
+		t.Errorf("transactions: %x  expected: %x", unpackedTxs, txs)
+	}
+
+	if _, err := UnpackTransactions([]byte{5, 1, 2}); fault.ErrInvalidLength != err {
+		t.Errorf("short transaction error: %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// peer protocol version and capabilities exchanged during registration
+package protocol
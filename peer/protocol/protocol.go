@@ -0,0 +1,132 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+// protocol versions
+const (
+	Version       = 2 // sent by this node
+	LegacyVersion = 1 // assumed for peers that do not send capabilities
+)
+
+// request commands of the listener
+//
+// subscription-type commands are not listed as they are pushed
+// without a specific reply
+var (
+	commands       = []string{"B", "BR", "BT", "CB", "H", "HR", "I", "N", "R", "W"}
+	legacyCommands = []string{"B", "H", "I", "N", "R"}
+)
+
+// Capabilities is the protocol version, the listener commands and the
+// range of block versions supported by a node
+type Capabilities struct {
+	Version             uint16   `json:"version"`
+	Commands            []string `json:"commands"`
+	MinimumBlockVersion uint16   `json:"minimumBlockVersion"`
+	MaximumBlockVersion uint16   `json:"maximumBlockVersion"`
+}
+
+// the capabilities of this node
+func Local() *Capabilities {
+	return &Capabilities{
+		Version:             Version,
+		Commands:            append([]string{}, commands...),
+		MinimumBlockVersion: blockrecord.MinimumVersion,
+		MaximumBlockVersion: blockrecord.Version,
+	}
+}
+
+// the capabilities of a node that predates negotiation
+func Legacy() *Capabilities {
+	return &Capabilities{
+		Version:             LegacyVersion,
+		Commands:            append([]string{}, legacyCommands...),
+		MinimumBlockVersion: blockrecord.MinimumVersion,
+		MaximumBlockVersion: blockrecord.Version,
+	}
+}
+
+// encode for sending as a message part
+func (c *Capabilities) Pack() []byte {
+	data, _ := json.Marshal(c) // cannot fail for this structure
+	return data
+}
+
+// decode a received message part
+func Unpack(data []byte) (*Capabilities, error) {
+	c := &Capabilities{}
+	err := json.Unmarshal(data, c)
+	if nil != err {
+		return nil, fault.ErrInvalidPeerResponse
+	}
+	if c.Version < LegacyVersion || c.MinimumBlockVersion > c.MaximumBlockVersion {
+		return nil, fault.ErrInvalidPeerResponse
+	}
+	return c, nil
+}
+
+// the capabilities common to both nodes
+//
+// the lower protocol version, the commands both support and the
+// overlap of the block versions; an error if the nodes cannot share
+// blocks
+func Negotiate(local *Capabilities, remote *Capabilities) (*Capabilities, error) {
+
+	result := &Capabilities{
+		Version:             local.Version,
+		Commands:            []string{},
+		MinimumBlockVersion: local.MinimumBlockVersion,
+		MaximumBlockVersion: local.MaximumBlockVersion,
+	}
+	if remote.Version < result.Version {
+		result.Version = remote.Version
+	}
+	if remote.MinimumBlockVersion > result.MinimumBlockVersion {
+		result.MinimumBlockVersion = remote.MinimumBlockVersion
+	}
+	if remote.MaximumBlockVersion < result.MaximumBlockVersion {
+		result.MaximumBlockVersion = remote.MaximumBlockVersion
+	}
+	if result.MinimumBlockVersion > result.MaximumBlockVersion {
+		return nil, fault.ErrIncompatiblePeerProtocol
+	}
+
+	for _, command := range local.Commands {
+		if remote.Supports(command) {
+			result.Commands = append(result.Commands, command)
+		}
+	}
+	sort.Strings(result.Commands)
+
+	// must at least be able to exchange blocks
+	if !result.Supports("B") || !result.Supports("N") {
+		return nil, fault.ErrIncompatiblePeerProtocol
+	}
+
+	return result, nil
+}
+
+// check if a command is supported
+func (c *Capabilities) Supports(command string) bool {
+	for _, s := range c.Commands {
+		if s == command {
+			return true
+		}
+	}
+	return false
+}
+
+// check if a block version is supported
+func (c *Capabilities) SupportsBlockVersion(version uint16) bool {
+	return version >= c.MinimumBlockVersion && version <= c.MaximumBlockVersion
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+func TestPackUnpack(t *testing.T) {
+	local := Local()
+	c, err := Unpack(local.Pack())
+	if nil != err {
+		t.Fatalf("unpack error: %s", err)
+	}
+	if !reflect.DeepEqual(local, c) {
+		t.Errorf("unpacked: %+v  expected: %+v", c, local)
+	}
+
+	invalid := [][]byte{
+		[]byte("not json"),
+		[]byte(`{"version":0,"commands":["B","N"]}`),
+		[]byte(`{"version":2,"commands":["B","N"],"minimumBlockVersion":3,"maximumBlockVersion":2}`),
+	}
+	for i, data := range invalid {
+		_, err := Unpack(data)
+		if fault.ErrInvalidPeerResponse != err {
+			t.Errorf("%d: error: %v  expected: %s", i, err, fault.ErrInvalidPeerResponse)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	local := Local()
+
+	// a legacy node does not have the newer commands
+	c, err := Negotiate(local, Legacy())
+	if nil != err {
+		t.Fatalf("negotiate legacy error: %s", err)
+	}
+	if LegacyVersion != c.Version {
+		t.Errorf("version: %d  expected: %d", c.Version, LegacyVersion)
+	}
+	if c.Supports("HR") || c.Supports("W") {
+		t.Errorf("legacy commands: %q", c.Commands)
+	}
+	if !c.Supports("B") || !c.Supports("N") {
+		t.Errorf("legacy commands: %q", c.Commands)
+	}
+
+	// a newer node with extra commands and block versions
+	remote := &Capabilities{
+		Version:             Version + 1,
+		Commands:            append([]string{"Z"}, local.Commands...),
+		MinimumBlockVersion: local.MaximumBlockVersion,
+		MaximumBlockVersion: local.MaximumBlockVersion + 1,
+	}
+	c, err = Negotiate(local, remote)
+	if nil != err {
+		t.Fatalf("negotiate newer error: %s", err)
+	}
+	if Version != c.Version {
+		t.Errorf("version: %d  expected: %d", c.Version, Version)
+	}
+	if !reflect.DeepEqual(local.Commands, c.Commands) {
+		t.Errorf("commands: %q  expected: %q", c.Commands, local.Commands)
+	}
+	if c.SupportsBlockVersion(local.MaximumBlockVersion+1) || !c.SupportsBlockVersion(local.MaximumBlockVersion) {
+		t.Errorf("block versions: %d to %d", c.MinimumBlockVersion, c.MaximumBlockVersion)
+	}
+
+	// no common block versions
+	remote.MinimumBlockVersion = local.MaximumBlockVersion + 1
+	_, err = Negotiate(local, remote)
+	if fault.ErrIncompatiblePeerProtocol != err {
+		t.Errorf("error: %v  expected: %s", err, fault.ErrIncompatiblePeerProtocol)
+	}
+
+	// cannot exchange blocks
+	remote = Local()
+	remote.Commands = []string{"I", "R"}
+	_, err = Negotiate(local, remote)
+	if fault.ErrIncompatiblePeerProtocol != err {
+		t.Errorf("error: %v  expected: %s", err, fault.ErrIncompatiblePeerProtocol)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"math/big"
+
+	"github.com/bitmark-inc/bitmarkd/announce"
+	"github.com/bitmark-inc/bitmarkd/asset"
+	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/checkpoint"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/reservoir"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"github.com/bitmark-inc/logger"
+)
+
+// replace the local blocks after the common ancestor with the blocks
+// of the selected client
+//
+// the ancestor must not be below the highest checkpoint reached and
+// the replacement headers must prove more work than the blocks they
+// displace; the work reported by the client is only a hint, the
+// decision uses the work of headers whose digests have been checked
+//
+// the replacement blocks are fetched before any local block is
+// deleted, the local blocks are kept so they can be restored if the
+// new chain fails to store, and their transactions are then offered
+// to the reservoir so that any not in the new chain become pending
+// again
+func (conn *connector) reorganise(ancestor uint64) error {
+	log := conn.log
+
+	height := block.GetHeight()
+
+	if last := checkpoint.Last(height); ancestor < last {
+		log.Errorf("reorganise from block: %d  below checkpoint: %d", ancestor, last)
+		return fault.ErrForkBelowCheckpoint
+	}
+
+	oldWork := new(big.Int).Sub(block.ChainWork(height), block.ChainWork(ancestor))
+
+	// the client claimed more work but has no blocks after ours
+	if conn.height <= ancestor {
+		log.Errorf("reorganise from block: %d  client height: %d  has no replacement blocks", ancestor, conn.height)
+		conn.penalise(conn.theClient, announce.ProtocolError)
+		return fault.ErrInsufficientChainWork
+	}
+
+	count := conn.height - ancestor
+	if count > fetchBlocksPerCycle {
+		count = fetchBlocksPerCycle
+	}
+	packedHeaders, err := conn.theClient.GetBlockHeaders(ancestor+1, uint16(count))
+	if nil != err {
+		conn.penaliseError(conn.theClient, err)
+		return err
+	}
+
+	ancestorDigest, err := block.DigestForBlock(ancestor)
+	if nil != err {
+		return err
+	}
+	n, newWork, err := proveHeaders(ancestorDigest, ancestor+1, packedHeaders, oldWork)
+	if nil != err {
+		conn.penaliseError(conn.theClient, err)
+		return err
+	}
+	packedHeaders = packedHeaders[:n]
+
+	log.Infof("reorganise from block: %d  old: %d blocks  work: %s  new: %d blocks  work: %s", ancestor, height-ancestor, oldWork, n, newWork)
+
+	if newWork.Cmp(oldWork) <= 0 {
+		conn.penalise(conn.theClient, announce.ProtocolError)
+		return fault.ErrInsufficientChainWork
+	}
+
+	// fetch the new blocks while the local chain is intact
+	last := ancestor + uint64(n)
+	sources := conn.blockSources(last)
+	replacement := make([][]byte, 0, n)
+	collect := func(packed []byte) error {
+		replacement = append(replacement, packed)
+		return nil
+	}
+	_, err = fetchBlocks(log, sources, ancestor+1, packedHeaders, 1, collect, conn.sourcePenalty(sources))
+	if nil != err {
+		log.Errorf("reorganise: fetch new chain error: %s", err)
+		return err
+	}
+
+	displaced, invalid, err := block.Reorganise(ancestor, replacement)
+	if invalid {
+		conn.penalise(conn.theClient, announce.InvalidBlock)
+	}
+	if nil != err {
+		return err
+	}
+
+	conn.startBlockNumber = last + 1
+	returnToReservoir(log, displaced)
+	return nil
+}
+
+// check the replacement headers and prove their work
+//
+// unlike validateHeaders every digest is computed; each must meet the
+// difficulty in its own header and be the previous block of the next
+// header, so the work counted has been done; as digests are slow,
+// checking stops once the work exceeds required
+//
+// returns the number of headers checked and their work
+func proveHeaders(previous blockdigest.Digest, start uint64, headers []blockrecord.PackedHeader, required *big.Int) (int, *big.Int, error) {
+
+	if 0 == len(headers) {
+		return 0, nil, fault.ErrBlockNotFound
+	}
+
+	work := big.NewInt(0)
+	version := uint16(0)
+	for i, packed := range headers {
+		header, err := packed.Unpack()
+		if nil != err {
+			return 0, nil, err
+		}
+		if start+uint64(i) != header.Number {
+			return 0, nil, fault.ErrInvalidBlockHeaderNumber
+		}
+		if previous != header.PreviousBlock {
+			return 0, nil, fault.ErrPreviousBlockDigestDoesNotMatch
+		}
+		if header.Version < version {
+			return 0, nil, fault.ErrBlockVersionMustNotDecrease
+		}
+		version = header.Version
+
+		digest := packed.Digest()
+		if digest.Cmp(header.Difficulty.BigInt()) > 0 {
+			return 0, nil, fault.ErrInvalidBlockHeaderDifficulty
+		}
+		err = checkpoint.Verify(header.Number, digest)
+		if nil != err {
+			return 0, nil, err
+		}
+		previous = digest
+
+		work.Add(work, block.HeadersWork([]*blockrecord.Header{header}))
+		if work.Cmp(required) > 0 {
+			return i + 1, work, nil
+		}
+	}
+	return len(headers), work, nil
+}
+
+// offer the transactions of replaced blocks to the reservoir
+//
+// transactions already confirmed by the new chain, or that conflict
+// with it, are rejected by the reservoir and are dropped
+func returnToReservoir(log *logger.L, blocks [][]byte) {
+
+	headerSize := len(blockrecord.PackedHeader{})
+	returned := 0
+
+block_loop:
+	for _, packed := range blocks {
+		data := transactionrecord.Packed(packed[headerSize:])
+
+		for 0 != len(data) {
+			transaction, n, err := data.Unpack(mode.IsTesting())
+			if nil != err {
+				log.Errorf("displaced transaction: unpack error: %s", err)
+				continue block_loop
+			}
+			data = data[n:]
+
+			switch tx := transaction.(type) {
+			case *transactionrecord.OldBaseData, *transactionrecord.BlockFoundation:
+				continue // belong to the replaced block
+
+			case *transactionrecord.AssetData:
+				_, _, err = asset.Cache(tx)
+
+			case *transactionrecord.BitmarkIssue:
+				_, _, err = reservoir.StoreIssues([]*transactionrecord.BitmarkIssue{tx})
+
+			case transactionrecord.BitmarkTransfer:
+				_, _, err = reservoir.StoreTransfer(tx)
+
+			default:
+				err = fault.ErrInvalidItem
+			}
+
+			if nil != err {
+				log.Debugf("displaced transaction: dropped: %s", err)
+			} else {
+				returned += 1
+			}
+		}
+	}
+
+	log.Infof("displaced transactions returned to reservoir: %d", returned)
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+func TestProveHeaders(t *testing.T) {
+
+	headers, _ := makeBlocks(2, 3)
+	first, _ := headers[0].Unpack()
+	required := big.NewInt(0)
+
+	// these fail before any digest is computed
+	if _, _, err := proveHeaders(blockdigest.Digest{0xff}, 2, headers, required); fault.ErrPreviousBlockDigestDoesNotMatch != err {
+		t.Errorf("previous: error: %v", err)
+	}
+	if _, _, err := proveHeaders(first.PreviousBlock, 3, headers, required); fault.ErrInvalidBlockHeaderNumber != err {
+		t.Errorf("number: error: %v", err)
+	}
+	if _, _, err := proveHeaders(first.PreviousBlock, 2, nil, required); fault.ErrBlockNotFound != err {
+		t.Errorf("empty: error: %v", err)
+	}
+
+	// claimed work that was not done is not counted
+	first.Difficulty.SetReciprocal(1e12)
+	headers[0] = first.Pack()
+	n, work, err := proveHeaders(first.PreviousBlock, 2, headers, required)
+	if fault.ErrInvalidBlockHeaderDifficulty != err {
+		t.Errorf("difficulty: error: %v", err)
+	}
+	if 0 != n || nil != work {
+		t.Errorf("difficulty: headers: %d  work: %v", n, work)
+	}
+}
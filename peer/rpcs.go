@@ -5,20 +5,32 @@
 package peer
 
 import (
+	"github.com/bitmark-inc/bitmarkd/peer/protocol"
 	"github.com/bitmark-inc/bitmarkd/zmqutil"
 )
 
-func FetchConnectors() []*zmqutil.Connected {
+// Connected is an outgoing connection and its negotiated capabilities
+type Connected struct {
+	*zmqutil.Connected
+	Protocol  *protocol.Capabilities `json:"protocol,omitempty"`
+	Throttled uint64                 `json:"throttled"`
+}
+
+func FetchConnectors() []*Connected {
 
 	globalData.RLock()
 
-	result := make([]*zmqutil.Connected, 0, len(globalData.connectorClients))
+	result := make([]*Connected, 0, len(globalData.connectorClients))
 
 	for _, c := range globalData.connectorClients {
 		if nil != c {
 			connect := c.ConnectedTo()
 			if nil != connect {
-				result = append(result, connect)
+				result = append(result, &Connected{
+					Connected: connect,
+					Protocol:  c.Capabilities(),
+					Throttled: c.Throttled(),
+				})
 			}
 		}
 	}
@@ -27,3 +39,8 @@ func FetchConnectors() []*zmqutil.Connected {
 
 	return result
 }
+
+// list the incoming clients that have been throttled by the listener
+func FetchThrottled() []Throttled {
+	return globalData.lstn.limits.throttled()
+}
@@ -26,13 +26,15 @@ type Connection struct {
 // a block of configuration data
 // this is read from the configuration file
 type Configuration struct {
-	DynamicConnections bool         `gluamapper:"dynamic_connections" json:"dynamic_connections"`
-	PreferIPv6         bool         `gluamapper:"prefer_ipv6" json:"prefer_ipv6"`
-	Listen             []string     `gluamapper:"listen" json:"listen"`
-	Announce           []string     `gluamapper:"announce" json:"announce"`
-	PrivateKey         string       `gluamapper:"private_key" json:"private_key"`
-	PublicKey          string       `gluamapper:"public_key" json:"public_key"`
-	Connect            []Connection `gluamapper:"connect" json:"connect,omitempty"`
+	DynamicConnections bool               `gluamapper:"dynamic_connections" json:"dynamic_connections"`
+	PreferIPv6         bool               `gluamapper:"prefer_ipv6" json:"prefer_ipv6"`
+	Listen             []string           `gluamapper:"listen" json:"listen"`
+	Announce           []string           `gluamapper:"announce" json:"announce"`
+	PrivateKey         string             `gluamapper:"private_key" json:"private_key"`
+	PublicKey          string             `gluamapper:"public_key" json:"public_key"`
+	Connect            []Connection       `gluamapper:"connect" json:"connect,omitempty"`
+	MinimumConnections int                `gluamapper:"minimum_connections" json:"minimum_connections,omitempty"`
+	Limits             LimitConfiguration `gluamapper:"limits" json:"limits"`
 }
 
 type PublishConfiguration struct {
@@ -54,8 +56,9 @@ type peerData struct {
 
 	publicKey []byte
 
-	clientCount int
-	blockHeight uint64
+	clientCount    int
+	blockHeight    uint64
+	connectorState connectorState // last state published by the connector
 
 	// for background
 	background *background.T
@@ -106,10 +109,10 @@ func Initialise(configuration *Configuration, version string) error {
 	// if err := globalData.brdc.initialise(privateKey, publicKey, configuration.Broadcast); nil != err {
 	// 	return err
 	// }
-	if err := globalData.lstn.initialise(privateKey, publicKey, configuration.Listen, version); nil != err {
+	if err := globalData.lstn.initialise(privateKey, publicKey, configuration.Listen, &configuration.Limits, version); nil != err {
 		return err
 	}
-	if err := globalData.conn.initialise(privateKey, publicKey, configuration.Connect, configuration.DynamicConnections, configuration.PreferIPv6); nil != err {
+	if err := globalData.conn.initialise(privateKey, publicKey, configuration.Connect, configuration.DynamicConnections, configuration.PreferIPv6, configuration.MinimumConnections, &configuration.Limits); nil != err {
 		return err
 	}
 
@@ -193,3 +196,12 @@ func GetCounts() (uint64, uint64) {
 func BlockHeight() uint64 {
 	return globalData.blockHeight
 }
+
+// return the name of the connector state and whether the connector
+// has reached the sampling state, i.e. synchronised with its peers
+func ConnectorState() (string, bool) {
+	globalData.RLock()
+	state := globalData.connectorState
+	globalData.RUnlock()
+	return state.String(), cStateSampling == state
+}
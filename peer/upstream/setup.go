@@ -7,17 +7,22 @@ package upstream
 import (
 	"encoding/binary"
 	"fmt"
+	"math/big"
+	"net"
 	"sync"
 	"time"
 
 	zmq "github.com/pebbe/zmq4"
+	"golang.org/x/time/rate"
 
 	"github.com/bitmark-inc/bitmarkd/announce"
 	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
 	"github.com/bitmark-inc/bitmarkd/counter"
 	"github.com/bitmark-inc/bitmarkd/fault"
 	"github.com/bitmark-inc/bitmarkd/messagebus"
 	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/peer/protocol"
 	"github.com/bitmark-inc/bitmarkd/util"
 	"github.com/bitmark-inc/bitmarkd/zmqutil"
 	"github.com/bitmark-inc/logger"
@@ -33,14 +38,27 @@ type Upstream struct {
 	log         *logger.L
 	client      *zmqutil.Client
 	registered  bool
+	address     *util.Connection // as dialled, nil if not connected
 	blockHeight uint64
+	chainWork   *big.Int // nil if the server does not report it
+
+	// negotiated at registration, nil until registered
+	capabilities *protocol.Capabilities
 	shutdown    chan<- struct{}
+
+	// bytes of broadcasts pushed to the server, nil if not limited
+	broadcastLimiter *rate.Limiter
+	throttled        uint64 // broadcasts dropped by the limit
 }
 
 // atomically incremented counter for log names
 var upstreamCounter counter.Counter
 
-func New(privateKey []byte, publicKey []byte, timeout time.Duration) (*Upstream, error) {
+// create an upstream client
+//
+// a non-zero broadcast rate limits the bytes per second of broadcasts
+// pushed to the server
+func New(privateKey []byte, publicKey []byte, timeout time.Duration, broadcastRate float64, broadcastBurst int) (*Upstream, error) {
 	client, err := zmqutil.NewClient(zmq.REQ, privateKey, publicKey, timeout)
 	if nil != err {
 		return nil, err
@@ -56,6 +74,9 @@ func New(privateKey []byte, publicKey []byte, timeout time.Duration) (*Upstream,
 		blockHeight: 0,
 		shutdown:    shutdown,
 	}
+	if 0 != broadcastRate {
+		u.broadcastLimiter = rate.NewLimiter(rate.Limit(broadcastRate), broadcastBurst)
+	}
 	go upstreamRunner(u, shutdown)
 	return u, nil
 }
@@ -74,11 +95,52 @@ func (u *Upstream) IsConnectedTo(serverPublicKey []byte) bool {
 	return u.client.IsConnectedTo(serverPublicKey)
 }
 
+// public key of the connected node, nil if not connected
+func (u *Upstream) ServerPublicKey() []byte {
+	if !u.client.IsConnected() {
+		return nil
+	}
+	return u.client.ServerPublicKey()
+}
+
+// the IP address that was dialled, nil if not connected
+func (u *Upstream) RemoteIP() net.IP {
+	u.RLock()
+	defer u.RUnlock()
+	if nil == u.address {
+		return nil
+	}
+	return u.address.IP()
+}
+
 // if registered the have avalid connection
 func (u *Upstream) IsOK() bool {
 	return u.registered
 }
 
+// capabilities negotiated with the server, nil if not registered
+func (u *Upstream) Capabilities() *protocol.Capabilities {
+	u.RLock()
+	defer u.RUnlock()
+	return u.capabilities
+}
+
+// check if the server supports a command
+//
+// assumed true before registration so that the request itself
+// reports the error
+func (u *Upstream) supports(command string) bool {
+	capabilities := u.Capabilities()
+	return nil == capabilities || capabilities.Supports(command)
+}
+
+// number of broadcasts dropped to keep within the broadcast limit
+func (u *Upstream) Throttled() uint64 {
+	u.RLock()
+	defer u.RUnlock()
+	return u.throttled
+}
+
 // if registered the have avalid connection
 func (u *Upstream) ConnectedTo() *zmqutil.Connected {
 	return u.client.ConnectedTo()
@@ -89,10 +151,34 @@ func (u *Upstream) Connect(address *util.Connection, serverPublicKey []byte) err
 	u.log.Infof("connecting to address: %s", address)
 	u.log.Infof("connecting to server: %x", serverPublicKey)
 	u.Lock()
+	start := time.Now()
+	u.address = nil
 	err := u.client.Connect(address, serverPublicKey, mode.ChainName())
 	if nil == err {
-		err = register(u.client, u.log)
+		u.address = address
+		u.capabilities, err = register(u.client, u.log)
+	}
+	u.Unlock()
+
+	if nil == err {
+		announce.ConnectSucceeded(serverPublicKey, time.Since(start))
+	} else {
+		announce.ConnectFailed(serverPublicKey)
 	}
+	return err
+}
+
+// drop the current connection, the client remains idle until the
+// next Connect
+func (u *Upstream) Disconnect() error {
+	u.log.Info("disconnecting")
+	u.Lock()
+	err := u.client.Disconnect()
+	u.registered = false
+	u.address = nil
+	u.blockHeight = 0
+	u.chainWork = nil
+	u.capabilities = nil
 	u.Unlock()
 	return err
 }
@@ -102,6 +188,15 @@ func (u *Upstream) GetHeight() uint64 {
 	return u.blockHeight
 }
 
+// fetch chain work from last polled value
+//
+// nil if the server is an older version that does not report it
+func (u *Upstream) GetChainWork() *big.Int {
+	u.RLock()
+	defer u.RUnlock()
+	return u.chainWork
+}
+
 // fetch block digest
 func (u *Upstream) GetBlockDigest(blockNumber uint64) (blockdigest.Digest, error) {
 	parameter := make([]byte, 8)
@@ -170,6 +265,109 @@ func (u *Upstream) GetBlockData(blockNumber uint64) ([]byte, error) {
 	return nil, fault.ErrInvalidPeerResponse
 }
 
+// fetch the packed data of a range of blocks
+//
+// fewer than count blocks are returned if the server does not have
+// all of the blocks or limits the size of the reply
+func (u *Upstream) GetBlockRange(blockNumber uint64, count uint16) ([][]byte, error) {
+	if !u.supports("BR") {
+		return nil, fault.ErrUnsupportedPeerCommand
+	}
+
+	start := make([]byte, 8)
+	binary.BigEndian.PutUint64(start, blockNumber)
+	n := make([]byte, 2)
+	binary.BigEndian.PutUint16(n, count)
+
+	// critical section - lock out the runner process
+	u.Lock()
+	var data [][]byte
+	err := u.client.Send("BR", start, n)
+	if nil == err {
+		data, err = u.client.Receive(0)
+	}
+	u.Unlock()
+
+	if nil != err {
+		return nil, err
+	}
+
+	if 2 != len(data) {
+		return nil, fault.ErrInvalidPeerResponse
+	}
+
+	switch string(data[0]) {
+	case "E":
+		return nil, fault.InvalidError(string(data[1]))
+	case "BR":
+		blocks := [][]byte{}
+		buffer := data[1]
+		for 0 != len(buffer) {
+			length, offset := util.FromVarint64(buffer)
+			if 0 == offset || 0 == length || uint64(len(buffer)-offset) < length || len(blocks) >= int(count) {
+				return nil, fault.ErrInvalidPeerResponse
+			}
+			blocks = append(blocks, buffer[offset:offset+int(length)])
+			buffer = buffer[offset+int(length):]
+		}
+		if 0 == len(blocks) {
+			return nil, fault.ErrInvalidPeerResponse
+		}
+		return blocks, nil
+	default:
+	}
+	return nil, fault.ErrInvalidPeerResponse
+}
+
+// fetch the packed headers of a range of blocks
+//
+// fewer than count headers are returned if the server does not have
+// all of the blocks
+func (u *Upstream) GetBlockHeaders(blockNumber uint64, count uint16) ([]blockrecord.PackedHeader, error) {
+	if !u.supports("HR") {
+		return nil, fault.ErrUnsupportedPeerCommand
+	}
+
+	start := make([]byte, 8)
+	binary.BigEndian.PutUint64(start, blockNumber)
+	n := make([]byte, 2)
+	binary.BigEndian.PutUint16(n, count)
+
+	// critical section - lock out the runner process
+	u.Lock()
+	var data [][]byte
+	err := u.client.Send("HR", start, n)
+	if nil == err {
+		data, err = u.client.Receive(0)
+	}
+	u.Unlock()
+
+	if nil != err {
+		return nil, err
+	}
+
+	if 2 != len(data) {
+		return nil, fault.ErrInvalidPeerResponse
+	}
+
+	switch string(data[0]) {
+	case "E":
+		return nil, fault.InvalidError(string(data[1]))
+	case "HR":
+		size := len(blockrecord.PackedHeader{})
+		if 0 == len(data[1]) || 0 != len(data[1])%size || len(data[1])/size > int(count) {
+			return nil, fault.ErrInvalidPeerResponse
+		}
+		headers := make([]blockrecord.PackedHeader, len(data[1])/size)
+		for i := range headers {
+			copy(headers[i][:], data[1][i*size:])
+		}
+		return headers, nil
+	default:
+	}
+	return nil, fault.ErrInvalidPeerResponse
+}
+
 func upstreamRunner(u *Upstream, shutdown <-chan struct{}) {
 	log := u.log
 
@@ -189,8 +387,17 @@ loop:
 			log.Debugf("from queue: %q  %x", item.Command, item.Parameters)
 			if u.registered {
 				u.Lock()
-				err := push(u.client, u.log, &item)
-				if nil != err {
+				if u.overBroadcastLimit(&item) {
+					u.throttled += 1
+					log.Warnf("push: throttled: %q", item.Command)
+					u.Unlock()
+					continue loop
+				}
+				err := u.pushItem(&item)
+				if nil != err && fault.IsErrInvalid(err) {
+					// the server replied with an error
+					log.Warnf("push: error: %s", err)
+				} else if nil != err {
 					log.Errorf("push: error: %s", err)
 					err := u.client.Reconnect()
 					if nil != err {
@@ -203,13 +410,14 @@ loop:
 		case <-time.After(cycleInterval):
 			u.Lock()
 			if !u.registered {
-				err := register(u.client, u.log)
+				capabilities, err := register(u.client, u.log)
 				if fault.ErrNotConnected == err {
 					log.Infof("register: %s", err)
 					u.Unlock()
 					continue loop // try again later
 				} else if nil != err {
 					log.Errorf("register: error: %s", err)
+					announce.ConnectFailed(u.client.ServerPublicKey())
 					err := u.client.Reconnect()
 					if nil != err {
 						log.Errorf("register: reconnect error: %s", err)
@@ -218,14 +426,27 @@ loop:
 					continue loop // try again later
 				}
 				u.registered = true
+				u.capabilities = capabilities
 			}
 
+			start := time.Now()
 			h, err := getHeight(u.client, u.log)
 			if nil == err {
 				u.blockHeight = h
+				announce.HeightReceived(u.client.ServerPublicKey(), h, time.Since(start))
+
+				// older servers do not support this so it is not
+				// a connection error
+				if nil == u.capabilities || u.capabilities.Supports("W") {
+					u.chainWork, err = getChainWork(u.client, u.log)
+					if nil != err {
+						log.Debugf("getChainWork: error: %s", err)
+					}
+				}
 			} else {
 				u.registered = false
 				log.Errorf("getHeight: error: %s", err)
+				announce.ConnectFailed(u.client.ServerPublicKey())
 				err := u.client.Reconnect()
 				if nil != err {
 					log.Errorf("highestBlock: reconnect error: %s", err)
@@ -239,30 +460,34 @@ loop:
 	log.Info("stopped")
 }
 
-func register(client *zmqutil.Client, log *logger.L) error {
+// register with the server and negotiate the protocol capabilities
+//
+// a server that does not reply with its capabilities is a legacy node
+func register(client *zmqutil.Client, log *logger.L) (*protocol.Capabilities, error) {
 
 	log.Debugf("register: client: %s", client)
 
-	err := announce.SendRegistration(client, "R")
+	local := protocol.Local()
+	err := announce.SendRegistration(client, "R", local.Pack())
 	if nil != err {
-		return err
+		return nil, err
 	}
 
 	data, err := client.Receive(0)
 	if nil != err {
-		return err
+		return nil, err
 	}
 
 	if len(data) < 2 {
-		return fmt.Errorf("register received: %d  expected at least: 2", len(data))
+		return nil, fmt.Errorf("register received: %d  expected at least: 2", len(data))
 	}
 
 	switch string(data[0]) {
 	case "E":
-		return fmt.Errorf("register error: %q", data[1])
+		return nil, fmt.Errorf("register error: %q", data[1])
 	case "R":
 		if len(data) < 5 {
-			return fmt.Errorf("register response incorrect: %x", data)
+			return nil, fmt.Errorf("register response incorrect: %x", data)
 		}
 		chain := mode.ChainName()
 		received := string(data[1])
@@ -274,9 +499,22 @@ func register(client *zmqutil.Client, log *logger.L) error {
 		timestamp := binary.BigEndian.Uint64(data[4])
 		log.Infof("register replied: public key: %x:  listeners: %x  timestamp: %d", data[2], data[3], timestamp)
 		announce.AddPeer(data[2], data[3], timestamp) // publicKey, broadcasts, listeners
-		return nil
+
+		capabilities := protocol.Legacy()
+		if len(data) >= 6 {
+			remote, err := protocol.Unpack(data[5])
+			if nil != err {
+				return nil, err
+			}
+			capabilities, err = protocol.Negotiate(local, remote)
+			if nil != err {
+				return nil, err
+			}
+		}
+		log.Infof("register negotiated: version: %d  commands: %q", capabilities.Version, capabilities.Commands)
+		return capabilities, nil
 	default:
-		return fmt.Errorf("rpc unexpected response: %q", data[0])
+		return nil, fmt.Errorf("rpc unexpected response: %q", data[0])
 	}
 }
 
@@ -312,6 +550,152 @@ func getHeight(client *zmqutil.Client, log *logger.L) (uint64, error) {
 	}
 }
 
+// chain work of the server's current block
+func getChainWork(client *zmqutil.Client, log *logger.L) (*big.Int, error) {
+
+	err := client.Send("W")
+	if nil != err {
+		return nil, err
+	}
+
+	data, err := client.Receive(0)
+	if nil != err {
+		return nil, err
+	}
+	if 2 != len(data) {
+		return nil, fmt.Errorf("getChainWork received: %d  expected: 2", len(data))
+	}
+
+	switch string(data[0]) {
+	case "E":
+		return nil, fmt.Errorf("rpc error response: %q", data[1])
+	case "W":
+		// an older server acknowledges with a single byte
+		if len(data[1]) < 8 {
+			return nil, fault.ErrInvalidPeerResponse
+		}
+		work := new(big.Int).SetBytes(data[1][8:])
+		log.Infof("chain work: %s", work)
+		return work, nil
+	default:
+		return nil, fmt.Errorf("rpc unexpected response: %q", data[0])
+	}
+}
+
+// true if a broadcast must be dropped to keep within the byte rate
+//
+// blocks are never dropped but are still charged, an item larger
+// than the burst is charged as the burst
+func (u *Upstream) overBroadcastLimit(item *messagebus.Message) bool {
+	if nil == u.broadcastLimiter {
+		return false
+	}
+
+	size := 0
+	for _, p := range item.Parameters {
+		size += len(p)
+	}
+	if size > u.broadcastLimiter.Burst() {
+		size = u.broadcastLimiter.Burst()
+	}
+
+	now := time.Now()
+	r := u.broadcastLimiter.ReserveN(now, size)
+	if "block" == item.Command {
+		return false
+	}
+	if !r.OK() || r.DelayFrom(now) > 0 {
+		r.CancelAt(now)
+		return true
+	}
+	return false
+}
+
+// push an item, sending blocks in compact form to servers that
+// support it and falling back to the full block
+func (u *Upstream) pushItem(item *messagebus.Message) error {
+	if "block" == item.Command && 1 == len(item.Parameters) && nil != u.capabilities && u.capabilities.Supports("CB") {
+		err := pushCompact(u.client, u.log, item.Parameters[0])
+		if nil == err {
+			return nil
+		}
+		u.log.Warnf("push compact: error: %s", err)
+
+		// a transport error leaves the socket needing a reconnect
+		if !fault.IsErrInvalid(err) && !fault.IsErrLength(err) && !fault.IsErrRecord(err) {
+			return err
+		}
+	}
+	return push(u.client, u.log, item)
+}
+
+// send the compact form of a block then any transactions that the
+// server could not find in its reservoir
+func pushCompact(client *zmqutil.Client, log *logger.L, packedBlock []byte) error {
+
+	compact, err := protocol.PackCompact(packedBlock)
+	if nil != err {
+		return err
+	}
+
+	log.Debugf("push compact: client: %s  %d of %d bytes", client, len(compact), len(packedBlock))
+
+	reply, err := request(client, "CB", compact)
+	if nil != err {
+		return err
+	}
+	missing, err := protocol.UnpackIndexes(reply)
+	if nil != err {
+		return err
+	}
+	if 0 == len(missing) {
+		return nil
+	}
+
+	header, txs, err := protocol.SplitBlock(packedBlock)
+	if nil != err {
+		return err
+	}
+	send := make([][]byte, len(missing))
+	for i, index := range missing {
+		if index >= len(txs) {
+			return fault.ErrInvalidPeerResponse
+		}
+		send[i] = txs[index]
+	}
+
+	log.Debugf("push compact: client: %s  missing: %d transactions", client, len(missing))
+
+	_, err = request(client, "BT", header[:], protocol.PackTransactions(send))
+	return err
+}
+
+// send a command and return the single data part of the reply
+func request(client *zmqutil.Client, command string, parameters ...[]byte) ([]byte, error) {
+
+	err := client.Send(command, parameters)
+	if nil != err {
+		return nil, err
+	}
+
+	data, err := client.Receive(0)
+	if nil != err {
+		return nil, err
+	}
+	if 2 != len(data) {
+		return nil, fault.ErrInvalidPeerResponse
+	}
+
+	switch string(data[0]) {
+	case "E":
+		return nil, fault.InvalidError(string(data[1]))
+	case command:
+		return data[1], nil
+	default:
+	}
+	return nil, fault.ErrInvalidPeerResponse
+}
+
 func push(client *zmqutil.Client, log *logger.L, item *messagebus.Message) error {
 
 	log.Debugf("push: client: %s  %q %x", client, item.Command, item.Parameters)
@@ -333,7 +717,7 @@ func push(client *zmqutil.Client, log *logger.L, item *messagebus.Message) error
 
 	switch string(data[0]) {
 	case "E":
-		return fmt.Errorf("rpc error response: %q", data[1])
+		return fault.InvalidError(string(data[1]))
 	case item.Command:
 		log.Debugf("push: client: %s complete: %q", client, data[1])
 		return nil
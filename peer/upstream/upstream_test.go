@@ -3,3 +3,49 @@
 // license that can be found in the LICENSE file.
 
 package upstream
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/time/rate"
+
+	"github.com/bitmark-inc/bitmarkd/messagebus"
+)
+
+func TestBroadcastLimit(t *testing.T) {
+	u := &Upstream{
+		broadcastLimiter: rate.NewLimiter(1, 1000),
+	}
+	item := &messagebus.Message{
+		Command:    "assets",
+		Parameters: [][]byte{bytes.Repeat([]byte{1}, 600)},
+	}
+	if u.overBroadcastLimit(item) {
+		t.Fatal("first broadcast throttled")
+	}
+	if !u.overBroadcastLimit(item) {
+		t.Fatal("second broadcast not throttled")
+	}
+
+	// blocks are always sent, but use up the remaining allowance
+	block := &messagebus.Message{
+		Command:    "block",
+		Parameters: [][]byte{bytes.Repeat([]byte{2}, 5000)},
+	}
+	if u.overBroadcastLimit(block) {
+		t.Error("block throttled")
+	}
+	small := &messagebus.Message{
+		Command:    "transfer",
+		Parameters: [][]byte{{3}},
+	}
+	if !u.overBroadcastLimit(small) {
+		t.Error("broadcast after block not throttled")
+	}
+
+	unlimited := &Upstream{}
+	if unlimited.overBroadcastLimit(block) {
+		t.Error("unlimited upstream throttled")
+	}
+}
@@ -10,7 +10,10 @@ import (
 	"github.com/bitmark-inc/logger"
 )
 
-const expirationCheckInterval = 5 * time.Minute
+const (
+	expirationCheckInterval = 5 * time.Minute
+	confirmedPayIdExpiry    = 24 * time.Hour
+)
 
 type cleaner struct {
 	log *logger.L
@@ -50,6 +53,11 @@ func (c *cleaner) deleteExpiredItems() {
 			internalDelete(key)
 		}
 	}
+	for key, item := range globalData.confirmedPayIds {
+		if expired(item.expiresAt) {
+			delete(globalData.confirmedPayIds, key)
+		}
+	}
 	globalData.Unlock()
 }
 
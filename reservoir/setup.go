@@ -65,6 +65,12 @@ type issueFreeData struct {
 	expiresAt  time.Time              // only used in pending state
 }
 
+// key: pay id
+type confirmedPayIdData struct {
+	txIds     []merkle.Digest // all records on this pay id
+	expiresAt time.Time       // forgotten after this time
+}
+
 type PaymentDetail struct {
 	Currency currency.Currency // code number
 	TxID     string            // tx id on currency blockchain
@@ -105,6 +111,10 @@ type globalDataType struct {
 	// ***** FIX THIS: need to expire
 	orphanPayments map[pay.PayId]*PaymentDetail
 
+	// pay ids whose transactions were confirmed, so that a client
+	// can still find them by pay id for a while
+	confirmedPayIds map[pay.PayId]*confirmedPayIdData
+
 	// set once during initialise
 	initialised bool
 }
@@ -142,6 +152,8 @@ func Initialise(reservoirDataFile string) error {
 
 	globalData.orphanPayments = make(map[pay.PayId]*PaymentDetail)
 
+	globalData.confirmedPayIds = make(map[pay.PayId]*confirmedPayIdData)
+
 	globalData.filename = reservoirDataFile
 
 	// all data initialised
@@ -247,6 +259,96 @@ func TransactionStatus(txId merkle.Digest) TransactionState {
 	return StateUnknown
 }
 
+// get status of all transactions on a pay id
+//
+// a confirmed pay id is remembered for confirmedPayIdExpiry, after
+// that, or if a block deletion unconfirmed any of its transactions,
+// the state is unknown and no tx ids are returned
+func PayIdStatus(payId pay.PayId) (TransactionState, []merkle.Digest) {
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	state, txIds := reservoirPayIdStatus(payId)
+	if StateUnknown != state {
+		return state, txIds
+	}
+
+	entry, ok := globalData.confirmedPayIds[payId]
+	if !ok {
+		return StateUnknown, nil
+	}
+	for _, txId := range entry.txIds {
+		if !storage.Pool.Transactions.Has(txId[:]) {
+			return StateUnknown, nil
+		}
+	}
+	return StateConfirmed, entry.txIds
+}
+
+// status of a pay id that is still in the reservoir, must hold lock
+// to call this
+func reservoirPayIdStatus(payId pay.PayId) (TransactionState, []merkle.Digest) {
+	if entry, ok := globalData.pendingTransactions[payId]; ok {
+		return StatePending, []merkle.Digest{entry.tx.txId}
+	}
+	if entry, ok := globalData.pendingFreeIssues[payId]; ok {
+		return StatePending, txIdsOf(entry.txs)
+	}
+	if entry, ok := globalData.pendingPaidIssues[payId]; ok {
+		return StatePending, txIdsOf(entry.txs)
+	}
+
+	if entry, ok := globalData.verifiedTransactions[payId]; ok {
+		return StateVerified, []merkle.Digest{entry.txId}
+	}
+	if entry, ok := globalData.verifiedFreeIssues[payId]; ok {
+		return StateVerified, txIdsOf(entry.txs)
+	}
+	if entry, ok := globalData.verifiedPaidIssues[payId]; ok {
+		return StateVerified, txIdsOf(entry.txs)
+	}
+
+	return StateUnknown, nil
+}
+
+// call a function for each pending and verified transaction
+//
+// the reservoir is locked so the function must not call back into it
+func ForEachTransaction(fn func(txId merkle.Digest, packed transactionrecord.Packed)) {
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	for _, entry := range globalData.pendingTransactions {
+		fn(entry.tx.txId, entry.tx.packed)
+	}
+	for _, entry := range globalData.verifiedTransactions {
+		fn(entry.txId, entry.packed)
+	}
+	for _, issues := range []map[pay.PayId]*issueFreeData{globalData.pendingFreeIssues, globalData.verifiedFreeIssues} {
+		for _, entry := range issues {
+			for _, tx := range entry.txs {
+				fn(tx.txId, tx.packed)
+			}
+		}
+	}
+	for _, issues := range []map[pay.PayId]*issuePaymentData{globalData.pendingPaidIssues, globalData.verifiedPaidIssues} {
+		for _, entry := range issues {
+			for _, tx := range entry.txs {
+				fn(tx.txId, tx.packed)
+			}
+		}
+	}
+}
+
+// extract tx ids in order
+func txIdsOf(txs []*transactionData) []merkle.Digest {
+	txIds := make([]merkle.Digest, len(txs))
+	for i, tx := range txs {
+		txIds[i] = tx.txId
+	}
+	return txIds
+}
+
 // move transaction(s) to verified cache
 func setVerified(payId pay.PayId, detail *PaymentDetail) bool {
 
@@ -349,6 +451,12 @@ func Enable() {
 	globalData.Unlock()
 }
 
+// true if the proofer is allowed to fetch, false while the node is
+// synchronising
+func IsEnabled() bool {
+	return enabled()
+}
+
 func enabled() bool {
 	globalData.RLock()
 	defer globalData.RUnlock()
@@ -361,6 +469,10 @@ func Rescan() {
 	globalData.Lock()
 	defer globalData.Unlock()
 
+	if globalData.enabled {
+		logger.Panic("reservoir rescan when not locked")
+	}
+
 	// pending
 
 	for _, item := range globalData.pendingTransactions {
@@ -409,7 +521,7 @@ func rescanItem(item *transactionData) {
 
 	case *transactionrecord.BitmarkIssue:
 		if storage.Pool.Transactions.Has(txId[:]) {
-			DeleteByTxId(txId)
+			deleteByTxId(txId)
 		}
 
 	case *transactionrecord.BitmarkTransferUnratified, *transactionrecord.BitmarkTransferCountersigned:
@@ -421,7 +533,7 @@ func rescanItem(item *transactionData) {
 			logger.Panic("Transactions database is corrupt")
 		}
 		if !ownership.CurrentlyOwns(linkOwner, link) {
-			DeleteByTxId(txId)
+			deleteByTxId(txId)
 		}
 
 	case *transactionrecord.BlockFoundation:
@@ -431,7 +543,7 @@ func rescanItem(item *transactionData) {
 		link := tx.Link
 		linkOwner := ownership.OwnerOf(link)
 		if !ownership.CurrentlyOwns(linkOwner, link) {
-			DeleteByTxId(txId)
+			deleteByTxId(txId)
 		}
 
 	default:
@@ -446,6 +558,37 @@ func DeleteByTxId(txId merkle.Digest) {
 	if enabled() {
 		logger.Panic("reservoir delete tx id when not locked")
 	}
+	deleteByTxId(txId)
+}
+
+// remove a record that has just been confirmed, remembering its pay
+// id so that PayIdStatus can still report it
+// note, as for DeleteByTxId the whole issue block is removed
+func ConfirmByTxId(txId merkle.Digest) {
+	if enabled() {
+		logger.Panic("reservoir confirm tx id when not locked")
+	}
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	payId, ok := globalData.pendingIndex[txId]
+	if !ok {
+		payId, ok = globalData.verifiedIndex[txId]
+	}
+	if ok {
+		if _, txIds := reservoirPayIdStatus(payId); nil != txIds {
+			globalData.confirmedPayIds[payId] = &confirmedPayIdData{
+				txIds:     txIds,
+				expiresAt: time.Now().Add(confirmedPayIdExpiry),
+			}
+		}
+	}
+	deleteByTxId(txId)
+}
+
+// internal delete, for use by rescan which already holds the lock
+func deleteByTxId(txId merkle.Digest) {
 	if payId, ok := globalData.pendingIndex[txId]; ok {
 		internalDelete(payId)
 	}
@@ -5,6 +5,8 @@
 package rpc
 
 import (
+	"encoding/hex"
+
 	"golang.org/x/time/rate"
 
 	"github.com/bitmark-inc/bitmarkd/asset"
@@ -102,36 +104,126 @@ loop:
 
 		assetId := transactionrecord.NewAssetIdentifier([]byte(fingerprint))
 
-		confirmed := true
-		_, packedAsset := storage.Pool.Assets.GetNB(assetId[:])
-		if nil == packedAsset {
-
-			confirmed = false
-			packedAsset = asset.Get(assetId)
-			if nil == packedAsset {
-				continue loop
-			}
+		record, ok := getAssetRecord(assetId)
+		if !ok {
+			continue loop
 		}
+		a[i] = record
+	}
+
+	reply.Assets = a
+
+	return nil
+}
+
+// Asset search
+// ------------
+
+type AssetSearchArguments struct {
+	Name          string `json:"name"`
+	Fingerprint   string `json:"fingerprint"`
+	MetadataKey   string `json:"metadataKey"`
+	MetadataValue string `json:"metadataValue"`
+	Start         string `json:"start"`
+	Count         int    `json:"count"`
+}
+
+type AssetSearchReply struct {
+	Assets []AssetRecord `json:"assets"`
+	Next   string        `json:"next,omitempty"`
+}
+
+// search confirmed assets
+//
+// name is matched by whole words, fingerprint by prefix and metadata
+// by key or key and value, all ignoring case except fingerprint
+//
+// start is the next value from the previous reply, blank for the
+// first page
+func (assets *Assets) Search(arguments *AssetSearchArguments, reply *AssetSearchReply) error {
+
+	log := assets.log
+
+	if err := rateLimitN(assets.limiter, arguments.Count, maximumAssets); nil != err {
+		return err
+	}
+
+	if !mode.Is(mode.Normal) {
+		return fault.ErrNotAvailableDuringSynchronise
+	}
+
+	if "" == arguments.MetadataKey && "" != arguments.MetadataValue {
+		return fault.ErrMissingParameters
+	}
 
-		assetTx, _, err := transactionrecord.Packed(packedAsset).Unpack(mode.IsTesting())
+	var start []byte
+	if "" != arguments.Start {
+		s, err := hex.DecodeString(arguments.Start)
 		if nil != err {
-			continue loop
+			return fault.ErrInvalidCursor
 		}
+		start = s
+	}
 
-		record, _ := transactionrecord.RecordName(assetTx)
-		a[i] = AssetRecord{
-			Record:    record,
-			Confirmed: confirmed,
-			AssetId:   assetId,
-			Data:      assetTx,
+	log.Infof("Assets.Search: %+v", arguments)
+
+	query := asset.SearchQuery{
+		Name:          arguments.Name,
+		Fingerprint:   arguments.Fingerprint,
+		MetadataKey:   arguments.MetadataKey,
+		MetadataValue: arguments.MetadataValue,
+	}
+	assetIds, next, err := asset.Search(&query, start, arguments.Count)
+	if nil != err {
+		return err
+	}
+
+	a := make([]AssetRecord, 0, len(assetIds))
+loop:
+	for _, assetId := range assetIds {
+		record, ok := getAssetRecord(assetId)
+		if !ok {
+			continue loop
 		}
+		a = append(a, record)
 	}
 
 	reply.Assets = a
+	if nil != next {
+		reply.Next = hex.EncodeToString(next)
+	}
 
 	return nil
 }
 
+// fetch a single asset either from storage or from the pending cache
+func getAssetRecord(assetId transactionrecord.AssetIdentifier) (AssetRecord, bool) {
+
+	confirmed := true
+	_, packedAsset := storage.Pool.Assets.GetNB(assetId[:])
+	if nil == packedAsset {
+
+		confirmed = false
+		packedAsset = asset.Get(assetId)
+		if nil == packedAsset {
+			return AssetRecord{}, false
+		}
+	}
+
+	assetTx, _, err := transactionrecord.Packed(packedAsset).Unpack(mode.IsTesting())
+	if nil != err {
+		return AssetRecord{}, false
+	}
+
+	record, _ := transactionrecord.RecordName(assetTx)
+	return AssetRecord{
+		Record:    record,
+		Confirmed: confirmed,
+		AssetId:   assetId,
+		Data:      assetTx,
+	}, true
+}
+
 // // Asset identifier
 // // -----------
 
@@ -5,8 +5,13 @@
 package rpc
 
 import (
+	"time"
+
 	"golang.org/x/time/rate"
 
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
 	"github.com/bitmark-inc/bitmarkd/fault"
 	"github.com/bitmark-inc/bitmarkd/merkle"
 	"github.com/bitmark-inc/bitmarkd/messagebus"
@@ -105,23 +110,35 @@ const (
 	maximumProvenanceCount = 100
 )
 
+// count is the number of transactions to return, an issue is always
+// returned together with its asset record
+//
+// start is the next value from a previous reply with the same txId
+// and direction, if omitted the history starts from txId, or from the
+// asset record if oldestFirst is set
 type ProvenanceArguments struct {
-	TxId  merkle.Digest `json:"txId"`
-	Count int           `json:"count"`
+	TxId        merkle.Digest  `json:"txId"`
+	Count       int            `json:"count"`
+	Start       *merkle.Digest `json:"start,omitempty"`
+	OldestFirst bool           `json:"oldestFirst"`
 }
 
 // can be any of the transaction records
 type ProvenanceRecord struct {
-	Record  string      `json:"record"`
-	IsOwner bool        `json:"isOwner"`
-	TxId    interface{} `json:"txId,omitempty"`
-	InBlock uint64      `json:"inBlock"`
-	AssetId interface{} `json:"assetId,omitempty"`
-	Data    interface{} `json:"data"`
+	Record         string              `json:"record"`
+	IsOwner        bool                `json:"isOwner"`
+	TxId           interface{}         `json:"txId,omitempty"`
+	InBlock        uint64              `json:"inBlock"`
+	BlockTimestamp *time.Time          `json:"blockTimestamp,omitempty"`
+	BlockDigest    *blockdigest.Digest `json:"blockDigest,omitempty"`
+	AssetId        interface{}         `json:"assetId,omitempty"`
+	Data           interface{}         `json:"data"`
 }
 
+// next is only present if more records are available
 type ProvenanceReply struct {
 	Data []ProvenanceRecord `json:"data"`
+	Next *merkle.Digest     `json:"next,omitempty"`
 }
 
 func (bitmark *Bitmark) Provenance(arguments *ProvenanceArguments, reply *ProvenanceReply) error {
@@ -136,92 +153,175 @@ func (bitmark *Bitmark) Provenance(arguments *ProvenanceArguments, reply *Proven
 
 	count := arguments.Count
 	id := arguments.TxId
+	if nil != arguments.Start {
+		id = *arguments.Start
+	} else if arguments.OldestFirst {
+		id = provenanceOrigin(id)
+	}
 
-	provenance := make([]ProvenanceRecord, 0, count)
+	provenance := make([]ProvenanceRecord, 0, count+1)
+	blocks := make(map[uint64]*BlockRecord)
 
+	var next *merkle.Digest
 loop:
 	for i := 0; i < count; i += 1 {
 
-		inBlock, packed := storage.Pool.Transactions.GetNB(id[:])
-		if nil == packed {
+		records, owner, previous, ok := readProvenance(id)
+		if !ok {
 			break loop
 		}
 
-		transaction, _, err := transactionrecord.Packed(packed).Unpack(mode.IsTesting())
-		if nil != err {
-			break loop
+		if id == arguments.TxId && nil != owner {
+			records[0].IsOwner = ownership.CurrentlyOwns(owner, id)
 		}
 
-		record, _ := transactionrecord.RecordName(transaction)
-		h := ProvenanceRecord{
-			Record:  record,
-			IsOwner: false,
-			TxId:    id,
-			InBlock: inBlock,
-			AssetId: nil,
-			Data:    transaction,
+		for j := range records {
+			addBlockDetails(&records[j], blocks)
 		}
 
-		switch tx := transaction.(type) {
-
-		case *transactionrecord.OldBaseData:
-			if 0 == i {
-				h.IsOwner = ownership.CurrentlyOwns(tx.Owner, id)
-			}
-
-			provenance = append(provenance, h)
-			break loop
-
-		case *transactionrecord.BlockFoundation:
-			if 0 == i {
-				h.IsOwner = ownership.CurrentlyOwns(tx.Owner, id)
-			}
-
-			provenance = append(provenance, h)
-			break loop
+		if arguments.OldestFirst {
 
-		case *transactionrecord.BitmarkIssue:
-			if 0 == i {
-				h.IsOwner = ownership.CurrentlyOwns(tx.Owner, id)
+			// asset before its issue
+			for j := len(records) - 1; j >= 0; j -= 1 {
+				provenance = append(provenance, records[j])
 			}
-			provenance = append(provenance, h)
 
-			_, packedAsset := storage.Pool.Assets.GetNB(tx.AssetId[:])
-			if nil == packedAsset {
+			// reached the requested transaction
+			if id == arguments.TxId {
 				break loop
 			}
-			assetTx, _, err := transactionrecord.Packed(packedAsset).Unpack(mode.IsTesting())
-			if nil != err {
+			successor := storage.Pool.TxSuccessor.Get(id[:])
+			if nil == successor {
 				break loop
 			}
+			copy(id[:], successor)
 
-			record, _ := transactionrecord.RecordName(assetTx)
-			h := ProvenanceRecord{
-				Record:  record,
-				IsOwner: false,
-				TxId:    nil,
-				AssetId: tx.AssetId,
-				Data:    assetTx,
-			}
-			provenance = append(provenance, h)
-			break loop
+		} else {
 
-		case *transactionrecord.BitmarkTransferUnratified, *transactionrecord.BitmarkTransferCountersigned, *transactionrecord.BlockOwnerTransfer:
-			tr := tx.(transactionrecord.BitmarkTransfer)
+			provenance = append(provenance, records...)
 
-			if 0 == i {
-				h.IsOwner = ownership.CurrentlyOwns(tr.GetOwner(), id)
+			// reached the start of the chain
+			if nil == previous {
+				break loop
 			}
+			id = *previous
+		}
 
-			provenance = append(provenance, h)
-			id = tr.GetLink()
-
-		default:
-			break loop
+		// more to fetch
+		if i+1 == count {
+			n := id
+			next = &n
 		}
 	}
 
 	reply.Data = provenance
+	reply.Next = next
 
 	return nil
 }
+
+// read one transaction of a provenance chain
+//
+// records contains a single record, or an issue followed by its
+// asset, owner is the owner set by the transaction and previous is
+// the link to the preceding transaction or nil at the start of the
+// chain
+func readProvenance(id merkle.Digest) ([]ProvenanceRecord, *account.Account, *merkle.Digest, bool) {
+
+	inBlock, packed := storage.Pool.Transactions.GetNB(id[:])
+	if nil == packed {
+		return nil, nil, nil, false
+	}
+
+	transaction, _, err := transactionrecord.Packed(packed).Unpack(mode.IsTesting())
+	if nil != err {
+		return nil, nil, nil, false
+	}
+
+	record, _ := transactionrecord.RecordName(transaction)
+	h := ProvenanceRecord{
+		Record:  record,
+		IsOwner: false,
+		TxId:    id,
+		InBlock: inBlock,
+		AssetId: nil,
+		Data:    transaction,
+	}
+
+	switch tx := transaction.(type) {
+
+	case *transactionrecord.OldBaseData:
+		return []ProvenanceRecord{h}, tx.Owner, nil, true
+
+	case *transactionrecord.BlockFoundation:
+		return []ProvenanceRecord{h}, tx.Owner, nil, true
+
+	case *transactionrecord.BitmarkIssue:
+		records := []ProvenanceRecord{h}
+
+		assetBlock, packedAsset := storage.Pool.Assets.GetNB(tx.AssetId[:])
+		if nil == packedAsset {
+			return records, tx.Owner, nil, true
+		}
+		assetTx, _, err := transactionrecord.Packed(packedAsset).Unpack(mode.IsTesting())
+		if nil != err {
+			return records, tx.Owner, nil, true
+		}
+
+		record, _ := transactionrecord.RecordName(assetTx)
+		records = append(records, ProvenanceRecord{
+			Record:  record,
+			IsOwner: false,
+			TxId:    nil,
+			InBlock: assetBlock,
+			AssetId: tx.AssetId,
+			Data:    assetTx,
+		})
+		return records, tx.Owner, nil, true
+
+	case *transactionrecord.BitmarkTransferUnratified, *transactionrecord.BitmarkTransferCountersigned, *transactionrecord.BlockOwnerTransfer:
+		tr := tx.(transactionrecord.BitmarkTransfer)
+		link := tr.GetLink()
+		return []ProvenanceRecord{h}, tr.GetOwner(), &link, true
+
+	default:
+		return nil, nil, nil, false
+	}
+}
+
+// the first transaction of a chain from the origin index, an id with
+// no entry is already the start of its chain
+func provenanceOrigin(id merkle.Digest) merkle.Digest {
+	origin := storage.Pool.TxOrigin.Get(id[:])
+	if nil != origin {
+		copy(id[:], origin)
+	}
+	return id
+}
+
+// add the timestamp and digest of the block containing a record
+func addBlockDetails(record *ProvenanceRecord, blocks map[uint64]*BlockRecord) {
+	if 0 == record.InBlock {
+		return
+	}
+
+	b, ok := blocks[record.InBlock]
+	if !ok {
+		header, digest, err := block.HeaderForBlock(record.InBlock)
+		if nil == err {
+			b = &BlockRecord{
+				Digest: digest,
+				Header: header,
+			}
+		}
+		blocks[record.InBlock] = b
+	}
+	if nil == b {
+		return
+	}
+
+	timestamp := time.Unix(int64(b.Header.Timestamp), 0).UTC()
+	digest := b.Digest
+	record.BlockTimestamp = &timestamp
+	record.BlockDigest = &digest
+}
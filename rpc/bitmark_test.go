@@ -0,0 +1,198 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/time/rate"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/storage"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+)
+
+// test database
+const (
+	testingDirName   = "testing"
+	databaseFileName = testingDirName + "/test"
+)
+
+func storageSetup(t *testing.T) {
+	os.RemoveAll(testingDirName)
+	os.Mkdir(testingDirName, 0700)
+
+	mustReindex, err := storage.Initialise(databaseFileName, storage.ReadWrite)
+	if nil != err {
+		t.Fatalf("storage initialise error: %s", err)
+	}
+	if mustReindex {
+		if err := storage.ReindexDone(); nil != err {
+			t.Fatalf("storage reindex done error: %s", err)
+		}
+	}
+}
+
+func storageTeardown(t *testing.T) {
+	storage.Finalise()
+	os.RemoveAll(testingDirName)
+}
+
+// a record that can be signed
+type packer interface {
+	Pack(*account.Account) (transactionrecord.Packed, error)
+}
+
+// sign a record with the key of the signer and return the packed data
+func signAndPack(t *testing.T, record packer, signature *account.Signature, signer ed25519.PrivateKey, signerAccount *account.Account) transactionrecord.Packed {
+	unsigned, _ := record.Pack(signerAccount)
+	*signature = ed25519.Sign(signer, unsigned)
+	packed, err := record.Pack(signerAccount)
+	if nil != err {
+		t.Fatalf("pack error: %s", err)
+	}
+	return packed
+}
+
+func TestProvenance(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+	storageSetup(t)
+	defer storageTeardown(t)
+
+	keys := make([]ed25519.PrivateKey, 4)
+	accounts := make([]*account.Account, 4)
+	for i := range keys {
+		seed := make([]byte, ed25519.SeedSize)
+		seed[0] = byte(i)
+		keys[i] = ed25519.NewKeyFromSeed(seed)
+		accounts[i] = &account.Account{
+			AccountInterface: &account.ED25519Account{
+				PublicKey: keys[i].Public().(ed25519.PublicKey),
+			},
+		}
+	}
+
+	// all in the genesis block so header details are available
+	blockNumberKey := make([]byte, 8)
+	binary.BigEndian.PutUint64(blockNumberKey, genesis.BlockNumber)
+
+	a := &transactionrecord.AssetData{
+		Name:        "provenance",
+		Fingerprint: "01-provenance",
+		Registrant:  accounts[0],
+	}
+	packedAsset := signAndPack(t, a, &a.Signature, keys[0], accounts[0])
+	assetId := a.AssetId()
+	storage.Pool.Assets.Put(assetId[:], blockNumberKey, packedAsset)
+
+	issue := &transactionrecord.BitmarkIssue{
+		AssetId: assetId,
+		Owner:   accounts[0],
+		Nonce:   1,
+	}
+	packed := signAndPack(t, issue, &issue.Signature, keys[0], accounts[0])
+	issueId := packed.MakeLink()
+	storage.Pool.Transactions.Put(issueId[:], blockNumberKey, packed)
+
+	// issue → 1 → 2 → 3
+	txIds := []merkle.Digest{issueId}
+	for i := 1; i < 4; i += 1 {
+		link := txIds[len(txIds)-1]
+		transfer := &transactionrecord.BitmarkTransferUnratified{
+			Link:  link,
+			Owner: accounts[i],
+		}
+		packed := signAndPack(t, transfer, &transfer.Signature, keys[i-1], accounts[i-1])
+		txId := packed.MakeLink()
+		storage.Pool.Transactions.Put(txId[:], blockNumberKey, packed)
+		storage.Pool.TxSuccessor.Put(link[:], txId[:])
+		storage.Pool.TxOrigin.Put(txId[:], issueId[:])
+		txIds = append(txIds, txId)
+	}
+
+	bitmark := &Bitmark{
+		log:     log,
+		limiter: rate.NewLimiter(rateLimitBitmark, rateBurstBitmark),
+	}
+
+	// collect the tx ids of all pages, asset has none
+	page := func(head merkle.Digest, oldestFirst bool, count int) []interface{} {
+		ids := []interface{}{}
+		arguments := ProvenanceArguments{
+			TxId:        head,
+			Count:       count,
+			OldestFirst: oldestFirst,
+		}
+	page_loop:
+		for n := 0; n < 10; n += 1 {
+			var reply ProvenanceReply
+			if err := bitmark.Provenance(&arguments, &reply); nil != err {
+				t.Fatalf("provenance error: %s", err)
+			}
+			for _, record := range reply.Data {
+				if nil == record.BlockDigest || genesis.LiveGenesisDigest != *record.BlockDigest {
+					t.Errorf("block digest: %v", record.BlockDigest)
+				}
+				if nil == record.BlockTimestamp || record.BlockTimestamp.IsZero() {
+					t.Errorf("block timestamp: %v", record.BlockTimestamp)
+				}
+				if nil == record.TxId {
+					ids = append(ids, record.AssetId)
+				} else {
+					ids = append(ids, record.TxId)
+				}
+			}
+			if nil == reply.Next {
+				break page_loop
+			}
+			arguments.Start = reply.Next
+		}
+		return ids
+	}
+
+	newest := []interface{}{txIds[3], txIds[2], txIds[1], txIds[0], assetId}
+	oldest := []interface{}{assetId, txIds[0], txIds[1], txIds[2], txIds[3]}
+
+	for _, count := range []int{1, 2, 3, 10} {
+		if ids := page(txIds[3], false, count); !sameIds(ids, newest) {
+			t.Errorf("count: %d  newest first: %v  expected: %v", count, ids, newest)
+		}
+		if ids := page(txIds[3], true, count); !sameIds(ids, oldest) {
+			t.Errorf("count: %d  oldest first: %v  expected: %v", count, ids, oldest)
+		}
+	}
+
+	// history stops at the requested transaction
+	if ids := page(txIds[1], true, 10); !sameIds(ids, oldest[:3]) {
+		t.Errorf("partial oldest first: %v  expected: %v", ids, oldest[:3])
+	}
+
+	// the origin comes from the index not by reading the history
+	storage.Pool.Transactions.Delete(txIds[2][:])
+	if origin := provenanceOrigin(txIds[3]); issueId != origin {
+		t.Errorf("origin: %v  expected: %v", origin, issueId)
+	}
+	if origin := provenanceOrigin(issueId); issueId != origin {
+		t.Errorf("issue origin: %v  expected: %v", origin, issueId)
+	}
+}
+
+func sameIds(a []interface{}, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
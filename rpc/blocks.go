@@ -0,0 +1,183 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"golang.org/x/time/rate"
+
+	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"github.com/bitmark-inc/logger"
+)
+
+// Blocks
+// ------
+
+type Blocks struct {
+	log     *logger.L
+	limiter *rate.Limiter
+}
+
+const (
+	maximumBlocksCount = 100
+)
+
+// a decoded block
+type BlockRecord struct {
+	Digest       blockdigest.Digest  `json:"digest"`
+	Header       *blockrecord.Header `json:"header"`
+	Transactions []BlockTransaction  `json:"transactions,omitempty"`
+}
+
+// one transaction from a block
+type BlockTransaction struct {
+	Index  int           `json:"index"`
+	TxId   merkle.Digest `json:"txId"`
+	Record string        `json:"record"`
+	Data   interface{}   `json:"data"`
+}
+
+// Blocks get
+// ----------
+
+// either number or digest can be given, digest takes priority
+type BlocksGetArguments struct {
+	Number uint64              `json:"number,string"`
+	Digest *blockdigest.Digest `json:"digest"`
+}
+
+type BlocksGetReply struct {
+	BlockRecord
+}
+
+func (blocks *Blocks) Get(arguments *BlocksGetArguments, reply *BlocksGetReply) error {
+
+	if err := rateLimit(blocks.limiter); nil != err {
+		return err
+	}
+
+	if !mode.Is(mode.Normal) {
+		return fault.ErrNotAvailableDuringSynchronise
+	}
+
+	log := blocks.log
+	log.Infof("Blocks.Get: %+v", arguments)
+
+	number := arguments.Number
+	if nil != arguments.Digest {
+		n, err := block.NumberForDigest(*arguments.Digest)
+		if nil != err {
+			return err
+		}
+		number = n
+	}
+
+	record, err := getBlockRecord(number, true)
+	if nil != err {
+		return err
+	}
+
+	reply.BlockRecord = *record
+
+	return nil
+}
+
+// Blocks range
+// ------------
+
+type BlocksRangeArguments struct {
+	Start       uint64 `json:"start,string"` // first block number
+	Count       int    `json:"count"`        // number of blocks
+	HeadersOnly bool   `json:"headersOnly"`  // omit transactions
+}
+
+type BlocksRangeReply struct {
+	Blocks []BlockRecord `json:"blocks"`
+	Next   uint64        `json:"next,string"` // start value for the next call
+}
+
+func (blocks *Blocks) Range(arguments *BlocksRangeArguments, reply *BlocksRangeReply) error {
+
+	if err := rateLimitN(blocks.limiter, arguments.Count, maximumBlocksCount); nil != err {
+		return err
+	}
+
+	if !mode.Is(mode.Normal) {
+		return fault.ErrNotAvailableDuringSynchronise
+	}
+
+	log := blocks.log
+	log.Infof("Blocks.Range: %+v", arguments)
+
+	start := arguments.Start
+	if start < genesis.BlockNumber {
+		start = genesis.BlockNumber
+	}
+	height := block.GetHeight()
+
+	records := make([]BlockRecord, 0, arguments.Count)
+	n := start
+loop:
+	for ; n < start+uint64(arguments.Count); n += 1 {
+		if n > height {
+			break loop
+		}
+		record, err := getBlockRecord(n, !arguments.HeadersOnly)
+		if nil != err {
+			return err
+		}
+		records = append(records, *record)
+	}
+
+	reply.Blocks = records
+	reply.Next = n
+
+	return nil
+}
+
+// fetch a block from storage and decode it
+//
+// stored blocks were validated when they were stored, so the header
+// is not checked again and the digest is read rather than computed
+func getBlockRecord(number uint64, withTransactions bool) (*BlockRecord, error) {
+
+	header, digest, data, err := block.DecodeBlock(number)
+	if nil != err {
+		return nil, err
+	}
+
+	record := &BlockRecord{
+		Digest: digest,
+		Header: header,
+	}
+	if !withTransactions {
+		return record, nil
+	}
+
+	txs := make([]BlockTransaction, header.TransactionCount)
+	for i := 0; i < int(header.TransactionCount); i += 1 {
+		transaction, n, err := transactionrecord.Packed(data).Unpack(mode.IsTesting())
+		if nil != err {
+			return nil, err
+		}
+		name, _ := transactionrecord.RecordName(transaction)
+		txs[i] = BlockTransaction{
+			Index:  i + 1,
+			TxId:   merkle.NewDigest(data[:n]),
+			Record: name,
+			Data:   transaction,
+		}
+		data = data[n:]
+	}
+	record.Transactions = txs
+
+	return record, nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+	"golang.org/x/time/rate"
+
+	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/chain"
+	"github.com/bitmark-inc/bitmarkd/difficulty"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/storage"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+)
+
+// the genesis block is not in storage so can be decoded directly
+func TestGenesisBlockRecord(t *testing.T) {
+	setup(t)
+	defer teardown(t)
+
+	record, err := getBlockRecord(genesis.BlockNumber, true)
+	if nil != err {
+		t.Fatalf("get block error: %s", err)
+	}
+
+	if genesis.LiveGenesisDigest != record.Digest {
+		t.Errorf("digest: %v  expected: %v", record.Digest, genesis.LiveGenesisDigest)
+	}
+	if genesis.BlockNumber != record.Header.Number {
+		t.Errorf("number: %d  expected: %d", record.Header.Number, genesis.BlockNumber)
+	}
+	if int(record.Header.TransactionCount) != len(record.Transactions) {
+		t.Fatalf("transactions: %d  expected: %d", len(record.Transactions), record.Header.TransactionCount)
+	}
+
+	txIds := make([]merkle.Digest, len(record.Transactions))
+	for i, tx := range record.Transactions {
+		if i+1 != tx.Index {
+			t.Errorf("%d: index: %d", i, tx.Index)
+		}
+		if _, ok := tx.Data.(*transactionrecord.OldBaseData); !ok {
+			t.Errorf("%d: unexpected transaction: %+v", i, tx.Data)
+		}
+		txIds[i] = tx.TxId
+	}
+
+	tree := merkle.FullMerkleTree(txIds)
+	if record.Header.MerkleRoot != tree[len(tree)-1] {
+		t.Errorf("merkle root: %v  does not match txIds", record.Header.MerkleRoot)
+	}
+
+	// headers only
+	record, err = getBlockRecord(genesis.BlockNumber, false)
+	if nil != err {
+		t.Fatalf("get block error: %s", err)
+	}
+	if nil != record.Transactions {
+		t.Errorf("unexpected transactions: %+v", record.Transactions)
+	}
+}
+
+// store blocks 2 and 3 with a difficulty far from the current one,
+// each holding the genesis transaction twice
+// returns the digest of block 2
+func storeOldBlocks(t *testing.T) blockdigest.Digest {
+
+	genesisHeader, _, err := block.HeaderForBlock(genesis.BlockNumber)
+	if nil != err {
+		t.Fatalf("genesis header error: %s", err)
+	}
+	tx := genesis.LiveGenesisBlock[len(blockrecord.PackedHeader{}):]
+	transactions := append(append([]byte{}, tx...), tx...)
+	txId := merkle.NewDigest(tx)
+	tree := merkle.FullMerkleTree([]merkle.Digest{txId, txId})
+
+	d := difficulty.New()
+	d.SetReciprocal(1000 * difficulty.Current.Reciprocal())
+
+	first := blockdigest.Digest{}
+	previous := genesis.LiveGenesisDigest
+	for n := genesis.BlockNumber + 1; n <= genesis.BlockNumber+2; n += 1 {
+		header := blockrecord.Header{
+			Version:          blockrecord.Version,
+			TransactionCount: 2,
+			Number:           n,
+			PreviousBlock:    previous,
+			MerkleRoot:       tree[len(tree)-1],
+			Timestamp:        genesisHeader.Timestamp + n,
+			Difficulty:       d,
+		}
+		packed := header.Pack()
+
+		// any digest will do as it is only read back
+		digest := blockdigest.Digest(sha3.Sum256(packed[:]))
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, n)
+		storage.Pool.Blocks.Put(key, append(packed[:], transactions...))
+		storage.Pool.BlockHeaderHash.Put(digest[:], key)
+		if genesis.BlockNumber+1 == n {
+			first = digest
+		}
+		previous = digest
+	}
+	return first
+}
+
+// stored blocks are decoded without any difficulty check or digest
+// computation
+func TestOldBlockRange(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+	storageSetup(t)
+	defer storageTeardown(t)
+
+	digest := storeOldBlocks(t)
+
+	blocks := &Blocks{
+		log:     log,
+		limiter: rate.NewLimiter(rate.Inf, 1),
+	}
+
+	record, err := getBlockRecord(genesis.BlockNumber+1, true)
+	if nil != err {
+		t.Fatalf("get block error: %s", err)
+	}
+	if digest != record.Digest {
+		t.Errorf("digest: %v  expected: %v", record.Digest, digest)
+	}
+	if record.Header.Difficulty.Reciprocal() == difficulty.Current.Reciprocal() {
+		t.Errorf("difficulty: %v  should differ from current", record.Header.Difficulty)
+	}
+	if int(record.Header.TransactionCount) != len(record.Transactions) {
+		t.Errorf("transactions: %d  expected: %d", len(record.Transactions), record.Header.TransactionCount)
+	}
+
+	getArguments := BlocksGetArguments{
+		Digest: &digest,
+	}
+	var getReply BlocksGetReply
+	if err := blocks.Get(&getArguments, &getReply); fault.ErrNotAvailableDuringSynchronise != err {
+		t.Errorf("Blocks.Get synchronising: error: %v  expected: %s", err, fault.ErrNotAvailableDuringSynchronise)
+	}
+	rangeArguments := BlocksRangeArguments{
+		Count: 1,
+	}
+	var rangeReply BlocksRangeReply
+	if err := blocks.Range(&rangeArguments, &rangeReply); fault.ErrNotAvailableDuringSynchronise != err {
+		t.Errorf("Blocks.Range synchronising: error: %v  expected: %s", err, fault.ErrNotAvailableDuringSynchronise)
+	}
+
+	mode.Initialise(chain.Bitmark)
+	defer mode.Finalise()
+	mode.Set(mode.Normal)
+
+	if err := blocks.Get(&getArguments, &getReply); nil != err {
+		t.Fatalf("Blocks.Get error: %s", err)
+	}
+	if genesis.BlockNumber+1 != getReply.Header.Number {
+		t.Errorf("Blocks.Get number: %d", getReply.Header.Number)
+	}
+
+	// headers only as used by Blocks.Range and light clients
+	record, err = getBlockRecord(genesis.BlockNumber+1, false)
+	if nil != err {
+		t.Fatalf("get header error: %s", err)
+	}
+	if digest != record.Digest || nil != record.Transactions {
+		t.Errorf("unexpected header record: %+v", record)
+	}
+}
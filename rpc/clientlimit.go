@@ -0,0 +1,348 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/bitmark-inc/bitmarkd/counter"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/util"
+)
+
+// per-client limits
+//
+// keys are strings of the form "<key> [<rate> [<burst>]]" the same
+// format is used for each line of the key file, blank lines and
+// lines beginning with '#' are ignored
+//
+// a zero rate disables that class of limiting; with require_key a
+// client_rpc connection must call Auth.Key before any other call
+type LimitConfiguration struct {
+	RequireKey   bool     `gluamapper:"require_key" json:"require_key"`
+	Keys         []string `gluamapper:"keys" json:"keys"`
+	KeyFile      string   `gluamapper:"key_file" json:"key_file"`
+	KeyRate      float64  `gluamapper:"key_rate" json:"key_rate"`
+	KeyBurst     int      `gluamapper:"key_burst" json:"key_burst"`
+	AddressRate  float64  `gluamapper:"address_rate" json:"address_rate"`
+	AddressBurst int      `gluamapper:"address_burst" json:"address_burst"`
+}
+
+// HTTP headers that can carry a key
+const (
+	apiKeyHeader        = "X-Api-Key"
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+)
+
+// rate limiting that tells the client when to retry
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("%s: retry after: %s", fault.ErrRateLimiting, e.retryAfter)
+}
+
+// retry time in whole seconds as required by the Retry-After header
+func (e *rateLimitError) seconds() int {
+	return int(math.Ceil(e.retryAfter.Seconds()))
+}
+
+// limits for one key
+type keyLimit struct {
+	rate  rate.Limit
+	burst int
+}
+
+// all the per-client limiters
+type clientLimits struct {
+	sync.Mutex
+
+	requireKey   bool
+	keys         map[string]keyLimit
+	addressLimit keyLimit
+
+	limiters *util.IdleMap // of *rate.Limiter
+}
+
+// create the limiters from configuration
+func newClientLimits(configuration *LimitConfiguration) (*clientLimits, error) {
+
+	limits := &clientLimits{
+		requireKey: configuration.RequireKey,
+		keys:       make(map[string]keyLimit),
+		addressLimit: keyLimit{
+			rate:  rate.Limit(configuration.AddressRate),
+			burst: configuration.AddressBurst,
+		},
+		limiters: util.NewIdleMap(),
+	}
+
+	defaultLimit := keyLimit{
+		rate:  rate.Limit(configuration.KeyRate),
+		burst: configuration.KeyBurst,
+	}
+	if !defaultLimit.valid() || !limits.addressLimit.valid() {
+		return nil, fault.ErrInvalidCount
+	}
+
+	for _, line := range configuration.Keys {
+		if err := limits.addKey(line, defaultLimit); nil != err {
+			return nil, err
+		}
+	}
+
+	if "" != configuration.KeyFile {
+		f, err := os.Open(configuration.KeyFile)
+		if nil != err {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if err := limits.addKey(scanner.Text(), defaultLimit); nil != err {
+				return nil, err
+			}
+		}
+		if err := scanner.Err(); nil != err {
+			return nil, err
+		}
+	}
+
+	if limits.requireKey && 0 == len(limits.keys) {
+		return nil, fault.ErrMissingAPIKey
+	}
+
+	return limits, nil
+}
+
+// take the keys and limits from a newly configured set
+//
+// existing limiters are discarded as their rates may have changed
+func (limits *clientLimits) replace(newLimits *clientLimits) {
+	limits.Lock()
+	limits.requireKey = newLimits.requireKey
+	limits.keys = newLimits.keys
+	limits.addressLimit = newLimits.addressLimit
+	limits.limiters = util.NewIdleMap()
+	limits.Unlock()
+}
+
+// parse: "<key> [<rate> [<burst>]]"
+func (limits *clientLimits) addKey(line string, defaultLimit keyLimit) error {
+
+	fields := strings.Fields(line)
+	if 0 == len(fields) || strings.HasPrefix(fields[0], "#") {
+		return nil
+	}
+	if len(fields) > 3 {
+		return fault.ErrInvalidAPIKey
+	}
+
+	limit := defaultLimit
+	if len(fields) >= 2 {
+		r, err := strconv.ParseFloat(fields[1], 64)
+		if nil != err || r < 0 {
+			return fault.ErrInvalidAPIKey
+		}
+		limit.rate = rate.Limit(r)
+	}
+	if len(fields) >= 3 {
+		b, err := strconv.Atoi(fields[2])
+		if nil != err || b < 0 {
+			return fault.ErrInvalidAPIKey
+		}
+		limit.burst = b
+	}
+
+	if !limit.valid() {
+		return fault.ErrInvalidAPIKey
+	}
+
+	limits.keys[fields[0]] = limit
+	return nil
+}
+
+// a non-zero rate needs a burst of at least one or every call would
+// be rejected
+func (limit keyLimit) valid() bool {
+	if limit.rate < 0 || limit.burst < 0 {
+		return false
+	}
+	return 0 == limit.rate || limit.burst >= 1
+}
+
+// check if a client can make count calls
+//
+// key is blank if none was supplied, in which case the source
+// address is limited
+func (limits *clientLimits) check(key string, address string, count int) error {
+
+	if nil == limits {
+		return nil
+	}
+
+	// keys can be replaced by a reload
+	limits.Lock()
+	defer limits.Unlock()
+
+	var id string
+	var limit keyLimit
+	var rejections *counter.Counter
+	if "" != key {
+		l, ok := limits.keys[key]
+		if !ok {
+			return fault.ErrInvalidAPIKey
+		}
+		id = "key:" + key
+		limit = l
+		rejections = &keyRejections
+	} else if limits.requireKey {
+		return fault.ErrMissingAPIKey
+	} else {
+		id = "address:" + address
+		limit = limits.addressLimit
+		rejections = &addressRejections
+	}
+
+	// not limited
+	if 0 == limit.rate {
+		return nil
+	}
+
+	if count < 1 {
+		count = 1
+	}
+
+	now := time.Now()
+	l := limits.limiters.Use(id, now, func() interface{} {
+		return rate.NewLimiter(limit.rate, limit.burst)
+	}).(*rate.Limiter)
+
+	r := l.ReserveN(now, count)
+	if !r.OK() {
+		rejections.Increment()
+		return fault.ErrRateLimiting
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		rejections.Increment()
+		return &rateLimitError{
+			retryAfter: delay,
+		}
+	}
+	return nil
+}
+
+// extract the key from either of the supported headers
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); "" != key {
+		return strings.TrimSpace(key)
+	}
+	if auth := r.Header.Get(authorizationHeader); strings.HasPrefix(auth, bearerPrefix) {
+		return strings.TrimSpace(strings.TrimPrefix(auth, bearerPrefix))
+	}
+	return ""
+}
+
+// the address part of http.Request.RemoteAddr
+func remoteAddress(r *http.Request) string {
+	return hostPart(r.RemoteAddr)
+}
+
+// the address part of the remote address of a connection, in the
+// same form as remoteAddress so that a client shares one limiter
+// across protocols and connections
+func connectionAddress(conn io.ReadWriteCloser) string {
+	c, ok := conn.(interface {
+		RemoteAddr() net.Addr
+	})
+	if !ok {
+		return ""
+	}
+	return hostPart(c.RemoteAddr().String())
+}
+
+// strip the port from "host:port"
+func hostPart(address string) string {
+	last := strings.LastIndex(address, ":")
+	if last < 0 {
+		return address
+	}
+	return address[:last]
+}
+
+// check the limits for an HTTP request and send an error reply if
+// the request must not proceed
+func (limits *clientLimits) allowHTTP(w http.ResponseWriter, r *http.Request, count int) bool {
+	err := limits.check(apiKey(r), remoteAddress(r), count)
+	if nil == err {
+		return true
+	}
+	sendLimitError(w, err)
+	return false
+}
+
+// send HTTP error for a limit failure
+func sendLimitError(w http.ResponseWriter, err error) {
+	switch e := err.(type) {
+	case *rateLimitError:
+		w.Header().Set("Retry-After", strconv.Itoa(e.seconds()))
+		sendError(w, e.Error(), http.StatusTooManyRequests)
+	default:
+		if fault.ErrInvalidAPIKey == err || fault.ErrMissingAPIKey == err {
+			sendError(w, err.Error(), http.StatusUnauthorized)
+		} else {
+			sendFault(w, err)
+		}
+	}
+}
+
+// wrap a codec to limit the calls on a single connection
+//
+// calls are limited by the address of the client so that opening a
+// new connection does not reset its limit, or by the key given to
+// Auth.Key which then applies to the rest of the connection
+type limitingCodec struct {
+	rpc.ServerCodec
+	limits  *clientLimits
+	address string
+	key     string
+}
+
+// after the body has been consumed the limit is checked, an error
+// here is sent back to the client by the RPC server
+func (codec *limitingCodec) ReadRequestBody(body interface{}) error {
+	if err := codec.ServerCodec.ReadRequestBody(body); nil != err {
+		return err
+	}
+	if nil == body {
+		return nil
+	}
+	if auth, ok := body.(*AuthKeyArguments); ok {
+		key := strings.TrimSpace(auth.Key)
+		if err := codec.limits.check(key, codec.address, 1); nil != err {
+			return err
+		}
+		codec.key = key
+		return nil
+	}
+	return codec.limits.check(codec.key, codec.address, 1)
+}
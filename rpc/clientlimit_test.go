@@ -0,0 +1,237 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+func TestClientLimitsKeys(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "api-keys")
+	if nil != err {
+		t.Fatalf("temp file error: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# comment\n\nfile-key 1 2\n")
+	f.Close()
+
+	configuration := &LimitConfiguration{
+		Keys: []string{
+			"config-key",
+			"fast-key 1000 1000",
+		},
+		KeyFile:      f.Name(),
+		KeyRate:      1,
+		KeyBurst:     1,
+		AddressRate:  1,
+		AddressBurst: 3,
+	}
+
+	limits, err := newClientLimits(configuration)
+	if nil != err {
+		t.Fatalf("new limits error: %s", err)
+	}
+
+	if err := limits.check("unknown-key", "127.0.0.1", 1); fault.ErrInvalidAPIKey != err {
+		t.Errorf("unknown key error: %v  expected: %v", err, fault.ErrInvalidAPIKey)
+	}
+
+	// burst of one then must retry
+	if err := limits.check("config-key", "127.0.0.1", 1); nil != err {
+		t.Errorf("first call error: %s", err)
+	}
+	err = limits.check("config-key", "127.0.0.1", 1)
+	if e, ok := err.(*rateLimitError); !ok {
+		t.Errorf("second call error: %v  expected retry", err)
+	} else if 1 != e.seconds() {
+		t.Errorf("retry after: %d s  expected: 1 s", e.seconds())
+	}
+
+	// key from file has burst of two
+	for i := 0; i < 2; i += 1 {
+		if err := limits.check("file-key", "127.0.0.1", 1); nil != err {
+			t.Errorf("%d: file key error: %s", i, err)
+		}
+	}
+
+	// more than the burst can never succeed
+	if err := limits.check("file-key", "127.0.0.1", 3); fault.ErrRateLimiting != err {
+		t.Errorf("over burst error: %v  expected: %v", err, fault.ErrRateLimiting)
+	}
+
+	// each key has its own limiter
+	for i := 0; i < 100; i += 1 {
+		if err := limits.check("fast-key", "127.0.0.1", 1); nil != err {
+			t.Fatalf("%d: fast key error: %s", i, err)
+		}
+	}
+
+	// address limits are separate from keys
+	if err := limits.check("", "127.0.0.1", 3); nil != err {
+		t.Errorf("address error: %s", err)
+	}
+	if err := limits.check("", "127.0.0.1", 1); nil == err {
+		t.Errorf("address was not limited")
+	}
+	if err := limits.check("", "[::1]", 1); nil != err {
+		t.Errorf("second address error: %s", err)
+	}
+}
+
+func TestClientLimitsRequireKey(t *testing.T) {
+
+	_, err := newClientLimits(&LimitConfiguration{RequireKey: true})
+	if fault.ErrMissingAPIKey != err {
+		t.Errorf("require without keys error: %v  expected: %v", err, fault.ErrMissingAPIKey)
+	}
+
+	_, err = newClientLimits(&LimitConfiguration{Keys: []string{"key 1 2 3"}})
+	if fault.ErrInvalidAPIKey != err {
+		t.Errorf("invalid key line error: %v  expected: %v", err, fault.ErrInvalidAPIKey)
+	}
+
+	// a rate without a burst would reject every call
+	invalid := []LimitConfiguration{
+		{KeyRate: 1},
+		{KeyRate: -1, KeyBurst: 1},
+		{AddressRate: 1},
+	}
+	for i, item := range invalid {
+		if _, err := newClientLimits(&item); fault.ErrInvalidCount != err {
+			t.Errorf("%d: invalid limit error: %v  expected: %v", i, err, fault.ErrInvalidCount)
+		}
+	}
+	_, err = newClientLimits(&LimitConfiguration{Keys: []string{"key 1 0"}})
+	if fault.ErrInvalidAPIKey != err {
+		t.Errorf("zero burst key error: %v  expected: %v", err, fault.ErrInvalidAPIKey)
+	}
+
+	limits, err := newClientLimits(&LimitConfiguration{
+		RequireKey: true,
+		Keys:       []string{"secret"},
+	})
+	if nil != err {
+		t.Fatalf("new limits error: %s", err)
+	}
+
+	tests := []struct {
+		header string
+		value  string
+		code   int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{apiKeyHeader, "wrong", http.StatusUnauthorized},
+		{apiKeyHeader, "secret", http.StatusOK},
+		{authorizationHeader, "Bearer secret", http.StatusOK},
+	}
+
+	for i, item := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/blocks/2", nil)
+		if "" != item.header {
+			r.Header.Set(item.header, item.value)
+		}
+		w := httptest.NewRecorder()
+		if limits.allowHTTP(w, r, 1) {
+			w.WriteHeader(http.StatusOK)
+		}
+		if item.code != w.Code {
+			t.Errorf("%d: code: %d  expected: %d", i, w.Code, item.code)
+		}
+	}
+
+	// no limits configured
+	var none *clientLimits
+	if err := none.check("", "127.0.0.1", 1000); nil != err {
+		t.Errorf("nil limits error: %s", err)
+	}
+}
+
+func TestClientLimitsRetryAfter(t *testing.T) {
+
+	limits, err := newClientLimits(&LimitConfiguration{
+		AddressRate:  0.5,
+		AddressBurst: 1,
+	})
+	if nil != err {
+		t.Fatalf("new limits error: %s", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/bitmarkd/rpc", nil)
+
+	w := httptest.NewRecorder()
+	if !limits.allowHTTP(w, r, 1) {
+		t.Fatalf("first request was limited")
+	}
+
+	w = httptest.NewRecorder()
+	if limits.allowHTTP(w, r, 1) {
+		t.Fatalf("second request was not limited")
+	}
+	if http.StatusTooManyRequests != w.Code {
+		t.Errorf("code: %d  expected: %d", w.Code, http.StatusTooManyRequests)
+	}
+	if "2" != w.Header().Get("Retry-After") {
+		t.Errorf("Retry-After: %q  expected: \"2\"", w.Header().Get("Retry-After"))
+	}
+}
+
+// decodes every auth body with the given key
+type keyCodec struct {
+	rpc.ServerCodec
+	key string
+}
+
+func (codec *keyCodec) ReadRequestBody(body interface{}) error {
+	if auth, ok := body.(*AuthKeyArguments); ok {
+		auth.Key = codec.key
+	}
+	return nil
+}
+
+func TestLimitingCodecAuth(t *testing.T) {
+
+	limits, err := newClientLimits(&LimitConfiguration{
+		RequireKey: true,
+		Keys:       []string{"secret"},
+	})
+	if nil != err {
+		t.Fatalf("new limits error: %s", err)
+	}
+
+	bodies := &keyCodec{}
+	codec := &limitingCodec{
+		ServerCodec: bodies,
+		limits:      limits,
+		address:     "127.0.0.1",
+	}
+
+	tests := []struct {
+		key  string
+		body interface{}
+		err  error
+	}{
+		{"", &NodeArguments{}, fault.ErrMissingAPIKey},
+		{"wrong", &AuthKeyArguments{}, fault.ErrInvalidAPIKey},
+		{"", &NodeArguments{}, fault.ErrMissingAPIKey},
+		{"secret", &AuthKeyArguments{}, nil},
+		{"", &NodeArguments{}, nil},
+		{"", nil, nil},
+	}
+
+	for i, item := range tests {
+		bodies.key = item.key
+		if err := codec.ReadRequestBody(item.body); item.err != err {
+			t.Errorf("%d: error: %v  expected: %v", i, err, item.err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/currency"
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/payment"
+	"github.com/bitmark-inc/bitmarkd/peer"
+	"github.com/bitmark-inc/bitmarkd/reservoir"
+)
+
+// a payment handler with no block or transaction for this long is
+// considered stale
+const maximumPaymentAge = time.Hour
+
+// state of the node as seen by a load balancer
+type healthReport struct {
+	Ready     bool            `json:"ready"`
+	Reasons   []string        `json:"reasons"`
+	Mode      string          `json:"mode"`
+	Connector string          `json:"connector"`
+	Blocks    healthBlocks    `json:"blocks"`
+	Reservoir bool            `json:"reservoirEnabled"`
+	Payments  []healthPayment `json:"payments"`
+}
+
+type healthBlocks struct {
+	Local  uint64 `json:"local"`
+	Remote uint64 `json:"remote"`
+}
+
+type healthPayment struct {
+	Currency   currency.Currency `json:"currency"`
+	LastUpdate time.Time         `json:"lastUpdate"`
+	Fresh      bool              `json:"fresh"`
+}
+
+// inputs to the readiness decision
+type healthInputs struct {
+	mode         string
+	normal       bool
+	connector    string
+	synchronised bool
+	local        uint64
+	remote       uint64
+	reservoir    bool
+	payments     []payment.CurrencyStatus
+	now          time.Time
+}
+
+// collect the current state of all the relevant subsystems
+func currentHealthInputs() *healthInputs {
+	connector, synchronised := peer.ConnectorState()
+	return &healthInputs{
+		mode:         mode.String(),
+		normal:       mode.Is(mode.Normal),
+		connector:    connector,
+		synchronised: synchronised,
+		local:        block.GetHeight(),
+		remote:       peer.BlockHeight(),
+		reservoir:    reservoir.IsEnabled(),
+		payments:     payment.ReadStatus(),
+		now:          time.Now(),
+	}
+}
+
+// ready only if every check passes, reasons lists the failures
+func evaluateHealth(inputs *healthInputs) *healthReport {
+
+	report := &healthReport{
+		Reasons:   []string{},
+		Mode:      inputs.mode,
+		Connector: inputs.connector,
+		Blocks: healthBlocks{
+			Local:  inputs.local,
+			Remote: inputs.remote,
+		},
+		Reservoir: inputs.reservoir,
+		Payments:  make([]healthPayment, 0, len(inputs.payments)),
+	}
+
+	if !inputs.normal {
+		report.Reasons = append(report.Reasons, "mode is not normal")
+	}
+	if !inputs.synchronised {
+		report.Reasons = append(report.Reasons, "connector is not synchronised")
+	}
+	if inputs.remote > inputs.local {
+		report.Reasons = append(report.Reasons, "local block height is behind upstream")
+	}
+	if !inputs.reservoir {
+		report.Reasons = append(report.Reasons, "reservoir is disabled")
+	}
+
+	for _, p := range inputs.payments {
+		fresh := !p.LastUpdate.IsZero() && inputs.now.Sub(p.LastUpdate) <= maximumPaymentAge
+		report.Payments = append(report.Payments, healthPayment{
+			Currency:   p.Currency,
+			LastUpdate: p.LastUpdate,
+			Fresh:      fresh,
+		})
+		if !fresh {
+			report.Reasons = append(report.Reasons, "payment handler is stale: "+p.Currency.String())
+		}
+	}
+
+	report.Ready = 0 == len(report.Reasons)
+	return report
+}
+
+// liveness: always 200 while the process can serve requests
+func (s *httpHandler) health(w http.ResponseWriter, r *http.Request) {
+	if http.MethodGet != r.Method && http.MethodHead != r.Method {
+		sendMethodNotAllowed(w)
+		return
+	}
+
+	report := evaluateHealth(currentHealthInputs())
+
+	w.Header().Set("Cache-Control", "no-cache")
+	sendReply(w, report)
+}
+
+// readiness: 200 if able to serve current data, otherwise 503 with
+// the reasons
+func (s *httpHandler) ready(w http.ResponseWriter, r *http.Request) {
+	if http.MethodGet != r.Method && http.MethodHead != r.Method {
+		sendMethodNotAllowed(w)
+		return
+	}
+
+	report := evaluateHealth(currentHealthInputs())
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if !report.Ready {
+		sendStatusReply(w, http.StatusServiceUnavailable, report)
+		return
+	}
+	sendReply(w, report)
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/currency"
+	"github.com/bitmark-inc/bitmarkd/payment"
+)
+
+func TestEvaluateHealth(t *testing.T) {
+
+	now := time.Now()
+	ready := healthInputs{
+		mode:         "Normal",
+		normal:       true,
+		connector:    "Sampling",
+		synchronised: true,
+		local:        100,
+		remote:       100,
+		reservoir:    true,
+		payments: []payment.CurrencyStatus{
+			{Currency: currency.Bitcoin, LastUpdate: now.Add(-time.Minute)},
+		},
+		now: now,
+	}
+
+	report := evaluateHealth(&ready)
+	if !report.Ready || 0 != len(report.Reasons) {
+		t.Errorf("expected ready: %+v", report)
+	}
+	if 1 != len(report.Payments) || !report.Payments[0].Fresh {
+		t.Errorf("expected fresh payment: %+v", report.Payments)
+	}
+
+	syncing := ready
+	syncing.normal = false
+	syncing.synchronised = false
+	syncing.remote = 150
+	syncing.reservoir = false
+	syncing.payments = []payment.CurrencyStatus{
+		{Currency: currency.Bitcoin, LastUpdate: now.Add(-2 * maximumPaymentAge)},
+		{Currency: currency.Litecoin},
+	}
+
+	report = evaluateHealth(&syncing)
+	expected := []string{
+		"mode is not normal",
+		"connector is not synchronised",
+		"local block height is behind upstream",
+		"reservoir is disabled",
+		"payment handler is stale: BTC",
+		"payment handler is stale: LTC",
+	}
+	if report.Ready {
+		t.Errorf("expected not ready: %+v", report)
+	}
+	if !reflect.DeepEqual(expected, report.Reasons) {
+		t.Errorf("reasons: %q  expected: %q", report.Reasons, expected)
+	}
+}
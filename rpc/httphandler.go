@@ -9,6 +9,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/rpc"
 	"net/rpc/jsonrpc"
@@ -20,10 +21,10 @@ import (
 	"github.com/bitmark-inc/bitmarkd/block"
 	"github.com/bitmark-inc/bitmarkd/difficulty"
 	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/payment"
 	"github.com/bitmark-inc/bitmarkd/peer"
 	"github.com/bitmark-inc/bitmarkd/reservoir"
 	"github.com/bitmark-inc/bitmarkd/util"
-	"github.com/bitmark-inc/bitmarkd/zmqutil"
 	"github.com/bitmark-inc/logger"
 )
 
@@ -33,6 +34,9 @@ const (
 	maximumCount = 100
 )
 
+// limit the size of an HTTP RPC request body
+const maximumRequestBytes = 1 << 20
+
 // type to allow rpc system to interface to http request
 type InternalConnection struct {
 	in  io.Reader
@@ -51,11 +55,13 @@ func (c *InternalConnection) Close() error {
 
 // the argument passed to the handlers
 type httpHandler struct {
-	log     *logger.L
-	server  *rpc.Server
-	start   time.Time
-	version string
-	allow   map[string]map[string]struct{}
+	log      *logger.L
+	server   *rpc.Server
+	jsonRPC2 *jsonRPC2Server
+	limits   *clientLimits
+	start    time.Time
+	version  string
+	allow    map[string]map[string]struct{}
 }
 
 // this matches anything not matched and returns error
@@ -64,22 +70,54 @@ func (s *httpHandler) root(w http.ResponseWriter, r *http.Request) {
 }
 
 // performs a call to any normal RPC
+//
+// JSON-RPC 2.0 requests (including batches) are detected by their
+// "jsonrpc" member, anything else is processed as JSON-RPC 1.0
 func (s *httpHandler) rpc(w http.ResponseWriter, r *http.Request) {
 	if http.MethodPost != r.Method {
 		sendMethodNotAllowed(w)
 		return
 	}
 
-	server := s.server
-
 	connectionCount.Increment()
 	defer connectionCount.Decrement()
 
-	serverCodec := jsonrpc.NewServerCodec(&InternalConnection{in: r.Body, out: w})
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maximumRequestBytes))
+	if nil != err {
+		sendError(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	isVersion2 := isJSONRPC2(body)
+
+	count := 1
+	if isVersion2 {
+		count = callCount(body)
+	}
+	if !s.limits.allowHTTP(w, r, count) {
+		return
+	}
+
+	if isVersion2 {
+		response := s.jsonRPC2.serve(body)
+		if nil == response {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+		return
+	}
+
+	server := s.server
+
+	serverCodec := newMeteredCodec(jsonrpc.NewServerCodec(&InternalConnection{in: bytes.NewReader(body), out: w}))
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.WriteHeader(http.StatusOK)
-	err := server.ServeRequest(serverCodec)
+	err = server.ServeRequest(serverCodec)
 	if nil != err {
 		sendInternalServerError(w)
 		return
@@ -175,21 +213,26 @@ allow_access:
 	defer connectionCount.Decrement()
 
 	type reply struct {
-		ConnectedTo []*zmqutil.Connected `json:"connectedTo"`
+		ConnectedTo []*peer.Connected `json:"connectedTo"`
+		Throttled   []peer.Throttled  `json:"throttled"`
 	}
 
 	var info reply
 
 	info.ConnectedTo = peer.FetchConnectors()
+	info.Throttled = peer.FetchThrottled()
 
 	sendReply(w, info)
 }
 
 // to output peer data
 type entry struct {
-	PublicKey string    `json:"publicKey"`
-	Listeners []string  `json:"listeners"`
-	Timestamp time.Time `json:"timestamp"`
+	PublicKey  string                `json:"publicKey"`
+	Listeners  []string              `json:"listeners"`
+	Timestamp  time.Time             `json:"timestamp"`
+	Score      float64               `json:"score"`
+	Quality    float64               `json:"quality"`
+	Statistics *announce.QualityItem `json:"statistics,omitempty"`
 }
 
 // GET to find data on all peers seen in the announcer
@@ -198,6 +241,7 @@ type entry struct {
 // query parameters:
 //   public_key=<64-hex-characters>   [32 byte public key in hex]
 //   count=<int>                      [1..100  default: 10]
+//   banned=true                      [list current bans instead]
 func (s *httpHandler) peers(w http.ResponseWriter, r *http.Request) {
 	if http.MethodGet != r.Method {
 		sendMethodNotAllowed(w)
@@ -222,6 +266,12 @@ allow_access:
 
 	r.ParseForm()
 
+	// banned peers are not in the announcer so are listed separately
+	if "true" == r.Form.Get("banned") {
+		sendReply(w, announce.Bans())
+		return
+	}
+
 	// public_key parsing
 	startkey := []byte{}
 	k, err := hex.DecodeString(r.Form.Get("public_key"))
@@ -264,18 +314,93 @@ item_loop:
 			lPack = lPack[n:]
 		}
 
+		quality, statistics := announce.Quality(publicKey)
 		peers = append(peers, entry{
-			PublicKey: p,
-			Listeners: lc,
-			Timestamp: timestamp,
+			PublicKey:  p,
+			Listeners:  lc,
+			Timestamp:  timestamp,
+			Score:      announce.Score(publicKey),
+			Quality:    quality,
+			Statistics: statistics,
 		})
 	}
 
 	sendReply(w, peers)
 }
 
+// GET statistics in the Prometheus text format
+// (restricted to local_allow)
+func (s *httpHandler) metrics(w http.ResponseWriter, r *http.Request) {
+	if http.MethodGet != r.Method {
+		sendMethodNotAllowed(w)
+		return
+	}
+
+	last := strings.LastIndex(r.RemoteAddr, ":")
+	if last >= 0 {
+		addr := r.RemoteAddr[:last]
+		if _, ok := s.allow["metrics"][addr]; ok {
+			goto allow_access
+		}
+	}
+	s.log.Warnf("Deny access: %q", r.RemoteAddr)
+	sendForbidden(w)
+	return // *IMPORTANT*
+
+allow_access:
+
+	var m metricsWriter
+
+	m.family("bitmarkd_info", "gauge", "Version and chain of this node.")
+	m.sample("bitmarkd_info", 1, "version", s.version, "chain", mode.ChainName())
+
+	m.family("bitmarkd_mode", "gauge", "Current operating mode.")
+	m.sample("bitmarkd_mode", 1, "mode", mode.String())
+
+	m.single("bitmarkd_uptime_seconds", "gauge", "Time since the server started.", time.Since(s.start).Seconds())
+
+	m.family("bitmarkd_block_height", "gauge", "Block height of the local chain and of the highest peer.")
+	m.sample("bitmarkd_block_height", float64(block.GetHeight()), "source", "local")
+	m.sample("bitmarkd_block_height", float64(peer.BlockHeight()), "source", "remote")
+
+	m.single("bitmarkd_difficulty", "gauge", "Current difficulty.", difficulty.Current.Reciprocal())
+
+	pending, verified := reservoir.ReadCounters()
+	m.family("bitmarkd_reservoir_transactions", "gauge", "Transactions in the reservoir by state.")
+	m.sample("bitmarkd_reservoir_transactions", float64(pending), "state", "pending")
+	m.sample("bitmarkd_reservoir_transactions", float64(verified), "state", "verified")
+
+	incoming, outgoing := peer.GetCounts()
+	m.family("bitmarkd_peers", "gauge", "Peer connections by direction.")
+	m.sample("bitmarkd_peers", float64(incoming), "direction", "incoming")
+	m.sample("bitmarkd_peers", float64(outgoing), "direction", "outgoing")
+
+	m.single("bitmarkd_upstream_connections", "gauge", "Upstream peers currently connected.", float64(len(peer.FetchConnectors())))
+	m.single("bitmarkd_rpc_connections", "gauge", "RPC requests currently in progress.", float64(connectionCount.Uint64()))
+
+	metrics.write(&m)
+
+	m.family("bitmarkd_rate_limit_rejections_total", "counter", "Requests rejected by rate limiting by class.")
+	m.sample("bitmarkd_rate_limit_rejections_total", float64(keyRejections.Uint64()), "class", "key")
+	m.sample("bitmarkd_rate_limit_rejections_total", float64(addressRejections.Uint64()), "class", "address")
+	m.sample("bitmarkd_rate_limit_rejections_total", float64(serviceRejections.Uint64()), "class", "service")
+
+	writePaymentMetrics(&m, payment.ReadStatus())
+	writeRuntimeMetrics(&m)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write(m.Bytes())
+}
+
 // send an JSON encoded reply
 func sendReply(w http.ResponseWriter, data interface{}) {
+	sendStatusReply(w, http.StatusOK, data)
+}
+
+// a JSON reply with a specific status code
+func sendStatusReply(w http.ResponseWriter, code int, data interface{}) {
 	text, err := json.Marshal(data)
 	if nil != err {
 		sendInternalServerError(w)
@@ -284,7 +409,7 @@ func sendReply(w http.ResponseWriter, data interface{}) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(code)
 	w.Write(text)
 }
 
@@ -292,6 +417,9 @@ func sendReply(w http.ResponseWriter, data interface{}) {
 func sendNotFound(w http.ResponseWriter) {
 	sendError(w, "not found", http.StatusNotFound)
 }
+func sendBadRequest(w http.ResponseWriter) {
+	sendError(w, "bad request", http.StatusBadRequest)
+}
 func sendMethodNotAllowed(w http.ResponseWriter) {
 	sendError(w, "method not allowed", http.StatusMethodNotAllowed)
 }
@@ -325,3 +453,21 @@ func sendError(w http.ResponseWriter, message string, code int) {
 	w.WriteHeader(code)
 	w.Write(text)
 }
+
+// machine-readable description of the JSON-RPC 2.0 methods
+//
+// the same document is returned by the rpc.discover method
+func (s *httpHandler) openRPC(w http.ResponseWriter, r *http.Request) {
+	if http.MethodGet != r.Method {
+		sendMethodNotAllowed(w)
+		return
+	}
+
+	if nil == s.jsonRPC2.discovery {
+		sendNotFound(w)
+		return
+	}
+
+	// only changes with a new version of the program
+	sendCacheableReply(w, r, s.jsonRPC2.discovery, true)
+}
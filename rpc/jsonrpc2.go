@@ -0,0 +1,379 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/logger"
+)
+
+// JSON-RPC 2.0 protocol version string
+const jsonRPC2Version = "2.0"
+
+// limit on the number of calls in a single batch
+const maximumBatchCount = 100
+
+// standard JSON-RPC 2.0 error codes
+const (
+	jsonRPC2ParseError     = -32700
+	jsonRPC2InvalidRequest = -32600
+	jsonRPC2MethodNotFound = -32601
+	jsonRPC2InvalidParams  = -32602
+	jsonRPC2InternalError  = -32603
+)
+
+// implementation defined server errors, one for each class of fault
+const (
+	jsonRPC2ServerError   = -32000 // error not from fault package
+	jsonRPC2ExistsError   = -32001
+	jsonRPC2InvalidError  = -32002
+	jsonRPC2LengthError   = -32003
+	jsonRPC2NotFoundError = -32004
+	jsonRPC2ProcessError  = -32005
+	jsonRPC2RecordError   = -32006
+	jsonRPC2RateLimiting  = -32029
+)
+
+// a single JSON-RPC 2.0 request
+//
+// params may be either a named object or an array containing exactly
+// one object, the latter to accept the same layout as JSON-RPC 1.0
+//
+// only a request without an id is a notification, an id of null is
+// kept as the literal null and is answered
+type jsonRPC2Request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Id      json.RawMessage `json:"id,omitempty"`
+}
+
+// a single JSON-RPC 2.0 response
+type jsonRPC2Response struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonRPC2Error  `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+// the error object of a response
+type jsonRPC2Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// a method of a registered service
+//
+// the same rules as net/rpc apply: exported method of an exported
+// type, two arguments the second of which is a pointer and a single
+// error return value
+type jsonRPC2Method struct {
+	receiver  reflect.Value
+	function  reflect.Value
+	argType   reflect.Type
+	replyType reflect.Type
+}
+
+// dispatcher for JSON-RPC 2.0 requests
+type jsonRPC2Server struct {
+	log       *logger.L
+	methods   map[string]*jsonRPC2Method
+	discovery *openRPCDocument // reply to rpc.discover, nil if not served
+}
+
+var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+
+// create a server for all methods of the services
+func newJSONRPC2Server(log *logger.L, services []interface{}) *jsonRPC2Server {
+	server := &jsonRPC2Server{
+		log:     log,
+		methods: make(map[string]*jsonRPC2Method),
+	}
+	for _, service := range services {
+		server.register(service)
+	}
+	return server
+}
+
+// add all suitable methods of a service
+func (server *jsonRPC2Server) register(service interface{}) {
+	for name, method := range serviceMethods(service) {
+		server.methods[name] = method
+	}
+}
+
+// find all suitable methods of a service as "Type.Method"
+func serviceMethods(service interface{}) map[string]*jsonRPC2Method {
+
+	methods := make(map[string]*jsonRPC2Method)
+
+	receiver := reflect.ValueOf(service)
+	serviceType := reflect.TypeOf(service)
+	name := reflect.Indirect(receiver).Type().Name()
+
+method_loop:
+	for i := 0; i < serviceType.NumMethod(); i += 1 {
+		method := serviceType.Method(i)
+		mType := method.Type
+
+		if "" != method.PkgPath || 3 != mType.NumIn() || 1 != mType.NumOut() {
+			continue method_loop
+		}
+		if reflect.Ptr != mType.In(2).Kind() || typeOfError != mType.Out(0) {
+			continue method_loop
+		}
+
+		methods[name+"."+method.Name] = &jsonRPC2Method{
+			receiver:  receiver,
+			function:  method.Func,
+			argType:   mType.In(1),
+			replyType: mType.In(2).Elem(),
+		}
+	}
+	return methods
+}
+
+// process a request body that is either a single request or a batch
+//
+// returns nil if there is nothing to send back, i.e. only notifications
+func (server *jsonRPC2Server) serve(body []byte) []byte {
+
+	body = bytes.TrimSpace(body)
+
+	if 0 == len(body) || '[' != body[0] {
+		response := server.serveSingle(body)
+		if nil == response {
+			return nil
+		}
+		return marshalResponse(response)
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); nil != err {
+		return marshalResponse(newErrorResponse(nil, jsonRPC2ParseError, "parse error"))
+	}
+	if 0 == len(batch) {
+		return marshalResponse(newErrorResponse(nil, jsonRPC2InvalidRequest, "invalid request"))
+	}
+	if len(batch) > maximumBatchCount {
+		return marshalResponse(newErrorResponse(nil, jsonRPC2InvalidRequest, fault.ErrTooManyItemsToProcess.Error()))
+	}
+
+	responses := make([]*jsonRPC2Response, 0, len(batch))
+	for _, item := range batch {
+		response := server.serveSingle(item)
+		if nil != response {
+			responses = append(responses, response)
+		}
+	}
+	if 0 == len(responses) {
+		return nil
+	}
+	return marshalResponse(responses)
+}
+
+// decode and call one request
+//
+// returns nil for a valid notification
+func (server *jsonRPC2Server) serveSingle(body []byte) *jsonRPC2Response {
+
+	var request jsonRPC2Request
+	if err := json.Unmarshal(body, &request); nil != err {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return newErrorResponse(nil, jsonRPC2ParseError, "parse error")
+		}
+		return newErrorResponse(nil, jsonRPC2InvalidRequest, "invalid request")
+	}
+
+	if jsonRPC2Version != request.Version || "" == request.Method {
+		return newErrorResponse(request.Id, jsonRPC2InvalidRequest, "invalid request")
+	}
+
+	start := time.Now()
+	result, rpcErr := server.call(&request)
+	metrics.observe(protocolJSONRPC2, request.Method, time.Since(start), nil != rpcErr)
+
+	// notifications never get a response even on error
+	if 0 == len(request.Id) {
+		return nil
+	}
+
+	if nil != rpcErr {
+		return &jsonRPC2Response{
+			Version: jsonRPC2Version,
+			Error:   rpcErr,
+			Id:      request.Id,
+		}
+	}
+	return &jsonRPC2Response{
+		Version: jsonRPC2Version,
+		Result:  result,
+		Id:      request.Id,
+	}
+}
+
+// call the method with decoded arguments
+func (server *jsonRPC2Server) call(request *jsonRPC2Request) (interface{}, *jsonRPC2Error) {
+
+	if discoverMethod == request.Method && nil != server.discovery {
+		return server.discovery, nil
+	}
+
+	method, ok := server.methods[request.Method]
+	if !ok {
+		return nil, &jsonRPC2Error{
+			Code:    jsonRPC2MethodNotFound,
+			Message: "method not found",
+			Data:    request.Method,
+		}
+	}
+
+	params := bytes.TrimSpace(request.Params)
+	if len(params) > 0 && '[' == params[0] {
+		var list []json.RawMessage
+		if err := json.Unmarshal(params, &list); nil != err || len(list) > 1 {
+			return nil, &jsonRPC2Error{
+				Code:    jsonRPC2InvalidParams,
+				Message: "invalid params",
+			}
+		}
+		params = nil
+		if 1 == len(list) {
+			params = list[0]
+		}
+	}
+
+	var argv reflect.Value
+	isPointer := reflect.Ptr == method.argType.Kind()
+	if isPointer {
+		argv = reflect.New(method.argType.Elem())
+	} else {
+		argv = reflect.New(method.argType)
+	}
+	if len(params) > 0 && "null" != string(params) {
+		if err := json.Unmarshal(params, argv.Interface()); nil != err {
+			return nil, &jsonRPC2Error{
+				Code:    jsonRPC2InvalidParams,
+				Message: "invalid params",
+				Data:    err.Error(),
+			}
+		}
+	}
+	if !isPointer {
+		argv = argv.Elem()
+	}
+
+	replyv := reflect.New(method.replyType)
+
+	server.log.Debugf("JSON-RPC 2.0 call: %s", request.Method)
+
+	out := method.function.Call([]reflect.Value{method.receiver, argv, replyv})
+	if err, _ := out[0].Interface().(error); nil != err {
+		return nil, errorFromFault(err)
+	}
+
+	return replyv.Interface(), nil
+}
+
+// map an error returned by a service to a JSON-RPC 2.0 error object
+func errorFromFault(err error) *jsonRPC2Error {
+
+	if e, ok := err.(*rateLimitError); ok {
+		return &jsonRPC2Error{
+			Code:    jsonRPC2RateLimiting,
+			Message: e.Error(),
+			Data: map[string]int{
+				"retryAfter": e.seconds(),
+			},
+		}
+	}
+
+	code := jsonRPC2ServerError
+	switch {
+	case fault.ErrRateLimiting == err:
+		code = jsonRPC2RateLimiting
+	case fault.IsErrExists(err):
+		code = jsonRPC2ExistsError
+	case fault.IsErrInvalid(err):
+		code = jsonRPC2InvalidError
+	case fault.IsErrLength(err):
+		code = jsonRPC2LengthError
+	case fault.IsErrNotFound(err):
+		code = jsonRPC2NotFoundError
+	case fault.IsErrProcess(err):
+		code = jsonRPC2ProcessError
+	case fault.IsErrRecord(err):
+		code = jsonRPC2RecordError
+	}
+
+	return &jsonRPC2Error{
+		Code:    code,
+		Message: err.Error(),
+	}
+}
+
+// build a response containing only an error
+func newErrorResponse(id json.RawMessage, code int, message string) *jsonRPC2Response {
+	if 0 == len(id) {
+		id = json.RawMessage("null")
+	}
+	return &jsonRPC2Response{
+		Version: jsonRPC2Version,
+		Error: &jsonRPC2Error{
+			Code:    code,
+			Message: message,
+		},
+		Id: id,
+	}
+}
+
+// encode a response or batch of responses
+func marshalResponse(response interface{}) []byte {
+	text, err := json.Marshal(response)
+	if nil != err {
+		// manually composed error just incase JSON fails
+		return []byte(`{"jsonrpc":"2.0","error":{"code":-32603,"message":"internal error"},"id":null}`)
+	}
+	return text
+}
+
+// the number of calls in a request, batches are counted as the
+// number of items
+func callCount(body []byte) int {
+	body = bytes.TrimSpace(body)
+	if 0 == len(body) || '[' != body[0] {
+		return 1
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); nil != err || 0 == len(batch) {
+		return 1
+	}
+	return len(batch)
+}
+
+// determine if a request body is JSON-RPC 2.0, i.e. a batch or an
+// object with the correct "jsonrpc" member
+func isJSONRPC2(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	if 0 == len(body) {
+		return false
+	}
+	if '[' == body[0] {
+		return true
+	}
+	var probe struct {
+		Version string `json:"jsonrpc"`
+	}
+	if err := json.Unmarshal(body, &probe); nil != err {
+		return false
+	}
+	return jsonRPC2Version == probe.Version
+}
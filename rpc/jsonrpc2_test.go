@@ -0,0 +1,172 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+// a service to exercise the dispatcher
+type Echo struct {
+	calls int
+}
+
+type EchoArguments struct {
+	Text string `json:"text"`
+}
+
+type EchoReply struct {
+	Text string `json:"text"`
+}
+
+func (echo *Echo) Say(arguments *EchoArguments, reply *EchoReply) error {
+	echo.calls += 1
+	if "" == arguments.Text {
+		return fault.ErrMissingParameters
+	}
+	reply.Text = arguments.Text
+	return nil
+}
+
+func (echo *Echo) Limited(arguments *EchoArguments, reply *EchoReply) error {
+	return fault.ErrRateLimiting
+}
+
+// not a valid RPC method so must not be registered
+func (echo *Echo) Count() int {
+	return echo.calls
+}
+
+func TestJSONRPC2Single(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	echo := &Echo{}
+	server := newJSONRPC2Server(log, []interface{}{echo})
+
+	if _, ok := server.methods["Echo.Count"]; ok {
+		t.Errorf("invalid method was registered")
+	}
+
+	tests := []struct {
+		request  string
+		expected string
+	}{
+		{
+			`{"jsonrpc":"2.0","method":"Echo.Say","params":{"text":"hello"},"id":1}`,
+			`{"jsonrpc":"2.0","result":{"text":"hello"},"id":1}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"Echo.Say","params":[{"text":"hi"}],"id":"a"}`,
+			`{"jsonrpc":"2.0","result":{"text":"hi"},"id":"a"}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"Echo.Say","params":{},"id":2}`,
+			`{"jsonrpc":"2.0","error":{"code":-32003,"message":"missing parameters"},"id":2}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"Echo.Limited","id":3}`,
+			`{"jsonrpc":"2.0","error":{"code":-32029,"message":"rate limiting"},"id":3}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"Echo.Shout","id":4}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found","data":"Echo.Shout"},"id":4}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"Echo.Say","params":[{"text":"a"},{"text":"b"}],"id":5}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid params"},"id":5}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"Echo.Say","params":{"text":"null"},"id":null}`,
+			`{"jsonrpc":"2.0","result":{"text":"null"},"id":null}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"Echo.Shout","id":null}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found","data":"Echo.Shout"},"id":null}`,
+		},
+		{
+			`{"jsonrpc":"1.0","method":"Echo.Say","id":6}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":6}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method"`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"parse error"},"id":null}`,
+		},
+		{
+			`[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}`,
+		},
+	}
+
+	for i, item := range tests {
+		actual := string(server.serve([]byte(item.request)))
+		if item.expected != actual {
+			t.Errorf("%d: actual: %s  expected: %s", i, actual, item.expected)
+		}
+	}
+}
+
+func TestJSONRPC2Batch(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	echo := &Echo{}
+	server := newJSONRPC2Server(log, []interface{}{echo})
+
+	request := `[
+  {"jsonrpc":"2.0","method":"Echo.Say","params":{"text":"one"},"id":1},
+  {"jsonrpc":"2.0","method":"Echo.Say","params":{"text":"notify"}},
+  {"jsonrpc":"2.0","method":"Echo.Say","params":{"text":"two"},"id":2},
+  1
+]`
+	expected := `[` +
+		`{"jsonrpc":"2.0","result":{"text":"one"},"id":1},` +
+		`{"jsonrpc":"2.0","result":{"text":"two"},"id":2},` +
+		`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}` +
+		`]`
+
+	actual := string(server.serve([]byte(request)))
+	if expected != actual {
+		t.Errorf("actual: %s  expected: %s", actual, expected)
+	}
+	if 3 != echo.calls {
+		t.Errorf("calls: %d  expected: 3", echo.calls)
+	}
+
+	// only notifications: no response at all
+	response := server.serve([]byte(`[{"jsonrpc":"2.0","method":"Echo.Say","params":{"text":"x"}}]`))
+	if nil != response {
+		t.Errorf("unexpected response: %s", response)
+	}
+}
+
+func TestIsJSONRPC2(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected bool
+	}{
+		{`{"jsonrpc":"2.0","method":"Node.Info","id":1}`, true},
+		{` [{"jsonrpc":"2.0","method":"Node.Info","id":1}]`, true},
+		{`{"method":"Node.Info","params":[{}],"id":1}`, false},
+		{``, false},
+		{`junk`, false},
+	}
+
+	for i, item := range tests {
+		if actual := isJSONRPC2([]byte(item.body)); item.expected != actual {
+			t.Errorf("%d: %q  actual: %v  expected: %v", i, item.body, actual, item.expected)
+		}
+	}
+
+	// check error objects encode as required by the specification
+	e := errorFromFault(fault.ErrAssetNotFound)
+	text, _ := json.Marshal(e)
+	if `{"code":-32004,"message":"asset not found"}` != string(text) {
+		t.Errorf("unexpected error encoding: %s", text)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/listener"
+	"github.com/bitmark-inc/logger"
+)
+
+// TLS listener for client_rpc
+//
+// each connection is passed to the callback as a net.Conn so that
+// calls can be limited by the address of the client; otherwise this
+// behaves as listener.MultiListener: tcp4 or tcp6 follows the host
+// IP, the number of connections and the bandwidth are limited and a
+// repeatedly failing accept panics
+type connectionListener struct {
+	sync.Mutex
+
+	log              *logger.L
+	channels         []listenChannel
+	tlsConfiguration *tls.Config
+	limiter          *listener.Limiter
+	callback         listener.Callback
+
+	listeners   []*net.TCPListener
+	connections map[net.Conn]struct{}
+	running     sync.WaitGroup
+	started     bool
+}
+
+// an address to listen on and its TCP version
+type listenChannel struct {
+	tcp     string
+	address string
+}
+
+// number of times a failed accept is retried before panicking
+const acceptRetries = 3
+
+// check the listen addresses, nothing is opened until Start
+func newConnectionListener(name string, addresses []string, tlsConfiguration *tls.Config, limiter *listener.Limiter, callback listener.Callback) (*connectionListener, error) {
+
+	channels := make([]listenChannel, 0, len(addresses))
+	for _, address := range addresses {
+		host, _, err := net.SplitHostPort(address)
+		if nil != err {
+			return nil, err
+		}
+
+		// only IPs are supported not hostnames
+		tcp := "tcp"
+		if "" != host {
+			ip := net.ParseIP(host)
+			if nil == ip {
+				return nil, &net.AddrError{Err: "invalid IP address", Addr: address}
+			}
+			tcp = "tcp4"
+			if nil == ip.To4() {
+				tcp = "tcp6"
+			}
+		}
+		channels = append(channels, listenChannel{
+			tcp:     tcp,
+			address: address,
+		})
+	}
+
+	return &connectionListener{
+		log:              logger.New(name),
+		channels:         channels,
+		tlsConfiguration: tlsConfiguration,
+		limiter:          limiter,
+		callback:         callback,
+		connections:      make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// listen on all addresses, an address that cannot be opened is
+// skipped with a warning
+func (l *connectionListener) Start(argument interface{}) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.started {
+		l.log.Warn("already started")
+		return
+	}
+	l.started = true
+
+	for _, c := range l.channels {
+		address, err := net.ResolveTCPAddr(c.tcp, c.address)
+		if nil != err {
+			l.log.Warnf("listen on: %q  error: %s", c.address, err)
+			continue
+		}
+		ln, err := net.ListenTCP(c.tcp, address)
+		if nil != err {
+			l.log.Warnf("listen on: %q  error: %s", c.address, err)
+			continue
+		}
+		l.listeners = append(l.listeners, ln)
+
+		l.running.Add(1)
+		go l.accept(ln, argument)
+	}
+}
+
+// close the listeners and all open connections
+func (l *connectionListener) Stop() {
+	l.Lock()
+	if !l.started {
+		l.Unlock()
+		l.log.Warn("already stopped")
+		return
+	}
+	l.started = false
+
+	for _, ln := range l.listeners {
+		ln.Close()
+	}
+	l.listeners = nil
+	for conn := range l.connections {
+		conn.Close()
+	}
+	l.Unlock()
+
+	l.running.Wait()
+	l.log.Info("stopped")
+}
+
+// accept connections until the listener is closed
+func (l *connectionListener) accept(ln *net.TCPListener, argument interface{}) {
+	defer l.running.Done()
+
+	retries := acceptRetries
+
+accept_loop:
+	for {
+		tcpConn, err := ln.AcceptTCP()
+		if nil != err {
+			l.Lock()
+			stopped := !l.started
+			l.Unlock()
+			if stopped {
+				break accept_loop
+			}
+			l.log.Errorf("accept error: %s", err)
+			retries -= 1
+			if retries < 0 {
+				panic("accept failed")
+			}
+			time.Sleep(time.Second)
+			continue accept_loop
+		}
+
+		// restrict number of clients
+		if nil != l.limiter && !l.limiter.Increment() {
+			tcpConn.Close()
+			continue accept_loop
+		}
+
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(5 * time.Minute)
+		tcpConn.SetNoDelay(true)
+		tcpConn.SetLinger(10)
+
+		conn := tls.Server(tcpConn, l.tlsConfiguration)
+
+		l.Lock()
+		if !l.started {
+			l.Unlock()
+			conn.Close()
+			if nil != l.limiter {
+				l.limiter.Decrement()
+			}
+			break accept_loop
+		}
+		l.connections[conn] = struct{}{}
+		l.running.Add(1)
+		l.Unlock()
+
+		go l.serve(&limitedConn{Conn: conn, limiter: l.limiter}, argument)
+	}
+}
+
+// run the callback for one connection
+func (l *connectionListener) serve(conn *limitedConn, argument interface{}) {
+	defer l.running.Done()
+
+	defer func() {
+		l.Lock()
+		delete(l.connections, conn.Conn)
+		l.Unlock()
+		conn.Close()
+		if nil != l.limiter {
+			l.limiter.Decrement()
+		}
+	}()
+
+	l.callback(conn, argument)
+}
+
+// a connection whose reads are limited by the shared bandwidth
+type limitedConn struct {
+	net.Conn
+	limiter *listener.Limiter
+}
+
+// a read beyond the bandwidth limit closes the connection
+func (conn *limitedConn) Read(p []byte) (int, error) {
+	if nil != conn.limiter && !conn.limiter.RateLimit(len(p)) {
+		conn.Conn.Close()
+		return 0, fault.ErrRateLimiting
+	}
+	return conn.Conn.Read(p)
+}
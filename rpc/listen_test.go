@@ -0,0 +1,81 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"crypto/tls"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/certgen"
+	"github.com/bitmark-inc/listener"
+)
+
+func TestConnectionListenerAddress(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	cert, key, err := certgen.NewTLSCertPair("test", time.Now().Add(time.Hour), false, nil)
+	if nil != err {
+		t.Fatalf("certificate error: %s", err)
+	}
+	tlsConfiguration, _, err := getCertificate(log, "test", string(cert), string(key))
+	if nil != err {
+		t.Fatalf("get certificate error: %s", err)
+	}
+
+	addresses := make(chan string, 2)
+	callback := func(conn io.ReadWriteCloser, argument interface{}) {
+		buffer := make([]byte, 2)
+		io.ReadFull(conn, buffer) // completes the handshake
+		addresses <- connectionAddress(conn)
+	}
+
+	if _, err := newConnectionListener("test", []string{"localhost:0"}, tlsConfiguration, nil, callback); nil == err {
+		t.Errorf("host name was accepted")
+	}
+
+	// TCP version follows the host as for listener.MultiListener
+	all, err := newConnectionListener("test", []string{"127.0.0.1:0", "[::1]:0", ":0"}, tlsConfiguration, nil, callback)
+	if nil != err {
+		t.Fatalf("new listener error: %s", err)
+	}
+	for i, tcp := range []string{"tcp4", "tcp6", "tcp"} {
+		if tcp != all.channels[i].tcp {
+			t.Errorf("%d: %q  tcp: %q  expected: %q", i, all.channels[i].address, all.channels[i].tcp, tcp)
+		}
+	}
+
+	l, err := newConnectionListener("test", []string{"127.0.0.1:0"}, tlsConfiguration, listener.NewLimiter(2), callback)
+	if nil != err {
+		t.Fatalf("new listener error: %s", err)
+	}
+	l.Start(nil)
+	defer l.Stop()
+
+	if 1 != len(l.listeners) {
+		t.Fatalf("listeners: %d  expected: 1", len(l.listeners))
+	}
+	address := l.listeners[0].Addr().String()
+
+	// each connection has a new port but the same address
+	for i := 0; i < 2; i += 1 {
+		conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+		if nil != err {
+			t.Fatalf("%d: dial error: %s", i, err)
+		}
+		conn.Write([]byte("{}"))
+		select {
+		case a := <-addresses:
+			if "127.0.0.1" != a {
+				t.Errorf("%d: address: %q  expected: %q", i, a, "127.0.0.1")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d: callback was not called", i)
+		}
+		conn.Close()
+	}
+}
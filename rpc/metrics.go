@@ -0,0 +1,321 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"net/rpc"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/counter"
+	"github.com/bitmark-inc/bitmarkd/payment"
+)
+
+// protocol labels for call metrics
+const (
+	protocolRPC      = "rpc"      // JSON-RPC 1.0 on TCP or HTTPS
+	protocolJSONRPC2 = "jsonrpc2" // JSON-RPC 2.0 on HTTPS
+	protocolREST     = "rest"     // read-only REST on HTTPS
+)
+
+// label for any method that is not registered, this prevents clients
+// creating an unbounded number of series
+const unknownMethod = "unknown"
+
+// upper bounds of the latency histogram in seconds
+var latencyBuckets = [...]float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// call statistics for one protocol and method
+//
+// buckets are not cumulative and the last one counts all calls
+// slower than the largest bound
+type methodMetrics struct {
+	errors  counter.Counter
+	buckets [len(latencyBuckets) + 1]counter.Counter
+	sum     uint64 // nanoseconds
+}
+
+// record one completed call
+func (m *methodMetrics) observe(elapsed time.Duration, failed bool) {
+	seconds := elapsed.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets[:], seconds)
+	m.buckets[i].Increment()
+	atomic.AddUint64(&m.sum, uint64(elapsed))
+	if failed {
+		m.errors.Increment()
+	}
+}
+
+// all call statistics
+type callMetrics struct {
+	sync.RWMutex
+	methods map[string]*methodMetrics // protocol + " " + method
+}
+
+// the global metrics
+var metrics = callMetrics{
+	methods: make(map[string]*methodMetrics),
+}
+
+// rate-limit rejections by class
+var (
+	keyRejections     counter.Counter
+	addressRejections counter.Counter
+	serviceRejections counter.Counter
+)
+
+// create the series for each method so that all are present from
+// start up
+func (m *callMetrics) register(services []interface{}) {
+	m.Lock()
+	defer m.Unlock()
+
+	add := func(protocol string, method string) {
+		key := protocol + " " + method
+		if _, ok := m.methods[key]; !ok {
+			m.methods[key] = &methodMetrics{}
+		}
+	}
+
+	for _, service := range services {
+		for name := range serviceMethods(service) {
+			add(protocolRPC, name)
+			add(protocolJSONRPC2, name)
+		}
+	}
+	for _, route := range restRoutes {
+		add(protocolREST, route)
+	}
+	add(protocolRPC, unknownMethod)
+	add(protocolJSONRPC2, unknownMethod)
+	add(protocolREST, unknownMethod)
+}
+
+// record a call
+func (m *callMetrics) observe(protocol string, method string, elapsed time.Duration, failed bool) {
+	m.RLock()
+	mm, ok := m.methods[protocol+" "+method]
+	if !ok {
+		mm, ok = m.methods[protocol+" "+unknownMethod]
+	}
+	m.RUnlock()
+	if ok {
+		mm.observe(elapsed, failed)
+	}
+}
+
+// wrap a codec to time each call
+//
+// the standard RPC server reads all requests on one goroutine but
+// replies may be written from others
+type meteredCodec struct {
+	rpc.ServerCodec
+
+	sync.Mutex
+	started map[uint64]time.Time
+}
+
+func newMeteredCodec(codec rpc.ServerCodec) *meteredCodec {
+	return &meteredCodec{
+		ServerCodec: codec,
+		started:     make(map[uint64]time.Time),
+	}
+}
+
+func (codec *meteredCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := codec.ServerCodec.ReadRequestHeader(r)
+	if nil == err {
+		codec.Lock()
+		codec.started[r.Seq] = time.Now()
+		codec.Unlock()
+	}
+	return err
+}
+
+func (codec *meteredCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	codec.Lock()
+	start, ok := codec.started[r.Seq]
+	delete(codec.started, r.Seq)
+	codec.Unlock()
+
+	if ok {
+		metrics.observe(protocolRPC, r.ServiceMethod, time.Since(start), "" != r.Error)
+	}
+	return codec.ServerCodec.WriteResponse(r, body)
+}
+
+// output in the Prometheus text exposition format
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+// start a new metric family
+func (w *metricsWriter) family(name string, kind string, help string) {
+	w.WriteString("# HELP " + name + " " + help + "\n")
+	w.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// a single sample, labels are name, value pairs
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.WriteByte('\n')
+}
+
+// a single metric with one sample
+func (w *metricsWriter) single(name string, kind string, help string, value float64) {
+	w.family(name, kind, help)
+	w.sample(name, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// write all call statistics sorted by protocol and method
+func (m *callMetrics) write(w *metricsWriter) {
+	m.RLock()
+	keys := make([]string, 0, len(m.methods))
+	for key := range m.methods {
+		keys = append(keys, key)
+	}
+	m.RUnlock()
+	sort.Strings(keys)
+
+	type snapshot struct {
+		protocol string
+		method   string
+		errors   uint64
+		buckets  [len(latencyBuckets) + 1]uint64
+		count    uint64
+		sum      uint64
+	}
+	items := make([]snapshot, 0, len(keys))
+
+	m.RLock()
+	for _, key := range keys {
+		mm := m.methods[key]
+		s := snapshot{
+			errors: mm.errors.Uint64(),
+			sum:    atomic.LoadUint64(&mm.sum),
+		}
+		s.protocol, s.method = splitKey(key)
+		for i := range mm.buckets {
+			s.buckets[i] = mm.buckets[i].Uint64()
+			s.count += s.buckets[i]
+		}
+		items = append(items, s)
+	}
+	m.RUnlock()
+
+	w.family("bitmarkd_rpc_calls_total", "counter", "Completed calls by protocol and method.")
+	for _, s := range items {
+		w.sample("bitmarkd_rpc_calls_total", float64(s.count), "protocol", s.protocol, "method", s.method)
+	}
+
+	w.family("bitmarkd_rpc_errors_total", "counter", "Calls that returned an error by protocol and method.")
+	for _, s := range items {
+		w.sample("bitmarkd_rpc_errors_total", float64(s.errors), "protocol", s.protocol, "method", s.method)
+	}
+
+	name := "bitmarkd_rpc_duration_seconds"
+	w.family(name, "histogram", "Call latency by protocol and method.")
+	for _, s := range items {
+		cumulative := uint64(0)
+		for i, bound := range latencyBuckets {
+			cumulative += s.buckets[i]
+			w.sample(name+"_bucket", float64(cumulative), "protocol", s.protocol, "method", s.method, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		w.sample(name+"_bucket", float64(s.count), "protocol", s.protocol, "method", s.method, "le", "+Inf")
+		w.sample(name+"_sum", time.Duration(s.sum).Seconds(), "protocol", s.protocol, "method", s.method)
+		w.sample(name+"_count", float64(s.count), "protocol", s.protocol, "method", s.method)
+	}
+}
+
+func splitKey(key string) (string, string) {
+	n := strings.Index(key, " ")
+	return key[:n], key[n+1:]
+}
+
+// progress of each payment handler
+func writePaymentMetrics(w *metricsWriter, status []payment.CurrencyStatus) {
+
+	w.family("bitmarkd_payment_discovery", "gauge", "1 if payments are received from a discovery proxy, 0 if polling.")
+	for _, s := range status {
+		discovery := 0.0
+		if s.Discovery {
+			discovery = 1
+		}
+		w.sample("bitmarkd_payment_discovery", discovery, "currency", s.Currency.String())
+	}
+
+	w.family("bitmarkd_payment_block_height", "gauge", "Last currency block scanned for payments.")
+	for _, s := range status {
+		w.sample("bitmarkd_payment_block_height", float64(s.BlockHeight), "currency", s.Currency.String())
+	}
+
+	w.family("bitmarkd_payment_transactions_total", "counter", "Currency transactions inspected for payments.")
+	for _, s := range status {
+		w.sample("bitmarkd_payment_transactions_total", float64(s.Transactions), "currency", s.Currency.String())
+	}
+
+	w.family("bitmarkd_payment_payments_total", "counter", "Payments passed to the reservoir.")
+	for _, s := range status {
+		w.sample("bitmarkd_payment_payments_total", float64(s.Payments), "currency", s.Currency.String())
+	}
+
+	w.family("bitmarkd_payment_last_update_timestamp_seconds", "gauge", "Unix time of the last payment activity, 0 if none.")
+	for _, s := range status {
+		t := 0.0
+		if !s.LastUpdate.IsZero() {
+			t = float64(s.LastUpdate.Unix())
+		}
+		w.sample("bitmarkd_payment_last_update_timestamp_seconds", t, "currency", s.Currency.String())
+	}
+}
+
+// Go runtime statistics as reported by memstats in the log
+func writeRuntimeMetrics(w *metricsWriter) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	w.family("go_info", "gauge", "Information about the Go environment.")
+	w.sample("go_info", 1, "version", runtime.Version())
+
+	w.single("go_goroutines", "gauge", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	w.single("go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.", float64(m.Alloc))
+	w.single("go_memstats_alloc_bytes_total", "counter", "Total number of bytes allocated, even if freed.", float64(m.TotalAlloc))
+	w.single("go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.", float64(m.Sys))
+	w.single("go_memstats_mallocs_total", "counter", "Total number of mallocs.", float64(m.Mallocs))
+	w.single("go_memstats_frees_total", "counter", "Total number of frees.", float64(m.Frees))
+	w.single("go_memstats_heap_alloc_bytes", "gauge", "Number of heap bytes allocated and still in use.", float64(m.HeapAlloc))
+	w.single("go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.", float64(m.HeapInuse))
+	w.single("go_memstats_heap_objects", "gauge", "Number of allocated objects.", float64(m.HeapObjects))
+	w.single("go_memstats_stack_inuse_bytes", "gauge", "Number of bytes in use by the stack allocator.", float64(m.StackInuse))
+	w.single("go_memstats_next_gc_bytes", "gauge", "Number of heap bytes when next garbage collection will take place.", float64(m.NextGC))
+	w.single("go_memstats_last_gc_time_seconds", "gauge", "Number of seconds since 1970 of last garbage collection.", float64(m.LastGC)/1e9)
+	w.single("go_memstats_gc_cpu_fraction", "gauge", "The fraction of this program's available CPU time used by the GC since the program started.", m.GCCPUFraction)
+	w.single("go_gc_cycles_total", "counter", "Number of completed GC cycles.", float64(m.NumGC))
+	w.single("go_gc_pause_seconds_total", "counter", "Cumulative time spent in GC stop-the-world pauses.", time.Duration(m.PauseTotalNs).Seconds())
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/currency"
+	"github.com/bitmark-inc/bitmarkd/payment"
+)
+
+func TestMetricsCalls(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	m := callMetrics{
+		methods: make(map[string]*methodMetrics),
+	}
+	m.register(createServices(log, "test"))
+
+	m.observe(protocolRPC, "Node.Info", 2*time.Millisecond, false)
+	m.observe(protocolRPC, "Node.Info", 2*time.Second, true)
+	m.observe(protocolJSONRPC2, "No.Such.Method", time.Microsecond, true)
+	m.observe(protocolREST, routeBlock, 20*time.Millisecond, false)
+
+	if _, ok := m.methods[protocolJSONRPC2+" No.Such.Method"]; ok {
+		t.Errorf("unregistered method created a series")
+	}
+
+	var w metricsWriter
+	m.write(&w)
+	text := w.String()
+
+	expected := []string{
+		"# TYPE bitmarkd_rpc_calls_total counter\n",
+		`bitmarkd_rpc_calls_total{protocol="rpc",method="Node.Info"} 2` + "\n",
+		`bitmarkd_rpc_errors_total{protocol="rpc",method="Node.Info"} 1` + "\n",
+		`bitmarkd_rpc_calls_total{protocol="rpc",method="Bitmarks.Create"} 0` + "\n",
+		`bitmarkd_rpc_calls_total{protocol="jsonrpc2",method="unknown"} 1` + "\n",
+		`bitmarkd_rpc_errors_total{protocol="jsonrpc2",method="unknown"} 1` + "\n",
+		`bitmarkd_rpc_calls_total{protocol="rest",method="/v1/blocks/{number}"} 1` + "\n",
+		"# TYPE bitmarkd_rpc_duration_seconds histogram\n",
+		`bitmarkd_rpc_duration_seconds_bucket{protocol="rpc",method="Node.Info",le="0.001"} 0` + "\n",
+		`bitmarkd_rpc_duration_seconds_bucket{protocol="rpc",method="Node.Info",le="0.005"} 1` + "\n",
+		`bitmarkd_rpc_duration_seconds_bucket{protocol="rpc",method="Node.Info",le="1"} 1` + "\n",
+		`bitmarkd_rpc_duration_seconds_bucket{protocol="rpc",method="Node.Info",le="5"} 2` + "\n",
+		`bitmarkd_rpc_duration_seconds_bucket{protocol="rpc",method="Node.Info",le="+Inf"} 2` + "\n",
+		`bitmarkd_rpc_duration_seconds_sum{protocol="rpc",method="Node.Info"} 2.002` + "\n",
+		`bitmarkd_rpc_duration_seconds_count{protocol="rpc",method="Node.Info"} 2` + "\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(text, e) {
+			t.Errorf("missing: %q", e)
+		}
+	}
+}
+
+func TestMetricsWriter(t *testing.T) {
+
+	var w metricsWriter
+	w.single("a_value", "gauge", "A value.", 1.5)
+	w.sample("b_value", 3, "name", `x"y\z`+"\n", "other", "")
+
+	expected := "# HELP a_value A value.\n" +
+		"# TYPE a_value gauge\n" +
+		"a_value 1.5\n" +
+		`b_value{name="x\"y\\z\n",other=""} 3` + "\n"
+
+	if expected != w.String() {
+		t.Errorf("output: %q  expected: %q", w.String(), expected)
+	}
+}
+
+func TestMetricsPayment(t *testing.T) {
+
+	status := []payment.CurrencyStatus{
+		{
+			Currency:     currency.Bitcoin,
+			Discovery:    true,
+			BlockHeight:  0,
+			Transactions: 12,
+			Payments:     2,
+			LastUpdate:   time.Unix(1500000000, 0),
+		},
+		{
+			Currency:    currency.Litecoin,
+			BlockHeight: 1234,
+		},
+	}
+
+	var w metricsWriter
+	writePaymentMetrics(&w, status)
+	text := w.String()
+
+	expected := []string{
+		`bitmarkd_payment_discovery{currency="BTC"} 1` + "\n",
+		`bitmarkd_payment_discovery{currency="LTC"} 0` + "\n",
+		`bitmarkd_payment_block_height{currency="LTC"} 1234` + "\n",
+		`bitmarkd_payment_transactions_total{currency="BTC"} 12` + "\n",
+		`bitmarkd_payment_payments_total{currency="BTC"} 2` + "\n",
+		`bitmarkd_payment_last_update_timestamp_seconds{currency="BTC"} 1.5e+09` + "\n",
+		`bitmarkd_payment_last_update_timestamp_seconds{currency="LTC"} 0` + "\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(text, e) {
+			t.Errorf("missing: %q", e)
+		}
+	}
+}
+
+func TestMetricsAccess(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	handler := &httpHandler{
+		log: log,
+		allow: map[string]map[string]struct{}{
+			"metrics": {"10.1.2.3": {}},
+		},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/bitmarkd/metrics", nil)
+	r.RemoteAddr = "127.0.0.1:4321"
+	w := httptest.NewRecorder()
+	handler.metrics(w, r)
+	if http.StatusForbidden != w.Code {
+		t.Errorf("code: %d  expected: %d", w.Code, http.StatusForbidden)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/bitmarkd/metrics", nil)
+	w = httptest.NewRecorder()
+	handler.metrics(w, r)
+	if http.StatusMethodNotAllowed != w.Code {
+		t.Errorf("code: %d  expected: %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding"
+	"encoding/json"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// version of the OpenRPC specification the document follows
+const openRPCVersion = "1.2.6"
+
+// the JSON-RPC 2.0 method that returns the document
+const discoverMethod = "rpc.discover"
+
+// an OpenRPC document describing all methods of the services
+type openRPCDocument struct {
+	OpenRPC    string            `json:"openrpc"`
+	Info       openRPCInfo       `json:"info"`
+	Methods    []openRPCMethod   `json:"methods"`
+	Components openRPCComponents `json:"components"`
+}
+
+type openRPCInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type openRPCMethod struct {
+	Name           string             `json:"name"`
+	ParamStructure string             `json:"paramStructure"`
+	Params         []openRPCContent   `json:"params"`
+	Result         openRPCContent     `json:"result"`
+	Errors         []openRPCReference `json:"errors"`
+}
+
+type openRPCContent struct {
+	Name   string      `json:"name"`
+	Schema *jsonSchema `json:"schema"`
+}
+
+type openRPCReference struct {
+	Ref string `json:"$ref"`
+}
+
+type openRPCComponents struct {
+	Schemas map[string]*jsonSchema  `json:"schemas"`
+	Errors  map[string]openRPCError `json:"errors"`
+}
+
+type openRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// the subset of JSON Schema needed to describe the encoding/json
+// output of the argument and reply types
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+}
+
+// errors that any method can return, the server error classes
+// correspond to the fault package error types
+var openRPCErrors = map[string]openRPCError{
+	"InvalidParams": {jsonRPC2InvalidParams, "invalid params"},
+	"ServerError":   {jsonRPC2ServerError, "error not from a fault class"},
+	"ExistsError":   {jsonRPC2ExistsError, "fault.ExistsError"},
+	"InvalidError":  {jsonRPC2InvalidError, "fault.InvalidError"},
+	"LengthError":   {jsonRPC2LengthError, "fault.LengthError"},
+	"NotFoundError": {jsonRPC2NotFoundError, "fault.NotFoundError"},
+	"ProcessError":  {jsonRPC2ProcessError, "fault.ProcessError"},
+	"RecordError":   {jsonRPC2RecordError, "fault.RecordError"},
+	"RateLimiting":  {jsonRPC2RateLimiting, "rate limiting, data.retryAfter is in seconds"},
+}
+
+var (
+	typeOfTime          = reflect.TypeOf(time.Time{})
+	typeOfTextMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	typeOfJSONMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// build the document from the methods of the services
+//
+// params are described by name, i.e. as the members of the params
+// object, which is the argument structure
+func describeServices(services []interface{}, version string) *openRPCDocument {
+
+	document := &openRPCDocument{
+		OpenRPC: openRPCVersion,
+		Info: openRPCInfo{
+			Title:       "bitmarkd",
+			Description: "JSON-RPC 2.0 interface served by POST to /bitmarkd/rpc",
+			Version:     version,
+		},
+		Methods: []openRPCMethod{},
+		Components: openRPCComponents{
+			Schemas: make(map[string]*jsonSchema),
+			Errors:  openRPCErrors,
+		},
+	}
+
+	errorNames := make([]string, 0, len(openRPCErrors))
+	for name := range openRPCErrors {
+		errorNames = append(errorNames, name)
+	}
+	sort.Strings(errorNames)
+	errors := make([]openRPCReference, 0, len(errorNames))
+	for _, name := range errorNames {
+		errors = append(errors, openRPCReference{Ref: "#/components/errors/" + name})
+	}
+
+	for _, service := range services {
+		for name, method := range serviceMethods(service) {
+
+			argType := method.argType
+			if reflect.Ptr == argType.Kind() {
+				argType = argType.Elem()
+			}
+
+			params := []openRPCContent{}
+			if reflect.Struct == argType.Kind() {
+				properties := document.properties(argType)
+				names := make([]string, 0, len(properties))
+				for n := range properties {
+					names = append(names, n)
+				}
+				sort.Strings(names)
+				for _, n := range names {
+					params = append(params, openRPCContent{
+						Name:   n,
+						Schema: properties[n],
+					})
+				}
+			}
+
+			document.Methods = append(document.Methods, openRPCMethod{
+				Name:           name,
+				ParamStructure: "by-name",
+				Params:         params,
+				Result: openRPCContent{
+					Name:   "reply",
+					Schema: document.schema(method.replyType, false),
+				},
+				Errors: errors,
+			})
+		}
+	}
+
+	sort.Slice(document.Methods, func(i int, j int) bool {
+		return document.Methods[i].Name < document.Methods[j].Name
+	})
+
+	return document
+}
+
+// the schema for a type as encoded by encoding/json
+//
+// named types other than basic ones are added to the components and
+// referenced so that recursive types terminate; asString is the
+// ",string" tag option
+func (document *openRPCDocument) schema(t reflect.Type, asString bool) *jsonSchema {
+
+	for reflect.Ptr == t.Kind() {
+		t = t.Elem()
+	}
+
+	name := schemaName(t)
+	if "" == name {
+		return document.inlineSchema(t, asString)
+	}
+
+	if _, ok := document.Components.Schemas[name]; !ok {
+		document.Components.Schemas[name] = &jsonSchema{} // placeholder for recursion
+		document.Components.Schemas[name] = document.inlineSchema(t, false)
+	}
+	return &jsonSchema{
+		Ref: "#/components/schemas/" + name,
+	}
+}
+
+// the schema without using a reference for this level
+func (document *openRPCDocument) inlineSchema(t reflect.Type, asString bool) *jsonSchema {
+
+	if typeOfTime == t {
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	}
+
+	// custom marshalling: JSON is unknown, text is a string
+	pt := reflect.PtrTo(t)
+	if t.Implements(typeOfJSONMarshaler) || pt.Implements(typeOfJSONMarshaler) {
+		return &jsonSchema{}
+	}
+	if t.Implements(typeOfTextMarshaler) || pt.Implements(typeOfTextMarshaler) {
+		return &jsonSchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if asString {
+			return &jsonSchema{Type: "string", Pattern: "^(true|false)$"}
+		}
+		return &jsonSchema{Type: "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if asString {
+			return &jsonSchema{Type: "string", Pattern: "^-?[0-9]+$"}
+		}
+		return &jsonSchema{Type: "integer"}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if asString {
+			return &jsonSchema{Type: "string", Pattern: "^[0-9]+$"}
+		}
+		return &jsonSchema{Type: "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		if asString {
+			return &jsonSchema{Type: "string"}
+		}
+		return &jsonSchema{Type: "number"}
+
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+
+	case reflect.Slice:
+		if reflect.Uint8 == t.Elem().Kind() {
+			return &jsonSchema{Type: "string", Format: "byte"}
+		}
+		return &jsonSchema{Type: "array", Items: document.schema(t.Elem(), false)}
+
+	case reflect.Array:
+		return &jsonSchema{Type: "array", Items: document.schema(t.Elem(), false)}
+
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: document.schema(t.Elem(), false)}
+
+	case reflect.Struct:
+		return &jsonSchema{Type: "object", Properties: document.properties(t)}
+
+	default:
+		// interface: any JSON value
+		return &jsonSchema{}
+	}
+}
+
+// the members of a structure following the encoding/json rules for
+// tags and embedded structures
+func (document *openRPCDocument) properties(t reflect.Type) map[string]*jsonSchema {
+
+	properties := make(map[string]*jsonSchema)
+
+field_loop:
+	for i := 0; i < t.NumField(); i += 1 {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if "-" == tag {
+			continue field_loop
+		}
+		options := strings.Split(tag, ",")
+		name := options[0]
+
+		fieldType := field.Type
+		for reflect.Ptr == fieldType.Kind() {
+			fieldType = fieldType.Elem()
+		}
+
+		if field.Anonymous && "" == name && reflect.Struct == fieldType.Kind() {
+			for n, s := range document.properties(fieldType) {
+				if _, ok := properties[n]; !ok {
+					properties[n] = s
+				}
+			}
+			continue field_loop
+		}
+		if "" != field.PkgPath {
+			continue field_loop // unexported
+		}
+
+		if "" == name {
+			name = field.Name
+		}
+		asString := false
+		for _, option := range options[1:] {
+			if "string" == option {
+				asString = true
+			}
+		}
+		properties[name] = document.schema(field.Type, asString)
+	}
+	return properties
+}
+
+// component name for a type, blank for basic and unnamed types
+func schemaName(t reflect.Type) string {
+	if "" == t.Name() || "" == t.PkgPath() || typeOfTime == t {
+		return ""
+	}
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// the published description, regenerate after changing any RPC types with:
+//   go test ./rpc -run TestOpenRPCDocument -update-openrpc
+const openRPCFile = "../doc/openrpc.json"
+
+var updateOpenRPC = flag.Bool("update-openrpc", false, "rewrite "+openRPCFile)
+
+func TestOpenRPCDocument(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	document := describeServices(createServices(log, "test"), "zero")
+
+	text, err := json.MarshalIndent(document, "", "  ")
+	if nil != err {
+		t.Fatalf("marshal error: %s", err)
+	}
+	text = append(text, '\n')
+
+	if *updateOpenRPC {
+		if err := ioutil.WriteFile(openRPCFile, text, 0644); nil != err {
+			t.Fatalf("write error: %s", err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(openRPCFile)
+	if nil != err {
+		t.Fatalf("read error: %s", err)
+	}
+	if !bytes.Equal(expected, text) {
+		t.Errorf("%s is out of date, rerun test with -update-openrpc", openRPCFile)
+	}
+
+	// every registered method is described
+	names := make(map[string]struct{})
+	for _, method := range document.Methods {
+		names[method.Name] = struct{}{}
+	}
+	for _, service := range createServices(log, "test") {
+		for name := range serviceMethods(service) {
+			if _, ok := names[name]; !ok {
+				t.Errorf("method: %q not described", name)
+			}
+		}
+	}
+
+	// all references must resolve
+	refs := bytes.Split(text, []byte(`"$ref": "#/components/schemas/`))
+	for _, r := range refs[1:] {
+		name := string(r[:bytes.IndexByte(r, '"')])
+		if _, ok := document.Components.Schemas[name]; !ok {
+			t.Errorf("unresolved schema: %q", name)
+		}
+	}
+}
+
+func TestOpenRPCDiscover(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	echo := &Echo{}
+	server := newJSONRPC2Server(log, []interface{}{echo})
+
+	response := server.serve([]byte(`{"jsonrpc":"2.0","method":"rpc.discover","id":1}`))
+	if !strings.Contains(string(response), `"code":-32601`) {
+		t.Errorf("discover without document: %s", response)
+	}
+
+	server.discovery = describeServices([]interface{}{echo}, "zero")
+
+	response = server.serve([]byte(`{"jsonrpc":"2.0","method":"rpc.discover","id":1}`))
+	if !strings.Contains(string(response), `"openrpc":"1.2.6"`) ||
+		!strings.Contains(string(response), `"name":"Echo.Say"`) {
+		t.Errorf("unexpected discover response: %s", response)
+	}
+}
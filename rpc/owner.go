@@ -33,9 +33,19 @@ const (
 )
 
 type OwnerBitmarksArguments struct {
-	Owner *account.Account `json:"owner"`        // base58
-	Start uint64           `json:"start,string"` // first record number
-	Count int              `json:"count"`        // number of records
+	Owner         *account.Account                   `json:"owner"`                   // base58
+	Start         uint64                             `json:"start,string"`            // first record number
+	Count         int                                `json:"count"`                   // number of records
+	AssetId       *transactionrecord.AssetIdentifier `json:"assetId,omitempty"`       // only bitmarks of this asset
+	Item          *ownership.OwnedItem               `json:"item,omitempty"`          // "Asset" or "Block"
+	IssueBlock    *BlockRange                        `json:"issueBlock,omitempty"`    // issued in these blocks
+	TransferBlock *BlockRange                        `json:"transferBlock,omitempty"` // last transferred in these blocks
+}
+
+// inclusive range of block numbers, a zero upper bound is unlimited
+type BlockRange struct {
+	From uint64 `json:"from,string"`
+	To   uint64 `json:"to,string"`
 }
 
 type OwnerBitmarksReply struct {
@@ -66,7 +76,24 @@ func (owner *Owner) Bitmarks(arguments *OwnerBitmarksArguments, reply *OwnerBitm
 	log := owner.log
 	log.Infof("Owner.Bitmarks: %+v", arguments)
 
-	ownershipData, err := ownership.ListBitmarksFor(arguments.Owner, arguments.Start, arguments.Count)
+	if nil == arguments.Owner {
+		return fault.ErrInvalidOwnerOrRegistrant
+	}
+
+	// with a filter the next value comes from the records examined
+	// rather than the records returned
+	filter, err := ownerFilter(arguments)
+	if nil != err {
+		return err
+	}
+
+	var ownershipData []ownership.Ownership
+	next := uint64(0)
+	if nil != filter {
+		ownershipData, next, err = ownership.ListFilteredBitmarksFor(arguments.Owner, arguments.Start, arguments.Count, filter)
+	} else {
+		ownershipData, err = ownership.ListBitmarksFor(arguments.Owner, arguments.Start, arguments.Count)
+	}
 	if nil != err {
 		return err
 	}
@@ -184,10 +211,39 @@ asset_loop:
 
 	// if no record were found the just return Next as zero
 	// otherwise the next possible number
-	if 0 == current {
+	if nil != filter {
+		reply.Next = next
+	} else if 0 == current {
 		reply.Next = 0
 	} else {
 		reply.Next = current + 1
 	}
 	return nil
 }
+
+// convert the optional filter arguments, nil if there are none
+func ownerFilter(arguments *OwnerBitmarksArguments) (*ownership.Filter, error) {
+	if nil == arguments.AssetId && nil == arguments.Item && nil == arguments.IssueBlock && nil == arguments.TransferBlock {
+		return nil, nil
+	}
+
+	for _, r := range []*BlockRange{arguments.IssueBlock, arguments.TransferBlock} {
+		if nil != r && 0 != r.To && r.From > r.To {
+			return nil, fault.ErrInvalidBlockRange
+		}
+	}
+
+	filter := &ownership.Filter{
+		AssetId: arguments.AssetId,
+		Item:    arguments.Item,
+	}
+	if nil != arguments.IssueBlock {
+		filter.IssueBlockFrom = arguments.IssueBlock.From
+		filter.IssueBlockTo = arguments.IssueBlock.To
+	}
+	if nil != arguments.TransferBlock {
+		filter.TransferBlockFrom = arguments.TransferBlock.From
+		filter.TransferBlockTo = arguments.TransferBlock.To
+	}
+	return filter, nil
+}
@@ -16,6 +16,7 @@ import (
 func rateLimit(limiter *rate.Limiter) error {
 	r := limiter.Reserve()
 	if !r.OK() {
+		serviceRejections.Increment()
 		return fault.ErrRateLimiting
 	}
 	time.Sleep(r.Delay())
@@ -30,6 +31,7 @@ func rateLimitN(limiter *rate.Limiter, count int, maximumCount int) error {
 
 		r := limiter.Reserve()
 		if !r.OK() {
+			serviceRejections.Increment()
 			return fault.ErrRateLimiting
 		}
 		time.Sleep(r.Delay())
@@ -39,6 +41,7 @@ func rateLimitN(limiter *rate.Limiter, count int, maximumCount int) error {
 
 	r := limiter.ReserveN(time.Now(), count)
 	if !r.OK() {
+		serviceRejections.Increment()
 		return fault.ErrRateLimiting
 	}
 	time.Sleep(r.Delay())
@@ -0,0 +1,502 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/ownership"
+	"github.com/bitmark-inc/bitmarkd/peer"
+	"github.com/bitmark-inc/bitmarkd/reservoir"
+	"github.com/bitmark-inc/bitmarkd/storage"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"github.com/bitmark-inc/logger"
+)
+
+// prefix for all REST routes
+const restPrefix = "/v1/"
+
+// routes as labels for metrics
+const (
+	routeBitmark           = "/v1/bitmarks/{txId}"
+	routeProvenance        = "/v1/bitmarks/{txId}/provenance"
+	routeAsset             = "/v1/assets/{assetId}"
+	routeOwnerBitmarks     = "/v1/owners/{account}/bitmarks"
+	routeBlock             = "/v1/blocks/{number}"
+	routeTransactionStatus = "/v1/tx/{txId}/status"
+)
+
+var restRoutes = []string{
+	routeBitmark,
+	routeProvenance,
+	routeAsset,
+	routeOwnerBitmarks,
+	routeBlock,
+	routeTransactionStatus,
+}
+
+// read-only REST interface to the RPC services
+//
+// routes:
+//   GET /v1/bitmarks/{txId}                  [the transaction record]
+//   GET /v1/bitmarks/{txId}/provenance       [count=<int> 1..100 default: 10]
+//                                            [start=<txId> oldest_first=<bool>]
+//   GET /v1/assets/{assetId}
+//   GET /v1/owners/{account}/bitmarks        [start=<uint64> count=<int>]
+//                                            [asset_id=<assetId> item=Asset|Block]
+//                                            [issue_from=<uint64> issue_to=<uint64>]
+//                                            [transfer_from=<uint64> transfer_to=<uint64>]
+//   GET /v1/blocks/{number}
+//   GET /v1/tx/{txId}/status
+//
+// confirmed data is immutable so carries an ETag computed from the
+// response body and an If-None-Match request gets 304 Not Modified;
+// blocks that a reorganisation could still replace keep the ETag but
+// must be revalidated
+type restHandler struct {
+	log         *logger.L
+	assets      *Assets
+	bitmark     *Bitmark
+	owner       *Owner
+	transaction *Transaction
+	blocks      *Blocks
+	limits      *clientLimits
+}
+
+// select the required services from the list
+func newRESTHandler(log *logger.L, services []interface{}, limits *clientLimits) *restHandler {
+	handler := &restHandler{
+		log:    log,
+		limits: limits,
+	}
+	for _, service := range services {
+		switch s := service.(type) {
+		case *Assets:
+			handler.assets = s
+		case *Bitmark:
+			handler.bitmark = s
+		case *Owner:
+			handler.owner = s
+		case *Transaction:
+			handler.transaction = s
+		case *Blocks:
+			handler.blocks = s
+		}
+	}
+	return handler
+}
+
+// split the path and pass to the appropriate route
+func (s *restHandler) serve(w http.ResponseWriter, r *http.Request) {
+	if http.MethodGet != r.Method && http.MethodHead != r.Method {
+		sendMethodNotAllowed(w)
+		return
+	}
+
+	if !s.limits.allowHTTP(w, r, 1) {
+		return
+	}
+
+	connectionCount.Increment()
+	defer connectionCount.Decrement()
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, restPrefix), "/")
+	parts := strings.Split(path, "/")
+
+	r.ParseForm()
+
+	start := time.Now()
+	recorder := &statusRecorder{
+		ResponseWriter: w,
+		status:         http.StatusOK,
+	}
+	route := unknownMethod
+
+	switch {
+	case 2 == len(parts) && "bitmarks" == parts[0]:
+		route = routeBitmark
+		s.bitmarkGet(recorder, r, parts[1])
+	case 3 == len(parts) && "bitmarks" == parts[0] && "provenance" == parts[2]:
+		route = routeProvenance
+		s.bitmarkProvenance(recorder, r, parts[1])
+	case 2 == len(parts) && "assets" == parts[0]:
+		route = routeAsset
+		s.assetGet(recorder, r, parts[1])
+	case 3 == len(parts) && "owners" == parts[0] && "bitmarks" == parts[2]:
+		route = routeOwnerBitmarks
+		s.ownerBitmarks(recorder, r, parts[1])
+	case 2 == len(parts) && "blocks" == parts[0]:
+		route = routeBlock
+		s.blockGet(recorder, r, parts[1])
+	case 3 == len(parts) && "tx" == parts[0] && "status" == parts[2]:
+		route = routeTransactionStatus
+		s.transactionStatus(recorder, r, parts[1])
+	default:
+		sendNotFound(recorder)
+	}
+
+	metrics.observe(protocolREST, route, time.Since(start), recorder.status >= http.StatusBadRequest)
+}
+
+// to capture the status of a reply
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// GET /v1/bitmarks/{txId}
+func (s *restHandler) bitmarkGet(w http.ResponseWriter, r *http.Request, txId string) {
+	arguments := ProvenanceArguments{
+		Count: 1,
+	}
+	if err := arguments.TxId.UnmarshalText([]byte(txId)); nil != err {
+		sendBadRequest(w)
+		return
+	}
+
+	var reply ProvenanceReply
+	if err := s.bitmark.Provenance(&arguments, &reply); nil != err {
+		sendFault(w, err)
+		return
+	}
+	if 0 == len(reply.Data) {
+		sendNotFound(w)
+		return
+	}
+
+	// only confirmed records are in storage, but isOwner changes
+	// when the bitmark is transferred
+	sendChangeableReply(w, r, reply.Data[0], true)
+}
+
+// GET /v1/bitmarks/{txId}/provenance
+func (s *restHandler) bitmarkProvenance(w http.ResponseWriter, r *http.Request, txId string) {
+	arguments := ProvenanceArguments{
+		Count: defaultCount,
+	}
+	if err := arguments.TxId.UnmarshalText([]byte(txId)); nil != err {
+		sendBadRequest(w)
+		return
+	}
+	if c := r.Form.Get("count"); "" != c {
+		n, err := strconv.Atoi(c)
+		if nil != err {
+			sendBadRequest(w)
+			return
+		}
+		arguments.Count = n
+	}
+	if st := r.Form.Get("start"); "" != st {
+		var start merkle.Digest
+		if err := start.UnmarshalText([]byte(st)); nil != err {
+			sendBadRequest(w)
+			return
+		}
+		arguments.Start = &start
+	}
+	if o := r.Form.Get("oldest_first"); "" != o {
+		b, err := strconv.ParseBool(o)
+		if nil != err {
+			sendBadRequest(w)
+			return
+		}
+		arguments.OldestFirst = b
+	}
+
+	var reply ProvenanceReply
+	if err := s.bitmark.Provenance(&arguments, &reply); nil != err {
+		sendFault(w, err)
+		return
+	}
+	if 0 == len(reply.Data) {
+		sendNotFound(w)
+		return
+	}
+
+	changeable := provenanceChangeable(arguments.TxId, reply.Data, block.GetHeight())
+	sendChangeableReply(w, r, reply, changeable)
+}
+
+// a page of provenance can change if it includes the requested
+// transaction, whose isOwner follows the current owner, or a record
+// in a block that a reorganisation could replace
+func provenanceChangeable(txId merkle.Digest, records []ProvenanceRecord, height uint64) bool {
+	for _, record := range records {
+		if id, ok := record.TxId.(merkle.Digest); ok && txId == id {
+			return true
+		}
+		if reorganisable(record.InBlock, height) {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /v1/assets/{assetId}
+func (s *restHandler) assetGet(w http.ResponseWriter, r *http.Request, id string) {
+	var assetId transactionrecord.AssetIdentifier
+	if err := assetId.UnmarshalText([]byte(id)); nil != err {
+		sendBadRequest(w)
+		return
+	}
+
+	if err := rateLimit(s.assets.limiter); nil != err {
+		sendFault(w, err)
+		return
+	}
+	if !mode.Is(mode.Normal) {
+		sendFault(w, fault.ErrNotAvailableDuringSynchronise)
+		return
+	}
+
+	s.log.Infof("REST asset: %v", assetId)
+
+	record, ok := getAssetRecord(assetId)
+	if !ok {
+		sendNotFound(w)
+		return
+	}
+
+	if !record.Confirmed {
+		sendCacheableReply(w, r, record, false)
+		return
+	}
+	blockNumber, _ := storage.Pool.Assets.GetNB(assetId[:])
+	sendChangeableReply(w, r, record, reorganisable(blockNumber, block.GetHeight()))
+}
+
+// GET /v1/owners/{account}/bitmarks
+func (s *restHandler) ownerBitmarks(w http.ResponseWriter, r *http.Request, owner string) {
+	a, err := account.AccountFromBase58(owner)
+	if nil != err {
+		sendBadRequest(w)
+		return
+	}
+
+	arguments := OwnerBitmarksArguments{
+		Owner: a,
+		Start: 0,
+		Count: defaultCount,
+	}
+	if c := r.Form.Get("count"); "" != c {
+		n, err := strconv.Atoi(c)
+		if nil != err {
+			sendBadRequest(w)
+			return
+		}
+		arguments.Count = n
+	}
+	if st := r.Form.Get("start"); "" != st {
+		n, err := strconv.ParseUint(st, 10, 64)
+		if nil != err {
+			sendBadRequest(w)
+			return
+		}
+		arguments.Start = n
+	}
+	if id := r.Form.Get("asset_id"); "" != id {
+		arguments.AssetId = &transactionrecord.AssetIdentifier{}
+		if err := arguments.AssetId.UnmarshalText([]byte(id)); nil != err {
+			sendBadRequest(w)
+			return
+		}
+	}
+	if item := r.Form.Get("item"); "" != item {
+		arguments.Item = new(ownership.OwnedItem)
+		if err := arguments.Item.UnmarshalText([]byte(item)); nil != err {
+			sendBadRequest(w)
+			return
+		}
+	}
+	if arguments.IssueBlock, err = blockRange(r, "issue_from", "issue_to"); nil != err {
+		sendBadRequest(w)
+		return
+	}
+	if arguments.TransferBlock, err = blockRange(r, "transfer_from", "transfer_to"); nil != err {
+		sendBadRequest(w)
+		return
+	}
+
+	var reply OwnerBitmarksReply
+	if err := s.owner.Bitmarks(&arguments, &reply); nil != err {
+		sendFault(w, err)
+		return
+	}
+
+	// ownership changes as transfers are confirmed
+	sendCacheableReply(w, r, reply, false)
+}
+
+// optional block range from a pair of query parameters, nil if
+// neither is present
+func blockRange(r *http.Request, fromName string, toName string) (*BlockRange, error) {
+	from := r.Form.Get(fromName)
+	to := r.Form.Get(toName)
+	if "" == from && "" == to {
+		return nil, nil
+	}
+
+	blocks := &BlockRange{}
+	if "" != from {
+		n, err := strconv.ParseUint(from, 10, 64)
+		if nil != err {
+			return nil, err
+		}
+		blocks.From = n
+	}
+	if "" != to {
+		n, err := strconv.ParseUint(to, 10, 64)
+		if nil != err {
+			return nil, err
+		}
+		blocks.To = n
+	}
+	return blocks, nil
+}
+
+// GET /v1/blocks/{number}
+func (s *restHandler) blockGet(w http.ResponseWriter, r *http.Request, number string) {
+	n, err := strconv.ParseUint(number, 10, 64)
+	if nil != err {
+		sendBadRequest(w)
+		return
+	}
+
+	arguments := BlocksGetArguments{
+		Number: n,
+	}
+
+	var reply BlocksGetReply
+	if err := s.blocks.Get(&arguments, &reply); nil != err {
+		sendFault(w, err)
+		return
+	}
+
+	sendChangeableReply(w, r, reply, reorganisable(n, block.GetHeight()))
+}
+
+// a block close enough to the tip to be replaced by a reorganisation
+//
+// replies from such a block can change so must be revalidated each
+// time, the ETag follows the body so an unchanged reply still gets 304
+func reorganisable(number uint64, height uint64) bool {
+	return number > genesis.BlockNumber && number+peer.ForkProtection > height
+}
+
+// GET /v1/tx/{txId}/status
+func (s *restHandler) transactionStatus(w http.ResponseWriter, r *http.Request, txId string) {
+	var arguments TransactionArguments
+	if err := arguments.TxId.UnmarshalText([]byte(txId)); nil != err {
+		sendBadRequest(w)
+		return
+	}
+
+	var reply TransactionStatusReply
+	if err := s.transaction.Status(&arguments, &reply); nil != err {
+		sendFault(w, err)
+		return
+	}
+
+	if reservoir.StateConfirmed.String() != reply.Status {
+		sendCacheableReply(w, r, reply, false)
+		return
+	}
+	blockNumber, _ := storage.Pool.Transactions.GetNB(arguments.TxId[:])
+	sendChangeableReply(w, r, reply, reorganisable(blockNumber, block.GetHeight()))
+}
+
+// send a JSON reply, adding an ETag if the data cannot change
+func sendCacheableReply(w http.ResponseWriter, r *http.Request, data interface{}, confirmed bool) {
+	if !confirmed {
+		w.Header().Set("Cache-Control", "no-cache")
+		sendReply(w, data)
+		return
+	}
+
+	sendTaggedReply(w, r, data, "public, max-age=60")
+}
+
+// send a confirmed JSON reply, one that can still change keeps its
+// ETag but must be revalidated each time
+func sendChangeableReply(w http.ResponseWriter, r *http.Request, data interface{}, changeable bool) {
+	if changeable {
+		sendTaggedReply(w, r, data, "no-cache")
+		return
+	}
+	sendCacheableReply(w, r, data, true)
+}
+
+// send a JSON reply with an ETag computed from the body
+func sendTaggedReply(w http.ResponseWriter, r *http.Request, data interface{}, cacheControl string) {
+	text, err := json.Marshal(data)
+	if nil != err {
+		sendInternalServerError(w)
+		return
+	}
+
+	digest := sha3.Sum256(text)
+	etag := `"` + hex.EncodeToString(digest[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write(text)
+}
+
+// check If-None-Match which can be a list of tags or "*"
+func etagMatches(header string, etag string) bool {
+	if "" == header {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if "*" == tag || etag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// convert an error from a service into an HTTP status
+func sendFault(w http.ResponseWriter, err error) {
+	switch {
+	case fault.ErrRateLimiting == err:
+		sendError(w, err.Error(), http.StatusTooManyRequests)
+	case fault.ErrNotAvailableDuringSynchronise == err:
+		sendError(w, err.Error(), http.StatusServiceUnavailable)
+	case fault.IsErrNotFound(err):
+		sendError(w, err.Error(), http.StatusNotFound)
+	case fault.IsErrInvalid(err), fault.IsErrLength(err), fault.IsErrRecord(err):
+		sendError(w, err.Error(), http.StatusBadRequest)
+	default:
+		sendInternalServerError(w)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/storage"
+)
+
+// a syntactically valid transaction id
+const zeroTxId = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// a syntactically valid account
+var testOwner = (&account.Account{
+	AccountInterface: &account.ED25519Account{
+		Test:      true,
+		PublicKey: make([]byte, 32),
+	},
+}).String()
+
+func TestRESTRouting(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	handler := newRESTHandler(log, createServices(log, "test"), nil)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodPost, "/v1/bitmarks/00", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/v1/", http.StatusNotFound},
+		{http.MethodGet, "/v1/unknown/route", http.StatusNotFound},
+		{http.MethodGet, "/v1/bitmarks/not-a-tx-id", http.StatusBadRequest},
+		{http.MethodGet, "/v1/bitmarks/xyz/provenance", http.StatusBadRequest},
+		{http.MethodGet, "/v1/bitmarks/" + zeroTxId + "/provenance?start=xyz", http.StatusBadRequest},
+		{http.MethodGet, "/v1/bitmarks/" + zeroTxId + "/provenance?oldest_first=maybe", http.StatusBadRequest},
+		{http.MethodGet, "/v1/assets/1234", http.StatusBadRequest},
+		{http.MethodGet, "/v1/owners/not-an-account/bitmarks", http.StatusBadRequest},
+		{http.MethodGet, "/v1/owners/" + testOwner + "/bitmarks?item=Coin", http.StatusBadRequest},
+		{http.MethodGet, "/v1/owners/" + testOwner + "/bitmarks?asset_id=1234", http.StatusBadRequest},
+		{http.MethodGet, "/v1/owners/" + testOwner + "/bitmarks?issue_to=x", http.StatusBadRequest},
+		{http.MethodGet, "/v1/blocks/minus-one", http.StatusBadRequest},
+		{http.MethodGet, "/v1/tx/abcd/status", http.StatusBadRequest},
+	}
+
+	for i, item := range tests {
+		r := httptest.NewRequest(item.method, item.path, nil)
+		w := httptest.NewRecorder()
+		handler.serve(w, r)
+		if item.code != w.Code {
+			t.Errorf("%d: %s %s  code: %d  expected: %d", i, item.method, item.path, w.Code, item.code)
+		}
+	}
+}
+
+func TestRESTETag(t *testing.T) {
+
+	data := map[string]string{"status": "Confirmed"}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/tx/x/status", nil)
+	w := httptest.NewRecorder()
+	sendCacheableReply(w, r, data, true)
+	if http.StatusOK != w.Code {
+		t.Fatalf("code: %d  expected: %d", w.Code, http.StatusOK)
+	}
+	etag := w.Header().Get("ETag")
+	if "" == etag {
+		t.Fatalf("missing ETag")
+	}
+	if `{"status":"Confirmed"}` != w.Body.String() {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+
+	// repeat with the tag
+	r = httptest.NewRequest(http.MethodGet, "/v1/tx/x/status", nil)
+	r.Header.Set("If-None-Match", `"other", `+etag)
+	w = httptest.NewRecorder()
+	sendCacheableReply(w, r, data, true)
+	if http.StatusNotModified != w.Code {
+		t.Errorf("code: %d  expected: %d", w.Code, http.StatusNotModified)
+	}
+	if 0 != w.Body.Len() {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+
+	// unconfirmed must not be cached
+	r = httptest.NewRequest(http.MethodGet, "/v1/tx/x/status", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	sendCacheableReply(w, r, data, false)
+	if http.StatusOK != w.Code {
+		t.Errorf("code: %d  expected: %d", w.Code, http.StatusOK)
+	}
+	if "" != w.Header().Get("ETag") {
+		t.Errorf("unexpected ETag: %s", w.Header().Get("ETag"))
+	}
+}
+
+func TestRESTBlockCache(t *testing.T) {
+
+	tests := []struct {
+		number uint64
+		height uint64
+		recent bool
+	}{
+		{1, 10, false},
+		{2, 10, true},
+		{10, 10, true},
+		{40, 100, false},
+		{41, 100, true},
+		{100, 100, true},
+		{101, 100, true},
+	}
+
+	for i, item := range tests {
+		if recent := reorganisable(item.number, item.height); item.recent != recent {
+			t.Errorf("%d: block: %d  height: %d  reorganisable: %t  expected: %t", i, item.number, item.height, recent, item.recent)
+		}
+	}
+
+	// a recent block keeps its ETag but must be revalidated
+	data := map[string]string{"digest": "aa"}
+	r := httptest.NewRequest(http.MethodGet, "/v1/blocks/2", nil)
+	w := httptest.NewRecorder()
+	sendTaggedReply(w, r, data, "no-cache")
+	etag := w.Header().Get("ETag")
+	if "" == etag || "no-cache" != w.Header().Get("Cache-Control") {
+		t.Fatalf("ETag: %q  Cache-Control: %q", etag, w.Header().Get("Cache-Control"))
+	}
+
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	sendTaggedReply(w, r, data, "no-cache")
+	if http.StatusNotModified != w.Code {
+		t.Errorf("unchanged code: %d  expected: %d", w.Code, http.StatusNotModified)
+	}
+
+	// a replaced block has a new digest
+	data["digest"] = "bb"
+	w = httptest.NewRecorder()
+	sendTaggedReply(w, r, data, "no-cache")
+	if http.StatusOK != w.Code {
+		t.Errorf("replaced code: %d  expected: %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestRESTProvenanceCache(t *testing.T) {
+
+	txId := merkle.NewDigest([]byte("requested"))
+	otherId := merkle.NewDigest([]byte("other"))
+
+	tests := []struct {
+		records    []ProvenanceRecord
+		changeable bool
+	}{
+		{[]ProvenanceRecord{{TxId: txId, InBlock: 1}}, true},
+		{[]ProvenanceRecord{{TxId: txId, IsOwner: true, InBlock: 1}}, true},
+		{[]ProvenanceRecord{{TxId: otherId, InBlock: 40}, {TxId: nil, InBlock: 1}}, false},
+		{[]ProvenanceRecord{{TxId: otherId, InBlock: 41}, {TxId: nil, InBlock: 1}}, true},
+		{[]ProvenanceRecord{{TxId: otherId, InBlock: 2}, {TxId: nil, InBlock: 90}}, true},
+	}
+
+	for i, item := range tests {
+		if changeable := provenanceChangeable(txId, item.records, 100); item.changeable != changeable {
+			t.Errorf("%d: changeable: %t  expected: %t", i, changeable, item.changeable)
+		}
+	}
+}
+
+func TestRESTTransactionStatusCache(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	storageSetup(t)
+	defer storageTeardown(t)
+
+	handler := newRESTHandler(log, createServices(log, "test"), nil)
+
+	// the block height is zero so only genesis is beyond reorganisation
+	oldId := merkle.NewDigest([]byte("old"))
+	recentId := merkle.NewDigest([]byte("recent"))
+	unknownId := merkle.NewDigest([]byte("unknown"))
+
+	blockNumberKey := make([]byte, 8)
+	binary.BigEndian.PutUint64(blockNumberKey, genesis.BlockNumber)
+	storage.Pool.Transactions.Put(oldId[:], blockNumberKey, []byte{0x01})
+	binary.BigEndian.PutUint64(blockNumberKey, genesis.BlockNumber+1)
+	storage.Pool.Transactions.Put(recentId[:], blockNumberKey, []byte{0x01})
+
+	tests := []struct {
+		txId         merkle.Digest
+		cacheControl string
+		etag         bool
+	}{
+		{oldId, "public, max-age=60", true},
+		{recentId, "no-cache", true},
+		{unknownId, "no-cache", false},
+	}
+
+	for i, item := range tests {
+		id, _ := item.txId.MarshalText()
+		r := httptest.NewRequest(http.MethodGet, "/v1/tx/"+string(id)+"/status", nil)
+		w := httptest.NewRecorder()
+		handler.serve(w, r)
+		if http.StatusOK != w.Code {
+			t.Fatalf("%d: code: %d  expected: %d", i, w.Code, http.StatusOK)
+		}
+		if cacheControl := w.Header().Get("Cache-Control"); item.cacheControl != cacheControl {
+			t.Errorf("%d: Cache-Control: %q  expected: %q", i, cacheControl, item.cacheControl)
+		}
+		if etag := "" != w.Header().Get("ETag"); item.etag != etag {
+			t.Errorf("%d: ETag: %t  expected: %t", i, etag, item.etag)
+		}
+	}
+}
+
+func TestRESTFaultStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{fault.ErrRateLimiting, http.StatusTooManyRequests},
+		{fault.ErrNotAvailableDuringSynchronise, http.StatusServiceUnavailable},
+		{fault.ErrBlockNotFound, http.StatusNotFound},
+		{fault.ErrInvalidCount, http.StatusBadRequest},
+		{fault.ErrTransactionLinksToSelf, http.StatusBadRequest},
+		{fault.ErrAlreadyInitialised, http.StatusInternalServerError},
+	}
+
+	for i, item := range tests {
+		w := httptest.NewRecorder()
+		sendFault(w, item.err)
+		if item.code != w.Code {
+			t.Errorf("%d: %s  code: %d  expected: %d", i, item.err, w.Code, item.code)
+		}
+	}
+}
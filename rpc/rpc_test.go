@@ -3,3 +3,50 @@
 // license that can be found in the LICENSE file.
 
 package rpc
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bitmark-inc/logger"
+)
+
+// test log file
+const (
+	testingLogFile = "test.log"
+)
+
+// common test setup routines
+
+// remove all files created by test
+func removeFiles() {
+	os.Remove(testingLogFile)
+}
+
+// configure for testing
+func setup(t *testing.T) *logger.L {
+	removeFiles()
+
+	logging := logger.Configuration{
+		Directory: ".",
+		File:      testingLogFile,
+		Size:      50000,
+		Count:     10,
+		Console:   false,
+		Levels: map[string]string{
+			logger.DefaultTag: "critical",
+		},
+	}
+
+	// start logging
+	if err := logger.Initialise(logging); nil != err {
+		t.Fatalf("logger initialise error: %s", err)
+	}
+	return logger.New("testing")
+}
+
+// post test cleanup
+func teardown(t *testing.T) {
+	logger.Finalise()
+	removeFiles()
+}
@@ -20,6 +20,7 @@ import (
 type serverArgument struct {
 	Log    *logger.L
 	Server *rpc.Server
+	Limits *clientLimits
 }
 
 var connectionCount counter.Counter
@@ -37,7 +38,11 @@ func Callback(conn io.ReadWriteCloser, argument interface{}) {
 	connectionCount.Increment()
 	defer connectionCount.Decrement()
 
-	codec := jsonrpc.NewServerCodec(conn)
+	codec := &limitingCodec{
+		ServerCodec: newMeteredCodec(jsonrpc.NewServerCodec(conn)),
+		limits:      serverArgument.Limits,
+		address:     connectionAddress(conn),
+	}
 	defer codec.Close()
 	server.ServeCodec(codec)
 
@@ -64,6 +64,9 @@ const (
 
 	rateLimitBlockOwner = 200
 	rateBurstBlockOwner = 100
+
+	rateLimitBlocks = 200
+	rateBurstBlocks = maximumBlocksCount
 )
 
 // globals
@@ -72,10 +75,12 @@ type rpcData struct {
 
 	log *logger.L // logger
 
-	listener *listener.MultiListener
+	listener *connectionListener
 
 	httpServer *httpHandler
 
+	limits *clientLimits
+
 	// set once during initialise
 	initialised bool
 }
@@ -84,7 +89,7 @@ type rpcData struct {
 var globalData rpcData
 
 // initialise peer backgrouds processes
-func Initialise(rpcConfiguration *RPCConfiguration, httpsConfiguration *HTTPSConfiguration, version string) error {
+func Initialise(rpcConfiguration *RPCConfiguration, httpsConfiguration *HTTPSConfiguration, limitConfiguration *LimitConfiguration, version string) error {
 
 	globalData.Lock()
 	defer globalData.Unlock()
@@ -98,12 +103,20 @@ func Initialise(rpcConfiguration *RPCConfiguration, httpsConfiguration *HTTPSCon
 	globalData.log = log
 	log.Info("starting…")
 
+	// per-client limits shared by all servers
+	limits, err := newClientLimits(limitConfiguration)
+	if nil != err {
+		log.Errorf("invalid client limits: %s", err)
+		return err
+	}
+	globalData.limits = limits
+
 	// servers
-	err := initialiseRPC(rpcConfiguration, version)
+	err = initialiseRPC(rpcConfiguration, limits, version)
 	if nil != err {
 		return err
 	}
-	err = initialiseHTTPS(httpsConfiguration, version)
+	err = initialiseHTTPS(httpsConfiguration, limits, version)
 	if nil != err {
 		return err
 	}
@@ -136,7 +149,29 @@ func Finalise() error {
 	return nil
 }
 
-func initialiseRPC(configuration *RPCConfiguration, version string) error {
+// apply new per-client limits to all running servers
+//
+// the current limits are kept if the new configuration is invalid
+func ReloadLimits(configuration *LimitConfiguration) error {
+	globalData.RLock()
+	defer globalData.RUnlock()
+
+	if !globalData.initialised {
+		return fault.ErrNotInitialised
+	}
+
+	limits, err := newClientLimits(configuration)
+	if nil != err {
+		globalData.log.Errorf("invalid client limits: %s", err)
+		return err
+	}
+	globalData.limits.replace(limits)
+
+	globalData.log.Infof("client limits reloaded: %d keys", len(limits.keys))
+	return nil
+}
+
+func initialiseRPC(configuration *RPCConfiguration, limits *clientLimits, version string) error {
 	name := "client_rpc"
 	log := globalData.log
 
@@ -164,13 +199,13 @@ func initialiseRPC(configuration *RPCConfiguration, version string) error {
 
 	log.Infof("%s: SHA3-256 fingerprint: %x", name, fingerprint)
 
-	log.Infof("multi listener for: %s", name)
-	ml, err := listener.NewMultiListener(name, configuration.Listen, tlsConfiguration, limiter, Callback)
+	log.Infof("listener for: %s", name)
+	cl, err := newConnectionListener(name, configuration.Listen, tlsConfiguration, limiter, Callback)
 	if nil != err {
-		log.Errorf("invalid %s listen addresses", name)
+		log.Errorf("invalid %s listen addresses: %s", name, err)
 		return err
 	}
-	globalData.listener = ml
+	globalData.listener = cl
 
 	// setup announce
 	rpcs := make([]byte, 0, 100) // ***** FIX THIS: need a better default size
@@ -192,10 +227,20 @@ process_rpcs:
 		return err
 	}
 
-	server := createRPCServer(log, version)
+	services := createServices(log, version)
+	metrics.register(services)
+
+	server := createRPCServer(services)
+
+	// HTTPS clients send a key with each request instead
+	server.Register(&Auth{
+		log: log,
+	})
+
 	argument := &serverArgument{
 		Log:    log,
 		Server: server,
+		Limits: limits,
 	}
 
 	log.Infof("starting server: %s  with: %v", name, argument)
@@ -205,7 +250,7 @@ process_rpcs:
 }
 
 // Start server with Test instance as a service
-func initialiseHTTPS(configuration *HTTPSConfiguration, version string) error {
+func initialiseHTTPS(configuration *HTTPSConfiguration, limits *clientLimits, version string) error {
 
 	name := "http_rpc"
 	log := globalData.log
@@ -247,13 +292,20 @@ func initialiseHTTPS(configuration *HTTPSConfiguration, version string) error {
 		}
 	}
 
-	server := createRPCServer(log, version)
+	services := createServices(log, version)
+	metrics.register(services)
+
+	jsonRPC2 := newJSONRPC2Server(log, services)
+	jsonRPC2.discovery = describeServices(services, version)
+
 	handler := &httpHandler{
-		log:     log,
-		server:  server,
-		version: version,
-		start:   time.Now(),
-		allow:   local,
+		log:      log,
+		server:   createRPCServer(services),
+		jsonRPC2: jsonRPC2,
+		limits:   limits,
+		version:  version,
+		start:    time.Now(),
+		allow:    local,
 	}
 
 	mux := http.NewServeMux()
@@ -261,6 +313,11 @@ func initialiseHTTPS(configuration *HTTPSConfiguration, version string) error {
 	mux.HandleFunc("/bitmarkd/details", handler.details)
 	mux.HandleFunc("/bitmarkd/connections", handler.connections)
 	mux.HandleFunc("/bitmarkd/peers", handler.peers)
+	mux.HandleFunc("/bitmarkd/metrics", handler.metrics)
+	mux.HandleFunc("/bitmarkd/openrpc.json", handler.openRPC)
+	mux.HandleFunc("/bitmarkd/health", handler.health)
+	mux.HandleFunc("/bitmarkd/ready", handler.ready)
+	mux.HandleFunc(restPrefix, newRESTHandler(log, services, limits).serve)
 	mux.HandleFunc("/", handler.root)
 
 	for _, listen := range configuration.Listen {
@@ -272,7 +329,9 @@ func initialiseHTTPS(configuration *HTTPSConfiguration, version string) error {
 	return nil
 }
 
-func createRPCServer(log *logger.L, version string) *rpc.Server {
+// create one instance of each RPC service so that all of the
+// protocols served on a listener share the same rate limiters
+func createServices(log *logger.L, version string) []interface{} {
 
 	start := time.Now().UTC()
 
@@ -314,15 +373,31 @@ func createRPCServer(log *logger.L, version string) *rpc.Server {
 		limiter: rate.NewLimiter(rateLimitBlockOwner, rateBurstBlockOwner),
 	}
 
+	blocks := &Blocks{
+		log:     log,
+		limiter: rate.NewLimiter(rateLimitBlocks, rateBurstBlocks),
+	}
+
+	return []interface{}{
+		assets,
+		bitmark,
+		bitmarks,
+		owner,
+		node,
+		transaction,
+		blockOwner,
+		blocks,
+	}
+}
+
+// register the services with a standard library RPC server
+func createRPCServer(services []interface{}) *rpc.Server {
+
 	server := rpc.NewServer()
 
-	server.Register(assets)
-	server.Register(bitmark)
-	server.Register(bitmarks)
-	server.Register(owner)
-	server.Register(node)
-	server.Register(transaction)
-	server.Register(blockOwner)
+	for _, service := range services {
+		server.Register(service)
+	}
 
 	return server
 }
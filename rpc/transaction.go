@@ -9,11 +9,21 @@ import (
 
 	"golang.org/x/time/rate"
 
+	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/fault"
 	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/pay"
 	"github.com/bitmark-inc/bitmarkd/reservoir"
+	"github.com/bitmark-inc/bitmarkd/storage"
 	"github.com/bitmark-inc/logger"
 )
 
+const (
+	maximumStatusCount = 100
+)
+
 // Transaction is a rpc entry for transaction related functions
 type Transaction struct {
 	log     *logger.L
@@ -41,3 +51,175 @@ func (t *Transaction) Status(arguments *TransactionArguments, reply *Transaction
 	reply.Status = reservoir.TransactionStatus(arguments.TxId).String()
 	return nil
 }
+
+// Transaction proof
+// -----------------
+
+// TransactionProofReply - the merkle branch linking a confirmed
+// transaction to the merkle root of its block header
+//
+// index is the zero based position of the transaction in the block
+// and the branch lists the sibling digests from the transaction up to
+// the root
+type TransactionProofReply struct {
+	TxId        merkle.Digest       `json:"txId"`
+	BlockNumber uint64              `json:"blockNumber"`
+	Digest      blockdigest.Digest  `json:"digest"`
+	Header      *blockrecord.Header `json:"header"`
+	Index       int                 `json:"index"`
+	Branch      []merkle.Digest     `json:"branch"`
+}
+
+// Proof - merkle inclusion proof for a confirmed transaction
+func (t *Transaction) Proof(arguments *TransactionArguments, reply *TransactionProofReply) error {
+
+	if err := rateLimit(t.limiter); nil != err {
+		return err
+	}
+
+	txId := arguments.TxId
+	blockNumber, packed := storage.Pool.Transactions.GetNB(txId[:])
+	if nil == packed {
+		return fault.ErrTransactionIsNotConfirmed
+	}
+
+	record, err := getBlockRecord(blockNumber, true)
+	if nil != err {
+		return err
+	}
+
+	index := -1
+	txIds := make([]merkle.Digest, len(record.Transactions))
+	for i, tx := range record.Transactions {
+		txIds[i] = tx.TxId
+		if txId == tx.TxId {
+			index = i
+		}
+	}
+	if index < 0 {
+		t.log.Errorf("proof: txId: %v  not in block: %d", txId, blockNumber)
+		return fault.ErrTransactionIsNotConfirmed
+	}
+
+	branch, err := merkle.Branch(txIds, index)
+	if nil != err {
+		return err
+	}
+
+	reply.TxId = txId
+	reply.BlockNumber = blockNumber
+	reply.Digest = record.Digest
+	reply.Header = record.Header
+	reply.Index = index
+	reply.Branch = branch
+	return nil
+}
+
+// Transaction bulk status
+// -----------------------
+
+// TransactionsStatusArguments - tx ids and pay ids to query, up to a
+// combined maximum of 100
+type TransactionsStatusArguments struct {
+	TxIds  []merkle.Digest `json:"txIds"`
+	PayIds []pay.PayId     `json:"payIds"`
+}
+
+// TransactionsStatusReply - one entry per tx id, then per pay id, in
+// the order of the arguments
+type TransactionsStatusReply struct {
+	TxIds  []TxIdStatus  `json:"txIds"`
+	PayIds []PayIdStatus `json:"payIds"`
+}
+
+// TxIdStatus - state of one transaction, block number and
+// confirmations are zero until confirmed
+type TxIdStatus struct {
+	TxId          merkle.Digest `json:"txId"`
+	Status        string        `json:"status"`
+	BlockNumber   uint64        `json:"blockNumber"`
+	Confirmations uint64        `json:"confirmations"`
+}
+
+// PayIdStatus - state of the transactions on a pay id, block number
+// is the last block holding any of them and is zero until confirmed
+//
+// a confirmed pay id is only remembered for a day, after which the
+// status is Unknown and the tx ids must be queried instead
+type PayIdStatus struct {
+	PayId         pay.PayId       `json:"payId"`
+	Status        string          `json:"status"`
+	TxIds         []merkle.Digest `json:"txIds"`
+	BlockNumber   uint64          `json:"blockNumber"`
+	Confirmations uint64          `json:"confirmations"`
+}
+
+// BulkStatus - status of several transactions or pay ids in one call
+func (t *Transaction) BulkStatus(arguments *TransactionsStatusArguments, reply *TransactionsStatusReply) error {
+
+	count := len(arguments.TxIds) + len(arguments.PayIds)
+	if err := rateLimitN(t.limiter, count, maximumStatusCount); nil != err {
+		return err
+	}
+
+	height := block.GetHeight()
+
+	reply.TxIds = make([]TxIdStatus, len(arguments.TxIds))
+	for i, txId := range arguments.TxIds {
+		reply.TxIds[i] = txIdStatus(txId, height)
+	}
+
+	reply.PayIds = make([]PayIdStatus, len(arguments.PayIds))
+	for i, payId := range arguments.PayIds {
+		reply.PayIds[i] = payIdStatus(payId, height)
+	}
+
+	return nil
+}
+
+// status of the transactions on a pay id relative to the current
+// block height
+func payIdStatus(payId pay.PayId, height uint64) PayIdStatus {
+
+	state, txIds := reservoir.PayIdStatus(payId)
+	if nil == txIds {
+		txIds = []merkle.Digest{}
+	}
+
+	status := PayIdStatus{
+		PayId:  payId,
+		Status: state.String(),
+		TxIds:  txIds,
+	}
+
+	if reservoir.StateConfirmed == state {
+		for _, txId := range txIds {
+			blockNumber, _ := storage.Pool.Transactions.GetNB(txId[:])
+			if blockNumber > status.BlockNumber {
+				status.BlockNumber = blockNumber
+			}
+		}
+		if height >= status.BlockNumber {
+			status.Confirmations = height - status.BlockNumber + 1
+		}
+	}
+	return status
+}
+
+// status of a single transaction relative to the current block height
+func txIdStatus(txId merkle.Digest, height uint64) TxIdStatus {
+
+	status := TxIdStatus{
+		TxId:   txId,
+		Status: reservoir.TransactionStatus(txId).String(),
+	}
+
+	if reservoir.StateConfirmed.String() == status.Status {
+		blockNumber, _ := storage.Pool.Transactions.GetNB(txId[:])
+		status.BlockNumber = blockNumber
+		if height >= blockNumber {
+			status.Confirmations = height - blockNumber + 1
+		}
+	}
+	return status
+}
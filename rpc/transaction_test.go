@@ -0,0 +1,210 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/time/rate"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/block"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/difficulty"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/pay"
+	"github.com/bitmark-inc/bitmarkd/reservoir"
+	"github.com/bitmark-inc/bitmarkd/storage"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+)
+
+func TestBulkStatus(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	storageSetup(t)
+	defer storageTeardown(t)
+
+	transaction := &Transaction{
+		log:     log,
+		limiter: rate.NewLimiter(rate.Inf, maximumStatusCount),
+	}
+
+	// block height is that of genesis
+	if err := block.Initialise(false); nil != err {
+		t.Fatalf("block initialise error: %s", err)
+	}
+	defer block.Finalise()
+	if err := reservoir.Initialise(testingDirName + "/reservoir.cache"); nil != err {
+		t.Fatalf("reservoir initialise error: %s", err)
+	}
+	defer reservoir.Finalise()
+
+	confirmedId := merkle.NewDigest([]byte("confirmed"))
+	unknownId := merkle.NewDigest([]byte("unknown"))
+
+	blockNumberKey := make([]byte, 8)
+	binary.BigEndian.PutUint64(blockNumberKey, genesis.BlockNumber)
+	storage.Pool.Transactions.Put(confirmedId[:], blockNumberKey, []byte{0x01})
+
+	// a free issue is pending until its block is stored
+	seed := make([]byte, ed25519.SeedSize)
+	key := ed25519.NewKeyFromSeed(seed)
+	owner := &account.Account{
+		AccountInterface: &account.ED25519Account{
+			PublicKey: key.Public().(ed25519.PublicKey),
+		},
+	}
+	assetId := transactionrecord.AssetIdentifier{0x01}
+	storage.Pool.Assets.Put(assetId[:], blockNumberKey, []byte{0x01})
+	issue := &transactionrecord.BitmarkIssue{
+		AssetId: assetId,
+		Owner:   owner,
+	}
+	issueId := signAndPack(t, issue, &issue.Signature, key, owner).MakeLink()
+	info, _, err := reservoir.StoreIssues([]*transactionrecord.BitmarkIssue{issue})
+	if nil != err {
+		t.Fatalf("store issue error: %s", err)
+	}
+
+	arguments := TransactionsStatusArguments{
+		TxIds:  []merkle.Digest{confirmedId, unknownId},
+		PayIds: []pay.PayId{pay.NewPayId([][]byte{{0x01}}), info.Id},
+	}
+	var reply TransactionsStatusReply
+	if err := transaction.BulkStatus(&arguments, &reply); nil != err {
+		t.Fatalf("bulk status error: %s", err)
+	}
+
+	if 2 != len(reply.TxIds) || 2 != len(reply.PayIds) {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+	if confirmedId != reply.TxIds[0].TxId || "Confirmed" != reply.TxIds[0].Status || genesis.BlockNumber != reply.TxIds[0].BlockNumber || 1 != reply.TxIds[0].Confirmations {
+		t.Errorf("confirmed: %+v", reply.TxIds[0])
+	}
+	if unknownId != reply.TxIds[1].TxId || "Unknown" != reply.TxIds[1].Status || 0 != reply.TxIds[1].BlockNumber || 0 != reply.TxIds[1].Confirmations {
+		t.Errorf("unknown: %+v", reply.TxIds[1])
+	}
+	if "Unknown" != reply.PayIds[0].Status || 0 != len(reply.PayIds[0].TxIds) || 0 != reply.PayIds[0].BlockNumber || 0 != reply.PayIds[0].Confirmations {
+		t.Errorf("unknown pay id: %+v", reply.PayIds[0])
+	}
+	if "Pending" != reply.PayIds[1].Status || 1 != len(reply.PayIds[1].TxIds) || issueId != reply.PayIds[1].TxIds[0] || 0 != reply.PayIds[1].BlockNumber || 0 != reply.PayIds[1].Confirmations {
+		t.Errorf("pending pay id: %+v", reply.PayIds[1])
+	}
+
+	// confirmation as done by block storage
+	reservoir.Disable()
+	reservoir.ConfirmByTxId(issueId)
+	reservoir.Enable()
+	storage.Pool.Transactions.Put(issueId[:], blockNumberKey, []byte{0x01})
+
+	if err := transaction.BulkStatus(&arguments, &reply); nil != err {
+		t.Fatalf("bulk status error: %s", err)
+	}
+	if "Confirmed" != reply.PayIds[1].Status || 1 != len(reply.PayIds[1].TxIds) || issueId != reply.PayIds[1].TxIds[0] || genesis.BlockNumber != reply.PayIds[1].BlockNumber || 1 != reply.PayIds[1].Confirmations {
+		t.Errorf("confirmed pay id: %+v", reply.PayIds[1])
+	}
+
+	// count must be 1..maximum
+	empty := TransactionsStatusArguments{}
+	if err := transaction.BulkStatus(&empty, &reply); fault.ErrInvalidCount != err {
+		t.Errorf("empty: error: %v  expected: %s", err, fault.ErrInvalidCount)
+	}
+	tooMany := TransactionsStatusArguments{
+		TxIds: make([]merkle.Digest, maximumStatusCount+1),
+	}
+	if err := transaction.BulkStatus(&tooMany, &reply); fault.ErrInvalidCount != err {
+		t.Errorf("too many: error: %v  expected: %s", err, fault.ErrInvalidCount)
+	}
+}
+
+func TestProof(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	storageSetup(t)
+	defer storageTeardown(t)
+
+	transaction := &Transaction{
+		log:     log,
+		limiter: rate.NewLimiter(rate.Inf, 1),
+	}
+
+	record, err := getBlockRecord(genesis.BlockNumber, true)
+	if nil != err {
+		t.Fatalf("get block error: %s", err)
+	}
+	last := len(record.Transactions) - 1
+	txId := record.Transactions[last].TxId
+
+	arguments := TransactionArguments{
+		TxId: txId,
+	}
+	var reply TransactionProofReply
+	if err := transaction.Proof(&arguments, &reply); fault.ErrTransactionIsNotConfirmed != err {
+		t.Errorf("unconfirmed: error: %v  expected: %s", err, fault.ErrTransactionIsNotConfirmed)
+	}
+
+	blockNumberKey := make([]byte, 8)
+	binary.BigEndian.PutUint64(blockNumberKey, genesis.BlockNumber)
+	storage.Pool.Transactions.Put(txId[:], blockNumberKey, []byte{0x01})
+
+	if err := transaction.Proof(&arguments, &reply); nil != err {
+		t.Fatalf("proof error: %s", err)
+	}
+	if txId != reply.TxId || genesis.BlockNumber != reply.BlockNumber || last != reply.Index {
+		t.Errorf("unexpected reply: %+v", reply)
+	}
+	if genesis.LiveGenesisDigest != reply.Digest {
+		t.Errorf("digest: %v  expected: %v", reply.Digest, genesis.LiveGenesisDigest)
+	}
+	if !merkle.VerifyBranch(reply.TxId, reply.Index, reply.Branch, reply.Header.MerkleRoot) {
+		t.Errorf("branch: %v  does not verify", reply.Branch)
+	}
+}
+
+// proofs for blocks mined at an earlier difficulty
+func TestProofOldBlock(t *testing.T) {
+	log := setup(t)
+	defer teardown(t)
+
+	storageSetup(t)
+	defer storageTeardown(t)
+
+	digest := storeOldBlocks(t)
+
+	transaction := &Transaction{
+		log:     log,
+		limiter: rate.NewLimiter(rate.Inf, 1),
+	}
+
+	number := genesis.BlockNumber + 1
+	blockNumberKey := make([]byte, 8)
+	binary.BigEndian.PutUint64(blockNumberKey, number)
+
+	txId := merkle.NewDigest(genesis.LiveGenesisBlock[len(blockrecord.PackedHeader{}):])
+	storage.Pool.Transactions.Put(txId[:], blockNumberKey, []byte{0x01})
+
+	arguments := TransactionArguments{
+		TxId: txId,
+	}
+	var reply TransactionProofReply
+	if err := transaction.Proof(&arguments, &reply); nil != err {
+		t.Fatalf("proof error: %s", err)
+	}
+	if number != reply.BlockNumber || digest != reply.Digest {
+		t.Errorf("block: %d  digest: %v  expected: %d  %v", reply.BlockNumber, reply.Digest, number, digest)
+	}
+	if reply.Header.Difficulty.Reciprocal() == difficulty.Current.Reciprocal() {
+		t.Errorf("difficulty: %v  should differ from current", reply.Header.Difficulty)
+	}
+	if !merkle.VerifyBranch(reply.TxId, reply.Index, reply.Branch, reply.Header.MerkleRoot) {
+		t.Errorf("branch: %v  does not verify", reply.Branch)
+	}
+}
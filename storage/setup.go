@@ -32,6 +32,11 @@ type pools struct {
 	OwnerCount        *PoolHandle `prefix:"N" database:"index"`
 	Ownership         *PoolHandle `prefix:"K" database:"index"`
 	OwnerDigest       *PoolHandle `prefix:"D" database:"index"`
+	BlockHeaderHash   *PoolHandle `prefix:"2" database:"index"`
+	AssetSearch       *PoolHandle `prefix:"S" database:"index"`
+	TxSuccessor       *PoolHandle `prefix:"X" database:"index"`
+	TxOrigin          *PoolHandle `prefix:"O" database:"index"`
+	ChainWork         *PoolHandle `prefix:"W" database:"index"`
 	TestData          *PoolHandle `prefix:"Z" database:"index"`
 }
 
@@ -41,8 +46,11 @@ var Pool pools
 // for database version
 var versionKey = []byte{0x00, 'V', 'E', 'R', 'S', 'I', 'O', 'N'}
 
+// the index version is separate so that adding an index only forces
+// the index database to be regenerated from the stored blocks
 const (
-	currentVersion = 0x100 // WAS: []byte{0x00, 0x00, 0x00, 0x03}
+	currentBlockVersion = 0x100 // WAS: []byte{0x00, 0x00, 0x00, 0x03}
+	currentIndexVersion = 0x105 // 0x101: added block header hash index, 0x102: added asset search index, 0x103: added transfer successor index, 0x104: added chain work index, 0x105: added transfer origin index
 )
 
 // holds the database handle
@@ -89,9 +97,9 @@ func Initialise(database string, readOnly bool) (bool, error) {
 	poolData.dbBlocks = db
 
 	// ensure no database downgrade
-	if blocksVersion > currentVersion {
-		logger.Criticalf("block database version: %d > current version: %d", blocksVersion, currentVersion)
-		return mustReindex, fmt.Errorf("block database version: %d > current version: %d", blocksVersion, currentVersion)
+	if blocksVersion > currentBlockVersion {
+		logger.Criticalf("block database version: %d > current version: %d", blocksVersion, currentBlockVersion)
+		return mustReindex, fmt.Errorf("block database version: %d > current version: %d", blocksVersion, currentBlockVersion)
 	}
 
 	db, indexVersion, err := getDB(indexDatabase, readOnly)
@@ -101,26 +109,26 @@ func Initialise(database string, readOnly bool) (bool, error) {
 	poolData.dbIndex = db
 
 	// ensure no database downgrade
-	if indexVersion > currentVersion {
-		logger.Criticalf("index database version: %d > current version: %d", indexVersion, currentVersion)
-		return mustReindex, fmt.Errorf("index database version: %d > current version: %d", indexVersion, currentVersion)
+	if indexVersion > currentIndexVersion {
+		logger.Criticalf("index database version: %d > current version: %d", indexVersion, currentIndexVersion)
+		return mustReindex, fmt.Errorf("index database version: %d > current version: %d", indexVersion, currentIndexVersion)
 	}
 
 	// prevent readOnly from modifying the database
-	if readOnly && (blocksVersion != currentVersion || indexVersion != currentVersion) {
-		logger.Criticalf("database is inconsistent: blocks: %d  index: %d  current: %d", blocksVersion, indexVersion, currentVersion)
-		return mustReindex, fmt.Errorf("database is inconsistent: blocks: %d  index: %d  current: %d", blocksVersion, indexVersion, currentVersion)
+	if readOnly && (blocksVersion != currentBlockVersion || indexVersion != currentIndexVersion) {
+		logger.Criticalf("database is inconsistent: blocks: %d/%d  index: %d/%d", blocksVersion, currentBlockVersion, indexVersion, currentIndexVersion)
+		return mustReindex, fmt.Errorf("database is inconsistent: blocks: %d/%d  index: %d/%d", blocksVersion, currentBlockVersion, indexVersion, currentIndexVersion)
 	}
 
-	if 0 < blocksVersion && blocksVersion < currentVersion {
+	if 0 < blocksVersion && blocksVersion < currentBlockVersion {
 
 		// fail if block database is too old
 		// this will be replaced by the appropriate migration code
 		// if the format of blocks needs to be changed in the future
 
 		logger.Criticalf("no migration for block database version: %d", blocksVersion)
-		logger.Criticalf("block database version: %d < current version: %d", blocksVersion, currentVersion)
-		return mustReindex, fmt.Errorf("block database version: %d < current version: %d", blocksVersion, currentVersion)
+		logger.Criticalf("block database version: %d < current version: %d", blocksVersion, currentBlockVersion)
+		return mustReindex, fmt.Errorf("block database version: %d < current version: %d", blocksVersion, currentBlockVersion)
 
 	} else if 0 == blocksVersion && util.EnsureFileExists(legacyDatabase) {
 
@@ -157,21 +165,21 @@ func Initialise(database string, readOnly bool) (bool, error) {
 			// either put error or iter error
 			return mustReindex, err
 		}
-		err = putVersion(poolData.dbBlocks, currentVersion)
+		err = putVersion(poolData.dbBlocks, currentBlockVersion)
 		if err != nil {
 			return mustReindex, err
 		}
 	} else if 0 == blocksVersion {
 
 		// database was empty so tag as current version
-		err = putVersion(poolData.dbBlocks, currentVersion)
+		err = putVersion(poolData.dbBlocks, currentBlockVersion)
 		if err != nil {
 			return mustReindex, err
 		}
 	}
 
 	// see if index need to be created or deleted and re-created
-	if mustReindex || indexVersion < currentVersion {
+	if mustReindex || indexVersion < currentIndexVersion {
 
 		mustReindex = true
 
@@ -272,7 +280,7 @@ func Finalise() {
 func ReindexDone() error {
 	poolData.Lock()
 	defer poolData.Unlock()
-	return putVersion(poolData.dbIndex, currentVersion)
+	return putVersion(poolData.dbIndex, currentIndexVersion)
 }
 
 // return:
@@ -93,6 +93,11 @@ func (conn *Connection) CanonicalIPandPort(prefix string) (string, bool) {
 	return prefix + "[" + conn.ip.String() + "]:" + strconv.Itoa(port), true
 }
 
+// the IP address of a connection
+func (conn *Connection) IP() net.IP {
+	return conn.ip
+}
+
 // basic string conversion
 func (conn Connection) String() string {
 	s, _ := conn.CanonicalIPandPort("")
@@ -0,0 +1,83 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"time"
+)
+
+// how long per-client state is kept after its last use and how often
+// the idle entries are looked for
+const (
+	IdleExpiry        = 10 * time.Minute
+	IdleSweepInterval = time.Minute
+)
+
+// IdleMap - per-client state that is discarded once the client has
+// been idle for IdleExpiry
+//
+// not safe for concurrent use, the owner must hold its own lock
+type IdleMap struct {
+	entries   map[string]*idleEntry
+	lastSweep time.Time
+}
+
+type idleEntry struct {
+	value    interface{}
+	lastUsed time.Time
+}
+
+// NewIdleMap - create an empty map
+func NewIdleMap() *IdleMap {
+	return &IdleMap{
+		entries:   make(map[string]*idleEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Use - fetch the value for a key, calling create if it is absent,
+// and mark it as used; idle entries are swept first
+func (m *IdleMap) Use(key string, now time.Time, create func() interface{}) interface{} {
+	m.sweep(now)
+
+	e, ok := m.entries[key]
+	if !ok {
+		e = &idleEntry{
+			value: create(),
+		}
+		m.entries[key] = e
+	}
+	e.lastUsed = now
+	return e.value
+}
+
+// Get - fetch the value for a key without marking it as used
+func (m *IdleMap) Get(key string) (interface{}, bool) {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Range - call f for every entry in no particular order
+func (m *IdleMap) Range(f func(key string, value interface{})) {
+	for key, e := range m.entries {
+		f(key, e.value)
+	}
+}
+
+// discard idle entries at most once per sweep interval
+func (m *IdleMap) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < IdleSweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, e := range m.entries {
+		if now.Sub(e.lastUsed) > IdleExpiry {
+			delete(m.entries, key)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2014-2018 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package util_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/util"
+)
+
+func TestIdleMap(t *testing.T) {
+
+	m := util.NewIdleMap()
+	created := 0
+	create := func() interface{} {
+		created += 1
+		return created
+	}
+
+	now := time.Now()
+	if 1 != m.Use("a", now, create) || 2 != m.Use("b", now, create) {
+		t.Fatalf("unexpected values")
+	}
+	if 1 != m.Use("a", now, create) {
+		t.Errorf("existing value was replaced")
+	}
+
+	// "a" is kept in use, "b" goes idle
+	later := now.Add(util.IdleExpiry)
+	m.Use("a", later, create)
+	m.Use("c", later.Add(util.IdleSweepInterval), create)
+
+	if _, ok := m.Get("b"); ok {
+		t.Errorf("idle entry was not discarded")
+	}
+	keys := 0
+	m.Range(func(key string, value interface{}) {
+		keys += 1
+		if "a" != key && "c" != key {
+			t.Errorf("unexpected key: %q", key)
+		}
+	})
+	if 2 != keys {
+		t.Errorf("keys: %d  expected: 2", keys)
+	}
+}
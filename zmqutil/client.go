@@ -301,6 +301,11 @@ func (client *Client) IsConnectedTo(serverPublicKey []byte) bool {
 	return bytes.Equal(client.serverPublicKey, serverPublicKey)
 }
 
+// copy of the public key of the node
+func (client *Client) ServerPublicKey() []byte {
+	return append([]byte{}, client.serverPublicKey...)
+}
+
 // // check if not connected to any node
 // func (client *Client) IsDisconnected() bool {
 // 	return "" == client.address
@@ -331,6 +336,21 @@ func (client *Client) ReconnectReturningSocket() (*zmq.Socket, error) {
 	return client.socket, nil
 }
 
+// disconnect and forget the server so that the client is idle until
+// the next Connect
+func (client *Client) Disconnect() error {
+	err := client.closeSocket()
+
+	client.Lock()
+	client.address = ""
+	for i := range client.serverPublicKey {
+		client.serverPublicKey[i] = 0
+	}
+	client.Unlock()
+
+	return err
+}
+
 // disconnect old address and close
 func (client *Client) Close() error {
 	return client.closeSocket()